@@ -10,6 +10,10 @@ import (
 	"time"
 
 	"microservice/internal/httpapi"
+	"microservice/internal/payment"
+	"microservice/internal/recurring"
+	"microservice/internal/scheduler"
+	"microservice/internal/serviceproduct"
 	"microservice/pkg/config"
 	"microservice/pkg/db"
 )
@@ -37,6 +41,24 @@ func main() {
 		DB:  conn,
 	})
 
+	var jobs *scheduler.Scheduler
+	if cfg.SchedulerEnabled {
+		jobs = scheduler.New(scheduler.Job{
+			Name:     "auto-charge",
+			Interval: cfg.AutoChargeInterval,
+			Run:      payment.AutoCharger{Cfg: cfg, DB: conn}.Run,
+		}, scheduler.Job{
+			Name:     "recurring-cycles",
+			Interval: cfg.RecurringInterval,
+			Run:      recurring.Generator{DB: conn}.Run,
+		}, scheduler.Job{
+			Name:     "config-activation",
+			Interval: cfg.ConfigActivationInterval,
+			Run:      serviceproduct.Activator{DB: conn}.Run,
+		})
+		jobs.Start(ctx)
+	}
+
 	srv := &http.Server{
 		Addr:              cfg.HTTPAddr,
 		Handler:           router,
@@ -56,6 +78,9 @@ func main() {
 	defer cancel()
 
 	_ = srv.Shutdown(shutdownCtx)
+	if jobs != nil {
+		jobs.Wait()
+	}
 }
 
 
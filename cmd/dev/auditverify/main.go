@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"microservice/internal/audit"
+	"microservice/internal/shop"
+	"microservice/pkg/config"
+	"microservice/pkg/db"
+)
+
+// auditverify walks the audit_logs hash chain of one shop (or every shop) and reports the first broken link.
+// Exit code is 1 when any chain fails to verify.
+func main() {
+	var (
+		shopDomain = flag.String("shop", "", "shop domain to verify (e.g. your-store.myshopify.com)")
+		all        = flag.Bool("all", false, "verify every installed shop")
+	)
+	flag.Parse()
+
+	if *shopDomain == "" && !*all {
+		fmt.Fprintln(os.Stderr, "missing -shop (or -all)")
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+	ctx := context.Background()
+
+	pool, err := db.Open(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db open: %v\n", err)
+		os.Exit(1)
+	}
+	defer pool.Close()
+
+	var shopIDs []string
+	if *all {
+		rows, err := pool.Query(ctx, `SELECT id FROM shops ORDER BY installed_at ASC`)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "list shops: %v\n", err)
+			os.Exit(1)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				fmt.Fprintf(os.Stderr, "scan shop: %v\n", err)
+				os.Exit(1)
+			}
+			shopIDs = append(shopIDs, id)
+		}
+		rows.Close()
+	} else {
+		sh, err := shop.NewRepository(pool).FindByDomain(ctx, *shopDomain)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shop not found: %v\n", err)
+			os.Exit(1)
+		}
+		shopIDs = append(shopIDs, sh.ID)
+	}
+
+	repo := audit.NewRepository(pool)
+	failed := false
+	enc := json.NewEncoder(os.Stdout)
+	for _, id := range shopIDs {
+		res, err := repo.VerifyChain(ctx, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "verify shop_id=%s: %v\n", id, err)
+			os.Exit(1)
+		}
+		_ = enc.Encode(res)
+		if !res.OK {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
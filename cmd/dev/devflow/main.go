@@ -103,7 +103,7 @@ func main() {
 	cfgJSON = []byte(`{"version":1,"currency":"USD","templates":[{"type":"percentage","value":50,"isFinal":false},{"type":"percentage","value":50,"isFinal":true}]}`)
 
 	spRepo := serviceproduct.NewRepository(pool)
-	_, err = spRepo.Upsert(ctx, sh.ID, *productID, cfgJSON)
+	_, err = spRepo.Upsert(ctx, sh.ID, *productID, "", cfgJSON, "devflow")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "upsert service product config: %v\n", err)
 		os.Exit(1)
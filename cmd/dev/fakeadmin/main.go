@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"microservice/pkg/shopify/shopifytest"
+)
+
+// fakeadmin serves an in-memory Shopify Admin API for local runs. Point SHOPIFY_ADMIN_BASE_URL at it.
+func main() {
+	var (
+		addr    = flag.String("addr", ":8099", "listen address")
+		orders  = flag.String("orders", "", "comma-separated orderId=mandateId pairs the fake knows about")
+		decline = flag.Int("decline", 0, "decline this many mandate payments before accepting")
+	)
+	flag.Parse()
+
+	fake := shopifytest.NewFakeAdmin()
+	for _, pair := range strings.Split(*orders, ",") {
+		if pair == "" {
+			continue
+		}
+		orderID, mandateID, ok := strings.Cut(pair, "=")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "bad -orders entry %q, want orderId=mandateId\n", pair)
+			os.Exit(2)
+		}
+		fake.AddOrder(orderID, mandateID)
+	}
+	if *decline > 0 {
+		fake.DeclineNext(*decline, "PAYMENT_DECLINED", "card declined")
+	}
+
+	log.Printf("fake shopify admin listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, fake))
+}
@@ -8,4 +8,11 @@ const (
 	ActionReopenService                  ActionType = "REOPEN_SERVICE"
 )
 
+type Status string
 
+const (
+	StatusPending  Status = "pending"
+	StatusApplied  Status = "applied"
+	StatusRejected Status = "rejected"
+	StatusExpired  Status = "expired"
+)
@@ -3,11 +3,54 @@ package adminaction
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func Insert(ctx context.Context, tx pgx.Tx, serviceID string, actionType ActionType, reason, actor string, metadata any) error {
+// Action is an override proposal. ProposedBy is stored in admin_actions.actor.
+type Action struct {
+	ID           string          `json:"id"`
+	ServiceID    string          `json:"serviceId"`
+	ActionType   ActionType      `json:"actionType"`
+	Reason       string          `json:"reason"`
+	ProposedBy   string          `json:"proposedBy"`
+	Status       Status          `json:"status"`
+	DecidedBy    string          `json:"decidedBy,omitempty"`
+	DecidedAt    *time.Time      `json:"decidedAt,omitempty"`
+	DecisionNote string          `json:"decisionNote,omitempty"`
+	ExpiresAt    *time.Time      `json:"expiresAt,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	CreatedAt    time.Time       `json:"createdAt"`
+}
+
+// Expired reports whether a pending proposal has outlived its TTL.
+func (a Action) Expired(now time.Time) bool {
+	return a.Status == StatusPending && a.ExpiresAt != nil && !a.ExpiresAt.After(now)
+}
+
+const selectColumns = `
+SELECT id, service_id, action_type, reason, actor, status, COALESCE(decided_by,''), decided_at,
+       COALESCE(decision_note,''), expires_at, metadata, created_at
+FROM admin_actions
+`
+
+func scan(row pgx.Row) (*Action, error) {
+	var a Action
+	var meta []byte
+	if err := row.Scan(&a.ID, &a.ServiceID, &a.ActionType, &a.Reason, &a.ProposedBy, &a.Status, &a.DecidedBy, &a.DecidedAt,
+		&a.DecisionNote, &a.ExpiresAt, &meta, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	if len(meta) > 0 {
+		a.Metadata = meta
+	}
+	return &a, nil
+}
+
+// Propose inserts a pending override that must be confirmed before expiresAt.
+func Propose(ctx context.Context, tx pgx.Tx, serviceID string, actionType ActionType, reason, proposedBy string, expiresAt time.Time, metadata any) (*Action, error) {
 	var s *string
 	if metadata != nil {
 		b, _ := json.Marshal(metadata)
@@ -15,11 +58,62 @@ func Insert(ctx context.Context, tx pgx.Tx, serviceID string, actionType ActionT
 		s = &str
 	}
 	const q = `
-INSERT INTO admin_actions (service_id, action_type, reason, actor, metadata)
-VALUES ($1, $2, $3, $4, CAST($5 AS jsonb))
+INSERT INTO admin_actions (service_id, action_type, reason, actor, metadata, status, expires_at)
+VALUES ($1, $2, $3, $4, CAST($5 AS jsonb), 'pending', $6)
+RETURNING id, service_id, action_type, reason, actor, status, COALESCE(decided_by,''), decided_at,
+          COALESCE(decision_note,''), expires_at, metadata, created_at
 `
-	_, err := tx.Exec(ctx, q, serviceID, string(actionType), reason, actor, s)
+	return scan(tx.QueryRow(ctx, q, serviceID, string(actionType), reason, proposedBy, s, expiresAt))
+}
+
+func GetForUpdate(ctx context.Context, tx pgx.Tx, serviceID, actionID string) (*Action, error) {
+	return scan(tx.QueryRow(ctx, selectColumns+`WHERE service_id = $1 AND id = $2 FOR UPDATE`, serviceID, actionID))
+}
+
+// Decide moves a pending proposal to its final status.
+func Decide(ctx context.Context, tx pgx.Tx, actionID string, status Status, decidedBy, note string, at time.Time) error {
+	const q = `
+UPDATE admin_actions
+SET status = $2, decided_by = NULLIF($3,''), decision_note = NULLIF($4,''), decided_at = $5
+WHERE id = $1 AND status = 'pending'
+`
+	_, err := tx.Exec(ctx, q, actionID, string(status), decidedBy, note, at)
 	return err
 }
 
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// ListByService returns all overrides for a service, newest first. Pending rows past their TTL are reported as expired.
+func (r *Repository) ListByService(ctx context.Context, serviceID string, pendingOnly bool) ([]Action, error) {
+	q := selectColumns + `WHERE service_id = $1`
+	if pendingOnly {
+		q += ` AND status = 'pending' AND (expires_at IS NULL OR expires_at > NOW())`
+	}
+	q += ` ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(ctx, q, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	now := time.Now()
+	var out []Action
+	for rows.Next() {
+		a, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		if a.Expired(now) {
+			a.Status = StatusExpired
+		}
+		out = append(out, *a)
+	}
+	return out, rows.Err()
+}
@@ -8,7 +8,16 @@ import (
 
 type ctxKey string
 
-const ctxKeyShop ctxKey = "shop"
+const (
+	ctxKeyShop  ctxKey = "shop"
+	ctxKeyStaff ctxKey = "staff"
+)
+
+// Staff identifies the Shopify staff member behind a merchant request.
+// UserID comes from the verified session token.
+type Staff struct {
+	UserID string `json:"userId"`
+}
 
 func WithShop(ctx context.Context, s *shop.Shop) context.Context {
 	return context.WithValue(ctx, ctxKeyShop, s)
@@ -23,4 +32,29 @@ func ShopFromContext(ctx context.Context) *shop.Shop {
 	return s
 }
 
+// WithStaff attaches the verified staff identity; call it alongside WithShop.
+func WithStaff(ctx context.Context, st *Staff) context.Context {
+	return context.WithValue(ctx, ctxKeyStaff, st)
+}
 
+func StaffFromContext(ctx context.Context) *Staff {
+	v := ctx.Value(ctxKeyStaff)
+	if v == nil {
+		return nil
+	}
+	st, _ := v.(*Staff)
+	return st
+}
+
+// Actor returns the value recorded in audit_logs.actor, admin_actions.actor and service_events.actor
+// for merchant requests: "staff:<userId>" when the staff member is known, otherwise "merchant".
+func Actor(ctx context.Context) string {
+	if st := StaffFromContext(ctx); st != nil && st.UserID != "" {
+		return StaffActor(st.UserID)
+	}
+	return "merchant"
+}
+
+func StaffActor(userID string) string {
+	return "staff:" + userID
+}
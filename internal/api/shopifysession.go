@@ -1,7 +1,10 @@
 package api
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,13 +13,24 @@ import (
 	"microservice/pkg/shopify"
 )
 
+// StaffRecorder remembers staff members seen on verified requests (implemented by staff.Repository).
+type StaffRecorder interface {
+	Touch(ctx context.Context, shopID, userID string) (needsIdentity bool, err error)
+	SetIdentity(ctx context.Context, shopID, userID, name, email string) error
+}
+
 // ShopifySessionAuth validates Shopify embedded session tokens.
 //
 // Expected header:
 // - Authorization: Bearer <JWT>
 //
 // In dev, if Authorization is missing, it can fall back to X-Shop-Domain to keep local testing simple.
-func ShopifySessionAuth(cfg config.Config, shopsRepo *shop.Repository) func(http.Handler) http.Handler {
+//
+// The token's "sub" claim identifies the staff member and is attached via WithStaff. Their name and email are
+// never taken from request headers, which any caller can set: that would let one staff member put another's
+// name on their own audit entries. They are fetched from Shopify by exchanging the session token for an online
+// access token, whose associated_user is the staff member Shopify issued the token to.
+func ShopifySessionAuth(cfg config.Config, shopsRepo *shop.Repository, staffRepo StaffRecorder) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authz := strings.TrimSpace(r.Header.Get("Authorization"))
@@ -63,13 +77,26 @@ func ShopifySessionAuth(cfg config.Config, shopsRepo *shop.Repository) func(http
 					}
 				}
 
-				next.ServeHTTP(w, r.WithContext(WithShop(r.Context(), s)))
+				ctx := WithShop(r.Context(), s)
+				if vs.UserID != "" {
+					st := &Staff{UserID: vs.UserID}
+					needsIdentity, err := staffRepo.Touch(r.Context(), s.ID, st.UserID)
+					if err != nil {
+						log.Printf("staff touch failed shop=%s user=%s err=%v", s.Domain, st.UserID, err)
+					}
+					if needsIdentity {
+						recordStaffIdentity(r.Context(), cfg, staffRepo, s, st.UserID, token)
+					}
+					ctx = WithStaff(ctx, st)
+				}
+
+				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 
 			// Dev fallback
 			if cfg.AppEnv != "prod" {
-				MerchantAuth(shopsRepo)(next).ServeHTTP(w, r)
+				MerchantAuth(shopsRepo)(devStaff(next)).ServeHTTP(w, r)
 				return
 			}
 
@@ -79,3 +106,40 @@ func ShopifySessionAuth(cfg config.Config, shopsRepo *shop.Repository) func(http
 }
 
 
+
+// recordStaffIdentity stores the name and email Shopify has for the staff member behind a session token. Failures
+// are only logged: the request goes on, and Touch asks again a few minutes later.
+func recordStaffIdentity(ctx context.Context, cfg config.Config, staffRepo StaffRecorder, s *shop.Shop, userID, sessionToken string) {
+	ex := shopify.OAuthExchanger{APIKey: cfg.Shopify.APIKey, APISecret: cfg.Shopify.APISecret}
+	u, err := ex.ExchangeSessionTokenForUser(ctx, s.Domain, sessionToken)
+	if err != nil {
+		log.Printf("staff identity lookup failed shop=%s user=%s err=%v", s.Domain, userID, err)
+		return
+	}
+	if strconv.FormatInt(u.ID, 10) != userID {
+		log.Printf("staff identity lookup returned another user shop=%s user=%s got=%d", s.Domain, userID, u.ID)
+		return
+	}
+	email := ""
+	if u.EmailVerified {
+		email = u.Email
+	}
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if err := staffRepo.SetIdentity(ctx, s.ID, userID, name, email); err != nil {
+		log.Printf("staff identity store failed shop=%s user=%s err=%v", s.Domain, userID, err)
+	}
+}
+
+// devStaff lets local tooling act as a specific staff member via X-Dev-Staff-User-Id, e.g. to exercise
+// two-person override approval without real session tokens. Only reachable from the non-prod fallback.
+func devStaff(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := strings.TrimSpace(r.Header.Get("X-Dev-Staff-User-Id"))
+		if userID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		st := &Staff{UserID: userID}
+		next.ServeHTTP(w, r.WithContext(WithStaff(r.Context(), st)))
+	})
+}
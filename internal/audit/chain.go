@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"microservice/pkg/db"
+)
+
+// chainVersion is hashed into every link so the canonical form can evolve without ambiguity.
+const chainVersion = 1
+
+// Link is the hashed content of one chained audit row.
+type Link struct {
+	ShopID    string
+	Seq       int64
+	ServiceID *string
+	Action    string
+	Actor     string
+	Metadata  json.RawMessage // canonical JSON or nil
+	CreatedAt time.Time
+	PrevHash  string
+}
+
+// Hash returns hex(sha256(canonical content)). The content includes PrevHash, which is what chains rows together.
+func (l Link) Hash() string {
+	var svc *string
+	if l.ServiceID != nil {
+		v := strings.ToLower(*l.ServiceID)
+		svc = &v
+	}
+	meta := l.Metadata
+	if len(meta) == 0 {
+		meta = json.RawMessage("null")
+	}
+
+	// Struct (not map) so field order is fixed.
+	content := struct {
+		V         int             `json:"v"`
+		ShopID    string          `json:"shopId"`
+		Seq       int64           `json:"seq"`
+		ServiceID *string         `json:"serviceId"`
+		Action    string          `json:"action"`
+		Actor     string          `json:"actor"`
+		Metadata  json.RawMessage `json:"metadata"`
+		CreatedAt string          `json:"createdAt"`
+		PrevHash  string          `json:"prevHash"`
+	}{
+		V:         chainVersion,
+		ShopID:    strings.ToLower(l.ShopID),
+		Seq:       l.Seq,
+		ServiceID: svc,
+		Action:    l.Action,
+		Actor:     l.Actor,
+		Metadata:  meta,
+		CreatedAt: l.CreatedAt.UTC().Format(time.RFC3339Nano),
+		PrevHash:  l.PrevHash,
+	}
+	b, _ := json.Marshal(content)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// CanonicalJSON re-encodes a JSON document with sorted object keys and no insignificant whitespace.
+// Postgres jsonb reorders keys and rewrites spacing, so both the writer and the verifier hash this form
+// rather than the bytes they happen to hold. Numbers keep their literal text.
+func CanonicalJSON(raw []byte) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// BrokenLink describes the first row where the chain no longer verifies.
+type BrokenLink struct {
+	Seq    int64  `json:"seq"`
+	ID     string `json:"id,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type VerifyResult struct {
+	ShopID      string      `json:"shopId"`
+	OK          bool        `json:"ok"`
+	Checked     int64       `json:"checked"`
+	Unchained   int64       `json:"unchained"` // rows written before the chain existed
+	HeadSeq     int64       `json:"headSeq"`
+	FirstBroken *BrokenLink `json:"firstBroken,omitempty"`
+}
+
+// VerifyChain walks a shop's chain in order, recomputing every hash, and reports the first broken link. It reads
+// the head and the rows from one snapshot, so entries written meanwhile neither break nor extend the check.
+func (r *Repository) VerifyChain(ctx context.Context, shopID string) (*VerifyResult, error) {
+	var res *VerifyResult
+	err := db.WithSnapshot(ctx, r.db, func(tx pgx.Tx) error {
+		var err error
+		res, err = verifyChain(ctx, tx, shopID)
+		return err
+	})
+	return res, err
+}
+
+func verifyChain(ctx context.Context, tx pgx.Tx, shopID string) (*VerifyResult, error) {
+	res := &VerifyResult{ShopID: shopID}
+
+	const qUnchained = `SELECT COUNT(*) FROM audit_logs WHERE shop_id = $1 AND chain_seq IS NULL`
+	if err := tx.QueryRow(ctx, qUnchained, shopID).Scan(&res.Unchained); err != nil {
+		return nil, err
+	}
+
+	var headHash string
+	const qHead = `SELECT last_seq, last_hash FROM audit_chain_heads WHERE shop_id = $1`
+	// No head yet: the chain is empty, and so must be the rows below.
+	if err := tx.QueryRow(ctx, qHead, shopID).Scan(&res.HeadSeq, &headHash); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	const q = `
+SELECT id, chain_seq, service_id::text, action, actor, metadata::text, created_at, COALESCE(prev_hash,''), COALESCE(hash,'')
+FROM audit_logs
+WHERE shop_id = $1 AND chain_seq IS NOT NULL
+ORDER BY chain_seq ASC
+`
+	rows, err := tx.Query(ctx, q, shopID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prev := ""
+	expected := int64(1)
+	for rows.Next() {
+		var (
+			id, action, actor, prevHash, hash string
+			seq                               int64
+			serviceID, meta                   *string
+			createdAt                         time.Time
+		)
+		if err := rows.Scan(&id, &seq, &serviceID, &action, &actor, &meta, &createdAt, &prevHash, &hash); err != nil {
+			return nil, err
+		}
+		res.Checked++
+
+		broken := func(reason string) (*VerifyResult, error) {
+			res.FirstBroken = &BrokenLink{Seq: seq, ID: id, Reason: reason}
+			return res, nil
+		}
+
+		if seq != expected {
+			res.FirstBroken = &BrokenLink{Seq: expected, Reason: "row missing from chain"}
+			return res, nil
+		}
+		if prevHash != prev {
+			return broken("prev_hash does not match previous row")
+		}
+		var canon json.RawMessage
+		if meta != nil {
+			canon, err = CanonicalJSON([]byte(*meta))
+			if err != nil {
+				return broken("metadata is not valid json")
+			}
+		}
+		link := Link{ShopID: shopID, Seq: seq, ServiceID: serviceID, Action: action, Actor: actor, Metadata: canon, CreatedAt: createdAt, PrevHash: prevHash}
+		if link.Hash() != hash {
+			return broken("row content does not match its hash")
+		}
+
+		prev = hash
+		expected++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	last := expected - 1
+	if last != res.HeadSeq || prev != headHash {
+		res.FirstBroken = &BrokenLink{Seq: last + 1, Reason: "chain head does not match last row (rows truncated?)"}
+		return res, nil
+	}
+
+	res.OK = true
+	return res, nil
+}
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCanonicalJSON_MatchesJSONBRendering(t *testing.T) {
+	// What Go writes vs. what Postgres hands back from a jsonb column.
+	written, _ := json.Marshal(map[string]any{"to": "Completed", "amount": "12.50", "from": "<none>", "seq": 3})
+	fromDB := []byte(`{"to": "Completed", "seq": 3, "from": "<none>", "amount": "12.50"}`)
+
+	a, err := CanonicalJSON(written)
+	if err != nil {
+		t.Fatalf("canonical written: %v", err)
+	}
+	b, err := CanonicalJSON(fromDB)
+	if err != nil {
+		t.Fatalf("canonical db: %v", err)
+	}
+	if string(a) != string(b) {
+		t.Fatalf("canonical forms differ:\n%s\n%s", a, b)
+	}
+}
+
+func TestLinkHash_CoversContentAndPrevHash(t *testing.T) {
+	svc := "5F1A6C3E-0000-4000-8000-000000000001"
+	base := Link{
+		ShopID:    "shop-1",
+		Seq:       7,
+		ServiceID: &svc,
+		Action:    "ADMIN_OVERRIDE",
+		Actor:     "merchant",
+		Metadata:  json.RawMessage(`{"reason":"paid in cash"}`),
+		CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 6000, time.UTC),
+		PrevHash:  "abc",
+	}
+	h := base.Hash()
+
+	// Same instant in another zone, and the lower-cased UUID Postgres returns, hash identically.
+	same := base
+	same.CreatedAt = base.CreatedAt.In(time.FixedZone("x", 3600))
+	lower := "5f1a6c3e-0000-4000-8000-000000000001"
+	same.ServiceID = &lower
+	if same.Hash() != h {
+		t.Fatalf("expected equal hash for equivalent content")
+	}
+
+	tampered := base
+	tampered.Metadata = json.RawMessage(`{"reason":"paid by card"}`)
+	if tampered.Hash() == h {
+		t.Fatalf("expected metadata change to change hash")
+	}
+
+	relinked := base
+	relinked.PrevHash = "abd"
+	if relinked.Hash() == h {
+		t.Fatalf("expected prev hash change to change hash")
+	}
+}
@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"microservice/internal/api"
+)
+
+type Handlers struct {
+	Repo *Repository
+}
+
+// List serves GET /v1/audit.
+//
+// Query params: serviceId, action, actor, from, to (RFC3339), cursor, limit, format=json|csv.
+// CSV ignores cursor/limit and streams every matching entry.
+func (h Handlers) List(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	q := r.URL.Query()
+	f := Filter{
+		ServiceID: strings.TrimSpace(q.Get("serviceId")),
+		Action:    strings.TrimSpace(q.Get("action")),
+		Actor:     strings.TrimSpace(q.Get("actor")),
+		Cursor:    strings.TrimSpace(q.Get("cursor")),
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		v := strings.TrimSpace(q.Get(p.name))
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", p.name+" must be RFC3339")
+			return
+		}
+		*p.dst = &t
+	}
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid limit")
+			return
+		}
+		f.Limit = n
+	}
+
+	if strings.EqualFold(q.Get("format"), "csv") {
+		h.writeCSV(w, r, s.ID, s.Domain, f)
+		return
+	}
+
+	items, next, err := h.Repo.List(r.Context(), s.ID, f)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCursor) {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid cursor")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if items == nil {
+		items = []Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": items, "nextCursor": next})
+}
+
+// Verify serves GET /v1/audit/verify: it walks the shop's audit hash chain and reports the first broken link.
+func (h Handlers) Verify(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	res, err := h.Repo.VerifyChain(r.Context(), s.ID)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func (h Handlers) writeCSV(w http.ResponseWriter, r *http.Request, shopID, shopDomain string, f Filter) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-`+time.Now().UTC().Format("20060102-150405")+`.csv"`)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "created_at", "action", "actor", "actor_name", "actor_email", "service_id", "changes", "metadata"})
+
+	f.Cursor = ""
+	f.Limit = MaxListLimit
+	for {
+		items, next, err := h.Repo.List(r.Context(), shopID, f)
+		if err != nil {
+			log.Printf("audit csv export failed shop=%s: %v", shopDomain, err)
+			break
+		}
+		for _, e := range items {
+			svc := ""
+			if e.ServiceID != nil {
+				svc = *e.ServiceID
+			}
+			changes := ""
+			if len(e.Changes) > 0 {
+				b, _ := json.Marshal(e.Changes)
+				changes = string(b)
+			}
+			_ = cw.Write([]string{e.ID, e.CreatedAt.UTC().Format(time.RFC3339Nano), e.Action, e.Actor, e.ActorName, e.ActorEmail, svc, changes, string(e.Metadata)})
+		}
+		cw.Flush()
+		if next == "" {
+			break
+		}
+		f.Cursor = next
+	}
+	cw.Flush()
+}
@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Entry struct {
+	ID        string  `json:"id"`
+	ShopID    string  `json:"shopId"`
+	ServiceID *string `json:"serviceId,omitempty"`
+	Action    string  `json:"action"`
+	Actor     string  `json:"actor"`
+	// ActorName/ActorEmail are resolved from staff_members for "staff:<userId>" actors when a verified name is known.
+	ActorName  string          `json:"actorName,omitempty"`
+	ActorEmail string          `json:"actorEmail,omitempty"`
+	Metadata   json.RawMessage `json:"metadata,omitempty"`
+	Changes    []FieldChange   `json:"changes,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// FieldChange is one before/after pair derived from an entry's metadata.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+type Filter struct {
+	ServiceID string
+	Action    string
+	Actor     string
+	From      *time.Time
+	To        *time.Time
+	Cursor    string
+	Limit     int
+}
+
+// ErrInvalidCursor is returned by List when the cursor was not produced by a previous page.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// List returns entries newest-first. The returned cursor is empty when there are no more pages.
+func (r *Repository) List(ctx context.Context, shopID string, f Filter) ([]Entry, string, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	where := []string{"a.shop_id = $1"}
+	args := []any{shopID}
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.ServiceID != "" {
+		add("a.service_id = $%d", f.ServiceID)
+	}
+	if f.Action != "" {
+		add("a.action = $%d", f.Action)
+	}
+	if f.Actor != "" {
+		add("a.actor = $%d", f.Actor)
+	}
+	if f.From != nil {
+		add("a.created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("a.created_at < $%d", *f.To)
+	}
+	if f.Cursor != "" {
+		at, id, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, at, id)
+		where = append(where, fmt.Sprintf("(a.created_at, a.id) < ($%d, $%d::uuid)", len(args)-1, len(args)))
+	}
+
+	// Fetch one extra row to know whether another page exists.
+	args = append(args, limit+1)
+	q := `
+SELECT a.id, a.shop_id, a.service_id, a.action, a.actor, COALESCE(sm.name,''), COALESCE(sm.email,''), a.metadata, a.created_at
+FROM audit_logs a
+LEFT JOIN staff_members sm ON sm.shop_id = a.shop_id AND a.actor = 'staff:' || sm.user_id
+WHERE ` + strings.Join(where, " AND ") + `
+ORDER BY a.created_at DESC, a.id DESC
+LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := r.db.Query(ctx, q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var out []Entry
+	for rows.Next() {
+		var e Entry
+		var meta []byte
+		if err := rows.Scan(&e.ID, &e.ShopID, &e.ServiceID, &e.Action, &e.Actor, &e.ActorName, &e.ActorEmail, &meta, &e.CreatedAt); err != nil {
+			return nil, "", err
+		}
+		if len(meta) > 0 {
+			e.Metadata = meta
+			e.Changes = ChangesFromMetadata(meta)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(out) > limit {
+		out = out[:limit]
+		last := out[len(out)-1]
+		next = encodeCursor(last.CreatedAt, last.ID)
+	}
+	return out, next, nil
+}
+
+// Diff builds the metadata keys understood by ChangesFromMetadata.
+// Callers merge the result into the metadata they pass to Insert.
+func Diff(before, after map[string]any) map[string]any {
+	return map[string]any{"before": before, "after": after}
+}
+
+// ChangesFromMetadata extracts field-level changes from metadata carrying "before"/"after" objects.
+// Entries without them (most non-mutating actions) yield nil.
+func ChangesFromMetadata(raw []byte) []FieldChange {
+	var m struct {
+		Before map[string]any `json:"before"`
+		After  map[string]any `json:"after"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil || (m.Before == nil && m.After == nil) {
+		return nil
+	}
+
+	keys := map[string]bool{}
+	for k := range m.Before {
+		keys[k] = true
+	}
+	for k := range m.After {
+		keys[k] = true
+	}
+	fields := make([]string, 0, len(keys))
+	for k := range keys {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+
+	var out []FieldChange
+	for _, k := range fields {
+		b, a := m.Before[k], m.After[k]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		out = append(out, FieldChange{Field: k, Before: b, After: a})
+	}
+	return out
+}
+
+func encodeCursor(at time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(at.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeCursor(c string) (time.Time, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(b), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return at, parts[1], nil
+}
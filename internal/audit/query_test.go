@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestChangesFromMetadata_OnlyChangedFields(t *testing.T) {
+	meta, _ := json.Marshal(map[string]any{
+		"reason": "client paid cash",
+		"before": map[string]any{"status": "Completed", "completedViaOverride": true},
+		"after":  map[string]any{"status": "InProgress", "completedViaOverride": true},
+	})
+
+	got := ChangesFromMetadata(meta)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 change, got %+v", got)
+	}
+	if got[0].Field != "status" || got[0].Before != "Completed" || got[0].After != "InProgress" {
+		t.Fatalf("unexpected change: %+v", got[0])
+	}
+}
+
+func TestChangesFromMetadata_NoDiffKeys(t *testing.T) {
+	if got := ChangesFromMetadata([]byte(`{"milestoneId":"abc"}`)); got != nil {
+		t.Fatalf("expected nil, got %+v", got)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 30, 0, 123456000, time.UTC)
+	c := encodeCursor(at, "0b6c1b8e-8b55-4d8e-9d0e-5a0c2f1d9b11")
+
+	gotAt, gotID, err := decodeCursor(c)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !gotAt.Equal(at) || gotID != "0b6c1b8e-8b55-4d8e-9d0e-5a0c2f1d9b11" {
+		t.Fatalf("round trip mismatch: %v %q", gotAt, gotID)
+	}
+	if _, _, err := decodeCursor("not-a-cursor"); err != ErrInvalidCursor {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
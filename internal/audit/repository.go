@@ -3,6 +3,7 @@ package audit
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -16,19 +17,69 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 	return &Repository{db: db}
 }
 
+// Insert appends an entry to the shop's audit chain.
+//
+// The shop's chain head row is locked for the rest of the transaction, which serializes audit writes per shop
+// and guarantees each row links to the one committed before it.
 func Insert(ctx context.Context, tx pgx.Tx, shopID string, serviceID *string, action, actor string, metadata any) error {
-	var s *string
+	var canon json.RawMessage
 	if metadata != nil {
-		b, _ := json.Marshal(metadata)
-		str := string(b)
+		b, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		if canon, err = CanonicalJSON(b); err != nil {
+			return err
+		}
+	}
+
+	const qEnsureHead = `
+INSERT INTO audit_chain_heads (shop_id)
+VALUES ($1)
+ON CONFLICT (shop_id) DO NOTHING
+`
+	if _, err := tx.Exec(ctx, qEnsureHead, shopID); err != nil {
+		return err
+	}
+
+	const qHead = `SELECT last_seq, last_hash FROM audit_chain_heads WHERE shop_id = $1 FOR UPDATE`
+	var lastSeq int64
+	var lastHash string
+	if err := tx.QueryRow(ctx, qHead, shopID).Scan(&lastSeq, &lastHash); err != nil {
+		return err
+	}
+
+	link := Link{
+		ShopID:    shopID,
+		Seq:       lastSeq + 1,
+		ServiceID: serviceID,
+		Action:    action,
+		Actor:     actor,
+		Metadata:  canon,
+		// Postgres keeps microseconds; truncate so the hashed timestamp is exactly what gets stored.
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		PrevHash:  lastHash,
+	}
+	hash := link.Hash()
+
+	var s *string
+	if canon != nil {
+		str := string(canon)
 		s = &str
 	}
 	const q = `
-INSERT INTO audit_logs (shop_id, service_id, action, actor, metadata)
-VALUES ($1, $2, $3, $4, CAST($5 AS jsonb))
+INSERT INTO audit_logs (shop_id, service_id, action, actor, metadata, created_at, chain_seq, prev_hash, hash)
+VALUES ($1, $2, $3, $4, CAST($5 AS jsonb), $6, $7, $8, $9)
+`
+	if _, err := tx.Exec(ctx, q, shopID, serviceID, action, actor, s, link.CreatedAt, link.Seq, link.PrevHash, hash); err != nil {
+		return err
+	}
+
+	const qAdvance = `
+UPDATE audit_chain_heads
+SET last_seq = $2, last_hash = $3, updated_at = NOW()
+WHERE shop_id = $1
 `
-	_, err := tx.Exec(ctx, q, shopID, serviceID, action, actor, s)
+	_, err := tx.Exec(ctx, qAdvance, shopID, link.Seq, hash)
 	return err
 }
-
-
@@ -101,6 +101,17 @@ func (h Handlers) Callback(w http.ResponseWriter, r *http.Request) {
 		if err := c.CreateWebhook(r.Context(), "orders/paid", base+"/v1/webhooks/shopify/orders_paid"); err != nil {
 			log.Printf("webhook register orders/paid failed shop=%s err=%v", shopDomain, err)
 		}
+		// Draft order lifecycle keeps milestone payment links in sync (deleted drafts, edited totals).
+		if err := c.CreateWebhook(r.Context(), "draft_orders/update", base+"/v1/webhooks/shopify/draft_orders_update"); err != nil {
+			log.Printf("webhook register draft_orders/update failed shop=%s err=%v", shopDomain, err)
+		}
+		if err := c.CreateWebhook(r.Context(), "draft_orders/delete", base+"/v1/webhooks/shopify/draft_orders_delete"); err != nil {
+			log.Printf("webhook register draft_orders/delete failed shop=%s err=%v", shopDomain, err)
+		}
+		// Service product configs of deleted products are flagged so admins can retire them.
+		if err := c.CreateWebhook(r.Context(), "products/delete", base+"/v1/webhooks/shopify/products_delete"); err != nil {
+			log.Printf("webhook register products/delete failed shop=%s err=%v", shopDomain, err)
+		}
 		if err := c.CreateWebhook(r.Context(), "app/uninstalled", base+"/v1/webhooks/shopify/app_uninstalled"); err != nil {
 			log.Printf("webhook register app/uninstalled failed shop=%s err=%v", shopDomain, err)
 		}
@@ -0,0 +1,207 @@
+// Package booking lets clients book a slot for their service from the merchant's weekly availability, and
+// reschedule or cancel it within the shop's rules.
+package booking
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	_ "time/tzdata" // shop timezones must resolve whatever zoneinfo the host has
+
+	"microservice/internal/milestone"
+)
+
+// Settings are a shop's availability and booking rules.
+type Settings struct {
+	// Timezone is the IANA zone window times and closed dates are in.
+	Timezone          string   `json:"timezone"`
+	MinNoticeHours    int      `json:"minNoticeHours"`    // how soon the earliest bookable slot starts
+	HorizonDays       int      `json:"horizonDays"`       // how far ahead slots can be booked
+	ChangeCutoffHours int      `json:"changeCutoffHours"` // clients cannot reschedule or cancel later than this before the slot
+	MaxReschedules    int      `json:"maxReschedules"`    // per booking, by the client
+	Windows           []Window `json:"windows"`
+	ClosedDates       []string `json:"closedDates"` // YYYY-MM-DD; no slots on these days
+
+	UpdatedBy string     `json:"updatedBy,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// Window is a weekly stretch of availability, split into back-to-back slots of SlotMinutes.
+type Window struct {
+	Weekday     int    `json:"weekday"` // 0 Sunday to 6 Saturday
+	Start       string `json:"start"`   // HH:MM
+	End         string `json:"end"`     // HH:MM, or 24:00 for midnight
+	SlotMinutes int    `json:"slotMinutes"`
+	Capacity    int    `json:"capacity"` // bookings each slot takes
+}
+
+// DefaultSettings are the rules of a shop that has not set up booking; without windows it offers no slots.
+func DefaultSettings() Settings {
+	return Settings{
+		Timezone: "UTC", MinNoticeHours: 24, HorizonDays: 60, ChangeCutoffHours: 24, MaxReschedules: 2,
+		Windows: []Window{}, ClosedDates: []string{},
+	}
+}
+
+// Limits on what a merchant can configure.
+const (
+	maxWindows     = 50
+	maxClosedDates = 366
+	maxCapacity    = 1000
+	minSlotMinutes = 5
+	maxHorizonDays = 365
+)
+
+// Validate checks the settings and the windows, which must not overlap on the same weekday so that every slot
+// start belongs to exactly one window. Errors are milestone.ValidationErrors.
+func (s Settings) Validate() error {
+	if _, err := time.LoadLocation(s.Timezone); err != nil || s.Timezone == "" || s.Timezone == "Local" {
+		return milestone.ValidationError{Code: "TIMEZONE_INVALID", Message: "timezone must be an IANA timezone such as Europe/Berlin"}
+	}
+	if s.MinNoticeHours < 0 || s.ChangeCutoffHours < 0 || s.MaxReschedules < 0 {
+		return milestone.ValidationError{Code: "BOOKING_RULES_INVALID", Message: "minNoticeHours, changeCutoffHours and maxReschedules must be >= 0"}
+	}
+	if s.HorizonDays < 1 || s.HorizonDays > maxHorizonDays {
+		return milestone.ValidationError{Code: "BOOKING_RULES_INVALID", Message: fmt.Sprintf("horizonDays must be 1 to %d", maxHorizonDays)}
+	}
+	if len(s.Windows) > maxWindows {
+		return milestone.ValidationError{Code: "AVAILABILITY_INVALID", Message: fmt.Sprintf("at most %d windows", maxWindows)}
+	}
+	if len(s.ClosedDates) > maxClosedDates {
+		return milestone.ValidationError{Code: "AVAILABILITY_INVALID", Message: fmt.Sprintf("at most %d closed dates", maxClosedDates)}
+	}
+	for _, d := range s.ClosedDates {
+		if _, err := time.Parse(time.DateOnly, d); err != nil {
+			return milestone.ValidationError{Code: "AVAILABILITY_INVALID", Message: "closed dates must be YYYY-MM-DD"}
+		}
+	}
+
+	type span struct{ start, end int }
+	byDay := map[int][]span{}
+	for _, w := range s.Windows {
+		start, end, err := w.minutes()
+		if err != nil {
+			return err
+		}
+		if w.Weekday < 0 || w.Weekday > 6 {
+			return milestone.ValidationError{Code: "AVAILABILITY_INVALID", Message: "weekday must be 0 (Sunday) to 6 (Saturday)"}
+		}
+		if w.SlotMinutes < minSlotMinutes || w.SlotMinutes > end-start {
+			return milestone.ValidationError{Code: "AVAILABILITY_INVALID", Message: fmt.Sprintf("slotMinutes must be at least %d and fit in the window", minSlotMinutes)}
+		}
+		if w.Capacity < 1 || w.Capacity > maxCapacity {
+			return milestone.ValidationError{Code: "AVAILABILITY_INVALID", Message: fmt.Sprintf("capacity must be 1 to %d", maxCapacity)}
+		}
+		byDay[w.Weekday] = append(byDay[w.Weekday], span{start, end})
+	}
+	for _, spans := range byDay {
+		sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+		for i := 1; i < len(spans); i++ {
+			if spans[i].start < spans[i-1].end {
+				return milestone.ValidationError{Code: "AVAILABILITY_INVALID", Message: "windows on the same weekday must not overlap"}
+			}
+		}
+	}
+	return nil
+}
+
+// minutes parses the window's times as minutes after midnight.
+func (w Window) minutes() (start, end int, err error) {
+	start, okStart := parseClock(w.Start)
+	end, okEnd := parseClock(w.End)
+	if !okStart || !okEnd || end <= start {
+		return 0, 0, milestone.ValidationError{Code: "AVAILABILITY_INVALID", Message: "window times must be HH:MM with end after start"}
+	}
+	return start, end, nil
+}
+
+func parseClock(s string) (int, bool) {
+	var h, m int
+	if len(s) != 5 || s[2] != ':' {
+		return 0, false
+	}
+	if _, err := fmt.Sscanf(s, "%02d:%02d", &h, &m); err != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m > 0) {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+// Slot is a bookable start time. Start and End are instants; Local is Start in the timezone it was listed for.
+type Slot struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Local     string    `json:"local"`
+	Capacity  int       `json:"capacity"`
+	Remaining int       `json:"remaining"`
+}
+
+// Slots lists the slots starting in [from, to), in order. Window times are wall-clock times, so a 09:00 slot
+// stays at 09:00 local time across daylight saving changes; a slot whose local start does not exist that day
+// moves with the clock change. taken counts the active bookings per slot start (Unix seconds); full slots are
+// listed with Remaining 0. The settings must be valid.
+func (s Settings) Slots(from, to time.Time, taken map[int64]int) []Slot {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil
+	}
+	closed := map[string]bool{}
+	for _, d := range s.ClosedDates {
+		closed[d] = true
+	}
+
+	var out []Slot
+	first := from.In(loc)
+	day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+	for ; day.Before(to); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc) {
+		if closed[day.Format(time.DateOnly)] {
+			continue
+		}
+		for _, w := range s.Windows {
+			if w.Weekday != int(day.Weekday()) {
+				continue
+			}
+			wStart, wEnd, err := w.minutes()
+			if err != nil {
+				continue
+			}
+			for m := wStart; m+w.SlotMinutes <= wEnd; m += w.SlotMinutes {
+				start := time.Date(day.Year(), day.Month(), day.Day(), 0, m, 0, 0, loc)
+				if start.Before(from) || !start.Before(to) {
+					continue
+				}
+				remaining := w.Capacity - taken[start.Unix()]
+				if remaining < 0 {
+					remaining = 0
+				}
+				out = append(out, Slot{
+					Start: start.UTC(), End: start.Add(time.Duration(w.SlotMinutes) * time.Minute).UTC(),
+					Local: start.Format(time.RFC3339), Capacity: w.Capacity, Remaining: remaining,
+				})
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// SlotAt finds the slot starting exactly at start, whether or not it has room left.
+func (s Settings) SlotAt(start time.Time) (Slot, bool) {
+	for _, slot := range s.Slots(start, start.Add(time.Second), nil) {
+		if slot.Start.Equal(start) {
+			return slot, true
+		}
+	}
+	return Slot{}, false
+}
+
+// Bookable is the range clients can book slots in at now: from the minimum notice to the horizon.
+func (s Settings) Bookable(now time.Time) (from, to time.Time) {
+	return now.Add(time.Duration(s.MinNoticeHours) * time.Hour), now.AddDate(0, 0, s.HorizonDays)
+}
+
+// InTimezone re-labels the slots' Local times in loc.
+func InTimezone(slots []Slot, loc *time.Location) {
+	for i := range slots {
+		slots[i].Local = slots[i].Start.In(loc).Format(time.RFC3339)
+	}
+}
@@ -0,0 +1,150 @@
+package booking
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"microservice/internal/milestone"
+)
+
+func weekdays(tz string, w Window) Settings {
+	s := DefaultSettings()
+	s.Timezone = tz
+	s.Windows = []Window{w}
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	ok := Window{Weekday: 1, Start: "09:00", End: "12:00", SlotMinutes: 60, Capacity: 2}
+	cases := []struct {
+		name string
+		edit func(*Settings)
+		code string
+	}{
+		{"valid", func(*Settings) {}, ""},
+		{"midnight end", func(s *Settings) { s.Windows[0].End = "24:00" }, ""},
+		{"bad timezone", func(s *Settings) { s.Timezone = "Mars/Base" }, "TIMEZONE_INVALID"},
+		{"bad clock", func(s *Settings) { s.Windows[0].Start = "9:00" }, "AVAILABILITY_INVALID"},
+		{"end before start", func(s *Settings) { s.Windows[0].End = "08:00" }, "AVAILABILITY_INVALID"},
+		{"slot longer than window", func(s *Settings) { s.Windows[0].SlotMinutes = 240 }, "AVAILABILITY_INVALID"},
+		{"no capacity", func(s *Settings) { s.Windows[0].Capacity = 0 }, "AVAILABILITY_INVALID"},
+		{"bad weekday", func(s *Settings) { s.Windows[0].Weekday = 7 }, "AVAILABILITY_INVALID"},
+		{"overlap", func(s *Settings) {
+			s.Windows = append(s.Windows, Window{Weekday: 1, Start: "11:00", End: "13:00", SlotMinutes: 30, Capacity: 1})
+		}, "AVAILABILITY_INVALID"},
+		{"back to back", func(s *Settings) {
+			s.Windows = append(s.Windows, Window{Weekday: 1, Start: "12:00", End: "13:00", SlotMinutes: 30, Capacity: 1})
+		}, ""},
+		{"bad closed date", func(s *Settings) { s.ClosedDates = []string{"2026-13-01"} }, "AVAILABILITY_INVALID"},
+		{"zero horizon", func(s *Settings) { s.HorizonDays = 0 }, "BOOKING_RULES_INVALID"},
+	}
+	for _, tc := range cases {
+		s := weekdays("Europe/Berlin", ok)
+		tc.edit(&s)
+		err := s.Validate()
+		var ve milestone.ValidationError
+		switch {
+		case tc.code == "" && err != nil:
+			t.Fatalf("%s: unexpected error %v", tc.name, err)
+		case tc.code != "" && (!errors.As(err, &ve) || ve.Code != tc.code):
+			t.Fatalf("%s: expected %s, got %v", tc.name, tc.code, err)
+		}
+	}
+}
+
+func TestSlots_CapacityAndClosedDates(t *testing.T) {
+	// Mondays 09:00-11:00 in Berlin, 45 minute slots: 09:00 and 09:45 (10:30 would end after the window).
+	s := weekdays("Europe/Berlin", Window{Weekday: 1, Start: "09:00", End: "11:00", SlotMinutes: 45, Capacity: 2})
+	s.ClosedDates = []string{"2026-11-09"}
+	from := time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 15)
+
+	first := time.Date(2026, 11, 2, 8, 0, 0, 0, time.UTC) // 09:00 CET
+	slots := s.Slots(from, to, map[int64]int{first.Unix(): 2})
+	if len(slots) != 4 {
+		t.Fatalf("expected 2 Mondays x 2 slots (9 Nov closed), got %d: %+v", len(slots), slots)
+	}
+	if !slots[0].Start.Equal(first) || slots[0].Remaining != 0 || slots[1].Remaining != 2 {
+		t.Fatalf("unexpected first slots: %+v", slots[:2])
+	}
+	if got := slots[2].Start; !got.Equal(time.Date(2026, 11, 16, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the next slot on 16 Nov, got %v", got)
+	}
+	if slots[1].Local != "2026-11-02T09:45:00+01:00" {
+		t.Fatalf("unexpected local time %q", slots[1].Local)
+	}
+}
+
+func TestSlots_WallClockAcrossDST(t *testing.T) {
+	// Europe/Berlin leaves summer time on 25 Oct 2026; the 09:00 slot moves from 07:00 to 08:00 UTC.
+	s := weekdays("Europe/Berlin", Window{Weekday: 1, Start: "09:00", End: "10:00", SlotMinutes: 60, Capacity: 1})
+	slots := s.Slots(time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 27, 0, 0, 0, 0, time.UTC), nil)
+	if len(slots) != 2 {
+		t.Fatalf("expected two Mondays, got %+v", slots)
+	}
+	if slots[0].Start.Hour() != 7 || slots[1].Start.Hour() != 8 {
+		t.Fatalf("expected 07:00 and 08:00 UTC, got %v and %v", slots[0].Start, slots[1].Start)
+	}
+}
+
+func TestSlotAt(t *testing.T) {
+	s := weekdays("America/New_York", Window{Weekday: 3, Start: "14:00", End: "16:00", SlotMinutes: 30, Capacity: 1})
+	start := time.Date(2026, 11, 4, 19, 30, 0, 0, time.UTC) // Wed 14:30 EST
+	if slot, ok := s.SlotAt(start); !ok || !slot.End.Equal(start.Add(30*time.Minute)) {
+		t.Fatalf("expected the 14:30 slot, got %+v %v", slot, ok)
+	}
+	if _, ok := s.SlotAt(start.Add(10 * time.Minute)); ok {
+		t.Fatal("expected no slot starting off the grid")
+	}
+}
+
+func TestInTimezone(t *testing.T) {
+	slots := []Slot{{Start: time.Date(2026, 11, 2, 8, 0, 0, 0, time.UTC)}}
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	InTimezone(slots, tokyo)
+	if slots[0].Local != "2026-11-02T17:00:00+09:00" {
+		t.Fatalf("unexpected local time %q", slots[0].Local)
+	}
+}
+
+func TestChangeRules(t *testing.T) {
+	s := weekdays("UTC", Window{Weekday: 1, Start: "09:00", End: "10:00", SlotMinutes: 60, Capacity: 1})
+	now := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	b := &Booking{Start: now.Add(20 * time.Hour)}
+
+	if err := s.checkChange(b, "client", now); !errors.Is(err, ErrChangeClosed) {
+		t.Fatalf("expected the 24h cutoff to apply to the client, got %v", err)
+	}
+	if err := s.checkChange(b, "staff:1", now); err != nil {
+		t.Fatalf("expected the merchant to be exempt, got %v", err)
+	}
+
+	b.Start = now.Add(72 * time.Hour)
+	b.Reschedules = s.MaxReschedules
+	if err := s.checkReschedule(b, "client", now); !errors.Is(err, ErrRescheduleLimited) {
+		t.Fatalf("expected the reschedule limit, got %v", err)
+	}
+	if err := s.checkReschedule(b, "staff:1", now); err != nil {
+		t.Fatalf("expected the merchant to be exempt, got %v", err)
+	}
+}
+
+func TestCheckSlot(t *testing.T) {
+	s := weekdays("UTC", Window{Weekday: 1, Start: "09:00", End: "10:00", SlotMinutes: 60, Capacity: 1})
+	now := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC) // Sunday
+	tomorrow := time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC)
+
+	if _, err := s.checkSlot(tomorrow, "client", now); !errors.Is(err, ErrSlotUnavailable) {
+		t.Fatalf("expected the minimum notice to apply to the client, got %v", err)
+	}
+	if _, err := s.checkSlot(tomorrow, "staff:1", now); err != nil {
+		t.Fatalf("expected the merchant to book within the notice, got %v", err)
+	}
+	if _, err := s.checkSlot(tomorrow.AddDate(0, 0, 7), "client", now); err != nil {
+		t.Fatalf("expected next week's slot to be bookable, got %v", err)
+	}
+	if _, err := s.checkSlot(tomorrow.AddDate(0, 0, 7*10), "client", now); !errors.Is(err, ErrSlotUnavailable) {
+		t.Fatalf("expected slots past the horizon to be refused, got %v", err)
+	}
+}
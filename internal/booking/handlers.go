@@ -0,0 +1,207 @@
+package booking
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/api"
+	"microservice/internal/ical"
+	"microservice/internal/milestone"
+	"microservice/internal/service"
+	"microservice/pkg/db"
+)
+
+type Handlers struct {
+	DB       *pgxpool.Pool
+	Repo     *Repository
+	Services *service.Repository
+}
+
+// WriteError writes the response for the booking errors; it reports whether err was one of them.
+func WriteError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, ErrServiceClosed):
+		api.WriteError(w, http.StatusConflict, "SERVICE_COMPLETED", err.Error())
+	case errors.Is(err, ErrDepositUnpaid):
+		api.WriteError(w, http.StatusConflict, "DEPOSIT_UNPAID", err.Error())
+	case errors.Is(err, ErrAlreadyBooked):
+		api.WriteError(w, http.StatusConflict, "ALREADY_BOOKED", err.Error())
+	case errors.Is(err, ErrNotBooked):
+		api.WriteError(w, http.StatusConflict, "NOT_BOOKED", err.Error())
+	case errors.Is(err, ErrSlotUnavailable):
+		api.WriteError(w, http.StatusConflict, "SLOT_UNAVAILABLE", err.Error())
+	case errors.Is(err, ErrSlotFull):
+		api.WriteError(w, http.StatusConflict, "SLOT_FULL", err.Error())
+	case errors.Is(err, ErrChangeClosed):
+		api.WriteError(w, http.StatusConflict, "BOOKING_CHANGE_CLOSED", err.Error())
+	case errors.Is(err, ErrRescheduleLimited):
+		api.WriteError(w, http.StatusConflict, "RESCHEDULE_LIMIT_REACHED", err.Error())
+	default:
+		return false
+	}
+	return true
+}
+
+// SlotRequest picks a slot by its start instant, as listed.
+type SlotRequest struct {
+	Start time.Time `json:"start"`
+}
+
+type CancelRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Calendar is the booking as a one-event calendar, the attachment clients add to their own calendar.
+// A cancelled booking is written as a cancelled event so the entry is removed again.
+func Calendar(b *Booking, summary, description string) ical.Calendar {
+	return ical.Calendar{Events: []ical.Event{Event(b, summary, description)}}
+}
+
+// Event is the booking as a calendar event.
+func Event(b *Booking, summary, description string) ical.Event {
+	return ical.Event{
+		UID: b.ID + "@booking", Sequence: b.Sequence, Summary: summary, Description: description,
+		Start: b.Start, End: b.End, Cancelled: b.Status == StatusCancelled, Updated: b.UpdatedAt,
+	}
+}
+
+// GetAvailability returns the shop's availability and booking rules.
+func (h Handlers) GetAvailability(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	settings, err := h.Repo.Settings(r.Context(), s.ID)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(settings)
+}
+
+// PutAvailability replaces the shop's availability and booking rules. Existing bookings keep their slot.
+func (h Handlers) PutAvailability(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	req := DefaultSettings()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+		return
+	}
+	if req.Windows == nil {
+		req.Windows = []Window{}
+	}
+	if req.ClosedDates == nil {
+		req.ClosedDates = []string{}
+	}
+	if err := req.Validate(); err != nil {
+		var ve milestone.ValidationError
+		errors.As(err, &ve)
+		api.WriteError(w, http.StatusBadRequest, ve.Code, ve.Message)
+		return
+	}
+
+	settings, err := h.Repo.PutSettings(r.Context(), s.ID, req, api.Actor(r.Context()))
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(settings)
+}
+
+// Get returns the service's booking: the active one, else the one cancelled last.
+func (h Handlers) Get(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	svc, err := h.Services.GetByID(r.Context(), s.ID, chi.URLParam(r, "id"))
+	if err != nil {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+		return
+	}
+	b, err := h.Repo.GetByService(r.Context(), svc.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service has no booking")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(b)
+}
+
+// Reschedule moves the service's booking to another slot on the merchant's behalf. The client's cutoff and
+// reschedule limit do not apply, but the slot must exist and have room.
+func (h Handlers) Reschedule(w http.ResponseWriter, r *http.Request) {
+	var req SlotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Start.IsZero() {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "start is required")
+		return
+	}
+	h.change(w, r, func(tx pgx.Tx, svc *service.Service) (*Booking, error) {
+		return Reschedule(r.Context(), tx, svc, req.Start, api.Actor(r.Context()), time.Now())
+	})
+}
+
+// Cancel cancels the service's booking on the merchant's behalf, at any time.
+func (h Handlers) Cancel(w http.ResponseWriter, r *http.Request) {
+	var req CancelRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	h.change(w, r, func(tx pgx.Tx, svc *service.Service) (*Booking, error) {
+		return Cancel(r.Context(), tx, svc, req.Reason, api.Actor(r.Context()), time.Now())
+	})
+}
+
+func (h Handlers) change(w http.ResponseWriter, r *http.Request, apply func(pgx.Tx, *service.Service) (*Booking, error)) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var b *Booking
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		svc, err := service.GetForUpdate(r.Context(), tx, s.ID, chi.URLParam(r, "id"))
+		if err != nil {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+			return pgx.ErrTxCommitRollback
+		}
+		b, err = apply(tx, svc)
+		if WriteError(w, err) {
+			return pgx.ErrTxCommitRollback
+		}
+		return err
+	})
+	if err == pgx.ErrTxCommitRollback {
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(b)
+}
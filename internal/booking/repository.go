@@ -0,0 +1,468 @@
+package booking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/internal/service"
+	"microservice/pkg/db"
+)
+
+const (
+	StatusBooked    = "booked"
+	StatusCancelled = "cancelled"
+)
+
+var (
+	ErrServiceClosed     = errors.New("service is completed")
+	ErrDepositUnpaid     = errors.New("the deposit must be paid before booking")
+	ErrAlreadyBooked     = errors.New("service already has a booking")
+	ErrNotBooked         = errors.New("service has no booking")
+	ErrSlotUnavailable   = errors.New("slot is not available")
+	ErrSlotFull          = errors.New("slot is fully booked")
+	ErrChangeClosed      = errors.New("booking can no longer be changed")
+	ErrRescheduleLimited = errors.New("booking cannot be rescheduled again")
+)
+
+// Booking is the slot booked for a service. ID and Sequence identify it in calendars: a reschedule keeps the ID
+// and bumps Sequence, so the calendar entry moves instead of being duplicated.
+type Booking struct {
+	ID           string     `json:"id"`
+	ServiceID    string     `json:"serviceId"`
+	Start        time.Time  `json:"start"`
+	End          time.Time  `json:"end"`
+	Timezone     string     `json:"timezone"`
+	Status       string     `json:"status"`
+	Sequence     int        `json:"sequence"`
+	Reschedules  int        `json:"reschedules"`
+	CancelledAt  *time.Time `json:"cancelledAt,omitempty"`
+	CancelledBy  string     `json:"cancelledBy,omitempty"`
+	CancelReason string     `json:"cancelReason,omitempty"`
+	CreatedBy    string     `json:"createdBy"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// Label is the booked start in the booking's timezone, for timeline summaries.
+func (b *Booking) Label() string {
+	return label(b.Start, b.Timezone)
+}
+
+func label(t time.Time, tz string) string {
+	if loc, err := time.LoadLocation(tz); err == nil {
+		t = t.In(loc)
+	}
+	return fmt.Sprintf("%s (%s)", t.Format("Mon 2 Jan 2006 15:04"), tz)
+}
+
+// clientRules reports whether the change rules apply to actor. The merchant can move or cancel a booking at
+// any time; clients only before the cutoff, and only MaxReschedules times.
+func clientRules(actor string) bool {
+	return actor == "client"
+}
+
+// checkChange applies the change cutoff to a client changing b at now.
+func (s Settings) checkChange(b *Booking, actor string, now time.Time) error {
+	if clientRules(actor) && now.Add(time.Duration(s.ChangeCutoffHours)*time.Hour).After(b.Start) {
+		return ErrChangeClosed
+	}
+	return nil
+}
+
+// checkReschedule applies the cutoff and the reschedule limit to a client moving b at now.
+func (s Settings) checkReschedule(b *Booking, actor string, now time.Time) error {
+	if err := s.checkChange(b, actor, now); err != nil {
+		return err
+	}
+	if clientRules(actor) && b.Reschedules >= s.MaxReschedules {
+		return ErrRescheduleLimited
+	}
+	return nil
+}
+
+// checkSlot finds the slot starting at start. Clients can only pick slots within the bookable range; the
+// merchant can use any future slot.
+func (s Settings) checkSlot(start time.Time, actor string, now time.Time) (Slot, error) {
+	slot, ok := s.SlotAt(start)
+	if !ok || !start.After(now) {
+		return Slot{}, ErrSlotUnavailable
+	}
+	if from, to := s.Bookable(now); clientRules(actor) && (start.Before(from) || start.After(to)) {
+		return Slot{}, ErrSlotUnavailable
+	}
+	return slot, nil
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const selectSettings = `
+SELECT timezone, min_notice_hours, horizon_days, change_cutoff_hours, max_reschedules, windows, closed_dates,
+       updated_by, updated_at
+FROM booking_settings
+WHERE shop_id = $1
+`
+
+func scanSettings(row pgx.Row) (*Settings, error) {
+	var s Settings
+	var windows []byte
+	var updatedAt time.Time
+	if err := row.Scan(&s.Timezone, &s.MinNoticeHours, &s.HorizonDays, &s.ChangeCutoffHours, &s.MaxReschedules,
+		&windows, &s.ClosedDates, &s.UpdatedBy, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(windows, &s.Windows); err != nil {
+		return nil, err
+	}
+	if s.ClosedDates == nil {
+		s.ClosedDates = []string{}
+	}
+	s.UpdatedAt = &updatedAt
+	return &s, nil
+}
+
+// Settings returns the shop's booking settings, or DefaultSettings when it has none.
+func (r *Repository) Settings(ctx context.Context, shopID string) (*Settings, error) {
+	s, err := scanSettings(r.db.QueryRow(ctx, selectSettings, shopID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		def := DefaultSettings()
+		return &def, nil
+	}
+	return s, err
+}
+
+// lockSettings loads the settings and locks them, serializing bookings of the shop. A shop without settings
+// has no availability: pgx.ErrNoRows.
+func lockSettings(ctx context.Context, tx pgx.Tx, shopID string) (*Settings, error) {
+	return scanSettings(tx.QueryRow(ctx, selectSettings+"FOR UPDATE", shopID))
+}
+
+// PutSettings saves the shop's booking settings, which must be valid. Existing bookings are kept even when
+// their slot is no longer offered.
+func (r *Repository) PutSettings(ctx context.Context, shopID string, s Settings, actor string) (*Settings, error) {
+	windows, err := json.Marshal(s.Windows)
+	if err != nil {
+		return nil, err
+	}
+	var out *Settings
+	err = db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		const q = `
+INSERT INTO booking_settings (shop_id, timezone, min_notice_hours, horizon_days, change_cutoff_hours, max_reschedules, windows, closed_dates, updated_by, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+ON CONFLICT (shop_id) DO UPDATE SET
+  timezone = EXCLUDED.timezone,
+  min_notice_hours = EXCLUDED.min_notice_hours,
+  horizon_days = EXCLUDED.horizon_days,
+  change_cutoff_hours = EXCLUDED.change_cutoff_hours,
+  max_reschedules = EXCLUDED.max_reschedules,
+  windows = EXCLUDED.windows,
+  closed_dates = EXCLUDED.closed_dates,
+  updated_by = EXCLUDED.updated_by,
+  updated_at = NOW()
+RETURNING timezone, min_notice_hours, horizon_days, change_cutoff_hours, max_reschedules, windows, closed_dates,
+          updated_by, updated_at
+`
+		var err error
+		out, err = scanSettings(tx.QueryRow(ctx, q, shopID, s.Timezone, s.MinNoticeHours, s.HorizonDays,
+			s.ChangeCutoffHours, s.MaxReschedules, windows, s.ClosedDates, actor))
+		if err != nil {
+			return err
+		}
+		return audit.Insert(ctx, tx, shopID, nil, "BOOKING_AVAILABILITY_SAVED", actor, map[string]any{
+			"timezone": s.Timezone, "windows": len(s.Windows), "closedDates": len(s.ClosedDates),
+		})
+	})
+	return out, err
+}
+
+// AvailableSlots lists the slots clients of the shop can book at now that still have room.
+func (r *Repository) AvailableSlots(ctx context.Context, shopID string, now time.Time) ([]Slot, *Settings, error) {
+	s, err := r.Settings(ctx, shopID)
+	if err != nil {
+		return nil, nil, err
+	}
+	from, to := s.Bookable(now)
+	taken, err := countTaken(ctx, r.db, shopID, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := []Slot{}
+	for _, slot := range s.Slots(from, to, taken) {
+		if slot.Remaining > 0 {
+			out = append(out, slot)
+		}
+	}
+	return out, s, nil
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// countTaken counts the active bookings per slot start (Unix seconds) in [from, to).
+func countTaken(ctx context.Context, q querier, shopID string, from, to time.Time) (map[int64]int, error) {
+	const sql = `
+SELECT slot_start, COUNT(*) FROM bookings
+WHERE shop_id = $1 AND status = 'booked' AND slot_start >= $2 AND slot_start < $3
+GROUP BY slot_start
+`
+	rows, err := q.Query(ctx, sql, shopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taken := map[int64]int{}
+	for rows.Next() {
+		var start time.Time
+		var n int
+		if err := rows.Scan(&start, &n); err != nil {
+			return nil, err
+		}
+		taken[start.Unix()] = n
+	}
+	return taken, rows.Err()
+}
+
+const bookingColumns = `
+id, service_id, slot_start, slot_end, timezone, status, sequence, reschedule_count, cancelled_at,
+COALESCE(cancelled_by,''), COALESCE(cancel_reason,''), created_by, created_at, updated_at
+`
+
+func scanBooking(row pgx.Row) (*Booking, error) {
+	var b Booking
+	if err := row.Scan(&b.ID, &b.ServiceID, &b.Start, &b.End, &b.Timezone, &b.Status, &b.Sequence, &b.Reschedules,
+		&b.CancelledAt, &b.CancelledBy, &b.CancelReason, &b.CreatedBy, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetByService returns the service's active booking or, when it has none, the one cancelled last;
+// pgx.ErrNoRows when it was never booked.
+func (r *Repository) GetByService(ctx context.Context, serviceID string) (*Booking, error) {
+	const q = `SELECT` + bookingColumns + `
+FROM bookings
+WHERE service_id = $1
+ORDER BY (status = 'booked') DESC, updated_at DESC
+LIMIT 1
+`
+	return scanBooking(r.db.QueryRow(ctx, q, serviceID))
+}
+
+func getActiveForUpdate(ctx context.Context, tx pgx.Tx, serviceID string) (*Booking, error) {
+	const q = `SELECT` + bookingColumns + `FROM bookings WHERE service_id = $1 AND status = 'booked' FOR UPDATE`
+	b, err := scanBooking(tx.QueryRow(ctx, q, serviceID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotBooked
+	}
+	return b, err
+}
+
+// depositPaid reports whether the service's deposit (milestone 0) is settled.
+func depositPaid(ctx context.Context, tx pgx.Tx, serviceID string) (bool, error) {
+	const q = `SELECT status FROM milestones WHERE service_id = $1 AND sequence = 0`
+	var status string
+	err := tx.QueryRow(ctx, q, serviceID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return milestone.IsSettled(status), err
+}
+
+// reserve checks that the slot starting at start can take another booking, with the shop's settings locked.
+func reserve(ctx context.Context, tx pgx.Tx, s *Settings, shopID string, start time.Time, actor string, now time.Time) (Slot, error) {
+	slot, err := s.checkSlot(start, actor, now)
+	if err != nil {
+		return Slot{}, err
+	}
+	taken, err := countTaken(ctx, tx, shopID, slot.Start, slot.Start.Add(time.Second))
+	if err != nil {
+		return Slot{}, err
+	}
+	if taken[slot.Start.Unix()] >= slot.Capacity {
+		return Slot{}, ErrSlotFull
+	}
+	return slot, nil
+}
+
+// Book books the slot starting at start for the locked service svc. The deposit must be paid and the service
+// must not have a booking already.
+func Book(ctx context.Context, tx pgx.Tx, svc *service.Service, start time.Time, actor string, now time.Time) (*Booking, error) {
+	if svc.Status == service.StatusCompleted {
+		return nil, ErrServiceClosed
+	}
+	paid, err := depositPaid(ctx, tx, svc.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !paid {
+		return nil, ErrDepositUnpaid
+	}
+	s, err := lockSettings(ctx, tx, svc.ShopID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSlotUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := getActiveForUpdate(ctx, tx, svc.ID); err == nil {
+		return nil, ErrAlreadyBooked
+	} else if !errors.Is(err, ErrNotBooked) {
+		return nil, err
+	}
+	slot, err := reserve(ctx, tx, s, svc.ShopID, start, actor, now)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `
+INSERT INTO bookings (shop_id, service_id, slot_start, slot_end, timezone, created_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING` + bookingColumns
+	b, err := scanBooking(tx.QueryRow(ctx, q, svc.ShopID, svc.ID, slot.Start, slot.End, s.Timezone, actor))
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]any{"bookingId": b.ID, "start": b.Start, "end": b.End, "timezone": b.Timezone}
+	svcID := svc.ID
+	if err := audit.Insert(ctx, tx, svc.ShopID, &svcID, "BOOKED_SLOT", actor, data); err != nil {
+		return nil, err
+	}
+	if err := events.Insert(ctx, tx, svc.ID, "BOOKED_SLOT", "Slot booked for "+b.Label(), actor, now, data); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reschedule moves the service's booking to the slot starting at start.
+func Reschedule(ctx context.Context, tx pgx.Tx, svc *service.Service, start time.Time, actor string, now time.Time) (*Booking, error) {
+	s, err := lockSettings(ctx, tx, svc.ShopID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSlotUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, err := getActiveForUpdate(ctx, tx, svc.ID)
+	if err != nil {
+		return nil, err
+	}
+	if b.Start.Equal(start) {
+		return b, nil
+	}
+	if err := s.checkReschedule(b, actor, now); err != nil {
+		return nil, err
+	}
+	slot, err := reserve(ctx, tx, s, svc.ShopID, start, actor, now)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `
+UPDATE bookings
+SET slot_start = $2, slot_end = $3, timezone = $4, sequence = sequence + 1,
+    reschedule_count = reschedule_count + CASE WHEN $5 THEN 1 ELSE 0 END, updated_at = NOW()
+WHERE id = $1
+RETURNING` + bookingColumns
+	from := *b
+	b, err = scanBooking(tx.QueryRow(ctx, q, b.ID, slot.Start, slot.End, s.Timezone, clientRules(actor)))
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]any{
+		"bookingId": b.ID, "fromStart": from.Start, "start": b.Start, "end": b.End, "timezone": b.Timezone,
+		"reschedules": b.Reschedules,
+	}
+	svcID := svc.ID
+	if err := audit.Insert(ctx, tx, svc.ShopID, &svcID, "BOOKING_RESCHEDULED", actor, data); err != nil {
+		return nil, err
+	}
+	summary := fmt.Sprintf("Booking moved from %s to %s", from.Label(), b.Label())
+	if err := events.Insert(ctx, tx, svc.ID, "BOOKING_RESCHEDULED", summary, actor, now, data); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Cancel cancels the service's booking. The slot becomes available again and the service can be booked anew.
+func Cancel(ctx context.Context, tx pgx.Tx, svc *service.Service, reason, actor string, now time.Time) (*Booking, error) {
+	b, err := getActiveForUpdate(ctx, tx, svc.ID)
+	if err != nil {
+		return nil, err
+	}
+	if clientRules(actor) {
+		s, err := lockSettings(ctx, tx, svc.ShopID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			def := DefaultSettings()
+			s, err = &def, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := s.checkChange(b, actor, now); err != nil {
+			return nil, err
+		}
+	}
+
+	const q = `
+UPDATE bookings
+SET status = 'cancelled', sequence = sequence + 1, cancelled_at = $2, cancelled_by = $3,
+    cancel_reason = NULLIF($4, ''), updated_at = NOW()
+WHERE id = $1
+RETURNING` + bookingColumns
+	b, err = scanBooking(tx.QueryRow(ctx, q, b.ID, now, actor, reason))
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]any{"bookingId": b.ID, "start": b.Start, "reason": reason}
+	svcID := svc.ID
+	if err := audit.Insert(ctx, tx, svc.ShopID, &svcID, "BOOKING_CANCELLED", actor, data); err != nil {
+		return nil, err
+	}
+	if err := events.Insert(ctx, tx, svc.ID, "BOOKING_CANCELLED", "Booking for "+b.Label()+" cancelled", actor, now, data); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ListByShop lists the shop's bookings, active and cancelled, starting in [from, to).
+func (r *Repository) ListByShop(ctx context.Context, shopID string, from, to time.Time) ([]Booking, error) {
+	const q = `SELECT` + bookingColumns + `
+FROM bookings
+WHERE shop_id = $1 AND slot_start >= $2 AND slot_start < $3
+ORDER BY slot_start ASC
+`
+	rows, err := r.db.Query(ctx, q, shopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Booking
+	for rows.Next() {
+		b, err := scanBooking(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *b)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,186 @@
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/approval"
+	"microservice/internal/events"
+	"microservice/internal/files"
+	"microservice/internal/milestone"
+	"microservice/internal/service"
+)
+
+// exportPageSize bounds how many services are held in memory at once while streaming.
+const exportPageSize = 100
+
+// ServiceRecord is one exported service with everything hanging off it.
+// NDJSON exports write one ServiceRecord per line; the import endpoint accepts the same shape.
+type ServiceRecord struct {
+	Service    service.Service    `json:"service"`
+	Milestones []milestone.Record `json:"milestones"`
+	Approval   *approval.Record   `json:"approval"`
+	Files      []files.Record     `json:"files"`
+	Events     []events.Event     `json:"events"`
+}
+
+type Exporter struct {
+	DB         *pgxpool.Pool
+	Services   *service.Repository
+	Milestones *milestone.Repository
+	Approvals  *approval.Repository
+	Files      *files.Repository
+}
+
+// Each walks every service of a shop in creation order and calls fn with the fully loaded record.
+// Services are paged so large shops can be streamed without loading everything up front.
+func (e Exporter) Each(ctx context.Context, shopID string, fn func(ServiceRecord) error) error {
+	var afterCreatedAt time.Time
+	var afterID string
+	for {
+		page, err := e.Services.ListPageByShop(ctx, shopID, afterCreatedAt, afterID, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for _, svc := range page {
+			rec, err := e.load(ctx, svc)
+			if err != nil {
+				return err
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+		last := page[len(page)-1]
+		afterCreatedAt, afterID = last.CreatedAt, last.ID
+	}
+}
+
+func (e Exporter) load(ctx context.Context, svc service.Service) (ServiceRecord, error) {
+	rec := ServiceRecord{Service: svc}
+
+	ms, err := e.Milestones.ListByService(ctx, svc.ID)
+	if err != nil {
+		return rec, err
+	}
+	rec.Milestones = ms
+
+	appr, err := e.Approvals.GetByService(ctx, svc.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return rec, err
+	}
+	rec.Approval = appr
+
+	fs, err := e.Files.ListByService(ctx, svc.ID)
+	if err != nil {
+		return rec, err
+	}
+	rec.Files = fs
+
+	evs, err := events.ListByService(ctx, e.DB, svc.ID)
+	if err != nil {
+		return rec, err
+	}
+	rec.Events = evs
+
+	if rec.Milestones == nil {
+		rec.Milestones = []milestone.Record{}
+	}
+	if rec.Files == nil {
+		rec.Files = []files.Record{}
+	}
+	if rec.Events == nil {
+		rec.Events = []events.Event{}
+	}
+	return rec, nil
+}
+
+// NDJSONWriter writes one JSON document per line.
+type NDJSONWriter struct {
+	enc *json.Encoder
+}
+
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *NDJSONWriter) Write(rec ServiceRecord) error {
+	return w.enc.Encode(rec)
+}
+
+// CSVHeader is the column layout of the CSV export.
+// CSV is flattened to one row per milestone (service columns repeated) because that is what
+// spreadsheets and accountants expect; NDJSON is the full-fidelity format for backups.
+var CSVHeader = []string{
+	"service_id", "display_id", "shopify_order_id", "shopify_product_id", "client_email", "client_name",
+	"total_amount", "currency", "service_status", "completed_via_override", "service_created_at",
+	"milestone_id", "milestone_sequence", "milestone_amount", "milestone_status", "milestone_paid_at",
+	"approval_approved", "approval_approved_at", "file_count", "event_count",
+}
+
+type CSVWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+func (w *CSVWriter) Write(rec ServiceRecord) error {
+	if !w.wroteHeader {
+		if err := w.w.Write(CSVHeader); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+
+	svc := rec.Service
+	base := []string{
+		svc.ID, svc.DisplayID, svc.ShopifyOrderID, svc.ShopifyProductID, svc.ClientEmail, svc.ClientName,
+		svc.TotalAmount, svc.Currency, string(svc.Status), strconv.FormatBool(svc.CompletedViaOverride), svc.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	apprApproved, apprAt := "", ""
+	if rec.Approval != nil {
+		apprApproved = strconv.FormatBool(rec.Approval.Approved)
+		if rec.Approval.ApprovedAt != nil {
+			apprAt = *rec.Approval.ApprovedAt
+		}
+	}
+	tail := []string{apprApproved, apprAt, strconv.Itoa(len(rec.Files)), strconv.Itoa(len(rec.Events))}
+
+	if len(rec.Milestones) == 0 {
+		row := append(append(append([]string{}, base...), "", "", "", "", ""), tail...)
+		return w.w.Write(row)
+	}
+	for _, m := range rec.Milestones {
+		paidAt := ""
+		if m.PaidAt != nil {
+			paidAt = m.PaidAt.UTC().Format(time.RFC3339)
+		}
+		row := append([]string{}, base...)
+		row = append(row, m.ID, strconv.Itoa(m.Sequence), m.Amount, m.Status, paidAt)
+		row = append(row, tail...)
+		if err := w.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush pushes buffered rows to the underlying writer; call it between pages when streaming.
+func (w *CSVWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,129 @@
+package bulk
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"microservice/internal/api"
+)
+
+// maxImportBytes keeps a single import request bounded.
+const maxImportBytes = 20 << 20
+
+type Handlers struct {
+	Exporter Exporter
+	Importer Importer
+}
+
+// Export streams every service of the shop as NDJSON (default) or CSV (?format=csv).
+func (h Handlers) Export(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "format must be ndjson or csv")
+		return
+	}
+
+	filename := "services-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	flusher, _ := w.(http.Flusher)
+	var write func(ServiceRecord) error
+	var flush func() error
+	switch format {
+	case "csv":
+		cw := NewCSVWriter(w)
+		write, flush = cw.Write, cw.Flush
+	default:
+		nw := NewNDJSONWriter(w)
+		write, flush = nw.Write, func() error { return nil }
+	}
+
+	n := 0
+	err := h.Exporter.Each(r.Context(), s.ID, func(rec ServiceRecord) error {
+		if err := write(rec); err != nil {
+			return err
+		}
+		n++
+		if n%exportPageSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Headers (and possibly rows) are already on the wire; the truncated body is the only signal left.
+		log.Printf("export failed shop=%s after %d services: %v", s.Domain, n, err)
+		return
+	}
+	_ = flush()
+}
+
+// Import creates services from NDJSON or CSV rows (?format=csv or Content-Type: text/csv).
+// With ?dryRun=true rows are validated and reported but nothing is written.
+func (h Handlers) Import(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	q := r.URL.Query()
+	dryRun := q.Get("dryRun") == "true" || q.Get("dryRun") == "1"
+
+	format := strings.ToLower(strings.TrimSpace(q.Get("format")))
+	if format == "" {
+		if strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "text/csv") {
+			format = "csv"
+		} else {
+			format = "ndjson"
+		}
+	}
+
+	body := io.Reader(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	var rows []ParsedRow
+	var err error
+	switch format {
+	case "csv":
+		rows, err = ParseCSV(body)
+	case "ndjson":
+		rows, err = ParseNDJSON(body)
+	default:
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "format must be ndjson or csv")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "no rows to import")
+		return
+	}
+
+	rep := h.Importer.Run(r.Context(), s.ID, rows, dryRun, api.Actor(r.Context()))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rep)
+}
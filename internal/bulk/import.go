@@ -0,0 +1,473 @@
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"microservice/internal/approval"
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/internal/portal"
+	"microservice/internal/service"
+	"microservice/internal/serviceproduct"
+	"microservice/pkg/currency"
+	"microservice/pkg/db"
+)
+
+// maxImportLine caps a single NDJSON line; exported records with many events can get large.
+const maxImportLine = 4 << 20
+
+// ImportRow is the flat, spreadsheet-friendly import shape.
+//
+// Config is optional: when omitted, the current config for ShopifyVariantID (when set) or ShopifyProductID is
+// used, resolved the way a paid order resolves it.
+// PaidSequences lists milestone sequences already paid; when omitted only the deposit (0) is paid,
+// matching what a fresh checkout produces.
+type ImportRow struct {
+	ShopifyOrderID   string          `json:"shopifyOrderId"`
+	ShopifyProductID string          `json:"shopifyProductId,omitempty"`
+	ShopifyVariantID string          `json:"shopifyVariantId,omitempty"`
+	ClientEmail      string          `json:"clientEmail,omitempty"`
+	ClientName       string          `json:"clientName,omitempty"`
+	TotalAmount      string          `json:"totalAmount"`
+	Currency         string          `json:"currency,omitempty"`
+	Status           string          `json:"status,omitempty"`
+	Config           json.RawMessage `json:"config,omitempty"`
+	PaidSequences    []int           `json:"paidSequences,omitempty"`
+}
+
+// importLine accepts either a flat ImportRow or an exported ServiceRecord line.
+type importLine struct {
+	ImportRow
+	Service    *service.Service   `json:"service"`
+	Milestones []milestone.Record `json:"milestones"`
+}
+
+func (l importLine) row() ImportRow {
+	if l.Service == nil {
+		return l.ImportRow
+	}
+	s := l.Service
+	row := ImportRow{
+		ShopifyOrderID:   s.ShopifyOrderID,
+		ShopifyProductID: s.ShopifyProductID,
+		ShopifyVariantID: s.ShopifyVariantID,
+		ClientEmail:      s.ClientEmail,
+		ClientName:       s.ClientName,
+		TotalAmount:      s.TotalAmount,
+		Currency:         s.Currency,
+		Status:           string(s.Status),
+		Config:           s.ServiceConfigSnapshot,
+		PaidSequences:    []int{},
+	}
+	for _, m := range l.Milestones {
+		if milestone.IsSettled(m.Status) {
+			row.PaidSequences = append(row.PaidSequences, m.Sequence)
+		}
+	}
+	return row
+}
+
+// RowError is reported per input row; Row is 1-based and excludes the CSV header.
+type RowError struct {
+	Row     int    `json:"row"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type RowResult struct {
+	Row            int    `json:"row"`
+	Status         string `json:"status"` // valid | created | error
+	ShopifyOrderID string `json:"shopifyOrderId,omitempty"`
+	ServiceID      string `json:"serviceId,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun  bool        `json:"dryRun"`
+	Total   int         `json:"total"`
+	Valid   int         `json:"valid"`
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Rows    []RowResult `json:"rows"`
+	Errors  []RowError  `json:"errors"`
+}
+
+// ParsedRow is either a decoded row or the reason it could not be decoded.
+type ParsedRow struct {
+	Row ImportRow
+	Err *RowError
+}
+
+// ParseNDJSON decodes one row per non-empty line. Malformed lines are reported, not fatal.
+func ParseNDJSON(r io.Reader) ([]ParsedRow, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+
+	var out []ParsedRow
+	n := 0
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		n++
+		var l importLine
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			out = append(out, ParsedRow{Err: &RowError{Row: n, Code: "INVALID_JSON", Message: err.Error()}})
+			continue
+		}
+		out = append(out, ParsedRow{Row: l.row()})
+	}
+	return out, sc.Err()
+}
+
+// ParseCSV decodes rows using the header line to locate columns, so column order does not matter.
+//
+// Columns: shopify_order_id, shopify_product_id, shopify_variant_id, client_email, client_name, total_amount, currency,
+// status, config (JSON), paid_sequences (e.g. "0;1").
+func ParseCSV(r io.Reader) ([]ParsedRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	idx := map[string]int{}
+	for i, h := range header {
+		idx[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := idx["shopify_order_id"]; !ok {
+		return nil, fmt.Errorf("missing shopify_order_id column")
+	}
+	if _, ok := idx["total_amount"]; !ok {
+		return nil, fmt.Errorf("missing total_amount column")
+	}
+
+	var out []ParsedRow
+	n := 0
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		n++
+		if err != nil {
+			out = append(out, ParsedRow{Err: &RowError{Row: n, Code: "INVALID_CSV", Message: err.Error()}})
+			continue
+		}
+		col := func(name string) string {
+			i, ok := idx[name]
+			if !ok || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+
+		row := ImportRow{
+			ShopifyOrderID:   col("shopify_order_id"),
+			ShopifyProductID: col("shopify_product_id"),
+			ShopifyVariantID: col("shopify_variant_id"),
+			ClientEmail:      col("client_email"),
+			ClientName:       col("client_name"),
+			TotalAmount:      col("total_amount"),
+			Currency:         col("currency"),
+			Status:           col("status"),
+		}
+		if c := col("config"); c != "" {
+			row.Config = json.RawMessage(c)
+		}
+		if ps := col("paid_sequences"); ps != "" {
+			seqs, err := parseSequences(ps)
+			if err != nil {
+				out = append(out, ParsedRow{Err: &RowError{Row: n, Code: "VALIDATION_FAILED", Message: err.Error()}})
+				continue
+			}
+			row.PaidSequences = seqs
+		}
+		out = append(out, ParsedRow{Row: row})
+	}
+	return out, nil
+}
+
+func parseSequences(s string) ([]int, error) {
+	out := []int{}
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '|' || r == ' ' }) {
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid paid_sequences value %q", part)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// plannedService is a validated row, ready to be written.
+type plannedService struct {
+	Row      ImportRow
+	Total    decimal.Decimal
+	Currency string
+	Scale    milestone.CurrencyScale
+	Status   service.Status
+	Snapshot json.RawMessage
+	Version  *int // config version the snapshot was taken from; nil for inline configs
+	Amounts  []milestone.CalculatedMilestone
+	Paid     map[int]bool
+}
+
+type Importer struct {
+	DB *pgxpool.Pool
+}
+
+// Run validates every row and, unless dryRun, writes each valid row in its own transaction
+// so one bad row never blocks the rest of the file.
+func (im Importer) Run(ctx context.Context, shopID string, rows []ParsedRow, dryRun bool, actor string) ImportReport {
+	rep := ImportReport{DryRun: dryRun, Total: len(rows), Rows: []RowResult{}, Errors: []RowError{}}
+
+	fail := func(rowNum int, orderID string, e RowError) {
+		e.Row = rowNum
+		rep.Failed++
+		rep.Errors = append(rep.Errors, e)
+		rep.Rows = append(rep.Rows, RowResult{Row: rowNum, Status: "error", ShopifyOrderID: orderID})
+	}
+
+	for i, pr := range rows {
+		rowNum := i + 1
+		if pr.Err != nil {
+			fail(rowNum, "", *pr.Err)
+			continue
+		}
+
+		plan, rerr := im.validate(ctx, shopID, pr.Row)
+		if rerr != nil {
+			fail(rowNum, pr.Row.ShopifyOrderID, *rerr)
+			continue
+		}
+		rep.Valid++
+
+		if dryRun {
+			rep.Rows = append(rep.Rows, RowResult{Row: rowNum, Status: "valid", ShopifyOrderID: plan.Row.ShopifyOrderID})
+			continue
+		}
+
+		var serviceID string
+		err := db.WithTx(ctx, im.DB, func(tx pgx.Tx) error {
+			id, err := im.write(ctx, tx, shopID, plan, actor)
+			serviceID = id
+			return err
+		})
+		if err != nil {
+			rep.Valid--
+			if isUniqueViolation(err) {
+				fail(rowNum, plan.Row.ShopifyOrderID, RowError{Code: "ORDER_ALREADY_IMPORTED", Message: "a service already exists for this order"})
+				continue
+			}
+			fail(rowNum, plan.Row.ShopifyOrderID, RowError{Code: "INTERNAL", Message: "failed to write service"})
+			continue
+		}
+		rep.Created++
+		rep.Rows = append(rep.Rows, RowResult{Row: rowNum, Status: "created", ShopifyOrderID: plan.Row.ShopifyOrderID, ServiceID: serviceID})
+	}
+	return rep
+}
+
+func (im Importer) validate(ctx context.Context, shopID string, row ImportRow) (*plannedService, *RowError) {
+	row.ShopifyOrderID = strings.TrimSpace(row.ShopifyOrderID)
+	if row.ShopifyOrderID == "" {
+		return nil, &RowError{Code: "VALIDATION_FAILED", Message: "shopify_order_id is required"}
+	}
+
+	total, err := decimal.NewFromString(strings.TrimSpace(row.TotalAmount))
+	if err != nil {
+		return nil, &RowError{Code: "VALIDATION_FAILED", Message: "total_amount is not a number"}
+	}
+	// Amounts are split and stored in the currency's minor units, so the currency cannot be left to a default.
+	scale, err := milestone.ScaleFor(row.Currency)
+	if err != nil {
+		return nil, validationRowError(err)
+	}
+	total = total.Round(int32(scale))
+
+	status := service.StatusBooked
+	if strings.TrimSpace(row.Status) != "" {
+		st, err := service.ParseStatus(strings.TrimSpace(row.Status))
+		if err != nil {
+			return nil, &RowError{Code: "VALIDATION_FAILED", Message: "invalid status"}
+		}
+		status = st
+	}
+
+	cfgRaw, snapshot := row.Config, row.Config
+	var cfgVersion *int
+	if len(cfgRaw) == 0 {
+		if row.ShopifyProductID == "" {
+			return nil, &RowError{Code: "SERVICE_CONFIG_MISSING", Message: "config or shopify_product_id is required"}
+		}
+		// Rules match on the cached product; an import does not call Shopify for every row.
+		facts := serviceproduct.CachedProductFacts(im.DB, shopID, row.ShopifyProductID)
+		res, err := serviceproduct.Resolve(ctx, im.DB, shopID, row.ShopifyProductID, row.ShopifyVariantID, facts)
+		if errors.Is(err, serviceproduct.ErrDisabled) {
+			return nil, &RowError{Code: "SERVICE_CONFIG_DISABLED", Message: "the service product config is disabled"}
+		}
+		if err != nil {
+			return nil, &RowError{Code: "SERVICE_CONFIG_MISSING", Message: "no service product config for shopify_product_id"}
+		}
+		cfgRaw = res.Config
+		if res.ConfigVersion > 0 {
+			cfgVersion = &res.ConfigVersion
+		}
+		if snapshot, err = res.Snapshot(); err != nil {
+			return nil, &RowError{Code: "VALIDATION_FAILED", Message: "invalid config json"}
+		}
+	}
+
+	cfg, err := serviceproduct.ParseAndValidate(cfgRaw)
+	if err != nil {
+		return nil, validationRowError(err)
+	}
+	if cfg.IsRecurring() {
+		return nil, &RowError{Code: "RECURRING_NOT_SUPPORTED", Message: "recurring services cannot be imported"}
+	}
+	amounts, err := milestone.CalculateAmounts(total, cfg.Templates, scale, cfg.Rounding)
+	if err != nil {
+		return nil, validationRowError(err)
+	}
+
+	paid := map[int]bool{0: true}
+	if row.PaidSequences != nil {
+		paid = map[int]bool{}
+		for _, seq := range row.PaidSequences {
+			if seq < 0 || seq >= len(amounts) {
+				return nil, &RowError{Code: "VALIDATION_FAILED", Message: fmt.Sprintf("paid sequence %d out of range", seq)}
+			}
+			paid[seq] = true
+		}
+	}
+
+	// Completion law: imported services cannot claim Completed unless the final milestone is paid.
+	if status == service.StatusCompleted && !paid[len(amounts)-1] {
+		return nil, &RowError{Code: "FINAL_MILESTONE_LOCKED", Message: "completed services require the final milestone to be paid"}
+	}
+
+	var exists bool
+	const qDup = `SELECT EXISTS (SELECT 1 FROM services WHERE shop_id = $1 AND shopify_order_id = $2)`
+	if err := im.DB.QueryRow(ctx, qDup, shopID, row.ShopifyOrderID).Scan(&exists); err != nil {
+		return nil, &RowError{Code: "INTERNAL", Message: "failed to check for duplicates"}
+	}
+	if exists {
+		return nil, &RowError{Code: "ORDER_ALREADY_IMPORTED", Message: "a service already exists for this order"}
+	}
+
+	return &plannedService{
+		Row:      row,
+		Total:    total,
+		Currency: currency.Normalize(row.Currency),
+		Scale:    scale,
+		Status:   status,
+		Snapshot: snapshot,
+		Version:  cfgVersion,
+		Amounts:  amounts,
+		Paid:     paid,
+	}, nil
+}
+
+func (im Importer) write(ctx context.Context, tx pgx.Tx, shopID string, p *plannedService, actor string) (string, error) {
+	const qSvc = `
+INSERT INTO services (shop_id, shopify_order_id, shopify_product_id, client_email, client_name, total_amount, currency, status, service_config_snapshot, config_version,
+                      shopify_variant_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11,''))
+RETURNING id
+`
+	var serviceID string
+	if err := tx.QueryRow(ctx, qSvc, shopID, p.Row.ShopifyOrderID, p.Row.ShopifyProductID, p.Row.ClientEmail, p.Row.ClientName,
+		p.Scale.Format(p.Total), p.Currency, string(p.Status), p.Snapshot, p.Version, p.Row.ShopifyVariantID).Scan(&serviceID); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	const qMs = `
+INSERT INTO milestones (service_id, sequence, amount, status)
+VALUES ($1, $2, $3, $4)
+RETURNING id
+`
+	ids := make([]string, len(p.Amounts))
+	for i, m := range p.Amounts {
+		status := milestone.StatusUnpaid
+		if !p.Paid[i] && m.IsFinal {
+			status = milestone.StatusLocked
+		}
+		if err := tx.QueryRow(ctx, qMs, serviceID, i, p.Scale.Format(m.Amount), status).Scan(&ids[i]); err != nil {
+			return "", err
+		}
+		if err := milestone.InsertDetails(ctx, tx, ids[i], m.Details); err != nil {
+			return "", err
+		}
+	}
+	// Paid sequences go through the ledger so amount_paid adds up like for any other payment.
+	for i, m := range p.Amounts {
+		if !p.Paid[i] {
+			continue
+		}
+		if _, err := milestone.RecordPayment(ctx, tx, serviceID, ids[i], milestone.Payment{
+			Amount:     m.Amount,
+			Source:     "import",
+			Reference:  p.Row.ShopifyOrderID,
+			ReceivedAt: now,
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	if p.Status == service.StatusWaitingForApproval {
+		if err := approval.UpsertRequested(ctx, tx, serviceID, false); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := portal.InsertToken(ctx, tx, serviceID, now.Add(30*24*time.Hour)); err != nil {
+		return "", err
+	}
+
+	meta := map[string]any{"shopifyOrderId": p.Row.ShopifyOrderID, "productId": p.Row.ShopifyProductID, "paidSequences": p.Row.PaidSequences}
+	if err := audit.Insert(ctx, tx, shopID, &serviceID, "SERVICE_IMPORTED", actor, meta); err != nil {
+		return "", err
+	}
+	if err := events.Insert(ctx, tx, serviceID, "SERVICE_IMPORTED", "Service imported", actor, now, map[string]any{"shopifyOrderId": p.Row.ShopifyOrderID}); err != nil {
+		return "", err
+	}
+	return serviceID, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if ok := errors.As(err, &pgErr); ok {
+		return pgErr.Code == "23505"
+	}
+	return false
+}
+
+func validationRowError(err error) *RowError {
+	var ve milestone.ValidationError
+	if errors.As(err, &ve) {
+		return &RowError{Code: ve.Code, Message: ve.Message}
+	}
+	return &RowError{Code: "VALIDATION_FAILED", Message: err.Error()}
+}
@@ -0,0 +1,91 @@
+package bulk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCSV_HeaderOrderAndPaidSequences(t *testing.T) {
+	in := "total_amount,shopify_order_id,paid_sequences,config\n" +
+		`250.00,1001,0;1,"{""templates"":[]}"` + "\n"
+
+	rows, err := ParseCSV(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Err != nil {
+		t.Fatalf("expected 1 valid row, got %+v", rows)
+	}
+	got := rows[0].Row
+	if got.ShopifyOrderID != "1001" || got.TotalAmount != "250.00" {
+		t.Fatalf("unexpected row: %+v", got)
+	}
+	if len(got.PaidSequences) != 2 || got.PaidSequences[1] != 1 {
+		t.Fatalf("unexpected paid sequences: %v", got.PaidSequences)
+	}
+	if string(got.Config) != `{"templates":[]}` {
+		t.Fatalf("unexpected config: %s", got.Config)
+	}
+}
+
+func TestParseCSV_VariantColumn(t *testing.T) {
+	in := "shopify_order_id,total_amount,shopify_product_id,shopify_variant_id\n1001,250.00,42,4202\n1002,90.00,42,\n"
+
+	rows, err := ParseCSV(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rows) != 2 || rows[0].Err != nil || rows[1].Err != nil {
+		t.Fatalf("expected 2 valid rows, got %+v", rows)
+	}
+	if got := rows[0].Row; got.ShopifyProductID != "42" || got.ShopifyVariantID != "4202" {
+		t.Fatalf("unexpected row: %+v", got)
+	}
+	if got := rows[1].Row; got.ShopifyVariantID != "" {
+		t.Fatalf("expected no variant, got %+v", got)
+	}
+}
+
+func TestParseCSV_BadSequenceIsRowError(t *testing.T) {
+	in := "shopify_order_id,total_amount,paid_sequences\n1,10,x\n2,20,0\n"
+	rows, err := ParseCSV(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	if rows[0].Err == nil || rows[0].Err.Row != 1 {
+		t.Fatalf("expected row 1 error, got %+v", rows[0])
+	}
+	if rows[1].Err != nil {
+		t.Fatalf("expected row 2 valid, got %+v", rows[1].Err)
+	}
+}
+
+func TestParseNDJSON_AcceptsExportedRecords(t *testing.T) {
+	in := `{"service":{"shopifyOrderId":"42","totalAmount":"100.00","currency":"EUR","status":"InProgress","serviceConfigSnapshot":{"version":1}},"milestones":[{"sequence":0,"status":"paid"},{"sequence":1,"status":"locked"}]}
+not json
+{"shopifyOrderId":"43","totalAmount":"10"}
+`
+	rows, err := ParseNDJSON(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(rows))
+	}
+	exp := rows[0].Row
+	if exp.ShopifyOrderID != "42" || exp.Currency != "EUR" || exp.Status != "InProgress" {
+		t.Fatalf("unexpected mapped row: %+v", exp)
+	}
+	if len(exp.PaidSequences) != 1 || exp.PaidSequences[0] != 0 {
+		t.Fatalf("expected only deposit paid, got %v", exp.PaidSequences)
+	}
+	if rows[1].Err == nil || rows[1].Err.Code != "INVALID_JSON" {
+		t.Fatalf("expected INVALID_JSON on line 2, got %+v", rows[1])
+	}
+	if rows[2].Row.ShopifyOrderID != "43" || rows[2].Row.PaidSequences != nil {
+		t.Fatalf("unexpected flat row: %+v", rows[2].Row)
+	}
+}
@@ -0,0 +1,338 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/adminaction"
+	"microservice/internal/api"
+	"microservice/internal/booking"
+	"microservice/internal/ical"
+	"microservice/internal/milestone"
+	"microservice/internal/recurring"
+	"microservice/internal/staff"
+)
+
+// A feed lists entries from feedPast ago to feedAhead ahead. Past entries stay for a while so subscribers see
+// payments and cancellations of recent entries rather than having them silently disappear.
+const (
+	feedPast  = 30 * 24 * time.Hour
+	feedAhead = 400 * 24 * time.Hour
+)
+
+// Feed builds the calendar behind a feed token. Due dates and recurring billing cycles are all-day events
+// dated in the shop's booking timezone.
+type Feed struct {
+	DB          *pgxpool.Pool
+	Bookings    *booking.Repository
+	Recurrences *recurring.Repository
+	Staff       *staff.Repository
+}
+
+// audience is who a feed is for: the shop (staffUserID empty) or a staff member acting with role.
+type audience struct {
+	staffUserID string
+	role        staff.Role
+}
+
+// confirms reports whether the override proposal's deadline belongs in the audience's feed. The shop feed
+// lists every deadline; a staff feed only those the member could confirm: not their own proposals, and only
+// from operator up.
+func (a audience) confirms(p proposal) bool {
+	if a.staffUserID == "" {
+		return true
+	}
+	return a.role.Allows(staff.RoleOperator) && p.ProposedBy != api.StaffActor(a.staffUserID)
+}
+
+// Build renders the feed of t at now.
+func (f Feed) Build(ctx context.Context, t *FeedToken, now time.Time) (*ical.Calendar, error) {
+	var shopDomain string
+	if err := f.DB.QueryRow(ctx, `SELECT shop_domain FROM shops WHERE id = $1`, t.ShopID).Scan(&shopDomain); err != nil {
+		return nil, err
+	}
+	settings, err := f.Bookings.Settings(ctx, t.ShopID)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	aud := audience{staffUserID: t.StaffUserID}
+	if aud.staffUserID != "" {
+		if aud.role, err = f.Staff.EffectiveRole(ctx, t.ShopID, aud.staffUserID); err != nil {
+			return nil, err
+		}
+	}
+	from, to := now.Add(-feedPast), now.Add(feedAhead)
+
+	cal := &ical.Calendar{Name: "Services · " + shopDomain, Timezone: loc.String()}
+
+	ms, err := f.dueMilestones(ctx, t.ShopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range ms {
+		cal.Events = append(cal.Events, milestoneEvent(m, loc))
+	}
+
+	props, err := f.proposals(ctx, t.ShopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range props {
+		if aud.confirms(p) {
+			cal.Events = append(cal.Events, proposalEvent(p, now))
+		}
+	}
+
+	recs, err := f.Recurrences.ListByShop(ctx, t.ShopID, from)
+	if err != nil {
+		return nil, err
+	}
+	bookings, err := f.Bookings.ListByShop(ctx, t.ShopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, r := range recs {
+		ids = append(ids, r.ServiceID)
+	}
+	for _, b := range bookings {
+		ids = append(ids, b.ServiceID)
+	}
+	labels, err := f.serviceLabels(ctx, t.ShopID, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range recs {
+		if e, ok := cycleSeries(r, labels[r.ServiceID], loc); ok {
+			cal.Events = append(cal.Events, e)
+		}
+	}
+	for i := range bookings {
+		cal.Events = append(cal.Events, bookingEvent(&bookings[i], labels[bookings[i].ServiceID]))
+	}
+
+	sort.SliceStable(cal.Events, func(i, j int) bool { return cal.Events[i].Start.Before(cal.Events[j].Start) })
+	return cal, nil
+}
+
+// serviceLabel is what feed entries call a service.
+type serviceLabel struct {
+	DisplayID  string
+	ClientName string
+	Currency   string
+}
+
+func (l serviceLabel) suffix() string {
+	if l.ClientName == "" {
+		return l.DisplayID
+	}
+	return l.DisplayID + " · " + l.ClientName
+}
+
+func (f Feed) serviceLabels(ctx context.Context, shopID string, ids []string) (map[string]serviceLabel, error) {
+	out := map[string]serviceLabel{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	const q = `
+SELECT id, display_id, COALESCE(client_name,''), currency
+FROM services
+WHERE shop_id = $1 AND id = ANY($2::uuid[])
+`
+	rows, err := f.DB.Query(ctx, q, shopID, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var l serviceLabel
+		if err := rows.Scan(&id, &l.DisplayID, &l.ClientName, &l.Currency); err != nil {
+			return nil, err
+		}
+		out[id] = l
+	}
+	return out, rows.Err()
+}
+
+// dueMilestone is a milestone with a due date and the service it belongs to.
+type dueMilestone struct {
+	milestone.Record
+	Service serviceLabel
+}
+
+func (f Feed) dueMilestones(ctx context.Context, shopID string, from, to time.Time) ([]dueMilestone, error) {
+	const q = `
+SELECT m.id, m.service_id, m.sequence, m.amount::text, m.status, m.due_at, m.period_start, COALESCE(m.title,''),
+       s.display_id, COALESCE(s.client_name,''), s.currency
+FROM milestones m
+JOIN services s ON s.id = m.service_id
+WHERE s.shop_id = $1 AND m.due_at >= $2 AND m.due_at < $3
+ORDER BY m.due_at ASC
+`
+	rows, err := f.DB.Query(ctx, q, shopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []dueMilestone
+	for rows.Next() {
+		var m dueMilestone
+		if err := rows.Scan(&m.ID, &m.ServiceID, &m.Sequence, &m.Amount, &m.Status, &m.DueAt, &m.PeriodStart, &m.Title,
+			&m.Service.DisplayID, &m.Service.ClientName, &m.Service.Currency); err != nil {
+			return nil, err
+		}
+		m.Currency = m.Service.Currency
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// milestoneEvent is an all-day event on the milestone's due date. Once paid it stays on the calendar, retitled,
+// so the merchant sees the payment came in.
+func milestoneEvent(m dueMilestone, loc *time.Location) ical.Event {
+	due := m.DueAt.In(loc)
+	day := time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, loc)
+	e := ical.Event{
+		UID:         m.ID + "@milestone",
+		Summary:     fmt.Sprintf("%s due · %s", m.DisplayTitle(), m.Service.suffix()),
+		Description: fmt.Sprintf("%s %s, %s", m.Amount, m.Currency, strings.ReplaceAll(m.Status, "_", " ")),
+		Start:       day,
+		End:         day.AddDate(0, 0, 1),
+		AllDay:      true,
+	}
+	if milestone.IsSettled(m.Status) {
+		e.Sequence = 1
+		e.Summary = fmt.Sprintf("%s paid · %s", m.DisplayTitle(), m.Service.suffix())
+	}
+	return e
+}
+
+// proposal is an admin override waiting for a second staff member's confirmation until ExpiresAt.
+type proposal struct {
+	ID         string
+	ServiceID  string
+	DisplayID  string
+	ActionType string
+	ProposedBy string
+	Status     string
+	ExpiresAt  time.Time
+}
+
+func (f Feed) proposals(ctx context.Context, shopID string, from, to time.Time) ([]proposal, error) {
+	const q = `
+SELECT a.id, a.service_id, s.display_id, a.action_type, a.actor, a.status, a.expires_at
+FROM admin_actions a
+JOIN services s ON s.id = a.service_id
+WHERE s.shop_id = $1 AND a.expires_at >= $2 AND a.expires_at < $3
+ORDER BY a.expires_at ASC
+`
+	rows, err := f.DB.Query(ctx, q, shopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []proposal
+	for rows.Next() {
+		var p proposal
+		if err := rows.Scan(&p.ID, &p.ServiceID, &p.DisplayID, &p.ActionType, &p.ProposedBy, &p.Status, &p.ExpiresAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// proposalEvent marks the approval deadline of an override proposal. A proposal that was confirmed, rejected
+// or has expired no longer has a deadline: it is published as cancelled.
+func proposalEvent(p proposal, now time.Time) ical.Event {
+	open := p.Status == string(adminaction.StatusPending) && p.ExpiresAt.After(now)
+	e := ical.Event{
+		UID:         p.ID + "@override",
+		Summary:     fmt.Sprintf("Confirm override (%s) · %s", strings.ReplaceAll(p.ActionType, "_", " "), p.DisplayID),
+		Description: "Proposed by " + p.ProposedBy + "; the proposal expires unless another staff member confirms it.",
+		Start:       p.ExpiresAt,
+		Cancelled:   !open,
+	}
+	if !open {
+		e.Sequence = 1
+	}
+	return e
+}
+
+// cycleSeries is one recurring all-day event for the billing cycles of a recurring service still to start;
+// cycles already started are milestones. The series moves on as cycles start (its sequence is the number of
+// cycles generated) and is published as cancelled once the recurrence is.
+func cycleSeries(rec recurring.Recurrence, svc serviceLabel, loc *time.Location) (ical.Event, bool) {
+	if rec.MaxCycles != nil && rec.CyclesGenerated >= *rec.MaxCycles {
+		return ical.Event{}, false
+	}
+	c := rec.CycleAt(rec.CyclesGenerated)
+	start := c.Start.In(loc)
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+
+	desc := fmt.Sprintf("%s %s per cycle", rec.CycleAmount, svc.Currency)
+	if rec.DueInDays > 0 {
+		desc += fmt.Sprintf(", due %d days after the cycle starts", rec.DueInDays)
+	}
+	e := ical.Event{
+		UID:         rec.ServiceID + "@recurrence",
+		Sequence:    rec.CyclesGenerated,
+		Summary:     "Billing cycle · " + svc.suffix(),
+		Description: desc,
+		Start:       day,
+		End:         day.AddDate(0, 0, 1),
+		AllDay:      true,
+		RRule:       recurrenceRule(rec, loc),
+	}
+	if rec.Status == recurring.StatusCancelled {
+		e.Sequence++
+		e.Cancelled = true
+	}
+	return e, true
+}
+
+// recurrenceRule is the RRULE of the cycles still to start. Monthly cycles are counted from the anchor and clamp
+// to the end of shorter months (see milestone.RecurringTemplate.CycleStart): anchored on the 31st they fall on
+// the last of the 28th to 31st the month has, which BYSETPOS=-1 expresses.
+func recurrenceRule(rec recurring.Recurrence, loc *time.Location) string {
+	count := rec.IntervalCount
+	if count <= 0 {
+		count = 1
+	}
+	var rule string
+	if rec.Interval == string(milestone.IntervalWeek) {
+		rule = fmt.Sprintf("FREQ=WEEKLY;INTERVAL=%d", count)
+	} else {
+		day := rec.AnchorAt.In(loc).Day()
+		rule = fmt.Sprintf("FREQ=MONTHLY;INTERVAL=%d;BYMONTHDAY=%d", count, day)
+		if day > 28 {
+			var days []string
+			for d := 28; d <= day; d++ {
+				days = append(days, strconv.Itoa(d))
+			}
+			rule = fmt.Sprintf("FREQ=MONTHLY;INTERVAL=%d;BYMONTHDAY=%s;BYSETPOS=-1", count, strings.Join(days, ","))
+		}
+	}
+	if rec.MaxCycles != nil {
+		rule += fmt.Sprintf(";COUNT=%d", *rec.MaxCycles-rec.CyclesGenerated)
+	}
+	return rule
+}
+
+// bookingEvent is the booked appointment. Reschedules move it and cancellations cancel it, under the same UID.
+func bookingEvent(b *booking.Booking, svc serviceLabel) ical.Event {
+	return booking.Event(b, "Appointment · "+svc.suffix(), "Service "+svc.DisplayID)
+}
@@ -0,0 +1,117 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	"microservice/internal/milestone"
+	"microservice/internal/recurring"
+	"microservice/internal/staff"
+)
+
+func TestRecurrenceRule(t *testing.T) {
+	three := 3
+	cases := []struct {
+		name string
+		rec  recurring.Recurrence
+		want string
+	}{
+		{"weekly", recurring.Recurrence{Interval: "week", IntervalCount: 2, AnchorAt: time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)},
+			"FREQ=WEEKLY;INTERVAL=2"},
+		{"monthly", recurring.Recurrence{Interval: "month", AnchorAt: time.Date(2026, 1, 15, 9, 0, 0, 0, time.UTC)},
+			"FREQ=MONTHLY;INTERVAL=1;BYMONTHDAY=15"},
+		{"clamped to month end", recurring.Recurrence{Interval: "month", IntervalCount: 1, AnchorAt: time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)},
+			"FREQ=MONTHLY;INTERVAL=1;BYMONTHDAY=28,29,30,31;BYSETPOS=-1"},
+		{"finite", recurring.Recurrence{Interval: "month", IntervalCount: 1, MaxCycles: &three, CyclesGenerated: 1, AnchorAt: time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)},
+			"FREQ=MONTHLY;INTERVAL=1;BYMONTHDAY=2;COUNT=2"},
+	}
+	for _, tc := range cases {
+		if got := recurrenceRule(tc.rec, time.UTC); got != tc.want {
+			t.Fatalf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCycleSeries(t *testing.T) {
+	two := 2
+	rec := recurring.Recurrence{
+		ServiceID: "svc", Interval: "month", IntervalCount: 1, CycleAmount: "50.00",
+		AnchorAt: time.Date(2026, 1, 31, 23, 30, 0, 0, time.UTC), CyclesGenerated: 1, Status: recurring.StatusActive,
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	e, ok := cycleSeries(rec, serviceLabel{DisplayID: "SRV-1", Currency: "EUR"}, berlin)
+	if !ok {
+		t.Fatal("expected a series")
+	}
+	// Cycle 1 starts on 28 Feb 23:30 UTC, which is 1 Mar in Berlin; the anchor is 1 Feb there.
+	if e.Start.Format(time.DateOnly) != "2026-03-01" || !e.AllDay || e.Sequence != 1 || e.Cancelled {
+		t.Fatalf("unexpected series: %+v", e)
+	}
+	if e.RRule != "FREQ=MONTHLY;INTERVAL=1;BYMONTHDAY=1" {
+		t.Fatalf("unexpected rule %q", e.RRule)
+	}
+
+	rec.Status = recurring.StatusCancelled
+	if e, _ := cycleSeries(rec, serviceLabel{}, time.UTC); !e.Cancelled || e.Sequence != 2 {
+		t.Fatalf("expected a cancelled series with a higher sequence, got %+v", e)
+	}
+
+	rec.MaxCycles, rec.CyclesGenerated = &two, 2
+	if _, ok := cycleSeries(rec, serviceLabel{}, time.UTC); ok {
+		t.Fatal("expected no series once every cycle started")
+	}
+}
+
+func TestProposalEvent(t *testing.T) {
+	now := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	p := proposal{ID: "a1", DisplayID: "SRV-1", ActionType: "mark_milestone_paid", Status: "pending", ExpiresAt: now.Add(time.Hour)}
+	if e := proposalEvent(p, now); e.Cancelled || e.Sequence != 0 || !e.Start.Equal(p.ExpiresAt) {
+		t.Fatalf("expected an open deadline, got %+v", e)
+	}
+	for _, status := range []string{"applied", "rejected", "expired"} {
+		p.Status = status
+		if e := proposalEvent(p, now); !e.Cancelled || e.Sequence != 1 {
+			t.Fatalf("%s: expected a cancelled deadline, got %+v", status, e)
+		}
+	}
+	p.Status, p.ExpiresAt = "pending", now.Add(-time.Minute)
+	if e := proposalEvent(p, now); !e.Cancelled {
+		t.Fatalf("expected a lapsed pending proposal to be cancelled, got %+v", e)
+	}
+}
+
+func TestAudienceConfirms(t *testing.T) {
+	p := proposal{ProposedBy: "staff:1"}
+	cases := []struct {
+		aud  audience
+		want bool
+	}{
+		{audience{}, true},
+		{audience{staffUserID: "2", role: staff.RoleOperator}, true},
+		{audience{staffUserID: "2", role: staff.RoleViewer}, false},
+		{audience{staffUserID: "1", role: staff.RoleFinanceAdmin}, false},
+	}
+	for _, tc := range cases {
+		if got := tc.aud.confirms(p); got != tc.want {
+			t.Fatalf("%+v: got %v, want %v", tc.aud, got, tc.want)
+		}
+	}
+}
+
+func TestMilestoneEvent(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	due := time.Date(2026, 11, 2, 20, 0, 0, 0, time.UTC) // 3 Nov in Tokyo
+	m := dueMilestone{
+		Record:  milestone.Record{ID: "m1", Sequence: 1, Amount: "100.00", Currency: "JPY", Status: milestone.StatusUnpaid, DueAt: &due},
+		Service: serviceLabel{DisplayID: "SRV-7", ClientName: "Ada"},
+	}
+	e := milestoneEvent(m, tokyo)
+	if e.Start.Format(time.DateOnly) != "2026-11-03" || e.Summary != "Milestone 2 due · SRV-7 · Ada" || e.Sequence != 0 {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	m.Status = milestone.StatusPaid
+	if e := milestoneEvent(m, tokyo); e.Summary != "Milestone 2 paid · SRV-7 · Ada" || e.Sequence != 1 {
+		t.Fatalf("unexpected paid event: %+v", e)
+	}
+}
@@ -0,0 +1,171 @@
+package calendar
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/api"
+	"microservice/internal/ical"
+	"microservice/pkg/config"
+	"microservice/pkg/db"
+)
+
+type Handlers struct {
+	Cfg  config.Config
+	DB   *pgxpool.Pool
+	Repo *Repository
+	Feed Feed
+}
+
+// url is the subscription URL of a feed token.
+func (h Handlers) url(t *FeedToken) string {
+	return strings.TrimRight(h.Cfg.PublicBaseURL, "/") + "/v1/calendar/" + t.Token + ".ics"
+}
+
+// List returns the shop feed and the caller's own staff feed, when they exist.
+func (h Handlers) List(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	out := map[string]any{"shop": nil, "me": nil}
+	shopFeed, err := h.Repo.Active(r.Context(), s.ID, "")
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if shopFeed != nil {
+		shopFeed.URL = h.url(shopFeed)
+		out["shop"] = shopFeed
+	}
+	if st := api.StaffFromContext(r.Context()); st != nil && st.UserID != "" {
+		mine, err := h.Repo.Active(r.Context(), s.ID, st.UserID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+			return
+		}
+		if mine != nil {
+			mine.URL = h.url(mine)
+			out["me"] = mine
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// RotateShop issues a new URL for the shop feed, revoking the current one.
+func (h Handlers) RotateShop(w http.ResponseWriter, r *http.Request) {
+	h.rotate(w, r, "")
+}
+
+// RotateMine issues a new URL for the caller's own feed, revoking the current one.
+func (h Handlers) RotateMine(w http.ResponseWriter, r *http.Request) {
+	st := api.StaffFromContext(r.Context())
+	if st == nil || st.UserID == "" {
+		api.WriteError(w, http.StatusForbidden, "STAFF_IDENTITY_REQUIRED", "a personal feed requires a verified staff member")
+		return
+	}
+	h.rotate(w, r, st.UserID)
+}
+
+// RevokeShop turns the shop feed off.
+func (h Handlers) RevokeShop(w http.ResponseWriter, r *http.Request) {
+	h.revoke(w, r, "")
+}
+
+// RevokeMine turns the caller's own feed off.
+func (h Handlers) RevokeMine(w http.ResponseWriter, r *http.Request) {
+	st := api.StaffFromContext(r.Context())
+	if st == nil || st.UserID == "" {
+		api.WriteError(w, http.StatusForbidden, "STAFF_IDENTITY_REQUIRED", "a personal feed requires a verified staff member")
+		return
+	}
+	h.revoke(w, r, st.UserID)
+}
+
+// RevokeStaff turns another staff member's feed off, e.g. when they leave.
+func (h Handlers) RevokeStaff(w http.ResponseWriter, r *http.Request) {
+	h.revoke(w, r, chi.URLParam(r, "userId"))
+}
+
+func (h Handlers) rotate(w http.ResponseWriter, r *http.Request, staffUserID string) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var t *FeedToken
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var err error
+		t, err = Rotate(r.Context(), tx, s.ID, staffUserID, api.Actor(r.Context()), time.Now())
+		return err
+	})
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	t.URL = h.url(t)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(t)
+}
+
+func (h Handlers) revoke(w http.ResponseWriter, r *http.Request, staffUserID string) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var revoked bool
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var err error
+		revoked, err = Revoke(r.Context(), tx, s.ID, staffUserID, api.Actor(r.Context()), time.Now())
+		return err
+	})
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if !revoked {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "feed not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Serve renders the feed behind a secret URL. It is public: the token is the credential.
+func (h Handlers) Serve(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing token")
+		return
+	}
+
+	t, err := h.Repo.GetActiveByToken(r.Context(), token)
+	if err != nil {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "calendar feed not found")
+		return
+	}
+	cal, err := h.Feed.Build(r.Context(), t, time.Now())
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	_, _ = w.Write(cal.Bytes())
+}
@@ -0,0 +1,119 @@
+// Package calendar publishes a shop's milestone due dates, approval deadlines and booked appointments as
+// iCalendar feeds behind secret URLs: one for the shop and one per staff member.
+package calendar
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/audit"
+)
+
+// FeedToken is the secret in a feed URL. StaffUserID is empty for the shop feed.
+type FeedToken struct {
+	ID          string     `json:"id"`
+	ShopID      string     `json:"-"`
+	StaffUserID string     `json:"staffUserId,omitempty"`
+	Token       string     `json:"token"`
+	URL         string     `json:"url,omitempty"`
+	CreatedBy   string     `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const selectToken = `
+SELECT id, shop_id, COALESCE(staff_user_id,''), token, created_by, created_at, revoked_at
+FROM calendar_feed_tokens
+`
+
+func scanToken(row pgx.Row) (*FeedToken, error) {
+	var t FeedToken
+	if err := row.Scan(&t.ID, &t.ShopID, &t.StaffUserID, &t.Token, &t.CreatedBy, &t.CreatedAt, &t.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Active returns the active token of the shop feed (staffUserID empty) or of a staff member's feed;
+// pgx.ErrNoRows when there is none.
+func (r *Repository) Active(ctx context.Context, shopID, staffUserID string) (*FeedToken, error) {
+	const q = selectToken + `WHERE shop_id = $1 AND COALESCE(staff_user_id,'') = $2 AND revoked_at IS NULL`
+	return scanToken(r.db.QueryRow(ctx, q, shopID, staffUserID))
+}
+
+// GetActiveByToken resolves a feed URL's token; revoked tokens are pgx.ErrNoRows.
+func (r *Repository) GetActiveByToken(ctx context.Context, token string) (*FeedToken, error) {
+	const q = selectToken + `WHERE token = $1 AND revoked_at IS NULL`
+	return scanToken(r.db.QueryRow(ctx, q, token))
+}
+
+// Rotate revokes the feed's active token, if any, and issues a new one. Subscribers of the old URL stop
+// receiving updates.
+func Rotate(ctx context.Context, tx pgx.Tx, shopID, staffUserID, actor string, now time.Time) (*FeedToken, error) {
+	revoked, err := revoke(ctx, tx, shopID, staffUserID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `
+INSERT INTO calendar_feed_tokens (shop_id, staff_user_id, token, created_by)
+VALUES ($1, NULLIF($2, ''), $3, $4)
+RETURNING id, shop_id, COALESCE(staff_user_id,''), token, created_by, created_at, revoked_at
+`
+	t, err := scanToken(tx.QueryRow(ctx, q, shopID, staffUserID, randomHex(32), actor))
+	if err != nil {
+		return nil, err
+	}
+	return t, audit.Insert(ctx, tx, shopID, nil, "CALENDAR_FEED_ROTATED", actor, map[string]any{
+		"feed": feedName(staffUserID), "staffUserId": staffUserID, "replacedExisting": revoked,
+	})
+}
+
+// Revoke revokes the feed's active token. It reports whether there was one.
+func Revoke(ctx context.Context, tx pgx.Tx, shopID, staffUserID, actor string, now time.Time) (bool, error) {
+	revoked, err := revoke(ctx, tx, shopID, staffUserID, now)
+	if err != nil || !revoked {
+		return false, err
+	}
+	return true, audit.Insert(ctx, tx, shopID, nil, "CALENDAR_FEED_REVOKED", actor, map[string]any{
+		"feed": feedName(staffUserID), "staffUserId": staffUserID,
+	})
+}
+
+func revoke(ctx context.Context, tx pgx.Tx, shopID, staffUserID string, now time.Time) (bool, error) {
+	const q = `
+UPDATE calendar_feed_tokens SET revoked_at = $3
+WHERE shop_id = $1 AND COALESCE(staff_user_id,'') = $2 AND revoked_at IS NULL
+`
+	tag, err := tx.Exec(ctx, q, shopID, staffUserID, now)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func feedName(staffUserID string) string {
+	if staffUserID == "" {
+		return "shop"
+	}
+	return "staff"
+}
+
+func randomHex(nBytes int) string {
+	b := make([]byte, nBytes)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
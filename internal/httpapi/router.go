@@ -6,16 +6,23 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"microservice/internal/adminaction"
 	"microservice/internal/api"
+	"microservice/internal/audit"
 	"microservice/internal/auth"
+	"microservice/internal/booking"
+	"microservice/internal/calendar"
 	"microservice/internal/approval"
+	"microservice/internal/bulk"
 	"microservice/internal/files"
 	"microservice/internal/milestone"
 	"microservice/internal/payment"
 	"microservice/internal/portal"
+	"microservice/internal/recurring"
 	"microservice/internal/service"
 	"microservice/internal/serviceproduct"
 	"microservice/internal/shop"
+	"microservice/internal/staff"
 	"microservice/internal/webhook"
 	"microservice/pkg/config"
 )
@@ -34,28 +41,58 @@ func NewRouter(deps Dependencies) http.Handler {
 	})
 
 	shopsRepo := shop.NewRepository(deps.DB)
+	staffRepo := staff.NewRepository(deps.DB)
 	authHandlers := auth.Handlers{
 		Cfg:   deps.Cfg,
 		Shops: shopsRepo,
 	}
 	serviceProductRepo := serviceproduct.NewRepository(deps.DB)
-	serviceProductHandlers := serviceproduct.Handlers{Repo: serviceProductRepo}
+	serviceProductHandlers := serviceproduct.Handlers{Cfg: deps.Cfg, Repo: serviceProductRepo}
 	filesRepo := files.NewRepository(deps.DB)
 	merchantFilesHandlers := files.MerchantHandlers{DB: deps.DB, Repo: filesRepo}
 	serviceRepo := service.NewRepository(deps.DB)
 	milestoneRepo := milestone.NewRepository(deps.DB)
 	approvalRepo := approval.NewRepository(deps.DB)
 	serviceHandlers := service.Handlers{
-		DB:         deps.DB,
-		Services:   serviceRepo,
-		Milestones: milestoneRepo,
-		Approvals:  approvalRepo,
+		Cfg:          deps.Cfg,
+		DB:           deps.DB,
+		Services:     serviceRepo,
+		Milestones:   milestoneRepo,
+		Approvals:    approvalRepo,
+		AdminActions: adminaction.NewRepository(deps.DB),
 	}
 	paymentHandlers := payment.Handlers{
 		Cfg:        deps.Cfg,
 		DB:         deps.DB,
 		Milestones: milestoneRepo,
+		Rails:      payment.NewRails(deps.Cfg),
+	}
+	recurrenceRepo := recurring.NewRepository(deps.DB)
+	recurringHandlers := recurring.Handlers{DB: deps.DB, Repo: recurrenceRepo, Rails: paymentHandlers.Rails}
+	bulkHandlers := bulk.Handlers{
+		Exporter: bulk.Exporter{
+			DB:         deps.DB,
+			Services:   serviceRepo,
+			Milestones: milestoneRepo,
+			Approvals:  approvalRepo,
+			Files:      filesRepo,
+		},
+		Importer: bulk.Importer{DB: deps.DB},
 	}
+	bookingRepo := booking.NewRepository(deps.DB)
+	bookingHandlers := booking.Handlers{DB: deps.DB, Repo: bookingRepo, Services: serviceRepo}
+	calendarHandlers := calendar.Handlers{
+		Cfg:  deps.Cfg,
+		DB:   deps.DB,
+		Repo: calendar.NewRepository(deps.DB),
+		Feed: calendar.Feed{DB: deps.DB, Bookings: bookingRepo, Recurrences: recurrenceRepo, Staff: staffRepo},
+	}
+	auditHandlers := audit.Handlers{Repo: audit.NewRepository(deps.DB)}
+	staffHandlers := staff.Handlers{DB: deps.DB, Repo: staffRepo}
+	authz := staff.Authorizer{Repo: staffRepo, AppEnv: deps.Cfg.AppEnv}
+	viewer := authz.Require(staff.RoleViewer)
+	operator := authz.Require(staff.RoleOperator)
+	financeAdmin := authz.Require(staff.RoleFinanceAdmin)
 	webhookHandler := webhook.Handler{
 		Cfg:             deps.Cfg,
 		DB:              deps.DB,
@@ -73,23 +110,85 @@ func NewRouter(deps Dependencies) http.Handler {
 		r.Group(func(r chi.Router) {
 			// Production: Shopify embedded session token auth
 			// Dev: falls back to X-Shop-Domain if Authorization is missing.
-			r.Use(api.ShopifySessionAuth(deps.Cfg, shopsRepo))
+			r.Use(api.ShopifySessionAuth(deps.Cfg, shopsRepo, staffRepo))
 
 			// Service product config
-			r.Get("/service-products", serviceProductHandlers.List)
-			r.Put("/service-products/{shopify_product_id}", serviceProductHandlers.Put)
+			r.With(viewer).Get("/service-products", serviceProductHandlers.List)
+			r.With(operator).Put("/service-products/{shopify_product_id}", serviceProductHandlers.Put)
+			r.With(operator).Patch("/service-products/{shopify_product_id}", serviceProductHandlers.Patch)
+			r.With(operator).Delete("/service-products/{shopify_product_id}", serviceProductHandlers.Delete)
+			r.With(viewer).Post("/service-products/{shopify_product_id}/simulate", serviceProductHandlers.Simulate)
+			r.With(viewer).Get("/service-products/{shopify_product_id}/versions", serviceProductHandlers.Versions)
+			r.With(viewer).Get("/service-products/{shopify_product_id}/versions/diff", serviceProductHandlers.DiffVersions)
+			r.With(operator).Post("/service-products/{shopify_product_id}/versions/{version}/restore", serviceProductHandlers.RestoreVersion)
+			// Variant configs override the product's config for orders of that variant.
+			r.With(operator).Put("/service-products/{shopify_product_id}/variants/{shopify_variant_id}", serviceProductHandlers.Put)
+			r.With(operator).Patch("/service-products/{shopify_product_id}/variants/{shopify_variant_id}", serviceProductHandlers.Patch)
+			r.With(operator).Delete("/service-products/{shopify_product_id}/variants/{shopify_variant_id}", serviceProductHandlers.Delete)
+			r.With(viewer).Post("/service-products/{shopify_product_id}/variants/{shopify_variant_id}/simulate", serviceProductHandlers.Simulate)
+			r.With(viewer).Get("/service-products/{shopify_product_id}/variants/{shopify_variant_id}/versions", serviceProductHandlers.Versions)
+			r.With(viewer).Get("/service-products/{shopify_product_id}/variants/{shopify_variant_id}/versions/diff", serviceProductHandlers.DiffVersions)
+			r.With(operator).Post("/service-products/{shopify_product_id}/variants/{shopify_variant_id}/versions/{version}/restore", serviceProductHandlers.RestoreVersion)
+			// Shop default template and rules for products without a config of their own.
+			r.With(viewer).Get("/service-config/default", serviceProductHandlers.GetDefault)
+			r.With(operator).Put("/service-config/default", serviceProductHandlers.PutDefault)
+			r.With(viewer).Get("/service-config/rules", serviceProductHandlers.ListRules)
+			r.With(operator).Put("/service-config/rules", serviceProductHandlers.PutRule)
+			r.With(operator).Delete("/service-config/rules/{id}", serviceProductHandlers.DeleteRule)
 
 			// Services (still to implement)
-			r.Get("/services", serviceHandlers.List)
-			r.Get("/services/{id}", serviceHandlers.Get)
-			r.Patch("/services/{id}/status", serviceHandlers.PatchStatus)
-			r.Get("/services/{id}/events", serviceHandlers.Events)
-			r.Post("/services/{id}/admin/override", serviceHandlers.AdminOverride)
-			r.Post("/services/{id}/files", merchantFilesHandlers.Create)
-			r.Get("/services/{id}/files", merchantFilesHandlers.List)
+			r.With(viewer).Get("/services", serviceHandlers.List)
+			r.With(viewer).Get("/services/{id}", serviceHandlers.Get)
+			r.With(operator).Patch("/services/{id}/status", serviceHandlers.PatchStatus)
+			r.With(viewer).Get("/services/{id}/events", serviceHandlers.Events)
+			r.With(operator).Post("/services/{id}/admin/override", serviceHandlers.AdminOverride)
+			r.With(viewer).Get("/services/{id}/admin/overrides", serviceHandlers.ListOverrides)
+			r.With(operator).Post("/services/{id}/admin/overrides/{actionId}/confirm", serviceHandlers.ConfirmOverride)
+			r.With(operator).Post("/services/{id}/admin/overrides/{actionId}/reject", serviceHandlers.RejectOverride)
+			r.With(operator).Patch("/milestones/{id}/deliverables/{deliverableId}", serviceHandlers.SetDeliverable)
+			r.With(operator).Post("/services/{id}/files", merchantFilesHandlers.Create)
+			r.With(viewer).Get("/services/{id}/files", merchantFilesHandlers.List)
+
+			// Booking: availability and the slot booked for a service
+			r.With(viewer).Get("/booking/availability", bookingHandlers.GetAvailability)
+			r.With(operator).Put("/booking/availability", bookingHandlers.PutAvailability)
+			r.With(viewer).Get("/services/{id}/booking", bookingHandlers.Get)
+			r.With(operator).Post("/services/{id}/booking/reschedule", bookingHandlers.Reschedule)
+			r.With(operator).Post("/services/{id}/booking/cancel", bookingHandlers.Cancel)
+
+			// Calendar feeds: secret ICS URLs for the shop and for each staff member
+			r.With(viewer).Get("/calendar-feeds", calendarHandlers.List)
+			r.With(operator).Post("/calendar-feeds/shop/rotate", calendarHandlers.RotateShop)
+			r.With(operator).Delete("/calendar-feeds/shop", calendarHandlers.RevokeShop)
+			r.With(viewer).Post("/calendar-feeds/me/rotate", calendarHandlers.RotateMine)
+			r.With(viewer).Delete("/calendar-feeds/me", calendarHandlers.RevokeMine)
+			r.With(financeAdmin).Delete("/calendar-feeds/staff/{userId}", calendarHandlers.RevokeStaff)
+
+			// Audit log
+			r.With(viewer).Get("/audit", auditHandlers.List)
+			r.With(viewer).Get("/audit/verify", auditHandlers.Verify)
+
+			// Staff roles
+			r.With(viewer).Get("/staff", staffHandlers.List)
+			r.With(financeAdmin).Put("/staff/{userId}/role", staffHandlers.PutRole)
+
+			// Bulk export/import
+			r.With(viewer).Get("/export/services", bulkHandlers.Export)
+			r.With(financeAdmin).Post("/import/services", bulkHandlers.Import)
 
 			// Milestones payments
-			r.Post("/milestones/{id}/request-payment", paymentHandlers.RequestPayment)
+			r.With(operator).Post("/milestones/{id}/request-payment", paymentHandlers.RequestPayment)
+			r.With(operator).Post("/milestones/{id}/cancel-payment-request", paymentHandlers.CancelPaymentRequest)
+			r.With(financeAdmin).Post("/milestones/{id}/confirm-payment", paymentHandlers.ConfirmPayment)
+			r.With(operator).Post("/services/{id}/pay-remaining-balance", paymentHandlers.PayRemainingBalance)
+			r.With(operator).Delete("/services/{id}/auto-charge", paymentHandlers.DisableAutoCharge)
+
+			// Recurring services
+			r.With(viewer).Get("/services/{id}/recurrence", recurringHandlers.Get)
+			r.With(operator).Post("/services/{id}/recurrence/cancel", recurringHandlers.Cancel)
+
+			// Shop settings
+			r.With(financeAdmin).Put("/settings/payment-rail", paymentHandlers.PutShopRail)
 		})
 
 		// Portal
@@ -103,17 +202,31 @@ func NewRouter(deps Dependencies) http.Handler {
 				MaxAgeSeconds:  600,
 			}))
 
-			portalHandlers := portal.Handlers{DB: deps.DB, Milestones: milestoneRepo, Cfg: deps.Cfg}
+			portalHandlers := portal.Handlers{DB: deps.DB, Milestones: milestoneRepo, Cfg: deps.Cfg,
+				Bundles:     payment.Bundles{Cfg: deps.Cfg, Rails: paymentHandlers.Rails},
+				Requests:    payment.Requests{Rails: paymentHandlers.Rails},
+				Recurrences: recurrenceRepo,
+				Bookings:    bookingRepo}
 			r.Get("/{token}", portalHandlers.View)
 			r.Get("/{token}/events", portalHandlers.Events)
 			r.Post("/{token}/approve", portalHandlers.Approve)
 			r.Post("/{token}/request-revision", portalHandlers.RequestRevision)
+			r.Post("/{token}/pay-remaining-balance", portalHandlers.PayRemainingBalance)
+			r.Post("/{token}/milestones/{id}/pay", portalHandlers.PayMilestone)
+			r.Get("/{token}/slots", portalHandlers.Slots)
+			r.Post("/{token}/booking", portalHandlers.Book)
+			r.Post("/{token}/booking/reschedule", portalHandlers.RescheduleBooking)
+			r.Post("/{token}/booking/cancel", portalHandlers.CancelBooking)
+			r.Get("/{token}/booking.ics", portalHandlers.BookingICS)
 
 			portalFilesHandlers := files.PortalHandlers{DB: deps.DB, Repo: filesRepo}
 			r.Post("/{token}/files", portalFilesHandlers.Create)
 			r.Get("/{token}/files", portalFilesHandlers.List)
 		})
 
+		// Calendar feeds (public; the token in the URL is the credential)
+		r.Get("/calendar/{token}.ics", calendarHandlers.Serve)
+
 		// Webhooks
 		r.Post("/webhooks/shopify/{topic}", webhookHandler.ServeHTTP)
 	})
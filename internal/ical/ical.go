@@ -0,0 +1,151 @@
+// Package ical writes iCalendar (RFC 5545) documents. Times are written in UTC, so no VTIMEZONE is needed;
+// calendar clients show them in the viewer's own timezone.
+package ical
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ProdID identifies the app as the producer of every calendar it writes.
+const ProdID = "-//microservice//Service Milestones//EN"
+
+// ContentType is the media type calendars are served with.
+const ContentType = "text/calendar; charset=utf-8"
+
+// Calendar is a VCALENDAR of events.
+type Calendar struct {
+	Name     string // X-WR-CALNAME, shown by most clients as the calendar title
+	Timezone string // X-WR-TIMEZONE, the zone all-day events were dated in
+	Events   []Event
+}
+
+// Event is a VEVENT. UID must stay the same for the life of the appointment and Sequence must grow with every
+// change to it, so clients update the event they already have rather than adding another.
+type Event struct {
+	UID         string
+	Sequence    int
+	Summary     string
+	Description string
+	URL         string
+	Start       time.Time
+	End         time.Time // zero: no DTEND
+	// AllDay writes Start as a date (and End, when set, as the exclusive end date).
+	AllDay bool
+	// RRule repeats the event, e.g. "FREQ=WEEKLY;INTERVAL=2;COUNT=5"; Start is the first occurrence.
+	RRule     string
+	Cancelled bool      // STATUS:CANCELLED; clients remove the event
+	Updated   time.Time // DTSTAMP and LAST-MODIFIED; zero means now
+}
+
+// Bytes renders the calendar.
+func (c Calendar) Bytes() []byte {
+	var buf bytes.Buffer
+	_ = c.Write(&buf)
+	return buf.Bytes()
+}
+
+// Write renders the calendar to w.
+func (c Calendar) Write(w io.Writer) error {
+	lw := &lineWriter{w: w}
+	lw.line("BEGIN:VCALENDAR")
+	lw.line("VERSION:2.0")
+	lw.line("PRODID:" + ProdID)
+	lw.line("CALSCALE:GREGORIAN")
+	lw.line("METHOD:PUBLISH")
+	if c.Name != "" {
+		lw.line("X-WR-CALNAME:" + Escape(c.Name))
+	}
+	if c.Timezone != "" {
+		lw.line("X-WR-TIMEZONE:" + c.Timezone)
+	}
+	now := time.Now()
+	for _, e := range c.Events {
+		e.write(lw, now)
+	}
+	lw.line("END:VCALENDAR")
+	return lw.err
+}
+
+func (e Event) write(lw *lineWriter, now time.Time) {
+	stamp := e.Updated
+	if stamp.IsZero() {
+		stamp = now
+	}
+	lw.line("BEGIN:VEVENT")
+	lw.line("UID:" + Escape(e.UID))
+	lw.line("SEQUENCE:" + strconv.Itoa(e.Sequence))
+	lw.line("DTSTAMP:" + utc(stamp))
+	lw.line("LAST-MODIFIED:" + utc(stamp))
+	if e.AllDay {
+		lw.line("DTSTART;VALUE=DATE:" + e.Start.Format("20060102"))
+		if !e.End.IsZero() {
+			lw.line("DTEND;VALUE=DATE:" + e.End.Format("20060102"))
+		}
+	} else {
+		lw.line("DTSTART:" + utc(e.Start))
+		if !e.End.IsZero() {
+			lw.line("DTEND:" + utc(e.End))
+		}
+	}
+	if e.RRule != "" {
+		lw.line("RRULE:" + e.RRule)
+	}
+	lw.line("SUMMARY:" + Escape(e.Summary))
+	if e.Description != "" {
+		lw.line("DESCRIPTION:" + Escape(e.Description))
+	}
+	if e.URL != "" {
+		lw.line("URL:" + e.URL)
+	}
+	if e.Cancelled {
+		lw.line("STATUS:CANCELLED")
+	} else {
+		lw.line("STATUS:CONFIRMED")
+	}
+	lw.line("END:VEVENT")
+}
+
+func utc(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Escape escapes a TEXT value (RFC 5545 3.3.11).
+func Escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+	return r.Replace(s)
+}
+
+// maxLineOctets is the longest content line RFC 5545 allows before folding, excluding the CRLF.
+const maxLineOctets = 75
+
+type lineWriter struct {
+	w   io.Writer
+	err error
+}
+
+// line writes a content line, folded at 75 octets without splitting a UTF-8 sequence.
+func (lw *lineWriter) line(s string) {
+	if lw.err != nil {
+		return
+	}
+	var b strings.Builder
+	limit := maxLineOctets
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		limit = maxLineOctets - 1 // the leading space counts
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+	_, lw.err = io.WriteString(lw.w, b.String())
+}
@@ -0,0 +1,70 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscape(t *testing.T) {
+	got := Escape("a,b;c\\d\ne")
+	if want := `a\,b\;c\\d\ne`; got != want {
+		t.Fatalf("Escape = %q, want %q", got, want)
+	}
+}
+
+func TestCalendar_Event(t *testing.T) {
+	start := time.Date(2026, 11, 2, 9, 30, 0, 0, time.FixedZone("CET", 3600))
+	cal := Calendar{Name: "Bookings", Events: []Event{{
+		UID: "b1@booking", Sequence: 2, Summary: "Appointment, shop", Start: start, End: start.Add(time.Hour),
+		Cancelled: true, Updated: start,
+	}}}
+	out := string(cal.Bytes())
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n", "X-WR-CALNAME:Bookings\r\n", "UID:b1@booking\r\n", "SEQUENCE:2\r\n",
+		"DTSTART:20261102T083000Z\r\n", "DTEND:20261102T093000Z\r\n", "SUMMARY:Appointment\\, shop\r\n",
+		"STATUS:CANCELLED\r\n", "END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("calendar missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCalendar_AllDay(t *testing.T) {
+	day := time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)
+	out := string(Calendar{Events: []Event{{UID: "m1", Summary: "Due", Start: day, AllDay: true, RRule: "FREQ=WEEKLY;COUNT=3"}}}.Bytes())
+	if !strings.Contains(out, "DTSTART;VALUE=DATE:20261102\r\n") || strings.Contains(out, "DTEND") {
+		t.Fatalf("unexpected all-day event:\n%s", out)
+	}
+	if !strings.Contains(out, "RRULE:FREQ=WEEKLY;COUNT=3\r\n") {
+		t.Fatalf("calendar missing the RRULE:\n%s", out)
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	summary := strings.Repeat("é", 60) // 120 octets
+	out := string(Calendar{Events: []Event{{UID: "x", Summary: summary, Start: time.Now()}}}.Bytes())
+
+	var unfolded []string
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > maxLineOctets {
+			t.Fatalf("line longer than %d octets: %q", maxLineOctets, line)
+		}
+		if strings.HasPrefix(line, " ") {
+			unfolded[len(unfolded)-1] += line[1:]
+			continue
+		}
+		unfolded = append(unfolded, line)
+	}
+	found := false
+	for _, line := range unfolded {
+		if line == "SUMMARY:"+summary {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("folded summary does not unfold to the original:\n%s", out)
+	}
+}
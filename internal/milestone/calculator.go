@@ -1,77 +1,198 @@
 package milestone
 
 import (
+	"sort"
+
 	"github.com/shopspring/decimal"
+
+	"microservice/pkg/currency"
 )
 
 // CalculatedMilestone is an instance amount computed from a template.
 // Sequence is assigned by the caller (deposit is expected to be sequence 0).
 type CalculatedMilestone struct {
-	Amount decimal.Decimal
-	IsFinal bool
+	Amount    decimal.Decimal
+	IsFinal   bool
+	DueInDays int
+	Details   Details
 }
 
+// CurrencyScale is the number of decimal places amounts of a currency are rounded to: its ISO 4217 minor units.
 type CurrencyScale int32
 
-const DefaultCurrencyScale CurrencyScale = 2
+// ScaleFor returns the scale of the currency. Unknown or missing currencies are an error rather than a guess.
+func ScaleFor(code string) (CurrencyScale, error) {
+	n, err := currency.MinorUnits(code)
+	if err != nil {
+		return 0, ValidationError{Code: "CURRENCY_INVALID", Message: err.Error()}
+	}
+	return CurrencyScale(n), nil
+}
+
+// Format renders amount with exactly the scale's decimal places.
+func (s CurrencyScale) Format(amount decimal.Decimal) string {
+	return amount.StringFixed(int32(s))
+}
+
+// Rounding decides which milestones absorb the difference between the rounded amounts and the total.
+type Rounding string
+
+const (
+	// RoundingFinal puts the whole rounding delta on the final milestone. It is the default.
+	RoundingFinal Rounding = "final"
+	// RoundingDeposit puts the whole rounding delta on the deposit (the first milestone).
+	RoundingDeposit Rounding = "deposit"
+	// RoundingLargestRemainder rounds every amount down and hands the leftover minor units, one each, to the
+	// milestones that lost the most to rounding (earlier milestones win ties).
+	RoundingLargestRemainder Rounding = "largest_remainder"
+)
+
+// ValidateRounding accepts the known strategies and the empty default.
+func ValidateRounding(r Rounding) error {
+	switch r {
+	case "", RoundingFinal, RoundingDeposit, RoundingLargestRemainder:
+		return nil
+	}
+	return ValidationError{Code: "ROUNDING_INVALID", Message: "rounding must be final, deposit or largest_remainder"}
+}
 
 // CalculateAmounts computes milestone amounts from templates.
 //
 // Rules:
 // - The sum of calculated milestone amounts must equal total (validated).
 // - Percentages are applied against total (not remaining) for determinism.
-// - Rounding is applied to the configured scale; any rounding delta is applied to the final milestone to force sum equality.
-func CalculateAmounts(total decimal.Decimal, templates []MilestoneTemplate, scale CurrencyScale) ([]CalculatedMilestone, error) {
+// - A remainder template takes whatever the other templates leave of the total.
+// - Amounts are rounded to the configured scale; rounding distributes the delta so the sum equals the total.
+func CalculateAmounts(total decimal.Decimal, templates []MilestoneTemplate, scale CurrencyScale, rounding Rounding) ([]CalculatedMilestone, error) {
 	if err := ValidateTemplate(templates); err != nil {
 		return nil, err
 	}
+	if err := ValidateRounding(rounding); err != nil {
+		return nil, err
+	}
 	if total.LessThanOrEqual(decimal.Zero) {
 		return nil, ValidationError{Code: "SERVICE_TOTAL_INVALID", Message: "service total must be > 0"}
 	}
 
-	if scale <= 0 {
-		scale = DefaultCurrencyScale
+	if scale < 0 || scale > currency.MaxMinorUnits {
+		return nil, ValidationError{Code: "CURRENCY_INVALID", Message: "currency scale must be between 0 and 3"}
 	}
+	total = total.Round(int32(scale))
 
-	out := make([]CalculatedMilestone, 0, len(templates))
-	sum := decimal.Zero
-	for _, t := range templates {
-		var amt decimal.Decimal
+	// Exact (unrounded) amounts first, so the remainder and the rounding see the same numbers.
+	exact := make([]decimal.Decimal, len(templates))
+	remainder := -1
+	allocated := decimal.Zero
+	for i, t := range templates {
 		switch t.Type {
 		case TemplateTypeFixed:
-			amt = t.Value
+			exact[i] = t.Value
 		case TemplateTypePercentage:
 			// Value is percentage like 30 for 30%.
-			amt = total.Mul(t.Value).Div(decimal.NewFromInt(100))
+			exact[i] = total.Mul(t.Value).Div(decimal.NewFromInt(100))
+		case TemplateTypeRemainder:
+			remainder = i
+			continue
 		default:
-			return nil, ValidationError{Code: "MILESTONE_TYPE_INVALID", Message: "milestone type must be fixed or percentage"}
+			return nil, ValidationError{Code: "MILESTONE_TYPE_INVALID", Message: "milestone type must be fixed, percentage or remainder"}
 		}
-		amt = amt.Round(int32(scale))
-		out = append(out, CalculatedMilestone{Amount: amt, IsFinal: t.IsFinal})
-		sum = sum.Add(amt)
+		allocated = allocated.Add(exact[i])
 	}
-
-	delta := total.Round(int32(scale)).Sub(sum)
-	if !delta.IsZero() {
-		// Apply rounding delta to the final milestone (must exist and be last by contract).
-		last := len(out) - 1
-		out[last] = CalculatedMilestone{
-			Amount: out[last].Amount.Add(delta).Round(int32(scale)),
-			IsFinal: true,
+	if remainder >= 0 {
+		exact[remainder] = total.Sub(allocated)
+		if exact[remainder].Round(int32(scale)).LessThanOrEqual(decimal.Zero) {
+			return nil, ValidationError{Code: "MILESTONE_REMAINDER_INVALID", Message: "the other milestones leave nothing for the remainder milestone"}
 		}
-		sum = sum.Add(delta).Round(int32(scale))
 	}
 
-	if !sum.Equal(total.Round(int32(scale))) {
+	var amounts []decimal.Decimal
+	switch rounding {
+	case RoundingDeposit:
+		amounts = absorbDelta(total, exact, scale, 0)
+	case RoundingLargestRemainder:
+		amounts = largestRemainder(total, exact, scale)
+	default:
+		amounts = absorbDelta(total, exact, scale, len(exact)-1)
+	}
+
+	out := make([]CalculatedMilestone, len(templates))
+	sum := decimal.Zero
+	for i, t := range templates {
+		out[i] = CalculatedMilestone{Amount: amounts[i], IsFinal: t.IsFinal, DueInDays: t.DueInDays, Details: t.Details}
+		sum = sum.Add(amounts[i])
+	}
+
+	if !sum.Equal(total) {
 		return nil, ValidationError{Code: "MILESTONE_SUM_MISMATCH", Message: "milestone amounts do not sum to service total"}
 	}
 
-	// Prevent zero/negative final milestone due to delta.
-	if out[len(out)-1].Amount.LessThanOrEqual(decimal.Zero) {
-		return nil, ValidationError{Code: "FINAL_MILESTONE_INVALID", Message: "final milestone amount must be > 0"}
+	// Prevent zero/negative milestones due to the delta.
+	for i, m := range out {
+		if m.Amount.GreaterThan(decimal.Zero) {
+			continue
+		}
+		switch {
+		case i == len(out)-1:
+			return nil, ValidationError{Code: "FINAL_MILESTONE_INVALID", Message: "final milestone amount must be > 0"}
+		case i == 0 && rounding == RoundingDeposit:
+			return nil, ValidationError{Code: "DEPOSIT_MILESTONE_INVALID", Message: "deposit amount must be > 0"}
+		case rounding == RoundingLargestRemainder:
+			return nil, ValidationError{Code: "MILESTONE_AMOUNT_INVALID", Message: "milestone amounts must be > 0"}
+		}
 	}
 
 	return out, nil
 }
 
+// absorbDelta rounds every amount and puts the difference to the total on the milestone at index at.
+func absorbDelta(total decimal.Decimal, exact []decimal.Decimal, scale CurrencyScale, at int) []decimal.Decimal {
+	out := make([]decimal.Decimal, len(exact))
+	sum := decimal.Zero
+	for i, amt := range exact {
+		out[i] = amt.Round(int32(scale))
+		sum = sum.Add(out[i])
+	}
+	out[at] = out[at].Add(total.Sub(sum))
+	return out
+}
 
+// largestRemainder rounds every amount down to the scale, then gives one minor unit each to the milestones with
+// the largest dropped fractions until the total is reached. Any difference that is not rounding (fixed amounts
+// that do not add up to the total) goes to the final milestone first, as it does by default.
+func largestRemainder(total decimal.Decimal, exact []decimal.Decimal, scale CurrencyScale) []decimal.Decimal {
+	adjusted := append([]decimal.Decimal(nil), exact...)
+	sum := decimal.Zero
+	for _, amt := range exact {
+		sum = sum.Add(amt)
+	}
+	last := len(adjusted) - 1
+	adjusted[last] = adjusted[last].Add(total.Sub(sum))
+
+	units := make([]decimal.Decimal, len(adjusted))
+	fractions := make([]decimal.Decimal, len(adjusted))
+	floored := decimal.Zero
+	for i, amt := range adjusted {
+		shifted := amt.Shift(int32(scale))
+		units[i] = shifted.Floor()
+		fractions[i] = shifted.Sub(units[i])
+		floored = floored.Add(units[i])
+	}
+
+	order := make([]int, len(adjusted))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return fractions[order[a]].GreaterThan(fractions[order[b]])
+	})
+	left := total.Shift(int32(scale)).Sub(floored).IntPart()
+	for k := 0; k < int(left) && k < len(order); k++ {
+		units[order[k]] = units[order[k]].Add(decimal.NewFromInt(1))
+	}
+
+	out := make([]decimal.Decimal, len(units))
+	for i, u := range units {
+		out[i] = u.Shift(-int32(scale))
+	}
+	return out
+}
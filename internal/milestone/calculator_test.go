@@ -1,7 +1,11 @@
 package milestone
 
 import (
+	"errors"
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
 
 	"github.com/shopspring/decimal"
 )
@@ -14,7 +18,7 @@ func TestCalculateAmounts_PercentageWithRoundingDeltaAppliedToFinal(t *testing.T
 		{Type: TemplateTypePercentage, Value: decimal.NewFromInt(34), IsFinal: true},  // 34.00
 	}
 
-	got, err := CalculateAmounts(total, templates, DefaultCurrencyScale)
+	got, err := CalculateAmounts(total, templates, CurrencyScale(2), RoundingFinal)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -44,4 +48,200 @@ func TestValidateTemplate_FinalMustBeLast(t *testing.T) {
 	}
 }
 
+func TestCalculateAmounts_UsesCurrencyMinorUnits(t *testing.T) {
+	templates := []MilestoneTemplate{
+		{Type: TemplateTypePercentage, Value: decimal.NewFromInt(33), IsFinal: false},
+		{Type: TemplateTypePercentage, Value: decimal.NewFromInt(67), IsFinal: true},
+	}
+	cases := []struct {
+		currency, total string
+		want            []string
+	}{
+		{"JPY", "10001", []string{"3300", "6701"}},
+		{"KWD", "10.001", []string{"3.300", "6.701"}},
+		{"EUR", "10.01", []string{"3.30", "6.71"}},
+	}
+	for _, c := range cases {
+		scale, err := ScaleFor(c.currency)
+		if err != nil {
+			t.Fatalf("ScaleFor(%s): %v", c.currency, err)
+		}
+		got, err := CalculateAmounts(decimal.RequireFromString(c.total), templates, scale, RoundingFinal)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.currency, err)
+		}
+		for i, m := range got {
+			if s := scale.Format(m.Amount); s != c.want[i] {
+				t.Fatalf("%s milestone %d = %s, want %s", c.currency, i, s, c.want[i])
+			}
+		}
+	}
+}
+
+func TestScaleFor_RejectsUnknownCurrency(t *testing.T) {
+	for _, code := range []string{"", "ZZZ"} {
+		_, err := ScaleFor(code)
+		var ve ValidationError
+		if !errors.As(err, &ve) || ve.Code != "CURRENCY_INVALID" {
+			t.Fatalf("ScaleFor(%q) = %v, want CURRENCY_INVALID", code, err)
+		}
+	}
+}
+
+// split is a random milestone split: a total in some currency and templates mixing fixed amounts, percentages
+// and at most one remainder. Fixed amounts stay small enough that the remainder keeps a share.
+type split struct {
+	Total     decimal.Decimal
+	Scale     CurrencyScale
+	Templates []MilestoneTemplate
+}
+
+func (split) Generate(r *rand.Rand, _ int) reflect.Value {
+	scale := CurrencyScale(r.Intn(4))
+	units := int64(100 + r.Intn(10_000_000))
+	total := decimal.New(units, -int32(scale))
 
+	n := 1 + r.Intn(6)
+	templates := make([]MilestoneTemplate, n)
+	remainder := -1
+	if r.Intn(2) == 0 {
+		remainder = r.Intn(n)
+	}
+	percentLeft := 100
+	for i := range templates {
+		switch {
+		case i == remainder:
+			templates[i] = MilestoneTemplate{Type: TemplateTypeRemainder}
+		case r.Intn(2) == 0 || percentLeft <= 1:
+			// Fixed amounts take at most a tenth of the total each, with up to four decimals.
+			value := decimal.New(1+r.Int63n(units*10), -int32(scale)-2).Round(4)
+			if value.Sign() <= 0 {
+				value = decimal.New(1, -int32(scale))
+			}
+			templates[i] = MilestoneTemplate{Type: TemplateTypeFixed, Value: value}
+		default:
+			p := 1 + r.Intn(percentLeft/2)
+			percentLeft -= p
+			templates[i] = MilestoneTemplate{Type: TemplateTypePercentage, Value: decimal.New(int64(p)*100+int64(r.Intn(100)), -2)}
+		}
+	}
+	templates[n-1].IsFinal = true
+	return reflect.ValueOf(split{Total: total, Scale: scale, Templates: templates})
+}
+
+func TestCalculateAmounts_AlwaysSumsToTotal(t *testing.T) {
+	for _, rounding := range []Rounding{RoundingFinal, RoundingDeposit, RoundingLargestRemainder} {
+		property := func(s split) bool {
+			got, err := CalculateAmounts(s.Total, s.Templates, s.Scale, rounding)
+			if err != nil {
+				// Rejections are fine as long as they are the documented ones, never a wrong sum.
+				var ve ValidationError
+				return errors.As(err, &ve) && ve.Code != "MILESTONE_SUM_MISMATCH"
+			}
+			sum := decimal.Zero
+			for _, m := range got {
+				if m.Amount.Round(int32(s.Scale)).Cmp(m.Amount) != 0 {
+					t.Logf("%s: amount %s exceeds scale %d", rounding, m.Amount, s.Scale)
+					return false
+				}
+				sum = sum.Add(m.Amount)
+			}
+			if !sum.Equal(s.Total) {
+				t.Logf("%s: %v sums to %s, want %s", rounding, got, sum, s.Total)
+				return false
+			}
+			return true
+		}
+		if err := quick.Check(property, &quick.Config{MaxCount: 2000, Rand: rand.New(rand.NewSource(42))}); err != nil {
+			t.Fatalf("%s: %v", rounding, err)
+		}
+	}
+}
+
+func TestCalculateAmounts_LargestRemainderStaysWithinOneUnit(t *testing.T) {
+	property := func(s split) bool {
+		got, err := CalculateAmounts(s.Total, s.Templates, s.Scale, RoundingLargestRemainder)
+		if err != nil {
+			return true
+		}
+		// Every milestone but the final (which also absorbs any gap left by fixed amounts) is within one minor
+		// unit of its exact share.
+		unit := decimal.New(1, -int32(s.Scale))
+		for i, m := range got[:len(got)-1] {
+			tpl := s.Templates[i]
+			var exact decimal.Decimal
+			switch tpl.Type {
+			case TemplateTypeFixed:
+				exact = tpl.Value
+			case TemplateTypePercentage:
+				exact = s.Total.Mul(tpl.Value).Div(decimal.NewFromInt(100))
+			default:
+				continue
+			}
+			if m.Amount.Sub(exact).Abs().GreaterThanOrEqual(unit) {
+				t.Logf("milestone %d: %s is not within one unit of %s", i, m.Amount, exact)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000, Rand: rand.New(rand.NewSource(7))}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCalculateAmounts_RoundingStrategies(t *testing.T) {
+	templates := []MilestoneTemplate{
+		{Type: TemplateTypePercentage, Value: decimal.RequireFromString("33.333")},
+		{Type: TemplateTypePercentage, Value: decimal.RequireFromString("33.333")},
+		{Type: TemplateTypePercentage, Value: decimal.RequireFromString("33.334"), IsFinal: true},
+	}
+	cases := map[Rounding][]string{
+		RoundingFinal:            {"3.33", "3.33", "3.34"},
+		RoundingDeposit:          {"3.34", "3.33", "3.33"},
+		RoundingLargestRemainder: {"3.33", "3.33", "3.34"},
+	}
+	for rounding, want := range cases {
+		got, err := CalculateAmounts(decimal.RequireFromString("10"), templates, CurrencyScale(2), rounding)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", rounding, err)
+		}
+		for i := range want {
+			if s := got[i].Amount.StringFixed(2); s != want[i] {
+				t.Fatalf("%s milestone %d = %s, want %s", rounding, i, s, want[i])
+			}
+		}
+	}
+}
+
+func TestCalculateAmounts_RemainderTakesWhatIsLeft(t *testing.T) {
+	templates := []MilestoneTemplate{
+		{Type: TemplateTypeFixed, Value: decimal.RequireFromString("250")},
+		{Type: TemplateTypeRemainder},
+		{Type: TemplateTypePercentage, Value: decimal.NewFromInt(10), IsFinal: true},
+	}
+	got, err := CalculateAmounts(decimal.RequireFromString("1000"), templates, CurrencyScale(2), RoundingFinal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got[1].Amount.StringFixed(2) != "650.00" {
+		t.Fatalf("remainder = %s, want 650.00", got[1].Amount)
+	}
+
+	_, err = CalculateAmounts(decimal.RequireFromString("200"), templates, CurrencyScale(2), RoundingFinal)
+	var ve ValidationError
+	if !errors.As(err, &ve) || ve.Code != "MILESTONE_REMAINDER_INVALID" {
+		t.Fatalf("expected MILESTONE_REMAINDER_INVALID, got %v", err)
+	}
+}
+
+func TestValidateTemplate_SingleValuelessRemainder(t *testing.T) {
+	two := []MilestoneTemplate{{Type: TemplateTypeRemainder}, {Type: TemplateTypeRemainder, IsFinal: true}}
+	if err := ValidateTemplate(two); err == nil {
+		t.Fatalf("expected two remainders to be rejected")
+	}
+	valued := []MilestoneTemplate{{Type: TemplateTypeRemainder, Value: decimal.NewFromInt(5), IsFinal: true}}
+	if err := ValidateTemplate(valued); err == nil {
+		t.Fatalf("expected a remainder with a value to be rejected")
+	}
+}
@@ -0,0 +1,121 @@
+package milestone
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Deliverable is one checklist item of a milestone.
+type Deliverable struct {
+	ID       string     `json:"id"`
+	Position int        `json:"position"`
+	Label    string     `json:"label"`
+	Done     bool       `json:"done"`
+	DoneAt   *time.Time `json:"doneAt,omitempty"`
+	DoneBy   string     `json:"doneBy,omitempty"`
+}
+
+// DisplayTitle is what clients see for the milestone: its template title, or a name derived from its place.
+func (r Record) DisplayTitle() string {
+	switch {
+	case r.Title != "":
+		return r.Title
+	case r.PeriodStart != nil:
+		return fmt.Sprintf("Billing cycle %d", r.Sequence+1)
+	case r.Sequence == 0:
+		return "Deposit"
+	default:
+		return fmt.Sprintf("Milestone %d", r.Sequence+1)
+	}
+}
+
+// InsertDetails copies a template's client-facing text and deliverables onto a new milestone.
+func InsertDetails(ctx context.Context, tx pgx.Tx, milestoneID string, d Details) error {
+	if d.Title == "" && d.Description == "" && d.ClientNote == "" && len(d.Deliverables) == 0 {
+		return nil
+	}
+	const q = `
+UPDATE milestones
+SET title = NULLIF($2, ''), description = NULLIF($3, ''), client_note = NULLIF($4, '')
+WHERE id = $1
+`
+	if _, err := tx.Exec(ctx, q, milestoneID, d.Title, d.Description, d.ClientNote); err != nil {
+		return err
+	}
+	const qItem = `INSERT INTO milestone_deliverables (milestone_id, position, label) VALUES ($1, $2, $3)`
+	for i, label := range d.Deliverables {
+		if _, err := tx.Exec(ctx, qItem, milestoneID, i, label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDeliverableDone ticks a checklist item of a milestone of the shop on or off. It returns the item as it
+// was before the change together with the updated one.
+func SetDeliverableDone(ctx context.Context, tx pgx.Tx, shopID, milestoneID, deliverableID string, done bool, actor string, at time.Time) (before, after *Deliverable, err error) {
+	const qLock = `
+SELECT d.id, d.position, d.label, d.done, d.done_at, COALESCE(d.done_by,'')
+FROM milestone_deliverables d
+JOIN milestones m ON m.id = d.milestone_id
+JOIN services s ON s.id = m.service_id
+WHERE d.id = $1 AND d.milestone_id = $2 AND s.shop_id = $3
+FOR UPDATE OF d
+`
+	var d Deliverable
+	if err := tx.QueryRow(ctx, qLock, deliverableID, milestoneID, shopID).Scan(&d.ID, &d.Position, &d.Label, &d.Done, &d.DoneAt, &d.DoneBy); err != nil {
+		return nil, nil, err
+	}
+	prev := d
+	if d.Done == done {
+		return &prev, &d, nil
+	}
+
+	d.Done = done
+	d.DoneAt, d.DoneBy = nil, ""
+	if done {
+		d.DoneAt, d.DoneBy = &at, actor
+	}
+	const qUpdate = `UPDATE milestone_deliverables SET done = $2, done_at = $3, done_by = NULLIF($4, '') WHERE id = $1`
+	if _, err := tx.Exec(ctx, qUpdate, d.ID, d.Done, d.DoneAt, d.DoneBy); err != nil {
+		return nil, nil, err
+	}
+	return &prev, &d, nil
+}
+
+func (r *Repository) loadDeliverables(ctx context.Context, serviceID string, ms []Record) error {
+	if len(ms) == 0 {
+		return nil
+	}
+	const q = `
+SELECT d.milestone_id, d.id, d.position, d.label, d.done, d.done_at, COALESCE(d.done_by,'')
+FROM milestone_deliverables d
+JOIN milestones m ON m.id = d.milestone_id
+WHERE m.service_id = $1
+ORDER BY m.sequence ASC, d.position ASC
+`
+	rows, err := r.db.Query(ctx, q, serviceID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byMilestone := make(map[string]int, len(ms))
+	for i := range ms {
+		byMilestone[ms[i].ID] = i
+	}
+	for rows.Next() {
+		var milestoneID string
+		var d Deliverable
+		if err := rows.Scan(&milestoneID, &d.ID, &d.Position, &d.Label, &d.Done, &d.DoneAt, &d.DoneBy); err != nil {
+			return err
+		}
+		if i, ok := byMilestone[milestoneID]; ok {
+			ms[i].Deliverables = append(ms[i].Deliverables, d)
+		}
+	}
+	return rows.Err()
+}
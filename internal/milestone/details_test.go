@@ -0,0 +1,69 @@
+package milestone
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMilestoneTemplate_DetailsDecodeFlat(t *testing.T) {
+	raw := `{"type":"fixed","value":"100","isFinal":true,"title":"Final delivery","description":"Hand-over","deliverables":["Source files","Invoice"],"clientNote":"Thanks!"}`
+	var tpl MilestoneTemplate
+	if err := json.Unmarshal([]byte(raw), &tpl); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tpl.Title != "Final delivery" || len(tpl.Deliverables) != 2 || tpl.ClientNote != "Thanks!" {
+		t.Fatalf("details not decoded: %+v", tpl.Details)
+	}
+}
+
+func TestCalculateAmounts_CopiesDetails(t *testing.T) {
+	templates := []MilestoneTemplate{
+		{Type: TemplateTypePercentage, Value: decimal.NewFromInt(40), Details: Details{Title: "Kick-off"}},
+		{Type: TemplateTypePercentage, Value: decimal.NewFromInt(60), IsFinal: true, Details: Details{Title: "Delivery", Deliverables: []string{"Logo"}}},
+	}
+	got, err := CalculateAmounts(decimal.RequireFromString("99.99"), templates, CurrencyScale(2), RoundingFinal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got[0].Details.Title != "Kick-off" || got[1].Details.Title != "Delivery" || len(got[1].Details.Deliverables) != 1 {
+		t.Fatalf("details not copied: %+v", got)
+	}
+}
+
+func TestDetailsValidate(t *testing.T) {
+	if err := (Details{Title: "Design", Deliverables: []string{"Wireframes"}}).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bad := []Details{
+		{Title: strings.Repeat("x", maxTitleLen+1)},
+		{Deliverables: []string{"  "}},
+		{ClientNote: strings.Repeat("x", maxTextLen+1)},
+	}
+	for _, d := range bad {
+		if err := d.Validate(); err == nil {
+			t.Fatalf("expected %+v to be rejected", d)
+		}
+	}
+}
+
+func TestDisplayTitle(t *testing.T) {
+	start := time.Now()
+	cases := []struct {
+		rec  Record
+		want string
+	}{
+		{Record{Sequence: 2, Title: "Delivery"}, "Delivery"},
+		{Record{Sequence: 0}, "Deposit"},
+		{Record{Sequence: 2}, "Milestone 3"},
+		{Record{Sequence: 1, PeriodStart: &start}, "Billing cycle 2"},
+	}
+	for _, c := range cases {
+		if got := c.rec.DisplayTitle(); got != c.want {
+			t.Fatalf("DisplayTitle(%+v) = %q, want %q", c.rec, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package milestone
+
+import "github.com/shopspring/decimal"
+
+// Milestone statuses. Amount paid comes from the milestone_payments ledger; the status is derived from it,
+// except that a locked milestone stays locked (it may still hold credit) until it is unlocked.
+const (
+	StatusLocked        = "locked"
+	StatusUnpaid        = "unpaid"
+	StatusPartiallyPaid = "partially_paid"
+	StatusPaid          = "paid"
+	StatusOverpaid      = "overpaid"
+)
+
+// Ledger entry kinds. Excess on one milestone moves to the next as a credit_out/credit_in pair.
+const (
+	EntryPayment   = "payment"
+	EntryCreditIn  = "credit_in"
+	EntryCreditOut = "credit_out"
+)
+
+// IsSettled reports whether nothing is owed on a milestone with this status.
+func IsSettled(status string) bool {
+	return status == StatusPaid || status == StatusOverpaid
+}
+
+// DeriveStatus maps an amount and what has been paid against it to a status. Nothing is owed on a zero amount,
+// such as a recurring cycle prorated to nothing, so it is paid (or overpaid, when it holds money).
+func DeriveStatus(amount, paid decimal.Decimal, locked bool) string {
+	switch {
+	case locked:
+		return StatusLocked
+	case amount.Sign() <= 0 && paid.Sign() <= 0:
+		return StatusPaid
+	case paid.Sign() <= 0:
+		return StatusUnpaid
+	case paid.LessThan(amount):
+		return StatusPartiallyPaid
+	case paid.Equal(amount):
+		return StatusPaid
+	default:
+		return StatusOverpaid
+	}
+}
+
+// Outstanding is what is still owed, never negative.
+func Outstanding(amount, paid decimal.Decimal) decimal.Decimal {
+	if paid.GreaterThanOrEqual(amount) {
+		return decimal.Zero
+	}
+	return amount.Sub(paid)
+}
+
+// Balance is one milestone of a service, in sequence order, as far as the ledger is concerned.
+type Balance struct {
+	Amount decimal.Decimal
+	Paid   decimal.Decimal
+}
+
+// LedgerEntry is a planned milestone_payments row. Index and Related point into the Balance slice;
+// Related is -1 for plain payments.
+type LedgerEntry struct {
+	Index   int
+	Kind    string
+	Amount  decimal.Decimal
+	Related int
+}
+
+// PlanPayment books amount against milestone idx and carries any excess forward as credit onto the next
+// milestones that still owe something, in sequence order. Excess that finds no such milestone stays on the
+// last one it reached, which ends up overpaid.
+func PlanPayment(bs []Balance, idx int, amount decimal.Decimal) []LedgerEntry {
+	if idx < 0 || idx >= len(bs) || amount.Sign() <= 0 {
+		return nil
+	}
+	paid := make([]decimal.Decimal, len(bs))
+	for i, b := range bs {
+		paid[i] = b.Paid
+	}
+
+	entries := []LedgerEntry{{Index: idx, Kind: EntryPayment, Amount: amount, Related: -1}}
+	paid[idx] = paid[idx].Add(amount)
+
+	from := idx
+	for {
+		excess := paid[from].Sub(bs[from].Amount)
+		if excess.Sign() <= 0 {
+			return entries
+		}
+		to := -1
+		for j := from + 1; j < len(bs); j++ {
+			if Outstanding(bs[j].Amount, paid[j]).Sign() > 0 {
+				to = j
+				break
+			}
+		}
+		if to < 0 {
+			return entries
+		}
+		entries = append(entries,
+			LedgerEntry{Index: from, Kind: EntryCreditOut, Amount: excess.Neg(), Related: to},
+			LedgerEntry{Index: to, Kind: EntryCreditIn, Amount: excess, Related: from},
+		)
+		paid[from] = paid[from].Sub(excess)
+		paid[to] = paid[to].Add(excess)
+		from = to
+	}
+}
@@ -0,0 +1,85 @@
+package milestone
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func d(s string) decimal.Decimal { return decimal.RequireFromString(s) }
+
+func TestDeriveStatus(t *testing.T) {
+	cases := []struct {
+		amount, paid string
+		locked       bool
+		want         string
+	}{
+		{"100", "0", false, StatusUnpaid},
+		{"100", "40", false, StatusPartiallyPaid},
+		{"100", "100.00", false, StatusPaid},
+		{"100", "120", false, StatusOverpaid},
+		{"100", "100", true, StatusLocked},
+		{"0", "0", false, StatusPaid},
+		{"0", "10", false, StatusOverpaid},
+	}
+	for _, c := range cases {
+		if got := DeriveStatus(d(c.amount), d(c.paid), c.locked); got != c.want {
+			t.Fatalf("DeriveStatus(%s, %s, %v) = %s, want %s", c.amount, c.paid, c.locked, got, c.want)
+		}
+	}
+}
+
+func TestPlanPayment_PartialStaysOnMilestone(t *testing.T) {
+	bs := []Balance{{Amount: d("30"), Paid: d("30")}, {Amount: d("40"), Paid: d("0")}}
+
+	got := PlanPayment(bs, 1, d("25"))
+	if len(got) != 1 || got[0].Kind != EntryPayment || got[0].Index != 1 || !got[0].Amount.Equal(d("25")) {
+		t.Fatalf("expected a single payment entry of 25 on milestone 1, got %+v", got)
+	}
+}
+
+func TestPlanPayment_ExcessCarriesForwardSkippingSettled(t *testing.T) {
+	bs := []Balance{
+		{Amount: d("30"), Paid: d("0")},
+		{Amount: d("30"), Paid: d("30")}, // already paid, skipped
+		{Amount: d("40"), Paid: d("0")},
+	}
+
+	got := PlanPayment(bs, 0, d("50"))
+	if len(got) != 3 {
+		t.Fatalf("expected payment plus a credit pair, got %+v", got)
+	}
+	if got[1].Kind != EntryCreditOut || got[1].Index != 0 || got[1].Related != 2 || !got[1].Amount.Equal(d("-20")) {
+		t.Fatalf("unexpected credit_out entry %+v", got[1])
+	}
+	if got[2].Kind != EntryCreditIn || got[2].Index != 2 || got[2].Related != 0 || !got[2].Amount.Equal(d("20")) {
+		t.Fatalf("unexpected credit_in entry %+v", got[2])
+	}
+
+	sum := decimal.Zero
+	for _, e := range got {
+		sum = sum.Add(e.Amount)
+	}
+	if !sum.Equal(d("50")) {
+		t.Fatalf("ledger entries must add up to the payment, got %s", sum)
+	}
+}
+
+func TestPlanPayment_ExcessWithNowhereToGoIsOverpaid(t *testing.T) {
+	bs := []Balance{{Amount: d("30"), Paid: d("30")}, {Amount: d("70"), Paid: d("50")}}
+
+	got := PlanPayment(bs, 1, d("35"))
+	if len(got) != 1 {
+		t.Fatalf("expected no credit entries, got %+v", got)
+	}
+	if s := DeriveStatus(bs[1].Amount, bs[1].Paid.Add(got[0].Amount), false); s != StatusOverpaid {
+		t.Fatalf("expected overpaid, got %s", s)
+	}
+}
+
+func TestPlanPayment_RejectsNonPositive(t *testing.T) {
+	bs := []Balance{{Amount: d("10"), Paid: d("0")}}
+	if got := PlanPayment(bs, 0, d("0")); got != nil {
+		t.Fatalf("expected nil for zero payment, got %+v", got)
+	}
+}
@@ -0,0 +1,198 @@
+package milestone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// ErrDuplicatePayment means the Shopify order was already booked against the milestone.
+var ErrDuplicatePayment = errors.New("payment already recorded")
+
+// Payment is money received against one milestone.
+type Payment struct {
+	Amount     decimal.Decimal
+	Source     string // order, manual, override, deposit, import
+	Reference  string // order id, invoice number, ...; optional
+	ReceivedAt time.Time
+	// PresentmentAmount and PresentmentCurrency record what the client paid when they paid in a currency other
+	// than the shop's; Amount is always in the shop currency. Optional.
+	PresentmentAmount   string
+	PresentmentCurrency string
+}
+
+// StatusChange describes a milestone touched by RecordPayment.
+type StatusChange struct {
+	MilestoneID string
+	Sequence    int
+	From        string
+	To          string
+	AmountPaid  decimal.Decimal
+	CreditIn    decimal.Decimal // credit carried onto this milestone by this payment
+	Final       bool
+}
+
+// RecordPayment writes p to the ledger for milestoneID, carries any excess forward as credit, and re-derives
+// amount_paid and status of every milestone it touched. All of the service's milestones are locked for the rest
+// of the transaction. The returned changes are in sequence order.
+func RecordPayment(ctx context.Context, tx pgx.Tx, serviceID, milestoneID string, p Payment) ([]StatusChange, error) {
+	const qLock = `
+SELECT id, sequence, amount::text, amount_paid::text, status
+FROM milestones
+WHERE service_id = $1
+ORDER BY sequence ASC
+FOR UPDATE
+`
+	rows, err := tx.Query(ctx, qLock, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	type row struct {
+		id       string
+		sequence int
+		status   string
+	}
+	var ms []row
+	var bs []Balance
+	idx := -1
+	for rows.Next() {
+		var rw row
+		var amount, paid string
+		if err := rows.Scan(&rw.id, &rw.sequence, &amount, &paid, &rw.status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b := Balance{Amount: decimal.RequireFromString(amount), Paid: decimal.RequireFromString(paid)}
+		if rw.id == milestoneID {
+			idx = len(ms)
+		}
+		ms = append(ms, rw)
+		bs = append(bs, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if idx < 0 {
+		return nil, pgx.ErrNoRows
+	}
+
+	entries := PlanPayment(bs, idx, p.Amount)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("payment amount must be positive")
+	}
+	scale, err := serviceScale(ctx, tx, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	const qInsert = `
+INSERT INTO milestone_payments (milestone_id, service_id, kind, amount, source, reference, related_milestone_id, received_at,
+                                presentment_amount, presentment_currency)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6,''), $7, $8, NULLIF($9,'')::numeric, NULLIF($10,''))
+ON CONFLICT (milestone_id, reference) WHERE kind = 'payment' AND source = 'order' AND reference IS NOT NULL DO NOTHING
+RETURNING id
+`
+	touched := map[int]decimal.Decimal{}
+	for _, e := range entries {
+		var related *string
+		if e.Related >= 0 {
+			related = &ms[e.Related].id
+		}
+		source, ref := p.Source, p.Reference
+		presentmentAmount, presentmentCurrency := p.PresentmentAmount, p.PresentmentCurrency
+		if e.Kind != EntryPayment {
+			source, ref = "credit", ""
+			presentmentAmount, presentmentCurrency = "", ""
+		}
+		var id string
+		if err := tx.QueryRow(ctx, qInsert, ms[e.Index].id, serviceID, e.Kind, scale.Format(e.Amount), source, ref, related, p.ReceivedAt,
+			presentmentAmount, presentmentCurrency).Scan(&id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, ErrDuplicatePayment
+			}
+			return nil, err
+		}
+		credit := touched[e.Index]
+		if e.Kind == EntryCreditIn {
+			credit = credit.Add(e.Amount)
+		}
+		touched[e.Index] = credit
+	}
+
+	var changes []StatusChange
+	for i, rw := range ms {
+		credit, ok := touched[i]
+		if !ok {
+			continue
+		}
+		ch, err := refreshBalance(ctx, tx, rw.id, rw.status, bs[i].Amount, scale, p.ReceivedAt)
+		if err != nil {
+			return nil, err
+		}
+		ch.Sequence, ch.CreditIn, ch.Final = rw.sequence, credit, i == len(ms)-1
+		changes = append(changes, *ch)
+	}
+	return changes, nil
+}
+
+// refreshBalance recomputes amount_paid from the ledger and re-derives the status. paid_at is set the first
+// time the milestone becomes settled.
+func refreshBalance(ctx context.Context, tx pgx.Tx, milestoneID, status string, amount decimal.Decimal, scale CurrencyScale, at time.Time) (*StatusChange, error) {
+	const qSum = `SELECT COALESCE(SUM(amount), 0)::text FROM milestone_payments WHERE milestone_id = $1`
+	var sum string
+	if err := tx.QueryRow(ctx, qSum, milestoneID).Scan(&sum); err != nil {
+		return nil, err
+	}
+	paid, err := decimal.NewFromString(sum)
+	if err != nil {
+		return nil, err
+	}
+
+	next := DeriveStatus(amount, paid, status == StatusLocked)
+	const qUpdate = `
+UPDATE milestones
+SET amount_paid = $2,
+    status = $3,
+    paid_at = CASE WHEN $3 IN ('paid', 'overpaid') THEN COALESCE(paid_at, $4) ELSE NULL END
+WHERE id = $1
+`
+	if _, err := tx.Exec(ctx, qUpdate, milestoneID, scale.Format(paid), next, at); err != nil {
+		return nil, err
+	}
+	return &StatusChange{MilestoneID: milestoneID, From: status, To: next, AmountPaid: paid}, nil
+}
+
+// Reprice changes what the milestone costs, e.g. when a recurring cycle is prorated on cancellation, and
+// re-derives its status from what was already paid.
+func Reprice(ctx context.Context, tx pgx.Tx, milestoneID string, amount decimal.Decimal, at time.Time) (*StatusChange, error) {
+	const qLock = `SELECT service_id, status FROM milestones WHERE id = $1 FOR UPDATE`
+	var serviceID, status string
+	if err := tx.QueryRow(ctx, qLock, milestoneID).Scan(&serviceID, &status); err != nil {
+		return nil, err
+	}
+	scale, err := serviceScale(ctx, tx, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	amount = amount.Round(int32(scale))
+	const qAmount = `UPDATE milestones SET amount = $2 WHERE id = $1`
+	if _, err := tx.Exec(ctx, qAmount, milestoneID, scale.Format(amount)); err != nil {
+		return nil, err
+	}
+	return refreshBalance(ctx, tx, milestoneID, status, amount, scale, at)
+}
+
+// serviceScale is the scale of the service's currency, which all of its amounts are kept in.
+func serviceScale(ctx context.Context, tx pgx.Tx, serviceID string) (CurrencyScale, error) {
+	const q = `SELECT currency FROM services WHERE id = $1`
+	var code string
+	if err := tx.QueryRow(ctx, q, serviceID).Scan(&code); err != nil {
+		return 0, err
+	}
+	return ScaleFor(code)
+}
@@ -0,0 +1,100 @@
+package milestone
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type Interval string
+
+const (
+	IntervalWeek  Interval = "week"
+	IntervalMonth Interval = "month"
+)
+
+// RecurringTemplate bills the same amount every cycle, for a fixed number of cycles or until cancelled.
+// Cycle 0 is paid by the order that creates the service; later cycles become milestones as they start.
+type RecurringTemplate struct {
+	Interval      Interval `json:"interval"`
+	IntervalCount int      `json:"intervalCount,omitempty"` // intervals per cycle; 0 means 1
+	// Cycles is the total number of cycles including the first; 0 means until cancelled.
+	Cycles int `json:"cycles,omitempty"`
+	// Amount is charged per cycle; zero means the order total.
+	Amount decimal.Decimal `json:"amount,omitempty"`
+	// DueInDays sets each generated milestone's due date relative to the start of its cycle.
+	DueInDays int `json:"dueInDays,omitempty"`
+}
+
+// ValidateRecurring enforces the recurring template contract.
+func ValidateRecurring(t RecurringTemplate) error {
+	switch t.Interval {
+	case IntervalWeek, IntervalMonth:
+	default:
+		return ValidationError{Code: "RECURRING_INTERVAL_INVALID", Message: "interval must be week or month"}
+	}
+	if t.IntervalCount < 0 {
+		return ValidationError{Code: "RECURRING_INTERVAL_INVALID", Message: "intervalCount must be >= 0"}
+	}
+	if t.Cycles < 0 {
+		return ValidationError{Code: "RECURRING_CYCLES_INVALID", Message: "cycles must be >= 0"}
+	}
+	if t.Amount.IsNegative() {
+		return ValidationError{Code: "MILESTONE_VALUE_INVALID", Message: "recurring amount must be >= 0"}
+	}
+	if t.DueInDays < 0 {
+		return ValidationError{Code: "MILESTONE_DUE_INVALID", Message: "dueInDays must be >= 0"}
+	}
+	return nil
+}
+
+// CycleAmount is what one cycle costs for a service whose order totalled total.
+func (t RecurringTemplate) CycleAmount(total decimal.Decimal, scale CurrencyScale) decimal.Decimal {
+	if t.Amount.Sign() > 0 {
+		return t.Amount.Round(int32(scale))
+	}
+	return total.Round(int32(scale))
+}
+
+// CycleStart is when cycle n begins for a service anchored at anchor. Months are added from the anchor rather
+// than from the previous cycle, and clamp to the end of shorter months, so a service anchored on the 31st bills
+// on the last day of February and on the 31st again in March.
+func (t RecurringTemplate) CycleStart(anchor time.Time, n int) time.Time {
+	count := t.IntervalCount
+	if count <= 0 {
+		count = 1
+	}
+	if t.Interval == IntervalWeek {
+		return anchor.AddDate(0, 0, 7*count*n)
+	}
+	return addMonthsClamped(anchor, count*n)
+}
+
+// Finite reports whether the recurrence stops after a fixed number of cycles.
+func (t RecurringTemplate) Finite() bool {
+	return t.Cycles > 0
+}
+
+func addMonthsClamped(t time.Time, months int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	if d > last {
+		d = last
+	}
+	return first.AddDate(0, 0, d-1)
+}
+
+// Prorate is the part of amount earned by at within the cycle [start, end): the cycle's amount scaled by the
+// elapsed share of its duration. Cancelling at or before start earns nothing; at or after end earns it all.
+func Prorate(amount decimal.Decimal, start, end, at time.Time, scale CurrencyScale) decimal.Decimal {
+	if !at.After(start) {
+		return decimal.Zero
+	}
+	if !at.Before(end) || !end.After(start) {
+		return amount.Round(int32(scale))
+	}
+	elapsed := decimal.NewFromInt(int64(at.Sub(start)))
+	length := decimal.NewFromInt(int64(end.Sub(start)))
+	return amount.Mul(elapsed).Div(length).Round(int32(scale))
+}
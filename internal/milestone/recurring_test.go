@@ -0,0 +1,64 @@
+package milestone
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCycleStart_MonthsClampFromAnchor(t *testing.T) {
+	tpl := RecurringTemplate{Interval: IntervalMonth}
+	anchor := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+
+	want := []time.Time{
+		anchor,
+		time.Date(2026, 2, 28, 9, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 31, 9, 0, 0, 0, time.UTC),
+		time.Date(2026, 4, 30, 9, 0, 0, 0, time.UTC),
+	}
+	for n, w := range want {
+		if got := tpl.CycleStart(anchor, n); !got.Equal(w) {
+			t.Fatalf("cycle %d: got %v, want %v", n, got, w)
+		}
+	}
+}
+
+func TestCycleStart_Weeks(t *testing.T) {
+	tpl := RecurringTemplate{Interval: IntervalWeek, IntervalCount: 2}
+	anchor := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	if got := tpl.CycleStart(anchor, 3); !got.Equal(anchor.AddDate(0, 0, 42)) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestProrate(t *testing.T) {
+	start := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		at   time.Time
+		want string
+	}{
+		{start, "0"},
+		{start.Add(-time.Hour), "0"},
+		{time.Date(2026, 4, 11, 0, 0, 0, 0, time.UTC), "100"},
+		{time.Date(2026, 4, 21, 0, 0, 0, 0, time.UTC), "200"},
+		{end, "300"},
+	}
+	for _, c := range cases {
+		if got := Prorate(d("300"), start, end, c.at, CurrencyScale(2)); !got.Equal(d(c.want)) {
+			t.Fatalf("Prorate at %v = %s, want %s", c.at, got, c.want)
+		}
+	}
+}
+
+func TestValidateRecurring(t *testing.T) {
+	if err := ValidateRecurring(RecurringTemplate{Interval: IntervalMonth, Cycles: 6}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ValidateRecurring(RecurringTemplate{Interval: "day"}); err == nil {
+		t.Fatal("expected unknown interval to fail")
+	}
+	if err := ValidateRecurring(RecurringTemplate{Interval: IntervalWeek, Cycles: -1}); err == nil {
+		t.Fatal("expected negative cycles to fail")
+	}
+}
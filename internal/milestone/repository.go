@@ -2,23 +2,52 @@ package milestone
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
 )
 
 type Record struct {
-	ID          string     `json:"id"`
-	ServiceID   string     `json:"serviceId"`
-	Sequence    int        `json:"sequence"`
-	Amount      string     `json:"amount"`
-	Status      string     `json:"status"`
-	Currency    string     `json:"currency,omitempty"`
-	DraftOrderID string    `json:"draftOrderId,omitempty"`
-	CheckoutURL string     `json:"checkoutUrl,omitempty"`
-	PaidAt      *time.Time `json:"paidAt,omitempty"`
-	CreatedAt   time.Time  `json:"createdAt"`
+	ID           string `json:"id"`
+	ServiceID    string `json:"serviceId"`
+	Sequence     int    `json:"sequence"`
+	Amount       string `json:"amount"`
+	AmountPaid   string `json:"amountPaid"` // sum of the milestone_payments ledger
+	Status       string `json:"status"`
+	Currency     string `json:"currency,omitempty"`
+	DraftOrderID string `json:"draftOrderId,omitempty"`
+	CheckoutURL  string `json:"checkoutUrl,omitempty"`
+	// PaymentRail and PaymentReference identify the outstanding payment request, whatever rail issued it.
+	PaymentRail         string `json:"paymentRail,omitempty"`
+	PaymentReference    string `json:"paymentReference,omitempty"`
+	PaymentInstructions string `json:"paymentInstructions,omitempty"`
+	// AmountMismatch is set when the payment received for this milestone differed from Amount.
+	AmountMismatch bool       `json:"amountMismatch,omitempty"`
+	ObservedAmount string     `json:"observedAmount,omitempty"`
+	DueAt          *time.Time `json:"dueAt,omitempty"`
+	// PeriodStart and PeriodEnd bound the billing cycle of a recurring service's milestone.
+	PeriodStart *time.Time `json:"periodStart,omitempty"`
+	PeriodEnd   *time.Time `json:"periodEnd,omitempty"`
+	// Client-facing text copied from the template; Deliverables is only loaded by ListByService.
+	Title        string        `json:"title,omitempty"`
+	Description  string        `json:"description,omitempty"`
+	ClientNote   string        `json:"clientNote,omitempty"`
+	Deliverables []Deliverable `json:"deliverables,omitempty"`
+	// PresentmentAmount is the milestone in the currency the client checked out in, when it differs from Currency.
+	PresentmentAmount   string     `json:"presentmentAmount,omitempty"`
+	PresentmentCurrency string     `json:"presentmentCurrency,omitempty"`
+	PaidAt              *time.Time `json:"paidAt,omitempty"`
+	CreatedAt           time.Time  `json:"createdAt"`
+}
+
+// Outstanding is what is still owed on the milestone.
+func (r Record) Outstanding() decimal.Decimal {
+	amount, _ := decimal.NewFromString(r.Amount)
+	paid, _ := decimal.NewFromString(r.AmountPaid)
+	return Outstanding(amount, paid)
 }
 
 type Repository struct {
@@ -31,10 +60,15 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 
 func (r *Repository) ListByService(ctx context.Context, serviceID string) ([]Record, error) {
 	const q = `
-SELECT id, service_id, sequence, amount::text, status, draft_order_id, checkout_url, paid_at, created_at
-FROM milestones
-WHERE service_id = $1
-ORDER BY sequence ASC
+SELECT m.id, m.service_id, m.sequence, m.amount::text, m.amount_paid::text, m.status, s.currency, m.draft_order_id, m.checkout_url,
+       COALESCE(m.payment_rail,''), COALESCE(m.payment_reference,''), COALESCE(m.payment_instructions,''),
+       m.amount_mismatch, COALESCE(m.observed_amount::text,''), m.due_at, m.period_start, m.period_end,
+       COALESCE(m.title,''), COALESCE(m.description,''), COALESCE(m.client_note,''),
+       COALESCE(m.presentment_amount::text,''), COALESCE(s.presentment_currency,''), m.paid_at, m.created_at
+FROM milestones m
+JOIN services s ON s.id = m.service_id
+WHERE m.service_id = $1
+ORDER BY m.sequence ASC
 `
 	rows, err := r.db.Query(ctx, q, serviceID)
 	if err != nil {
@@ -46,7 +80,8 @@ ORDER BY sequence ASC
 	for rows.Next() {
 		var rec Record
 		var draftOrderID, checkoutURL *string
-		if err := rows.Scan(&rec.ID, &rec.ServiceID, &rec.Sequence, &rec.Amount, &rec.Status, &draftOrderID, &checkoutURL, &rec.PaidAt, &rec.CreatedAt); err != nil {
+		if err := rows.Scan(&rec.ID, &rec.ServiceID, &rec.Sequence, &rec.Amount, &rec.AmountPaid, &rec.Status, &rec.Currency, &draftOrderID, &checkoutURL, &rec.PaymentRail, &rec.PaymentReference, &rec.PaymentInstructions, &rec.AmountMismatch, &rec.ObservedAmount, &rec.DueAt, &rec.PeriodStart, &rec.PeriodEnd, &rec.Title, &rec.Description, &rec.ClientNote,
+			&rec.PresentmentAmount, &rec.PresentmentCurrency, &rec.PaidAt, &rec.CreatedAt); err != nil {
 			return nil, err
 		}
 		if draftOrderID != nil {
@@ -57,20 +92,29 @@ ORDER BY sequence ASC
 		}
 		out = append(out, rec)
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, r.loadDeliverables(ctx, serviceID, out)
 }
 
 func GetForUpdate(ctx context.Context, tx pgx.Tx, milestoneID string) (*Record, error) {
 	const q = `
-SELECT id, service_id, sequence, amount::text, status, draft_order_id, checkout_url, paid_at, created_at
-FROM milestones
-WHERE id = $1
-FOR UPDATE
+SELECT m.id, m.service_id, m.sequence, m.amount::text, m.amount_paid::text, m.status, s.currency, m.draft_order_id, m.checkout_url,
+       COALESCE(m.payment_rail,''), COALESCE(m.payment_reference,''), COALESCE(m.payment_instructions,''),
+       m.amount_mismatch, COALESCE(m.observed_amount::text,''), m.due_at, m.period_start, m.period_end,
+       COALESCE(m.title,''), COALESCE(m.description,''), COALESCE(m.client_note,''),
+       COALESCE(m.presentment_amount::text,''), COALESCE(s.presentment_currency,''), m.paid_at, m.created_at
+FROM milestones m
+JOIN services s ON s.id = m.service_id
+WHERE m.id = $1
+FOR UPDATE OF m
 `
 	var rec Record
 	var draftOrderID, checkoutURL *string
 	if err := tx.QueryRow(ctx, q, milestoneID).Scan(
-		&rec.ID, &rec.ServiceID, &rec.Sequence, &rec.Amount, &rec.Status, &draftOrderID, &checkoutURL, &rec.PaidAt, &rec.CreatedAt,
+		&rec.ID, &rec.ServiceID, &rec.Sequence, &rec.Amount, &rec.AmountPaid, &rec.Status, &rec.Currency, &draftOrderID, &checkoutURL, &rec.PaymentRail, &rec.PaymentReference, &rec.PaymentInstructions, &rec.AmountMismatch, &rec.ObservedAmount, &rec.DueAt, &rec.PeriodStart, &rec.PeriodEnd, &rec.Title, &rec.Description, &rec.ClientNote,
+		&rec.PresentmentAmount, &rec.PresentmentCurrency, &rec.PaidAt, &rec.CreatedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -85,7 +129,11 @@ FOR UPDATE
 
 func GetForUpdateScoped(ctx context.Context, tx pgx.Tx, shopID string, milestoneID string) (*Record, error) {
 	const q = `
-SELECT m.id, m.service_id, m.sequence, m.amount::text, m.status, s.currency, m.draft_order_id, m.checkout_url, m.paid_at, m.created_at
+SELECT m.id, m.service_id, m.sequence, m.amount::text, m.amount_paid::text, m.status, s.currency, m.draft_order_id, m.checkout_url,
+       COALESCE(m.payment_rail,''), COALESCE(m.payment_reference,''), COALESCE(m.payment_instructions,''),
+       m.amount_mismatch, COALESCE(m.observed_amount::text,''), m.due_at, m.period_start, m.period_end,
+       COALESCE(m.title,''), COALESCE(m.description,''), COALESCE(m.client_note,''),
+       COALESCE(m.presentment_amount::text,''), COALESCE(s.presentment_currency,''), m.paid_at, m.created_at
 FROM milestones m
 JOIN services s ON s.id = m.service_id
 WHERE m.id = $1 AND s.shop_id = $2
@@ -94,7 +142,8 @@ FOR UPDATE OF m
 	var rec Record
 	var draftOrderID, checkoutURL *string
 	if err := tx.QueryRow(ctx, q, milestoneID, shopID).Scan(
-		&rec.ID, &rec.ServiceID, &rec.Sequence, &rec.Amount, &rec.Status, &rec.Currency, &draftOrderID, &checkoutURL, &rec.PaidAt, &rec.CreatedAt,
+		&rec.ID, &rec.ServiceID, &rec.Sequence, &rec.Amount, &rec.AmountPaid, &rec.Status, &rec.Currency, &draftOrderID, &checkoutURL, &rec.PaymentRail, &rec.PaymentReference, &rec.PaymentInstructions, &rec.AmountMismatch, &rec.ObservedAmount, &rec.DueAt, &rec.PeriodStart, &rec.PeriodEnd, &rec.Title, &rec.Description, &rec.ClientNote,
+		&rec.PresentmentAmount, &rec.PresentmentCurrency, &rec.PaidAt, &rec.CreatedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -118,26 +167,113 @@ WHERE id = $1
 	return err
 }
 
-func UnlockFinal(ctx context.Context, tx pgx.Tx, serviceID string) error {
+// SetPaymentRequest records the payment request a rail issued for the milestone.
+// For the draft order rail the reference is also kept in draft_order_id, which older code paths look up by.
+func SetPaymentRequest(ctx context.Context, tx pgx.Tx, milestoneID, rail, reference, checkoutURL, instructions string) error {
 	const q = `
 UPDATE milestones
-SET status = 'unpaid'
-WHERE service_id = $1
-  AND sequence = (SELECT sequence FROM milestones WHERE service_id = $1 ORDER BY sequence DESC LIMIT 1)
-  AND status = 'locked'
+SET payment_rail = $2,
+    payment_reference = NULLIF($3,''),
+    checkout_url = NULLIF($4,''),
+    payment_instructions = NULLIF($5,''),
+    draft_order_id = CASE WHEN $2 = 'draft_order' THEN NULLIF($3,'') ELSE draft_order_id END,
+    payment_request_signed = TRUE
+WHERE id = $1
 `
-	_, err := tx.Exec(ctx, q, serviceID)
+	_, err := tx.Exec(ctx, q, milestoneID, rail, reference, checkoutURL, instructions)
 	return err
 }
 
-func MarkPaid(ctx context.Context, tx pgx.Tx, milestoneID string, paidAt time.Time) error {
+// ClearPaymentRequest forgets a withdrawn payment request so a new one can be issued.
+func ClearPaymentRequest(ctx context.Context, tx pgx.Tx, milestoneID string) error {
 	const q = `
 UPDATE milestones
-SET status = 'paid', paid_at = $2
+SET payment_rail = NULL, payment_reference = NULL, payment_instructions = NULL, draft_order_id = NULL, checkout_url = NULL,
+    draft_order_status = NULL, payment_request_signed = FALSE
 WHERE id = $1
 `
-	_, err := tx.Exec(ctx, q, milestoneID, paidAt)
+	_, err := tx.Exec(ctx, q, milestoneID)
 	return err
 }
 
+// IssuedUnsignedDraft reports whether the milestone's payment request is a draft order issued before drafts
+// carried signed reconciliation attributes, the only kind whose paid order may be matched by its note.
+func IssuedUnsignedDraft(ctx context.Context, tx pgx.Tx, milestoneID string) (bool, error) {
+	const q = `SELECT draft_order_id IS NOT NULL AND NOT payment_request_signed FROM milestones WHERE id = $1`
+	var ok bool
+	err := tx.QueryRow(ctx, q, milestoneID).Scan(&ok)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return ok, err
+}
+
+// SetDraftOrderStatus records the last draft order status Shopify reported.
+func SetDraftOrderStatus(ctx context.Context, tx pgx.Tx, milestoneID, status string) error {
+	const q = `UPDATE milestones SET draft_order_status = $2 WHERE id = $1`
+	_, err := tx.Exec(ctx, q, milestoneID, status)
+	return err
+}
+
+// FlagAmountMismatch marks a milestone whose payment did not match its amount; observed is what was paid.
+func FlagAmountMismatch(ctx context.Context, tx pgx.Tx, milestoneID, observed string) error {
+	const q = `UPDATE milestones SET amount_mismatch = TRUE, observed_amount = $2::numeric WHERE id = $1`
+	_, err := tx.Exec(ctx, q, milestoneID, observed)
+	return err
+}
 
+// FindByDraftOrderForUpdate locks the shop's milestone that the draft order was issued for.
+func FindByDraftOrderForUpdate(ctx context.Context, tx pgx.Tx, shopID, draftOrderID string) (*Record, error) {
+	const q = `
+SELECT m.id
+FROM milestones m
+JOIN services s ON s.id = m.service_id
+WHERE s.shop_id = $1 AND m.draft_order_id = $2
+LIMIT 1
+`
+	var id string
+	if err := tx.QueryRow(ctx, q, shopID, draftOrderID).Scan(&id); err != nil {
+		return nil, err
+	}
+	return GetForUpdateScoped(ctx, tx, shopID, id)
+}
+
+// FindByPaymentReference resolves a rail reference back to a milestone of the shop.
+func FindByPaymentReference(ctx context.Context, tx pgx.Tx, shopID, rail, reference string) (string, error) {
+	const q = `
+SELECT m.id
+FROM milestones m
+JOIN services s ON s.id = m.service_id
+WHERE s.shop_id = $1 AND m.payment_rail = $2 AND m.payment_reference = $3
+LIMIT 1
+`
+	var id string
+	err := tx.QueryRow(ctx, q, shopID, rail, reference).Scan(&id)
+	return id, err
+}
+
+// UnlockFinal releases the final milestone after approval. Its status is derived from what it already
+// holds, since credit carried forward may have paid it in part or in full. It returns the new status, or ""
+// when the final milestone was not locked.
+func UnlockFinal(ctx context.Context, tx pgx.Tx, serviceID string) (string, error) {
+	const q = `
+UPDATE milestones
+SET status = CASE
+      WHEN amount_paid <= 0 THEN 'unpaid'
+      WHEN amount_paid < amount THEN 'partially_paid'
+      WHEN amount_paid = amount THEN 'paid'
+      ELSE 'overpaid'
+    END,
+    paid_at = CASE WHEN amount_paid >= amount THEN NOW() ELSE NULL END
+WHERE service_id = $1
+  AND sequence = (SELECT sequence FROM milestones WHERE service_id = $1 ORDER BY sequence DESC LIMIT 1)
+  AND status = 'locked'
+RETURNING status
+`
+	var status string
+	err := tx.QueryRow(ctx, q, serviceID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return status, err
+}
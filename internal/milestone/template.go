@@ -2,6 +2,8 @@ package milestone
 
 import (
 	"fmt"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/shopspring/decimal"
 )
@@ -11,12 +13,52 @@ type TemplateType string
 const (
 	TemplateTypeFixed      TemplateType = "fixed"
 	TemplateTypePercentage TemplateType = "percentage"
+	// TemplateTypeRemainder takes what the other templates leave of the total; it has no value.
+	TemplateTypeRemainder TemplateType = "remainder"
 )
 
 type MilestoneTemplate struct {
 	Type    TemplateType    `json:"type"`
 	Value   decimal.Decimal `json:"value"`
 	IsFinal bool            `json:"isFinal"`
+	// DueInDays sets the milestone's due date relative to service creation; 0 means no due date.
+	DueInDays int `json:"dueInDays,omitempty"`
+	Details
+}
+
+// Details is the client-facing text of a milestone. It is copied onto the milestone when the service is
+// created and shown in the portal and on payment requests.
+type Details struct {
+	Title        string   `json:"title,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Deliverables []string `json:"deliverables,omitempty"` // checklist items the merchant ticks off
+	ClientNote   string   `json:"clientNote,omitempty"`
+}
+
+const (
+	maxTitleLen       = 120
+	maxTextLen        = 2000
+	maxDeliverables   = 50
+	maxDeliverableLen = 200
+)
+
+// Validate checks the lengths of the client-facing text.
+func (d Details) Validate() error {
+	if utf8.RuneCountInString(d.Title) > maxTitleLen {
+		return ValidationError{Code: "MILESTONE_TITLE_INVALID", Message: fmt.Sprintf("title must be at most %d characters", maxTitleLen)}
+	}
+	if utf8.RuneCountInString(d.Description) > maxTextLen || utf8.RuneCountInString(d.ClientNote) > maxTextLen {
+		return ValidationError{Code: "MILESTONE_TEXT_INVALID", Message: fmt.Sprintf("description and clientNote must be at most %d characters", maxTextLen)}
+	}
+	if len(d.Deliverables) > maxDeliverables {
+		return ValidationError{Code: "MILESTONE_DELIVERABLES_INVALID", Message: fmt.Sprintf("at most %d deliverables per milestone", maxDeliverables)}
+	}
+	for _, item := range d.Deliverables {
+		if strings.TrimSpace(item) == "" || utf8.RuneCountInString(item) > maxDeliverableLen {
+			return ValidationError{Code: "MILESTONE_DELIVERABLES_INVALID", Message: fmt.Sprintf("deliverables must be 1 to %d characters", maxDeliverableLen)}
+		}
+	}
+	return nil
 }
 
 type ValidationError struct {
@@ -34,21 +76,34 @@ func (e ValidationError) Error() string {
 // ValidateTemplate enforces the milestone contract:
 // - Deposit is milestone[0] at the call site (sequence is external); this function validates the template list itself.
 // - Exactly one final milestone, and it must be last.
-// - All values must be > 0.
+// - All values must be > 0, except for the remainder, which has none.
+// - At most one remainder milestone.
 func ValidateTemplate(templates []MilestoneTemplate) error {
 	if len(templates) == 0 {
 		return ValidationError{Code: "MILESTONE_TEMPLATE_EMPTY", Message: "milestone template cannot be empty"}
 	}
 
 	finalIdx := -1
+	remainders := 0
 	for i, t := range templates {
-		if t.Value.LessThanOrEqual(decimal.Zero) {
+		if t.Type == TemplateTypeRemainder {
+			if !t.Value.IsZero() {
+				return ValidationError{Code: "MILESTONE_VALUE_INVALID", Message: "remainder milestones take no value"}
+			}
+			remainders++
+		} else if t.Value.LessThanOrEqual(decimal.Zero) {
 			return ValidationError{Code: "MILESTONE_VALUE_INVALID", Message: "milestone value must be > 0"}
 		}
+		if t.DueInDays < 0 {
+			return ValidationError{Code: "MILESTONE_DUE_INVALID", Message: "dueInDays must be >= 0"}
+		}
+		if err := t.Details.Validate(); err != nil {
+			return err
+		}
 		switch t.Type {
-		case TemplateTypeFixed, TemplateTypePercentage:
+		case TemplateTypeFixed, TemplateTypePercentage, TemplateTypeRemainder:
 		default:
-			return ValidationError{Code: "MILESTONE_TYPE_INVALID", Message: "milestone type must be fixed or percentage"}
+			return ValidationError{Code: "MILESTONE_TYPE_INVALID", Message: "milestone type must be fixed, percentage or remainder"}
 		}
 		if t.IsFinal {
 			if finalIdx != -1 {
@@ -58,6 +113,9 @@ func ValidateTemplate(templates []MilestoneTemplate) error {
 		}
 	}
 
+	if remainders > 1 {
+		return ValidationError{Code: "MILESTONE_REMAINDER_DUPLICATE", Message: "at most one remainder milestone is allowed"}
+	}
 	if finalIdx == -1 {
 		return ValidationError{Code: "FINAL_MILESTONE_MISSING", Message: "final milestone is required"}
 	}
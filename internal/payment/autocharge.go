@@ -0,0 +1,445 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/internal/service"
+	"microservice/internal/shop"
+	"microservice/pkg/config"
+	"microservice/pkg/currency"
+	"microservice/pkg/db"
+	"microservice/pkg/shopify"
+)
+
+// AttrAutoCharge is the cart attribute a storefront sets when the client opts into automatic charging at
+// checkout. It reaches us as a note attribute of the paid order.
+const AttrAutoCharge = "_sw_auto_charge"
+
+// AutoChargeOptedIn reports whether the order's attributes carry the auto-charge opt-in.
+func AutoChargeOptedIn(attrs map[string]string) bool {
+	switch strings.ToLower(strings.TrimSpace(attrs[AttrAutoCharge])) {
+	case "true", "yes", "1", "on":
+		return true
+	}
+	return false
+}
+
+// pendingResubmitAfter is how long an attempt may stay pending before it is looked at again. An attempt
+// Shopify accepted is waiting for its payment job and is polled for the outcome; one without a payment
+// reference was left behind by a crash or transport error, where it is unknown whether Shopify charged, and
+// is sent again with the same idempotency key.
+const pendingResubmitAfter = 10 * time.Minute
+
+// AutoCharger charges due milestones of opted-in services through the payment mandate on the service's
+// original order. It is run by the scheduler; several instances may run it at once.
+type AutoCharger struct {
+	Cfg   config.Config
+	DB    *pgxpool.Pool
+	Batch int // milestones per run; 0 means 50
+}
+
+type chargeAttempt struct {
+	ID          string
+	MilestoneID string
+	ServiceID   string
+	ShopID      string
+	Shop        *shop.Shop // loaded by claim from ShopID
+	OrderID     string
+	MandateID   string
+	Amount      string
+	Currency    string
+	Attempt     int
+	Key         string
+	Reference   string // Shopify's payment reference once the charge was accepted
+}
+
+// Run charges every milestone that is due now.
+func (a AutoCharger) Run(ctx context.Context) error {
+	attempts, err := a.claim(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, at := range attempts {
+		if err := a.charge(ctx, at); err != nil {
+			log.Printf("auto-charge: milestone %s attempt %d: %v", at.MilestoneID, at.Attempt, err)
+		}
+	}
+	return nil
+}
+
+// RetryAt is when a failed attempt is retried: backoff after the first failure, doubling after each further
+// one. ok is false once maxAttempts have been made.
+func RetryAt(attempt, maxAttempts int, backoff time.Duration, now time.Time) (at time.Time, ok bool) {
+	if attempt >= maxAttempts {
+		return time.Time{}, false
+	}
+	return now.Add(backoff * time.Duration(1<<(attempt-1))), true
+}
+
+// claim records a pending attempt for each due milestone and picks up stale pending attempts, in one
+// transaction, so no other runner charges the same milestone.
+func (a AutoCharger) claim(ctx context.Context, now time.Time) ([]chargeAttempt, error) {
+	batch := a.Batch
+	if batch <= 0 {
+		batch = 50
+	}
+
+	var out []chargeAttempt
+	err := db.WithTx(ctx, a.DB, func(tx pgx.Tx) error {
+		// Stale attempts are read with the milestone and service as they are now: the milestone may have been
+		// paid or repriced, or the service opted out, since the attempt was made.
+		const qStale = `
+SELECT a.id, a.milestone_id, a.service_id, s.shop_id, s.shopify_order_id, COALESCE(s.payment_mandate_id,''),
+       a.amount::text, s.currency, a.attempt, a.idempotency_key, COALESCE(a.payment_reference,''), a.resubmissions,
+       s.auto_charge AND s.status <> 'Completed' AND m.status IN ('unpaid', 'partially_paid'),
+       a.amount = m.amount - m.amount_paid
+FROM milestone_charge_attempts a
+JOIN milestones m ON m.id = a.milestone_id
+JOIN services s ON s.id = a.service_id
+WHERE a.status = 'pending' AND COALESCE(a.last_sent_at, a.created_at) < $1
+ORDER BY a.created_at ASC
+LIMIT $2
+FOR UPDATE OF a SKIP LOCKED
+`
+		var stale []staleAttempt
+		rows, err := tx.Query(ctx, qStale, now.Add(-pendingResubmitAfter), batch)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var st staleAttempt
+			if err := rows.Scan(&st.ID, &st.MilestoneID, &st.ServiceID, &st.ShopID, &st.OrderID, &st.MandateID, &st.Amount, &st.Currency, &st.Attempt, &st.Key,
+				&st.Reference, &st.Resubmissions, &st.Chargeable, &st.SameAmount); err != nil {
+				rows.Close()
+				return err
+			}
+			stale = append(stale, st)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, st := range stale {
+			resubmit, err := a.settleStale(ctx, tx, st, now)
+			if err != nil {
+				return err
+			}
+			if resubmit {
+				out = append(out, st.chargeAttempt)
+			}
+		}
+
+		// Due milestones with no attempt in flight, none that succeeded, and no retry scheduled for later.
+		// An exhausted milestone's last failed attempt has no next_retry_at and keeps it out for good.
+		const qDue = `
+SELECT m.id, m.service_id, s.shop_id, s.shopify_order_id, COALESCE(s.payment_mandate_id,''),
+       (m.amount - m.amount_paid)::text, s.currency,
+       (SELECT COUNT(*) FROM milestone_charge_attempts c WHERE c.milestone_id = m.id)
+FROM milestones m
+JOIN services s ON s.id = m.service_id
+WHERE s.auto_charge
+  AND s.status <> 'Completed'
+  AND m.due_at <= $1
+  AND m.status IN ('unpaid', 'partially_paid')
+  AND m.amount - m.amount_paid > 0
+  AND NOT EXISTS (
+    SELECT 1 FROM milestone_charge_attempts c
+    WHERE c.milestone_id = m.id
+      AND (c.status IN ('pending', 'succeeded')
+           OR (c.status = 'failed' AND (c.next_retry_at IS NULL OR c.next_retry_at > $1)))
+  )
+ORDER BY m.due_at ASC
+LIMIT $2
+FOR UPDATE OF m SKIP LOCKED
+`
+		rows, err = tx.Query(ctx, qDue, now, batch)
+		if err != nil {
+			return err
+		}
+		var due []chargeAttempt
+		for rows.Next() {
+			var at chargeAttempt
+			if err := rows.Scan(&at.MilestoneID, &at.ServiceID, &at.ShopID, &at.OrderID, &at.MandateID, &at.Amount, &at.Currency, &at.Attempt); err != nil {
+				rows.Close()
+				return err
+			}
+			at.Attempt++
+			at.Key = fmt.Sprintf("%s:%d", at.MilestoneID, at.Attempt)
+			due = append(due, at)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		const qInsert = `
+INSERT INTO milestone_charge_attempts (milestone_id, service_id, attempt, idempotency_key, amount, last_sent_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id
+`
+		for _, at := range due {
+			amount, err := decimal.NewFromString(at.Amount)
+			if err != nil || amount.Sign() <= 0 {
+				continue
+			}
+			at.Amount = currency.Format(amount, at.Currency)
+			if err := tx.QueryRow(ctx, qInsert, at.MilestoneID, at.ServiceID, at.Attempt, at.Key, at.Amount, now).Scan(&at.ID); err != nil {
+				return err
+			}
+			data := map[string]any{"milestoneId": at.MilestoneID, "attempt": at.Attempt, "amount": at.Amount}
+			if err := events.Insert(ctx, tx, at.ServiceID, "AUTO_CHARGE_ATTEMPTED", "Automatic charge attempted", "system", now, data); err != nil {
+				return err
+			}
+			out = append(out, at)
+		}
+		for i := range out {
+			s, err := shop.GetByID(ctx, tx, out[i].ShopID)
+			if err != nil {
+				return err
+			}
+			out[i].Shop = s
+		}
+		return nil
+	})
+	return out, err
+}
+
+type staleAttempt struct {
+	chargeAttempt
+	Resubmissions int
+	Chargeable    bool // the milestone is still owed and the service still opted in
+	SameAmount    bool // the attempt's amount is still what the milestone owes
+}
+
+// staleVerdict decides what happens to a pending attempt. One Shopify accepted is polled until its payment
+// job has an outcome, whatever became of the milestone since: the charge may still go through. One Shopify
+// never answered is resubmitted with the same idempotency key, abandoned because it no longer matches its
+// milestone, or given up because the milestone's attempts, resubmissions included, are used up. reason
+// explains the latter two.
+func staleVerdict(st staleAttempt, maxAttempts int) (verdict, reason string) {
+	switch {
+	case st.Reference != "":
+		return "poll", ""
+	case !st.Chargeable:
+		return "abandon", "the milestone is no longer due for automatic charging"
+	case !st.SameAmount:
+		return "abandon", "the milestone amount changed"
+	case st.Attempt+st.Resubmissions >= maxAttempts:
+		return "exhaust", fmt.Sprintf("no response from Shopify after %d submissions", st.Resubmissions+1)
+	}
+	return "resubmit", ""
+}
+
+// settleStale applies staleVerdict in the claim transaction. It reports whether the attempt is to be sent
+// again or polled. An abandoned attempt blocks nothing: if the milestone is still due, the next run charges
+// what it owes now under a new attempt.
+func (a AutoCharger) settleStale(ctx context.Context, tx pgx.Tx, st staleAttempt, now time.Time) (bool, error) {
+	verdict, reason := staleVerdict(st, a.Cfg.AutoChargeMaxAttempts)
+	data := map[string]any{"milestoneId": st.MilestoneID, "attempt": st.Attempt, "amount": st.Amount, "resubmissions": st.Resubmissions}
+	switch verdict {
+	case "abandon":
+		const q = `UPDATE milestone_charge_attempts SET status = 'abandoned', error = $2, finished_at = $3 WHERE id = $1`
+		if _, err := tx.Exec(ctx, q, st.ID, reason, now); err != nil {
+			return false, err
+		}
+		data["reason"] = reason
+		data["idempotencyKey"] = st.Key
+		if err := events.Insert(ctx, tx, st.ServiceID, "AUTO_CHARGE_ABANDONED", "Automatic charge abandoned", "system", now, data); err != nil {
+			return false, err
+		}
+		// Shopify never answered, so it may have charged; the merchant has to check the order before collecting.
+		return false, audit.Insert(ctx, tx, st.ShopID, &st.ServiceID, "AUTO_CHARGE_ABANDONED", "system", data)
+	case "exhaust":
+		const q = `UPDATE milestone_charge_attempts SET status = 'failed', error = $2, next_retry_at = NULL, finished_at = $3 WHERE id = $1`
+		if _, err := tx.Exec(ctx, q, st.ID, reason, now); err != nil {
+			return false, err
+		}
+		data["error"] = reason
+		if err := events.Insert(ctx, tx, st.ServiceID, "AUTO_CHARGE_FAILED", "Automatic charge failed", "system", now, data); err != nil {
+			return false, err
+		}
+		// Shopify may or may not have charged; the merchant has to check and collect this milestone another way.
+		return false, audit.Insert(ctx, tx, st.ShopID, &st.ServiceID, "AUTO_CHARGE_EXHAUSTED", "system", data)
+	case "poll":
+		const q = `UPDATE milestone_charge_attempts SET last_sent_at = $2 WHERE id = $1`
+		_, err := tx.Exec(ctx, q, st.ID, now)
+		return true, err
+	}
+	const q = `UPDATE milestone_charge_attempts SET resubmissions = resubmissions + 1, last_sent_at = $2 WHERE id = $1`
+	_, err := tx.Exec(ctx, q, st.ID, now)
+	return true, err
+}
+
+// charge sends one attempt to Shopify, or polls the payment of an attempt Shopify already accepted, and
+// records the outcome. Shopify charges in a background job, so an accepted attempt stays pending until its
+// transaction succeeded or failed. Transport errors leave the attempt pending so it is resubmitted (or
+// polled) later.
+func (a AutoCharger) charge(ctx context.Context, at chargeAttempt) error {
+	client := shop.AdminClient(a.Cfg, at.Shop)
+
+	mandateID := at.MandateID
+	if at.Reference == "" {
+		if mandateID == "" {
+			mandates, err := client.OrderMandates(ctx, at.OrderID)
+			if err != nil {
+				return err
+			}
+			if len(mandates) == 0 {
+				return a.finish(ctx, at, "", "", errors.New("the order has no payment mandate"))
+			}
+			mandateID = mandates[0]
+		}
+
+		res, err := client.CreateMandatePayment(ctx, shopify.MandatePayment{
+			OrderID:        at.OrderID,
+			MandateID:      mandateID,
+			Amount:         at.Amount,
+			Currency:       at.Currency,
+			IdempotencyKey: at.Key,
+		})
+		var declined *shopify.MandatePaymentError
+		switch {
+		case errors.As(err, &declined):
+			return a.finish(ctx, at, mandateID, "", declined)
+		case err != nil:
+			return err
+		}
+		if err := a.accepted(ctx, at, mandateID, res.PaymentReferenceID); err != nil {
+			return err
+		}
+		at.Reference = res.PaymentReferenceID
+	}
+
+	txs, err := client.PaymentTransactions(ctx, at.OrderID, at.Reference)
+	if err != nil {
+		return err
+	}
+	settled, err := mandateOutcome(txs)
+	switch {
+	case err != nil:
+		return a.finish(ctx, at, mandateID, at.Reference, err)
+	case !settled:
+		return nil // still processing; the next run polls again
+	}
+	return a.finish(ctx, at, mandateID, at.Reference, nil)
+}
+
+// mandateOutcome reads the transactions Shopify recorded for a mandate payment. settled is true once the
+// charge went through; a failed charge is returned as *shopify.MandatePaymentError. Neither means Shopify is
+// still processing it.
+func mandateOutcome(txs []shopify.OrderTransaction) (settled bool, err error) {
+	for _, t := range txs {
+		if (t.Kind == "SALE" || t.Kind == "CAPTURE") && t.Status == "SUCCESS" {
+			return true, nil
+		}
+	}
+	for _, t := range txs {
+		if t.Status == "FAILURE" || t.Status == "ERROR" {
+			return false, &shopify.MandatePaymentError{Code: t.ErrorCode, Message: "payment " + strings.ToLower(t.Status)}
+		}
+	}
+	return false, nil
+}
+
+// accepted records the payment reference of a charge Shopify queued, so the attempt is polled rather than
+// resubmitted from now on, and remembers the mandate it was charged through.
+func (a AutoCharger) accepted(ctx context.Context, at chargeAttempt, mandateID, reference string) error {
+	return db.WithTx(ctx, a.DB, func(tx pgx.Tx) error {
+		const q = `UPDATE milestone_charge_attempts SET payment_reference = $2 WHERE id = $1 AND status = 'pending'`
+		if _, err := tx.Exec(ctx, q, at.ID, reference); err != nil {
+			return err
+		}
+		if mandateID == "" || mandateID == at.MandateID {
+			return nil
+		}
+		const qMandate = `UPDATE services SET payment_mandate_id = $2 WHERE id = $1`
+		_, err := tx.Exec(ctx, qMandate, at.ServiceID, mandateID)
+		return err
+	})
+}
+
+func (a AutoCharger) finish(ctx context.Context, at chargeAttempt, mandateID, reference string, chargeErr error) error {
+	now := time.Now()
+	return db.WithTx(ctx, a.DB, func(tx pgx.Tx) error {
+		const qLock = `SELECT status FROM milestone_charge_attempts WHERE id = $1 FOR UPDATE`
+		var status string
+		if err := tx.QueryRow(ctx, qLock, at.ID).Scan(&status); err != nil {
+			return err
+		}
+		if status != "pending" {
+			return nil
+		}
+		if mandateID != "" && mandateID != at.MandateID {
+			const qMandate = `UPDATE services SET payment_mandate_id = $2 WHERE id = $1`
+			if _, err := tx.Exec(ctx, qMandate, at.ServiceID, mandateID); err != nil {
+				return err
+			}
+		}
+
+		actor := "system"
+		data := map[string]any{"milestoneId": at.MilestoneID, "attempt": at.Attempt, "amount": at.Amount}
+
+		if chargeErr != nil {
+			next, retry := RetryAt(at.Attempt, a.Cfg.AutoChargeMaxAttempts, a.Cfg.AutoChargeRetryBackoff, now)
+			var nextAt *time.Time
+			if retry {
+				nextAt = &next
+				data["nextRetryAt"] = next
+			}
+			const qFail = `
+UPDATE milestone_charge_attempts
+SET status = 'failed', error = $2, next_retry_at = $3, finished_at = $4
+WHERE id = $1
+`
+			if _, err := tx.Exec(ctx, qFail, at.ID, chargeErr.Error(), nextAt, now); err != nil {
+				return err
+			}
+			data["error"] = chargeErr.Error()
+			if err := events.Insert(ctx, tx, at.ServiceID, "AUTO_CHARGE_FAILED", "Automatic charge failed", actor, now, data); err != nil {
+				return err
+			}
+			if retry {
+				return nil
+			}
+			// Out of attempts: the merchant has to collect this milestone another way.
+			return audit.Insert(ctx, tx, at.Shop.ID, &at.ServiceID, "AUTO_CHARGE_EXHAUSTED", actor, data)
+		}
+
+		const qOK = `
+UPDATE milestone_charge_attempts
+SET status = 'succeeded', payment_reference = $2, finished_at = $3
+WHERE id = $1
+`
+		if _, err := tx.Exec(ctx, qOK, at.ID, reference, now); err != nil {
+			return err
+		}
+		m, err := milestone.GetForUpdateScoped(ctx, tx, at.Shop.ID, at.MilestoneID)
+		if err != nil {
+			return err
+		}
+		_, err = service.RecordMilestonePayment(ctx, tx, at.Shop.ID, m, milestone.Payment{
+			Amount:     decimal.RequireFromString(at.Amount),
+			Source:     "auto_charge",
+			Reference:  reference,
+			ReceivedAt: now,
+		}, actor, map[string]any{"attempt": at.Attempt, "paymentReference": reference})
+		if err != nil {
+			return err
+		}
+		data["paymentReference"] = reference
+		return events.Insert(ctx, tx, at.ServiceID, "AUTO_CHARGE_SUCCEEDED", "Automatic charge succeeded", actor, now, data)
+	})
+}
@@ -0,0 +1,91 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"microservice/pkg/shopify"
+)
+
+func TestAutoChargeOptedIn(t *testing.T) {
+	for _, v := range []string{"true", "Yes", " 1 ", "on"} {
+		if !AutoChargeOptedIn(map[string]string{AttrAutoCharge: v}) {
+			t.Fatalf("expected %q to opt in", v)
+		}
+	}
+	for _, v := range []string{"", "false", "no", "0"} {
+		if AutoChargeOptedIn(map[string]string{AttrAutoCharge: v}) {
+			t.Fatalf("expected %q not to opt in", v)
+		}
+	}
+	if AutoChargeOptedIn(nil) {
+		t.Fatal("expected no attributes not to opt in")
+	}
+}
+
+func TestRetryAt_BacksOffExponentially(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	backoff := 24 * time.Hour
+
+	at, ok := RetryAt(1, 3, backoff, now)
+	if !ok || !at.Equal(now.Add(24*time.Hour)) {
+		t.Fatalf("attempt 1: got %v %v", at, ok)
+	}
+	at, ok = RetryAt(2, 3, backoff, now)
+	if !ok || !at.Equal(now.Add(48*time.Hour)) {
+		t.Fatalf("attempt 2: got %v %v", at, ok)
+	}
+	if _, ok := RetryAt(3, 3, backoff, now); ok {
+		t.Fatal("attempt 3 of 3 must not be retried")
+	}
+}
+
+func TestStaleVerdict(t *testing.T) {
+	st := staleAttempt{chargeAttempt: chargeAttempt{Attempt: 1}, Chargeable: true, SameAmount: true}
+	if v, _ := staleVerdict(st, 3); v != "resubmit" {
+		t.Fatalf("fresh stale attempt: got %q", v)
+	}
+
+	st.Resubmissions = 2
+	if v, reason := staleVerdict(st, 3); v != "exhaust" || reason == "" {
+		t.Fatalf("resubmissions must count against the attempts: got %q %q", v, reason)
+	}
+
+	st.Resubmissions = 0
+	st.SameAmount = false
+	if v, _ := staleVerdict(st, 3); v != "abandon" {
+		t.Fatalf("repriced milestone: got %q", v)
+	}
+	st.SameAmount, st.Chargeable = true, false
+	if v, _ := staleVerdict(st, 3); v != "abandon" {
+		t.Fatalf("settled milestone or opted-out service: got %q", v)
+	}
+
+	// Shopify accepted the charge: whatever happened to the milestone, the payment job's outcome decides.
+	st.Reference, st.Resubmissions = "payment-1", 5
+	if v, _ := staleVerdict(st, 3); v != "poll" {
+		t.Fatalf("accepted attempt: got %q", v)
+	}
+}
+
+func TestMandateOutcome(t *testing.T) {
+	if settled, err := mandateOutcome(nil); settled || err != nil {
+		t.Fatalf("no transaction yet: got %v %v", settled, err)
+	}
+	if settled, err := mandateOutcome([]shopify.OrderTransaction{{Kind: "SALE", Status: "PENDING"}}); settled || err != nil {
+		t.Fatalf("pending transaction: got %v %v", settled, err)
+	}
+	if settled, err := mandateOutcome([]shopify.OrderTransaction{{Kind: "AUTHORIZATION", Status: "SUCCESS"}}); settled || err != nil {
+		t.Fatalf("authorized but not captured: got %v %v", settled, err)
+	}
+	if settled, err := mandateOutcome([]shopify.OrderTransaction{{Kind: "SALE", Status: "SUCCESS"}}); !settled || err != nil {
+		t.Fatalf("successful sale: got %v %v", settled, err)
+	}
+
+	_, err := mandateOutcome([]shopify.OrderTransaction{{Kind: "SALE", Status: "FAILURE", ErrorCode: "CARD_DECLINED"}})
+	var declined *shopify.MandatePaymentError
+	if !errors.As(err, &declined) || declined.Code != "CARD_DECLINED" {
+		t.Fatalf("failed sale: got %v", err)
+	}
+}
@@ -0,0 +1,404 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/internal/service"
+	"microservice/internal/shop"
+	"microservice/pkg/config"
+	"microservice/pkg/currency"
+	"microservice/pkg/shopify"
+)
+
+// Payment bundle statuses.
+const (
+	BundleOpen      = "open"
+	BundlePaid      = "paid"
+	BundleCancelled = "cancelled"
+)
+
+// Bundle is one draft order covering every milestone of a service that could be paid when it was issued.
+type Bundle struct {
+	ID           string       `json:"bundleId"`
+	ServiceID    string       `json:"serviceId"`
+	Status       string       `json:"status"`
+	Amount       string       `json:"amount"`
+	Currency     string       `json:"currency"`
+	DraftOrderID string       `json:"draftOrderId,omitempty"`
+	CheckoutURL  string       `json:"checkoutUrl,omitempty"`
+	Items        []BundleItem `json:"milestones"`
+}
+
+type BundleItem struct {
+	MilestoneID string `json:"milestoneId"`
+	Sequence    int    `json:"sequence"`
+	Title       string `json:"title,omitempty"`
+	Amount      string `json:"amount"`
+}
+
+var (
+	// ErrNothingToPay means no milestone of the service can be paid right now.
+	ErrNothingToPay = errors.New("no milestone can be paid right now")
+	// ErrBundleRailUnsupported means the service collects payments through a rail without multi-line checkouts.
+	ErrBundleRailUnsupported = errors.New("combined checkout requires the draft order payment rail")
+)
+
+// Bundles issues and settles "pay remaining balance" draft orders. Only the draft order rail supports them.
+type Bundles struct {
+	Cfg   config.Config
+	Rails Rails
+}
+
+// Issue returns the open bundle of a service, issuing a new draft order when there is none or when the open one
+// no longer covers exactly what can be paid. Only new bundles are audited.
+func (b Bundles) Issue(ctx context.Context, tx pgx.Tx, s *shop.Shop, serviceID, actor string) (*Bundle, error) {
+	svc, err := service.GetForUpdate(ctx, tx, s.ID, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	const qRail = `SELECT COALESCE(service_config_snapshot->>'paymentRail','') FROM services WHERE id = $1`
+	var productRail string
+	if err := tx.QueryRow(ctx, qRail, svc.ID).Scan(&productRail); err != nil {
+		return nil, err
+	}
+	rail, err := b.Rails.Select(productRail, s.PaymentRail)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBundleRailUnsupported, err)
+	}
+	draftRail, ok := rail.(DraftOrderRail)
+	if !ok {
+		return nil, ErrBundleRailUnsupported
+	}
+
+	items, err := eligibleForBundle(ctx, tx, svc.ID, svc.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNothingToPay
+	}
+
+	open, err := openBundleForUpdate(ctx, tx, svc.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	var replaced string
+	if open != nil {
+		if open.CheckoutURL != "" && sameItems(open.Items, items) {
+			return open, nil
+		}
+		// What can be paid changed since the open bundle was issued (a milestone was paid or unlocked).
+		if open.DraftOrderID != "" {
+			if err := draftRail.CancelPaymentRequest(ctx, s, open.DraftOrderID); err != nil {
+				log.Printf("pay remaining balance: cancel bundle %s draft order %s failed: %v", open.ID, open.DraftOrderID, err)
+			}
+		}
+		if err := setBundleStatus(ctx, tx, open.ID, BundleCancelled); err != nil {
+			return nil, err
+		}
+		replaced = open.ID
+	}
+
+	total := decimal.Zero
+	for _, it := range items {
+		total = total.Add(decimal.RequireFromString(it.Amount))
+	}
+	bundle := &Bundle{ServiceID: svc.ID, Status: BundleOpen, Amount: currency.Format(total, svc.Currency), Currency: svc.Currency, Items: items}
+
+	const qInsert = `
+INSERT INTO payment_bundles (shop_id, service_id, amount, currency, requested_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id
+`
+	if err := tx.QueryRow(ctx, qInsert, s.ID, svc.ID, bundle.Amount, bundle.Currency, actor).Scan(&bundle.ID); err != nil {
+		return nil, err
+	}
+	const qItem = `INSERT INTO payment_bundle_items (bundle_id, milestone_id, amount) VALUES ($1, $2, $3)`
+	lines := make([]shopify.DraftOrderLine, 0, len(items))
+	for _, it := range items {
+		if _, err := tx.Exec(ctx, qItem, bundle.ID, it.MilestoneID, it.Amount); err != nil {
+			return nil, err
+		}
+		lines = append(lines, shopify.DraftOrderLine{Title: LineTitle(it.Title, svc.DisplayID), Amount: it.Amount})
+	}
+
+	attrs := BundleAttributes(b.Cfg.ReconciliationSecret, s.ID, bundle.ID, svc.ID)
+	note := fmt.Sprintf("service_workflow: bundle_id=%s service_id=%s", bundle.ID, svc.ID)
+	bundle.DraftOrderID, bundle.CheckoutURL, err = draftRail.client(s).CreateDraftOrderLines(ctx, lines, bundle.Currency, note, attrs)
+	if err != nil {
+		return nil, err
+	}
+	const qSet = `UPDATE payment_bundles SET draft_order_id = $2, checkout_url = $3 WHERE id = $1`
+	if _, err := tx.Exec(ctx, qSet, bundle.ID, bundle.DraftOrderID, bundle.CheckoutURL); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.MilestoneID)
+	}
+	data := map[string]any{"bundleId": bundle.ID, "milestoneIds": ids, "amount": bundle.Amount, "draftOrderId": bundle.DraftOrderID}
+	if replaced != "" {
+		data["replacedBundleId"] = replaced
+	}
+	if err := audit.Insert(ctx, tx, s.ID, &svc.ID, "PAYMENT_BUNDLE_REQUESTED", actor, data); err != nil {
+		return nil, err
+	}
+	if err := events.Insert(ctx, tx, svc.ID, "PAYMENT_BUNDLE_REQUESTED", "Remaining balance payment requested", actor, time.Now(), data); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// ApplyBundlePayment books a paid bundle order against each milestone it covers, in the caller's transaction.
+// paidAmount is the order's subtotal, without tax, shipping and tips. It is spread over the items in sequence
+// order; anything beyond the bundle amount lands on the last item, from where the ledger carries it forward.
+// Orders for unknown or already paid bundles are ignored; an order that paid nothing books nothing and is audited.
+func ApplyBundlePayment(ctx context.Context, tx pgx.Tx, shopID, bundleID, serviceID, orderID, paidAmount, actor string) error {
+	bundle, err := getBundleForUpdate(ctx, tx, shopID, bundleID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if !strings.EqualFold(bundle.ServiceID, serviceID) || bundle.Status == BundlePaid {
+		return nil
+	}
+
+	billed := decimal.RequireFromString(bundle.Amount)
+	paid, err := decimal.NewFromString(strings.TrimSpace(paidAmount))
+	if err != nil || paid.Sign() <= 0 {
+		// Nothing was paid, or the order's subtotal cannot be read: book nothing and leave the bundle open.
+		data := map[string]any{"bundleId": bundle.ID, "orderId": orderID, "amount": bundle.Amount, "paidAmount": paidAmount}
+		svcID := bundle.ServiceID
+		if err := audit.Insert(ctx, tx, shopID, &svcID, "PAYMENT_BUNDLE_AMOUNT_MISMATCH", actor, data); err != nil {
+			return err
+		}
+		return events.Insert(ctx, tx, svcID, "PAYMENT_BUNDLE_AMOUNT_MISMATCH", "Remaining balance order paid nothing", actor, time.Now(), data)
+	}
+
+	now := time.Now()
+	for i, amount := range AllocateBundlePayment(bundle.Items, paid) {
+		if amount.Sign() <= 0 {
+			continue
+		}
+		m, err := milestone.GetForUpdateScoped(ctx, tx, shopID, bundle.Items[i].MilestoneID)
+		if err != nil {
+			return err
+		}
+		_, err = service.RecordMilestonePayment(ctx, tx, shopID, m, milestone.Payment{
+			Amount:     amount,
+			Source:     "order",
+			Reference:  orderID,
+			ReceivedAt: now,
+		}, actor, map[string]any{"bundleId": bundle.ID, "orderId": orderID})
+		if err != nil && !errors.Is(err, milestone.ErrDuplicatePayment) {
+			return err
+		}
+	}
+
+	const qPaid = `UPDATE payment_bundles SET status = 'paid', paid_order_id = $2, paid_at = $3 WHERE id = $1`
+	if _, err := tx.Exec(ctx, qPaid, bundle.ID, orderID, now); err != nil {
+		return err
+	}
+
+	data := map[string]any{"bundleId": bundle.ID, "orderId": orderID, "amount": bundle.Amount, "paidAmount": currency.Format(paid, bundle.Currency)}
+	if !paid.Equal(billed) {
+		data["amountMismatch"] = true
+	}
+	svcID := bundle.ServiceID
+	if err := audit.Insert(ctx, tx, shopID, &svcID, "PAYMENT_BUNDLE_PAID", actor, data); err != nil {
+		return err
+	}
+	return events.Insert(ctx, tx, svcID, "PAYMENT_BUNDLE_PAID", "Remaining balance paid", actor, now, data)
+}
+
+// CancelBundleByDraftOrder cancels the open bundle whose draft order was deleted in Shopify.
+// found is false when the draft order does not belong to a bundle.
+func CancelBundleByDraftOrder(ctx context.Context, tx pgx.Tx, shopID, draftOrderID, actor string) (found bool, err error) {
+	const q = `
+UPDATE payment_bundles
+SET status = 'cancelled'
+WHERE shop_id = $1 AND draft_order_id = $2 AND status = 'open'
+RETURNING id, service_id
+`
+	var bundleID, serviceID string
+	if err := tx.QueryRow(ctx, q, shopID, draftOrderID).Scan(&bundleID, &serviceID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	data := map[string]any{"bundleId": bundleID, "draftOrderId": draftOrderID, "reason": "deleted"}
+	if err := audit.Insert(ctx, tx, shopID, &serviceID, "PAYMENT_BUNDLE_CANCELLED", actor, data); err != nil {
+		return true, err
+	}
+	return true, events.Insert(ctx, tx, serviceID, "PAYMENT_BUNDLE_CANCELLED", "Remaining balance payment link removed", actor, time.Now(), data)
+}
+
+// AllocateBundlePayment splits paid over the bundle items in order, each up to its billed amount.
+// Whatever is left after the last item is added to it.
+func AllocateBundlePayment(items []BundleItem, paid decimal.Decimal) []decimal.Decimal {
+	out := make([]decimal.Decimal, len(items))
+	left := paid
+	for i, it := range items {
+		amount := decimal.RequireFromString(it.Amount)
+		if left.LessThan(amount) {
+			amount = left
+		}
+		out[i] = amount
+		left = left.Sub(amount)
+	}
+	if len(out) > 0 && left.Sign() > 0 {
+		out[len(out)-1] = out[len(out)-1].Add(left)
+	}
+	return out
+}
+
+// eligibleForBundle locks the service's milestones and returns those that can be paid now, billed at what is
+// still owed: unlocked, not settled, and the final milestone only once the client has approved. A recurring
+// service's cycles have no final milestone to hold back.
+func eligibleForBundle(ctx context.Context, tx pgx.Tx, serviceID, currencyCode string) ([]BundleItem, error) {
+	const q = `
+SELECT id, sequence, amount::text, amount_paid::text, status, COALESCE(title,''), period_start
+FROM milestones
+WHERE service_id = $1
+ORDER BY sequence ASC
+FOR UPDATE
+`
+	rows, err := tx.Query(ctx, q, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	type row struct {
+		id, status   string
+		sequence     int
+		amount, paid decimal.Decimal
+		title        string
+		cycle        bool
+	}
+	var ms []row
+	for rows.Next() {
+		var rw row
+		var amount, paid string
+		var rec milestone.Record
+		if err := rows.Scan(&rw.id, &rw.sequence, &amount, &paid, &rw.status, &rec.Title, &rec.PeriodStart); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rw.amount, rw.paid = decimal.RequireFromString(amount), decimal.RequireFromString(paid)
+		rec.Sequence = rw.sequence
+		rw.title = rec.DisplayTitle()
+		rw.cycle = rec.PeriodStart != nil
+		ms = append(ms, rw)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	const qAppr = `SELECT approved FROM approvals WHERE service_id = $1`
+	var approved bool
+	if err := tx.QueryRow(ctx, qAppr, serviceID).Scan(&approved); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	recurring, err := isRecurring(ctx, tx, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []BundleItem
+	for _, m := range ms {
+		if m.status == milestone.StatusLocked || milestone.IsSettled(m.status) {
+			continue
+		}
+		if !approved && awaitsFinalApproval(m.sequence, ms[len(ms)-1].sequence, recurring || m.cycle) {
+			continue
+		}
+		owed := milestone.Outstanding(m.amount, m.paid)
+		if owed.Sign() <= 0 {
+			continue
+		}
+		items = append(items, BundleItem{MilestoneID: m.id, Sequence: m.sequence, Title: m.title, Amount: currency.Format(owed, currencyCode)})
+	}
+	return items, nil
+}
+
+func openBundleForUpdate(ctx context.Context, tx pgx.Tx, serviceID string) (*Bundle, error) {
+	const q = `SELECT id, shop_id FROM payment_bundles WHERE service_id = $1 AND status = 'open'`
+	var id, shopID string
+	if err := tx.QueryRow(ctx, q, serviceID).Scan(&id, &shopID); err != nil {
+		return nil, err
+	}
+	return getBundleForUpdate(ctx, tx, shopID, id)
+}
+
+func getBundleForUpdate(ctx context.Context, tx pgx.Tx, shopID, bundleID string) (*Bundle, error) {
+	const q = `
+SELECT id, service_id, status, amount::text, currency, COALESCE(draft_order_id,''), COALESCE(checkout_url,'')
+FROM payment_bundles
+WHERE id = $1 AND shop_id = $2
+FOR UPDATE
+`
+	var b Bundle
+	if err := tx.QueryRow(ctx, q, bundleID, shopID).Scan(&b.ID, &b.ServiceID, &b.Status, &b.Amount, &b.Currency, &b.DraftOrderID, &b.CheckoutURL); err != nil {
+		return nil, err
+	}
+
+	const qItems = `
+SELECT i.milestone_id, m.sequence, i.amount::text, COALESCE(m.title,''), m.period_start
+FROM payment_bundle_items i
+JOIN milestones m ON m.id = i.milestone_id
+WHERE i.bundle_id = $1
+ORDER BY m.sequence ASC
+`
+	rows, err := tx.Query(ctx, qItems, b.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var it BundleItem
+		var rec milestone.Record
+		if err := rows.Scan(&it.MilestoneID, &it.Sequence, &it.Amount, &rec.Title, &rec.PeriodStart); err != nil {
+			return nil, err
+		}
+		rec.Sequence = it.Sequence
+		it.Title = rec.DisplayTitle()
+		b.Items = append(b.Items, it)
+	}
+	return &b, rows.Err()
+}
+
+func setBundleStatus(ctx context.Context, tx pgx.Tx, bundleID, status string) error {
+	const q = `UPDATE payment_bundles SET status = $2 WHERE id = $1`
+	_, err := tx.Exec(ctx, q, bundleID, status)
+	return err
+}
+
+// sameItems reports whether a bundle still bills exactly the given milestones and amounts.
+func sameItems(a, b []BundleItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].MilestoneID != b[i].MilestoneID || !SameAmount(a[i].Amount, b[i].Amount) {
+			return false
+		}
+	}
+	return true
+}
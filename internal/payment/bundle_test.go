@@ -0,0 +1,72 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func bundleItems(amounts ...string) []BundleItem {
+	out := make([]BundleItem, len(amounts))
+	for i, a := range amounts {
+		out[i] = BundleItem{MilestoneID: string(rune('a' + i)), Sequence: i + 1, Amount: a}
+	}
+	return out
+}
+
+func TestAllocateBundlePayment(t *testing.T) {
+	cases := []struct {
+		name string
+		paid string
+		want []string
+	}{
+		{"exact", "100", []string{"30", "70"}},
+		{"short payment fills in order", "50", []string{"30", "20"}},
+		{"excess lands on last item", "110", []string{"30", "80"}},
+		{"too little for the first item", "10", []string{"10", "0"}},
+	}
+	for _, c := range cases {
+		got := AllocateBundlePayment(bundleItems("30.00", "70.00"), decimal.RequireFromString(c.paid))
+		for i, w := range c.want {
+			if !got[i].Equal(decimal.RequireFromString(w)) {
+				t.Fatalf("%s: item %d got %s, want %s", c.name, i, got[i], w)
+			}
+		}
+	}
+}
+
+func TestSameItems(t *testing.T) {
+	if !sameItems(bundleItems("30", "70.00"), bundleItems("30.00", "70")) {
+		t.Fatalf("expected numerically equal items to match")
+	}
+	if sameItems(bundleItems("30", "70"), bundleItems("30", "60")) {
+		t.Fatalf("expected changed amount to differ")
+	}
+	if sameItems(bundleItems("30", "70"), bundleItems("30")) {
+		t.Fatalf("expected different item count to differ")
+	}
+}
+
+func TestVerifyBundleAttributes(t *testing.T) {
+	attrs := map[string]string{}
+	for _, a := range BundleAttributes("s3cret", "shop-1", "b-1", "svc-1") {
+		attrs[a.Key] = a.Value
+	}
+
+	b, svc, ok, err := VerifyBundleAttributes("s3cret", "shop-1", attrs)
+	if err != nil || !ok || b != "b-1" || svc != "svc-1" {
+		t.Fatalf("got %q %q %v %v", b, svc, ok, err)
+	}
+
+	// A bundle signature must not pass as a milestone signature over the same ids.
+	forged := map[string]string{AttrMilestoneID: "b-1", AttrServiceID: "svc-1", AttrSignature: attrs[AttrSignature]}
+	if _, _, _, err := VerifyMilestoneAttributes("s3cret", "shop-1", forged); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for bundle signature reused on a milestone, got %v", err)
+	}
+
+	// Milestone orders carry no bundle id.
+	if _, _, ok, err := VerifyBundleAttributes("s3cret", "shop-1", attrMap("s3cret", "shop-1", "m-1", "svc-1")); ok || err != nil {
+		t.Fatalf("expected milestone attributes to be ignored, got ok=%v err=%v", ok, err)
+	}
+}
@@ -0,0 +1,65 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"microservice/internal/serviceproduct"
+	"microservice/internal/shop"
+)
+
+// FakeRail is an in-memory rail for local development and tests. It issues references like "fake_1"
+// and resolves confirmations that quote one of them.
+type FakeRail struct {
+	mu        *sync.Mutex
+	next      *int
+	requests  map[string]Request
+	cancelled map[string]bool
+}
+
+func NewFakeRail() FakeRail {
+	return FakeRail{mu: &sync.Mutex{}, next: new(int), requests: map[string]Request{}, cancelled: map[string]bool{}}
+}
+
+func (FakeRail) Name() string { return serviceproduct.PaymentRailFake }
+
+func (f FakeRail) CreatePaymentRequest(_ context.Context, _ *shop.Shop, req Request) (*IssuedRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.next++
+	ref := fmt.Sprintf("fake_%d", *f.next)
+	f.requests[ref] = req
+	return &IssuedRequest{Reference: ref, CheckoutURL: "http://fake-rail.local/pay/" + ref}, nil
+}
+
+func (f FakeRail) CancelPaymentRequest(_ context.Context, _ *shop.Shop, reference string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.requests[reference]; !ok {
+		return ErrUnresolved
+	}
+	f.cancelled[reference] = true
+	return nil
+}
+
+func (f FakeRail) ResolveConfirmation(_ context.Context, _ *shop.Shop, c Confirmation) (*Resolution, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	req, ok := f.requests[c.Reference]
+	if !ok || f.cancelled[c.Reference] {
+		return nil, ErrUnresolved
+	}
+	return &Resolution{MilestoneID: req.MilestoneID, Reference: c.Reference}, nil
+}
+
+// Requests returns what has been issued so far, keyed by reference.
+func (f FakeRail) Requests() map[string]Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]Request, len(f.requests))
+	for k, v := range f.requests {
+		out[k] = v
+	}
+	return out
+}
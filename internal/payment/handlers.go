@@ -2,6 +2,7 @@ package payment
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,20 +11,24 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
 
 	"microservice/internal/api"
 	"microservice/internal/audit"
 	"microservice/internal/events"
 	"microservice/internal/milestone"
+	"microservice/internal/service"
+	"microservice/internal/serviceproduct"
+	"microservice/internal/shop"
 	"microservice/pkg/config"
 	"microservice/pkg/db"
-	"microservice/pkg/shopify"
 )
 
 type Handlers struct {
 	Cfg        config.Config
 	DB         *pgxpool.Pool
 	Milestones *milestone.Repository
+	Rails      Rails
 }
 
 func (h Handlers) RequestPayment(w http.ResponseWriter, r *http.Request) {
@@ -46,75 +51,280 @@ func (h Handlers) RequestPayment(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
-		if m.Status == "paid" {
+		resp, err = Requests{Rails: h.Rails}.Issue(r.Context(), tx, shopCtx, m, api.Actor(r.Context()))
+		if WriteRequestError(w, err) {
+			return pgx.ErrTxCommitRollback
+		}
+		return err
+	})
+
+	if err != nil {
+		if err == pgx.ErrTxCommitRollback {
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "milestone not found")
+			return
+		}
+		if h.Cfg.AppEnv != "prod" {
+			api.WriteError(w, http.StatusInternalServerError, "INTERNAL", fmt.Sprintf("request payment failed: %v", err))
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// CancelPaymentRequest withdraws the outstanding payment request of an unpaid milestone through its rail,
+// so a new one can be requested (e.g. after switching rails).
+func (h Handlers) CancelPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		m, err := milestone.GetForUpdateScoped(r.Context(), tx, shopCtx.ID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if milestone.IsSettled(m.Status) {
 			api.WriteError(w, http.StatusConflict, "MILESTONE_ALREADY_PAID", "milestone already paid")
 			return pgx.ErrTxCommitRollback
 		}
-		if m.Status == "locked" {
-			api.WriteError(w, http.StatusConflict, "MILESTONE_LOCKED", "milestone is locked")
+		if m.PaymentReference == "" {
+			api.WriteError(w, http.StatusConflict, "NO_PAYMENT_REQUEST", "milestone has no outstanding payment request")
 			return pgx.ErrTxCommitRollback
 		}
+		rail, ok := h.Rails[m.PaymentRail]
+		if !ok {
+			api.WriteError(w, http.StatusConflict, "PAYMENT_RAIL_UNAVAILABLE", fmt.Sprintf("payment rail %q is not available", m.PaymentRail))
+			return pgx.ErrTxCommitRollback
+		}
+		if err := rail.CancelPaymentRequest(r.Context(), shopCtx, m.PaymentReference); err != nil {
+			return err
+		}
+		if err := milestone.ClearPaymentRequest(r.Context(), tx, m.ID); err != nil {
+			return err
+		}
 
-		// Idempotency: if already has a draft order, return existing link.
-		if m.DraftOrderID != "" && m.CheckoutURL != "" {
-			resp = map[string]any{"draftOrderId": m.DraftOrderID, "checkoutUrl": m.CheckoutURL}
-			return nil
+		actor := api.Actor(r.Context())
+		data := map[string]any{"milestoneId": m.ID, "paymentRail": m.PaymentRail, "reference": m.PaymentReference}
+		if err := audit.Insert(r.Context(), tx, shopCtx.ID, &m.ServiceID, "MILESTONE_PAYMENT_CANCELLED", actor, data); err != nil {
+			return err
+		}
+		return events.Insert(r.Context(), tx, m.ServiceID, "MILESTONE_PAYMENT_CANCELLED", "Milestone payment request cancelled", actor, time.Now(), data)
+	})
+
+	if err != nil {
+		if err == pgx.ErrTxCommitRollback {
+			return
 		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "milestone not found")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ConfirmPaymentRequest struct {
+	Reference string `json:"reference"`
+	Note      string `json:"note,omitempty"`
+	Amount    string `json:"amount,omitempty"`
+}
 
-		// Block final milestone until approval (strict).
-		const qFinalSeq = `SELECT sequence FROM milestones WHERE service_id = $1 ORDER BY sequence DESC LIMIT 1`
-		var finalSeq int
-		if err := tx.QueryRow(r.Context(), qFinalSeq, m.ServiceID).Scan(&finalSeq); err == nil && m.Sequence == finalSeq {
-			const qAppr = `SELECT approved FROM approvals WHERE service_id = $1`
-			var approved bool
-			if err := tx.QueryRow(r.Context(), qAppr, m.ServiceID).Scan(&approved); err != nil || !approved {
-				api.WriteError(w, http.StatusConflict, "FINAL_MILESTONE_LOCKED", "final payment requires approval")
+// ConfirmPayment records money received outside Shopify (manual invoice, external processor) against the
+// milestone. The confirmation must resolve, through the milestone's rail, to the milestone's own request, and
+// may not exceed what is still owed or OverrideSecondApproverAbove; larger amounts go through an admin override.
+// Draft order payments are confirmed by the orders/paid webhook instead.
+func (h Handlers) ConfirmPayment(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var req ConfirmPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+		return
+	}
+	if strings.TrimSpace(req.Reference) == "" && strings.TrimSpace(req.Note) == "" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "reference or note is required")
+		return
+	}
+
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		m, err := milestone.GetForUpdateScoped(r.Context(), tx, shopCtx.ID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if milestone.IsSettled(m.Status) {
+			api.WriteError(w, http.StatusConflict, "MILESTONE_ALREADY_PAID", "milestone already paid")
+			return pgx.ErrTxCommitRollback
+		}
+		if m.PaymentReference == "" {
+			api.WriteError(w, http.StatusConflict, "NO_PAYMENT_REQUEST", "milestone has no outstanding payment request")
+			return pgx.ErrTxCommitRollback
+		}
+		if m.PaymentRail == serviceproduct.PaymentRailDraftOrder {
+			api.WriteError(w, http.StatusConflict, "CONFIRMED_BY_WEBHOOK", "draft order payments are confirmed by Shopify; use an admin override instead")
+			return pgx.ErrTxCommitRollback
+		}
+		rail, ok := h.Rails[m.PaymentRail]
+		if !ok {
+			api.WriteError(w, http.StatusConflict, "PAYMENT_RAIL_UNAVAILABLE", fmt.Sprintf("payment rail %q is not available", m.PaymentRail))
+			return pgx.ErrTxCommitRollback
+		}
+
+		res, err := rail.ResolveConfirmation(r.Context(), shopCtx, Confirmation{Reference: strings.TrimSpace(req.Reference), Note: req.Note, Amount: req.Amount})
+		if err != nil || (res.MilestoneID != "" && res.MilestoneID != m.ID) || (res.MilestoneID == "" && res.Reference != m.PaymentReference) {
+			api.WriteError(w, http.StatusConflict, "PAYMENT_REFERENCE_MISMATCH", "confirmation does not match this milestone's payment request")
+			return pgx.ErrTxCommitRollback
+		}
+
+		// Without an amount the confirmation covers what is still owed.
+		amount := m.Outstanding()
+		if strings.TrimSpace(req.Amount) != "" {
+			amount, err = decimal.NewFromString(strings.TrimSpace(req.Amount))
+			if err != nil || amount.Sign() <= 0 {
+				api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "amount must be a positive decimal")
 				return pgx.ErrTxCommitRollback
 			}
 		}
-
-		client := shopify.Client{
-			ShopDomain:  shopCtx.Domain,
-			AccessToken: shopCtx.AccessToken,
-			APIVersion:  h.Cfg.Shopify.APIVersion,
+		// One person confirming money nobody else has seen must not be able to book more than the milestone
+		// owes, nor more than an override could without a second approver.
+		if amount.GreaterThan(m.Outstanding()) {
+			api.WriteError(w, http.StatusConflict, "AMOUNT_EXCEEDS_OUTSTANDING", "amount exceeds what the milestone still owes")
+			return pgx.ErrTxCommitRollback
 		}
-		// Dev convenience: allow using a Shopify "Develop app" Admin API token (shpat_...) to bypass
-		// Protected Customer Data restrictions that apply to public apps.
-		if h.Cfg.AppEnv != "prod" && strings.TrimSpace(h.Cfg.Shopify.DevAdminAccessToken) != "" {
-			client.AccessToken = strings.TrimSpace(h.Cfg.Shopify.DevAdminAccessToken)
+		if limit, err := decimal.NewFromString(strings.TrimSpace(h.Cfg.OverrideSecondApproverAbove)); err == nil && amount.GreaterThan(limit) {
+			api.WriteError(w, http.StatusConflict, "OVERRIDE_REQUIRED", "amounts above the second approver threshold need an admin override")
+			return pgx.ErrTxCommitRollback
 		}
 
-		title := fmt.Sprintf("Milestone payment (service %s, seq %d)", m.ServiceID, m.Sequence)
-		// Note is used later to resolve paid orders back to a milestone via the orders/paid webhook.
-		note := fmt.Sprintf("service_workflow: milestone_id=%s service_id=%s", m.ID, m.ServiceID)
-		currency := m.Currency
-		if currency == "" {
-			currency = "USD"
+		_, err = service.RecordMilestonePayment(r.Context(), tx, shopCtx.ID, m, milestone.Payment{
+			Amount:     amount,
+			Source:     "manual",
+			Reference:  m.PaymentReference,
+			ReceivedAt: time.Now(),
+		}, api.Actor(r.Context()), map[string]any{"paymentRail": m.PaymentRail, "reference": m.PaymentReference})
+		return err
+	})
+
+	if err != nil {
+		if err == pgx.ErrTxCommitRollback {
+			return
 		}
-		draftOrderID, checkoutURL, err := client.CreateDraftOrder(r.Context(), title, m.Amount, currency, note)
-		if err != nil {
-			return err
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "milestone not found")
+			return
 		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
 
-		if err := milestone.SetDraftOrder(r.Context(), tx, m.ID, draftOrderID, checkoutURL); err != nil {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type PutShopRailRequest struct {
+	PaymentRail string `json:"paymentRail"`
+}
+
+// PutShopRail sets the shop's default payment rail. Product configs may still override it.
+func (h Handlers) PutShopRail(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var req PutShopRailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+		return
+	}
+	req.PaymentRail = strings.TrimSpace(req.PaymentRail)
+	if req.PaymentRail != "" {
+		if _, ok := h.Rails[req.PaymentRail]; !ok {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "unknown paymentRail")
+			return
+		}
+	}
+
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		if err := shop.SetPaymentRail(r.Context(), tx, shopCtx.ID, req.PaymentRail); err != nil {
 			return err
 		}
+		meta := audit.Diff(map[string]any{"paymentRail": shopCtx.PaymentRail}, map[string]any{"paymentRail": req.PaymentRail})
+		return audit.Insert(r.Context(), tx, shopCtx.ID, nil, "SHOP_PAYMENT_RAIL_CHANGED", api.Actor(r.Context()), meta)
+	})
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
 
-		now := time.Now()
-		actor := "merchant"
-		_ = audit.Insert(r.Context(), tx, shopCtx.ID, &m.ServiceID, "MILESTONE_PAYMENT_REQUESTED", actor, map[string]any{"milestoneId": m.ID, "draftOrderId": draftOrderID})
-		_ = events.Insert(r.Context(), tx, m.ServiceID, "MILESTONE_PAYMENT_REQUESTED", "Milestone payment requested", actor, now, map[string]any{"milestoneId": m.ID, "draftOrderId": draftOrderID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// outstandingRequest returns the rail and reference of a milestone's payment request.
+// Milestones from before payment rails only have draft_order_id.
+func outstandingRequest(m *milestone.Record) (rail, reference string) {
+	rail, reference = m.PaymentRail, m.PaymentReference
+	if rail == "" {
+		rail = serviceproduct.PaymentRailDraftOrder
+	}
+	if reference == "" {
+		reference = m.DraftOrderID
+	}
+	return rail, reference
+}
 
-		resp = map[string]any{"draftOrderId": draftOrderID, "checkoutUrl": checkoutURL}
-		return nil
+func paymentRequestResponse(m *milestone.Record) map[string]any {
+	rail, ref := outstandingRequest(m)
+	out := map[string]any{"paymentRail": rail, "reference": ref, "checkoutUrl": m.CheckoutURL}
+	if m.DraftOrderID != "" {
+		out["draftOrderId"] = m.DraftOrderID
+	}
+	if m.PaymentInstructions != "" {
+		out["instructions"] = m.PaymentInstructions
+	}
+	return out
+}
+
+// PayRemainingBalance issues (or hands out again) one draft order covering every milestone of the service
+// that can be paid now.
+func (h Handlers) PayRemainingBalance(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var bundle *Bundle
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var err error
+		bundle, err = Bundles{Cfg: h.Cfg, Rails: h.Rails}.Issue(r.Context(), tx, shopCtx, chi.URLParam(r, "id"), api.Actor(r.Context()))
+		if WriteBundleError(w, err) {
+			return pgx.ErrTxCommitRollback
+		}
+		return err
 	})
 
 	if err != nil {
 		if err == pgx.ErrTxCommitRollback {
 			return
 		}
-		if h.Cfg.AppEnv != "prod" {
-			api.WriteError(w, http.StatusInternalServerError, "INTERNAL", fmt.Sprintf("request payment failed: %v", err))
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
 			return
 		}
 		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
@@ -122,5 +332,58 @@ func (h Handlers) RequestPayment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
+	_ = json.NewEncoder(w).Encode(bundle)
+}
+
+// WriteBundleError answers the client-facing bundle errors and reports whether it did.
+func WriteBundleError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, ErrNothingToPay):
+		api.WriteError(w, http.StatusConflict, "NOTHING_TO_PAY", err.Error())
+	case errors.Is(err, ErrBundleRailUnsupported):
+		api.WriteError(w, http.StatusConflict, "PAYMENT_RAIL_UNSUPPORTED", err.Error())
+	default:
+		return false
+	}
+	return true
+}
+
+// DisableAutoCharge opts the service out of charging its milestones on their due date. Attempts already
+// sent to Shopify still finish; no new ones are made.
+func (h Handlers) DisableAutoCharge(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		s, err := service.GetForUpdate(r.Context(), tx, shopCtx.ID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if !s.AutoCharge {
+			return nil
+		}
+		if err := service.SetAutoCharge(r.Context(), tx, s.ID, false); err != nil {
+			return err
+		}
+
+		actor := api.Actor(r.Context())
+		if err := audit.Insert(r.Context(), tx, shopCtx.ID, &s.ID, "AUTO_CHARGE_DISABLED", actor, map[string]any{}); err != nil {
+			return err
+		}
+		return events.Insert(r.Context(), tx, s.ID, "AUTO_CHARGE_DISABLED", "Automatic charges turned off", actor, time.Now(), map[string]any{})
+	})
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,33 @@
+package payment
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+)
+
+// FlagAmountMismatch records that a payment for a milestone (observed) differs from what was requested.
+// The ledger books what actually arrived; the flag lets staff find milestones that need a look.
+func FlagAmountMismatch(ctx context.Context, tx pgx.Tx, shopID string, m *milestone.Record, observed, actor string, data map[string]any) error {
+	if m.AmountMismatch && SameAmount(m.ObservedAmount, observed) {
+		return nil
+	}
+	if err := milestone.FlagAmountMismatch(ctx, tx, m.ID, observed); err != nil {
+		return err
+	}
+
+	meta := map[string]any{"milestoneId": m.ID, "expectedAmount": m.Amount, "observedAmount": observed}
+	for k, v := range data {
+		meta[k] = v
+	}
+	serviceID := m.ServiceID
+	if err := audit.Insert(ctx, tx, shopID, &serviceID, "MILESTONE_AMOUNT_MISMATCH", actor, meta); err != nil {
+		return err
+	}
+	return events.Insert(ctx, tx, serviceID, "MILESTONE_AMOUNT_MISMATCH", "Payment amount differs from milestone amount", actor, time.Now(), meta)
+}
@@ -0,0 +1,256 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"microservice/internal/serviceproduct"
+	"microservice/internal/shop"
+	"microservice/pkg/config"
+	"microservice/pkg/shopify"
+)
+
+// PaymentRail is a way of collecting a milestone payment: it issues a payment request, can withdraw it,
+// and maps inbound confirmations (webhooks, reconciliation entries) back to the request.
+//
+// Rails never touch the database; callers persist the returned Request on the milestone and look
+// milestones up by (rail name, reference).
+type PaymentRail interface {
+	Name() string
+	CreatePaymentRequest(ctx context.Context, s *shop.Shop, req Request) (*IssuedRequest, error)
+	CancelPaymentRequest(ctx context.Context, s *shop.Shop, reference string) error
+	ResolveConfirmation(ctx context.Context, s *shop.Shop, c Confirmation) (*Resolution, error)
+}
+
+// RequestChecker is implemented by rails whose payment requests can change outside the app
+// (a merchant deleting or editing a Shopify draft order). Callers re-check before handing out a link.
+type RequestChecker interface {
+	CheckPaymentRequest(ctx context.Context, s *shop.Shop, reference, amount string) (*RequestCheck, error)
+}
+
+type RequestCheck struct {
+	Stale     bool   // the request can no longer be paid as issued; issue a new one
+	Completed bool   // the client already paid; confirmation is on its way
+	Reason    string // why it is stale: deleted, amount_changed, missing_link
+	Amount    string // what the rail currently asks for
+}
+
+// Request is what a rail needs to ask the client for money.
+type Request struct {
+	MilestoneID string
+	ServiceID   string
+	Sequence    int
+	Title       string
+	Amount      string
+	Currency    string
+}
+
+type IssuedRequest struct {
+	Reference    string // rail-specific id: draft order id, invoice number, ...
+	CheckoutURL  string // empty when the client pays outside a hosted checkout
+	Instructions string // shown to the client when there is no checkout link
+}
+
+// Confirmation is an inbound signal that a payment happened.
+type Confirmation struct {
+	Reference  string // the rail reference when the sender knows it
+	Note       string // free-form text carried by the payment (order note, bank transfer memo)
+	ExternalID string // id of the paying object, e.g. the Shopify order id
+	Amount     string
+	Attributes map[string]string // structured key/values carried by the payment (order note_attributes)
+}
+
+// Resolution says which payment request a confirmation settles.
+// MilestoneID is set when the rail can tell directly; otherwise callers resolve Reference.
+type Resolution struct {
+	MilestoneID string
+	ServiceID   string
+	Reference   string
+	Source      string // what the match was based on, e.g. "signed_attributes" or "note"
+}
+
+// ErrUnresolved means a confirmation does not belong to any request issued by the rail.
+var ErrUnresolved = errors.New("payment confirmation does not match a payment request")
+
+// Rails holds the available rails by name.
+type Rails map[string]PaymentRail
+
+// NewRails returns the rails this deployment offers. The fake rail is only available outside prod.
+func NewRails(cfg config.Config) Rails {
+	rails := Rails{}
+	for _, r := range []PaymentRail{DraftOrderRail{Cfg: cfg}, ManualInvoiceRail{}} {
+		rails[r.Name()] = r
+	}
+	if cfg.AppEnv != "prod" {
+		rails[serviceproduct.PaymentRailFake] = NewFakeRail()
+	}
+	return rails
+}
+
+// Select picks the rail for a service: its product config wins, then the shop setting, then draft orders.
+func (rs Rails) Select(productRail, shopRail string) (PaymentRail, error) {
+	name := serviceproduct.PaymentRailDraftOrder
+	switch {
+	case productRail != "":
+		name = productRail
+	case shopRail != "":
+		name = shopRail
+	}
+	r, ok := rs[name]
+	if !ok {
+		return nil, fmt.Errorf("payment rail %q is not available", name)
+	}
+	return r, nil
+}
+
+// DraftOrderRail collects payments through Shopify draft order invoices; orders/paid confirms them.
+type DraftOrderRail struct {
+	Cfg config.Config
+}
+
+func (DraftOrderRail) Name() string { return serviceproduct.PaymentRailDraftOrder }
+
+func (d DraftOrderRail) CreatePaymentRequest(ctx context.Context, s *shop.Shop, req Request) (*IssuedRequest, error) {
+	if req.Currency == "" {
+		return nil, fmt.Errorf("payment request for milestone %s has no currency", req.MilestoneID)
+	}
+	attrs := MilestoneAttributes(d.Cfg.ReconciliationSecret, s.ID, req.MilestoneID, req.ServiceID)
+	id, url, err := d.client(s).CreateDraftOrder(ctx, req.Title, req.Amount, req.Currency, milestoneNote(req), attrs)
+	if err != nil {
+		return nil, err
+	}
+	return &IssuedRequest{Reference: id, CheckoutURL: url}, nil
+}
+
+func (d DraftOrderRail) CancelPaymentRequest(ctx context.Context, s *shop.Shop, reference string) error {
+	return d.client(s).DeleteDraftOrder(ctx, reference)
+}
+
+// CheckPaymentRequest reports whether the draft order behind a milestone link still exists and still asks
+// for the milestone amount.
+func (d DraftOrderRail) CheckPaymentRequest(ctx context.Context, s *shop.Shop, reference, amount string) (*RequestCheck, error) {
+	st, err := d.client(s).GetDraftOrder(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		return &RequestCheck{Stale: true, Reason: "deleted"}, nil
+	}
+	asked := draftAmount(st)
+	switch {
+	case strings.EqualFold(st.Status, "COMPLETED"):
+		return &RequestCheck{Completed: true, Amount: asked}, nil
+	case !SameAmount(asked, amount):
+		return &RequestCheck{Stale: true, Reason: "amount_changed", Amount: asked}, nil
+	case st.InvoiceURL == "":
+		return &RequestCheck{Stale: true, Reason: "missing_link", Amount: asked}, nil
+	}
+	return &RequestCheck{Amount: asked}, nil
+}
+
+// draftAmount is what a draft order asks for before tax and shipping, which Shopify may add on top of the
+// milestone amount; the total is only used when the subtotal is missing.
+func draftAmount(st *shopify.DraftOrderState) string {
+	if st.SubtotalPrice != "" {
+		return st.SubtotalPrice
+	}
+	return st.TotalPrice
+}
+
+// ResolveConfirmation matches a paid order to its milestone using the signed custom attributes the draft
+// order carried. Orders without them fall back to the note, which customers and staff can edit, so callers
+// must only accept a note match for a draft issued before signing (see milestone.IssuedUnsignedDraft);
+// orders whose attributes do not verify are rejected outright.
+func (d DraftOrderRail) ResolveConfirmation(_ context.Context, s *shop.Shop, c Confirmation) (*Resolution, error) {
+	milestoneID, serviceID, signed, err := VerifyMilestoneAttributes(d.Cfg.ReconciliationSecret, s.ID, c.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	if signed {
+		return &Resolution{MilestoneID: milestoneID, ServiceID: serviceID, Reference: c.Reference, Source: "signed_attributes"}, nil
+	}
+	if id := ParseKeyFromNote(c.Note, "milestone_id"); id != "" {
+		return &Resolution{MilestoneID: id, ServiceID: ParseKeyFromNote(c.Note, "service_id"), Reference: c.Reference, Source: "note"}, nil
+	}
+	if c.Reference != "" {
+		return &Resolution{Reference: c.Reference, Source: "reference"}, nil
+	}
+	return nil, ErrUnresolved
+}
+
+func (d DraftOrderRail) client(s *shop.Shop) shopify.Client {
+	return shop.AdminClient(d.Cfg, s)
+}
+
+// milestoneNote is used later to resolve paid orders back to a milestone via the orders/paid webhook.
+func milestoneNote(req Request) string {
+	return fmt.Sprintf("service_workflow: milestone_id=%s service_id=%s", req.MilestoneID, req.ServiceID)
+}
+
+// ManualInvoiceRail issues an invoice number for bank transfer or an external processor.
+// Nothing is sent anywhere; staff reconcile incoming money against the invoice number.
+type ManualInvoiceRail struct{}
+
+func (ManualInvoiceRail) Name() string { return serviceproduct.PaymentRailManualInvoice }
+
+func (ManualInvoiceRail) CreatePaymentRequest(_ context.Context, _ *shop.Shop, req Request) (*IssuedRequest, error) {
+	ref := InvoiceNumber(req.MilestoneID)
+	return &IssuedRequest{
+		Reference:    ref,
+		Instructions: fmt.Sprintf("Please pay %s %s and quote reference %s.", req.Amount, req.Currency, ref),
+	}, nil
+}
+
+// CancelPaymentRequest has nothing to withdraw; the invoice number simply stops matching once cleared.
+func (ManualInvoiceRail) CancelPaymentRequest(context.Context, *shop.Shop, string) error { return nil }
+
+// ResolveConfirmation accepts the invoice number either as the reference or quoted in the transfer memo.
+func (ManualInvoiceRail) ResolveConfirmation(_ context.Context, _ *shop.Shop, c Confirmation) (*Resolution, error) {
+	ref := strings.ToUpper(strings.TrimSpace(c.Reference))
+	if ref == "" {
+		ref = findInvoiceNumber(c.Note)
+	}
+	if !strings.HasPrefix(ref, invoicePrefix) {
+		return nil, ErrUnresolved
+	}
+	return &Resolution{Reference: ref}, nil
+}
+
+// SameAmount compares two decimal strings numerically ("100" equals "100.00"). Unparseable amounts never match.
+func SameAmount(a, b string) bool {
+	x, err := decimal.NewFromString(strings.TrimSpace(a))
+	if err != nil {
+		return false
+	}
+	y, err := decimal.NewFromString(strings.TrimSpace(b))
+	if err != nil {
+		return false
+	}
+	return x.Equal(y)
+}
+
+const invoicePrefix = "INV-"
+
+// InvoiceNumber derives a stable, human-quotable reference from the milestone id.
+func InvoiceNumber(milestoneID string) string {
+	compact := strings.ToUpper(strings.ReplaceAll(milestoneID, "-", ""))
+	if len(compact) > 12 {
+		compact = compact[:12]
+	}
+	return invoicePrefix + compact
+}
+
+func findInvoiceNumber(memo string) string {
+	for _, f := range strings.FieldsFunc(strings.ToUpper(memo), func(r rune) bool {
+		return !(r == '-' || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'))
+	}) {
+		if strings.HasPrefix(f, invoicePrefix) && len(f) > len(invoicePrefix) {
+			return f
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"microservice/internal/serviceproduct"
+	"microservice/internal/shop"
+	"microservice/pkg/config"
+)
+
+func TestRailsSelect_Precedence(t *testing.T) {
+	rails := NewRails(config.Config{AppEnv: "dev"})
+
+	cases := []struct {
+		product, shop, want string
+	}{
+		{"", "", serviceproduct.PaymentRailDraftOrder},
+		{"", serviceproduct.PaymentRailManualInvoice, serviceproduct.PaymentRailManualInvoice},
+		{serviceproduct.PaymentRailFake, serviceproduct.PaymentRailManualInvoice, serviceproduct.PaymentRailFake},
+	}
+	for _, c := range cases {
+		r, err := rails.Select(c.product, c.shop)
+		if err != nil {
+			t.Fatalf("select(%q,%q): %v", c.product, c.shop, err)
+		}
+		if r.Name() != c.want {
+			t.Fatalf("select(%q,%q) = %s, want %s", c.product, c.shop, r.Name(), c.want)
+		}
+	}
+}
+
+func TestRailsSelect_FakeUnavailableInProd(t *testing.T) {
+	if _, err := NewRails(config.Config{AppEnv: "prod"}).Select(serviceproduct.PaymentRailFake, ""); err == nil {
+		t.Fatalf("expected fake rail to be unavailable in prod")
+	}
+}
+
+func TestManualInvoiceRail_ResolvesReferenceFromMemo(t *testing.T) {
+	ctx := context.Background()
+	rail := ManualInvoiceRail{}
+	issued, err := rail.CreatePaymentRequest(ctx, nil, Request{MilestoneID: "3f2a9c1e-0b7d-4e52-9a61-1c2d3e4f5a6b", Amount: "250.00", Currency: "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued.Reference != "INV-3F2A9C1E0B7D" {
+		t.Fatalf("unexpected reference %q", issued.Reference)
+	}
+
+	res, err := rail.ResolveConfirmation(ctx, nil, Confirmation{Note: "SEPA transfer, ref: inv-3f2a9c1e0b7d thanks"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reference != issued.Reference {
+		t.Fatalf("resolved %q, want %q", res.Reference, issued.Reference)
+	}
+
+	if _, err := rail.ResolveConfirmation(ctx, nil, Confirmation{Note: "no reference here"}); !errors.Is(err, ErrUnresolved) {
+		t.Fatalf("expected ErrUnresolved, got %v", err)
+	}
+}
+
+func TestFakeRail_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	rail := NewFakeRail()
+	issued, err := rail.CreatePaymentRequest(ctx, nil, Request{MilestoneID: "m-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := rail.ResolveConfirmation(ctx, nil, Confirmation{Reference: issued.Reference})
+	if err != nil || res.MilestoneID != "m-1" {
+		t.Fatalf("resolve: %+v %v", res, err)
+	}
+
+	if err := rail.CancelPaymentRequest(ctx, nil, issued.Reference); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rail.ResolveConfirmation(ctx, nil, Confirmation{Reference: issued.Reference}); !errors.Is(err, ErrUnresolved) {
+		t.Fatalf("cancelled request should not resolve, got %v", err)
+	}
+}
+
+func TestDraftOrderRail_ResolvesMilestoneFromNote(t *testing.T) {
+	rail := DraftOrderRail{}
+	note := milestoneNote(Request{MilestoneID: "abc-123", ServiceID: "def-456"})
+	res, err := rail.ResolveConfirmation(context.Background(), &shop.Shop{ID: "shop-1"}, Confirmation{Note: note})
+	if err != nil || res.MilestoneID != "abc-123" {
+		t.Fatalf("resolve: %+v %v", res, err)
+	}
+}
+
+func TestSameAmount(t *testing.T) {
+	if !SameAmount("100", "100.00") {
+		t.Fatalf("100 and 100.00 should match")
+	}
+	if SameAmount("99.99", "100.00") {
+		t.Fatalf("99.99 and 100.00 should not match")
+	}
+	if SameAmount("", "0") {
+		t.Fatalf("empty amount should never match")
+	}
+}
@@ -0,0 +1,191 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"microservice/internal/api"
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/internal/serviceproduct"
+	"microservice/internal/shop"
+	"microservice/pkg/currency"
+)
+
+var (
+	ErrMilestonePaid              = errors.New("milestone already paid")
+	ErrMilestoneLocked            = errors.New("milestone is locked")
+	ErrFinalMilestoneLocked       = errors.New("final payment requires approval")
+	ErrPaymentPendingConfirmation = errors.New("the client already completed this payment; waiting for confirmation")
+	ErrRailUnavailable            = errors.New("payment rail is not available")
+)
+
+// awaitsFinalApproval reports whether a milestone is the final one of a fixed-split service, which is only
+// billed once the client approves. A recurring service's latest cycle is just the last one generated so far.
+func awaitsFinalApproval(sequence, finalSequence int, recurring bool) bool {
+	return !recurring && sequence == finalSequence
+}
+
+// isRecurring reports whether the service bills per cycle (a retainer), active or not.
+func isRecurring(ctx context.Context, tx pgx.Tx, serviceID string) (bool, error) {
+	const q = `SELECT EXISTS (SELECT 1 FROM service_recurrences WHERE service_id = $1)`
+	var ok bool
+	err := tx.QueryRow(ctx, q, serviceID).Scan(&ok)
+	return ok, err
+}
+
+// Requests issues milestone payment requests. The merchant admin and the client portal share it so both
+// apply the same locking, approval and idempotency rules.
+type Requests struct {
+	Rails Rails
+}
+
+// Issue returns the milestone's outstanding payment request, or issues one through the service's rail.
+// m must be locked by the caller. Only newly issued requests are audited.
+func (q Requests) Issue(ctx context.Context, tx pgx.Tx, s *shop.Shop, m *milestone.Record, actor string) (map[string]any, error) {
+	if milestone.IsSettled(m.Status) {
+		return nil, ErrMilestonePaid
+	}
+	if m.Status == milestone.StatusLocked {
+		return nil, ErrMilestoneLocked
+	}
+
+	// Idempotency: if a payment request is already outstanding, return it. Rails whose requests can change
+	// outside the app (deleted or edited draft orders) are re-checked first and stale links are regenerated.
+	var stale *RequestCheck
+	var staleRail, staleRef string
+	if m.PaymentReference != "" || (m.DraftOrderID != "" && m.CheckoutURL != "") {
+		staleRail, staleRef = outstandingRequest(m)
+
+		checker, ok := q.Rails[staleRail].(RequestChecker)
+		if !ok {
+			return paymentRequestResponse(m), nil
+		}
+		check, err := checker.CheckPaymentRequest(ctx, s, staleRef, currency.Format(m.Outstanding(), m.Currency))
+		if err != nil {
+			return nil, err
+		}
+		if check.Completed {
+			return nil, ErrPaymentPendingConfirmation
+		}
+		if !check.Stale {
+			return paymentRequestResponse(m), nil
+		}
+
+		// Withdraw what is left of the old request; a deleted draft has nothing left to withdraw.
+		if check.Reason != "deleted" {
+			if err := q.Rails[staleRail].CancelPaymentRequest(ctx, s, staleRef); err != nil {
+				log.Printf("request payment: cancel stale %s request %s failed: %v", staleRail, staleRef, err)
+			}
+		}
+		if err := milestone.ClearPaymentRequest(ctx, tx, m.ID); err != nil {
+			return nil, err
+		}
+		m.DraftOrderID, m.CheckoutURL = "", ""
+		stale = check
+	}
+
+	// Block final milestone until approval (strict).
+	recurring, err := isRecurring(ctx, tx, m.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	const qFinalSeq = `SELECT sequence FROM milestones WHERE service_id = $1 ORDER BY sequence DESC LIMIT 1`
+	var finalSeq int
+	if err := tx.QueryRow(ctx, qFinalSeq, m.ServiceID).Scan(&finalSeq); err == nil && awaitsFinalApproval(m.Sequence, finalSeq, recurring || m.PeriodStart != nil) {
+		const qAppr = `SELECT approved FROM approvals WHERE service_id = $1`
+		var approved bool
+		if err := tx.QueryRow(ctx, qAppr, m.ServiceID).Scan(&approved); err != nil || !approved {
+			return nil, ErrFinalMilestoneLocked
+		}
+	}
+
+	const qRail = `SELECT COALESCE(service_config_snapshot->>'paymentRail',''), display_id FROM services WHERE id = $1`
+	var productRail, displayID string
+	if err := tx.QueryRow(ctx, qRail, m.ServiceID).Scan(&productRail, &displayID); err != nil {
+		return nil, err
+	}
+	// The service's config snapshot wins over the shop setting.
+	rail, err := q.Rails.Select(productRail, s.PaymentRail)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRailUnavailable, err)
+	}
+
+	issued, err := rail.CreatePaymentRequest(ctx, s, Request{
+		MilestoneID: m.ID,
+		ServiceID:   m.ServiceID,
+		Sequence:    m.Sequence,
+		Title:       LineTitle(m.DisplayTitle(), displayID),
+		Amount:      currency.Format(m.Outstanding(), m.Currency), // a partially paid milestone asks for the remainder
+		Currency:    m.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := milestone.SetPaymentRequest(ctx, tx, m.ID, rail.Name(), issued.Reference, issued.CheckoutURL, issued.Instructions); err != nil {
+		return nil, err
+	}
+	m.PaymentRail, m.PaymentReference, m.CheckoutURL, m.PaymentInstructions = rail.Name(), issued.Reference, issued.CheckoutURL, issued.Instructions
+	if rail.Name() == serviceproduct.PaymentRailDraftOrder {
+		m.DraftOrderID = issued.Reference
+	}
+
+	now := time.Now()
+	data := map[string]any{"milestoneId": m.ID, "paymentRail": rail.Name(), "reference": issued.Reference}
+	if m.DraftOrderID != "" {
+		data["draftOrderId"] = m.DraftOrderID
+	}
+	if err := audit.Insert(ctx, tx, s.ID, &m.ServiceID, "MILESTONE_PAYMENT_REQUESTED", actor, data); err != nil {
+		return nil, err
+	}
+	if err := events.Insert(ctx, tx, m.ServiceID, "MILESTONE_PAYMENT_REQUESTED", "Milestone payment requested", actor, now, data); err != nil {
+		return nil, err
+	}
+	if stale != nil {
+		regen := map[string]any{
+			"milestoneId": m.ID, "reason": stale.Reason, "previousPaymentRail": staleRail, "previousReference": staleRef,
+			"paymentRail": rail.Name(), "reference": issued.Reference,
+		}
+		if err := audit.Insert(ctx, tx, s.ID, &m.ServiceID, "MILESTONE_PAYMENT_LINK_REGENERATED", actor, regen); err != nil {
+			return nil, err
+		}
+		if err := events.Insert(ctx, tx, m.ServiceID, "MILESTONE_PAYMENT_LINK_REGENERATED", "Stale payment link replaced", actor, now, regen); err != nil {
+			return nil, err
+		}
+	}
+
+	return paymentRequestResponse(m), nil
+}
+
+// WriteRequestError answers the client-facing payment request errors and reports whether it did.
+func WriteRequestError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, ErrMilestonePaid):
+		api.WriteError(w, http.StatusConflict, "MILESTONE_ALREADY_PAID", err.Error())
+	case errors.Is(err, ErrMilestoneLocked):
+		api.WriteError(w, http.StatusConflict, "MILESTONE_LOCKED", err.Error())
+	case errors.Is(err, ErrFinalMilestoneLocked):
+		api.WriteError(w, http.StatusConflict, "FINAL_MILESTONE_LOCKED", err.Error())
+	case errors.Is(err, ErrPaymentPendingConfirmation):
+		api.WriteError(w, http.StatusConflict, "PAYMENT_PENDING_CONFIRMATION", err.Error())
+	case errors.Is(err, ErrRailUnavailable):
+		api.WriteError(w, http.StatusConflict, "PAYMENT_RAIL_UNAVAILABLE", err.Error())
+	default:
+		return false
+	}
+	return true
+}
+
+// LineTitle is the line item title of a payment request: what the milestone is called, and which service it
+// belongs to.
+func LineTitle(milestoneTitle, serviceDisplayID string) string {
+	return fmt.Sprintf("%s (%s)", milestoneTitle, serviceDisplayID)
+}
@@ -0,0 +1,23 @@
+package payment
+
+import "testing"
+
+func TestAwaitsFinalApproval(t *testing.T) {
+	cases := []struct {
+		name                    string
+		sequence, finalSequence int
+		recurring               bool
+		want                    bool
+	}{
+		{"final milestone of a fixed split", 2, 2, false, true},
+		{"earlier milestone of a fixed split", 1, 2, false, false},
+		// Cycle 1 of a retainer is the latest milestone until the next cycle is generated.
+		{"retainer cycle 1", 1, 1, true, false},
+		{"latest retainer cycle", 5, 5, true, false},
+	}
+	for _, c := range cases {
+		if got := awaitsFinalApproval(c.sequence, c.finalSequence, c.recurring); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"microservice/pkg/shopify"
+)
+
+// Custom attributes attached to payment draft orders. Shopify copies them into the paid order's
+// note_attributes; the leading underscore keeps them out of the customer's view.
+const (
+	AttrMilestoneID = "_sw_milestone_id"
+	AttrBundleID    = "_sw_bundle_id"
+	AttrServiceID   = "_sw_service_id"
+	AttrSignature   = "_sw_sig"
+)
+
+// signatureVersion prefixes every signature so the signed content can change later.
+const signatureVersion = "v1"
+
+// ErrBadSignature means an order carried reconciliation attributes that do not verify.
+// Such orders are never matched, not even through the note.
+var ErrBadSignature = errors.New("payment reconciliation signature does not verify")
+
+// SignMilestoneRef signs the ids of a milestone payment request for one shop.
+func SignMilestoneRef(secret, shopID, milestoneID, serviceID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{signatureVersion, strings.ToLower(shopID), strings.ToLower(milestoneID), strings.ToLower(serviceID)}, "|")))
+	return signatureVersion + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// MilestoneAttributes returns the signed custom attributes for a draft order.
+func MilestoneAttributes(secret, shopID, milestoneID, serviceID string) []shopify.Attribute {
+	return []shopify.Attribute{
+		{Key: AttrMilestoneID, Value: milestoneID},
+		{Key: AttrServiceID, Value: serviceID},
+		{Key: AttrSignature, Value: SignMilestoneRef(secret, shopID, milestoneID, serviceID)},
+	}
+}
+
+// VerifyMilestoneAttributes extracts the milestone and service ids from order attributes.
+// ok is false when the order carries none of them (orders from older drafts); err is ErrBadSignature when
+// they are present but incomplete or do not verify.
+func VerifyMilestoneAttributes(secret, shopID string, attrs map[string]string) (milestoneID, serviceID string, ok bool, err error) {
+	milestoneID, serviceID, sig := attrs[AttrMilestoneID], attrs[AttrServiceID], attrs[AttrSignature]
+	if milestoneID == "" && serviceID == "" && sig == "" {
+		return "", "", false, nil
+	}
+	if milestoneID == "" || serviceID == "" || sig == "" || secret == "" {
+		return "", "", true, ErrBadSignature
+	}
+	want := SignMilestoneRef(secret, shopID, milestoneID, serviceID)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return "", "", true, ErrBadSignature
+	}
+	return milestoneID, serviceID, true, nil
+}
+
+// SignBundleRef signs the ids of a payment bundle for one shop. The "bundle" label keeps a bundle signature
+// from ever verifying as a milestone signature with the same ids.
+func SignBundleRef(secret, shopID, bundleID, serviceID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{signatureVersion, "bundle", strings.ToLower(shopID), strings.ToLower(bundleID), strings.ToLower(serviceID)}, "|")))
+	return signatureVersion + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// BundleAttributes returns the signed custom attributes for a bundle draft order.
+func BundleAttributes(secret, shopID, bundleID, serviceID string) []shopify.Attribute {
+	return []shopify.Attribute{
+		{Key: AttrBundleID, Value: bundleID},
+		{Key: AttrServiceID, Value: serviceID},
+		{Key: AttrSignature, Value: SignBundleRef(secret, shopID, bundleID, serviceID)},
+	}
+}
+
+// VerifyBundleAttributes extracts the bundle and service ids from order attributes. ok is false when the order
+// carries no bundle id, in which case callers go on to the milestone attributes.
+func VerifyBundleAttributes(secret, shopID string, attrs map[string]string) (bundleID, serviceID string, ok bool, err error) {
+	bundleID, serviceID, sig := attrs[AttrBundleID], attrs[AttrServiceID], attrs[AttrSignature]
+	if bundleID == "" {
+		return "", "", false, nil
+	}
+	if serviceID == "" || sig == "" || secret == "" {
+		return "", "", true, ErrBadSignature
+	}
+	want := SignBundleRef(secret, shopID, bundleID, serviceID)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return "", "", true, ErrBadSignature
+	}
+	return bundleID, serviceID, true, nil
+}
@@ -0,0 +1,75 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"microservice/internal/shop"
+	"microservice/pkg/config"
+)
+
+func attrMap(secret, shopID, milestoneID, serviceID string) map[string]string {
+	out := map[string]string{}
+	for _, a := range MilestoneAttributes(secret, shopID, milestoneID, serviceID) {
+		out[a.Key] = a.Value
+	}
+	return out
+}
+
+func TestVerifyMilestoneAttributes(t *testing.T) {
+	attrs := attrMap("s3cret", "shop-1", "m-1", "svc-1")
+
+	m, svc, ok, err := VerifyMilestoneAttributes("s3cret", "shop-1", attrs)
+	if err != nil || !ok || m != "m-1" || svc != "svc-1" {
+		t.Fatalf("got %q %q %v %v", m, svc, ok, err)
+	}
+
+	// Signed for another shop.
+	if _, _, _, err := VerifyMilestoneAttributes("s3cret", "shop-2", attrs); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for other shop, got %v", err)
+	}
+
+	// Milestone id swapped after signing.
+	tampered := attrMap("s3cret", "shop-1", "m-1", "svc-1")
+	tampered[AttrMilestoneID] = "m-2"
+	if _, _, _, err := VerifyMilestoneAttributes("s3cret", "shop-1", tampered); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for tampered id, got %v", err)
+	}
+
+	// No attributes at all: legacy order.
+	if _, _, ok, err := VerifyMilestoneAttributes("s3cret", "shop-1", map[string]string{}); ok || err != nil {
+		t.Fatalf("expected unsigned legacy order, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestDraftOrderRail_PrefersSignedAttributesOverNote(t *testing.T) {
+	rail := DraftOrderRail{Cfg: config.Config{ReconciliationSecret: "s3cret"}}
+	s := &shop.Shop{ID: "shop-1"}
+	c := Confirmation{
+		Note:       "service_workflow: milestone_id=edited-by-customer service_id=svc-1",
+		Attributes: attrMap("s3cret", "shop-1", "m-1", "svc-1"),
+	}
+
+	res, err := rail.ResolveConfirmation(context.Background(), s, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MilestoneID != "m-1" || res.Source != "signed_attributes" {
+		t.Fatalf("unexpected resolution %+v", res)
+	}
+}
+
+func TestDraftOrderRail_BadSignatureDoesNotFallBackToNote(t *testing.T) {
+	rail := DraftOrderRail{Cfg: config.Config{ReconciliationSecret: "s3cret"}}
+	s := &shop.Shop{ID: "shop-1"}
+	attrs := attrMap("other-secret", "shop-1", "m-1", "svc-1")
+
+	_, err := rail.ResolveConfirmation(context.Background(), s, Confirmation{
+		Note:       "service_workflow: milestone_id=m-1 service_id=svc-1",
+		Attributes: attrs,
+	})
+	if !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature, got %v", err)
+	}
+}
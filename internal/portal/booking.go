@@ -0,0 +1,180 @@
+package portal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"microservice/internal/api"
+	"microservice/internal/booking"
+	"microservice/internal/ical"
+	"microservice/internal/service"
+	"microservice/pkg/db"
+)
+
+// Slots lists the slots the client can book now. Local times are in the shop's timezone unless the client
+// asks for its own with ?timezone=.
+func (h Handlers) Slots(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing token")
+		return
+	}
+
+	now := time.Now()
+	const qTok = `
+SELECT s.shop_id
+FROM portal_tokens t
+JOIN services s ON s.id = t.service_id
+WHERE t.token = $1 AND t.revoked_at IS NULL AND t.expires_at > $2
+`
+	var shopID string
+	if err := h.DB.QueryRow(r.Context(), qTok, token, now).Scan(&shopID); err != nil {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "portal link not found")
+		return
+	}
+
+	var loc *time.Location
+	if tz := r.URL.Query().Get("timezone"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil || tz == "Local" {
+			api.WriteError(w, http.StatusBadRequest, "TIMEZONE_INVALID", "timezone must be an IANA timezone such as Europe/Berlin")
+			return
+		}
+	}
+
+	slots, settings, err := h.Bookings.AvailableSlots(r.Context(), shopID, now)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	timezone := settings.Timezone
+	if loc != nil {
+		booking.InTimezone(slots, loc)
+		timezone = loc.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"timezone":     timezone,
+		"shopTimezone": settings.Timezone,
+		"rules": map[string]any{
+			"changeCutoffHours": settings.ChangeCutoffHours,
+			"maxReschedules":    settings.MaxReschedules,
+		},
+		"items": slots,
+	})
+}
+
+// Book books a slot once the deposit is paid.
+func (h Handlers) Book(w http.ResponseWriter, r *http.Request) {
+	var req booking.SlotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Start.IsZero() {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "start is required")
+		return
+	}
+	h.bookingAction(w, r, func(tx pgx.Tx, svc *service.Service, now time.Time) (*booking.Booking, error) {
+		return booking.Book(r.Context(), tx, svc, req.Start, "client", now)
+	})
+}
+
+// RescheduleBooking moves the booking to another slot, up to the change cutoff and reschedule limit.
+func (h Handlers) RescheduleBooking(w http.ResponseWriter, r *http.Request) {
+	var req booking.SlotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Start.IsZero() {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "start is required")
+		return
+	}
+	h.bookingAction(w, r, func(tx pgx.Tx, svc *service.Service, now time.Time) (*booking.Booking, error) {
+		return booking.Reschedule(r.Context(), tx, svc, req.Start, "client", now)
+	})
+}
+
+// CancelBooking cancels the booking, up to the change cutoff.
+func (h Handlers) CancelBooking(w http.ResponseWriter, r *http.Request) {
+	var req booking.CancelRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	h.bookingAction(w, r, func(tx pgx.Tx, svc *service.Service, now time.Time) (*booking.Booking, error) {
+		return booking.Cancel(r.Context(), tx, svc, req.Reason, "client", now)
+	})
+}
+
+func (h Handlers) bookingAction(w http.ResponseWriter, r *http.Request, apply func(pgx.Tx, *service.Service, time.Time) (*booking.Booking, error)) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing token")
+		return
+	}
+
+	now := time.Now()
+	var b *booking.Booking
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		tr, err := GetActiveByTokenForUpdate(r.Context(), tx, token, now)
+		if err != nil {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "portal link not found")
+			return pgx.ErrTxCommitRollback
+		}
+		svc, err := service.GetForUpdateAny(r.Context(), tx, tr.ServiceID)
+		if err != nil {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+			return pgx.ErrTxCommitRollback
+		}
+		b, err = apply(tx, svc, now)
+		if booking.WriteError(w, err) {
+			return pgx.ErrTxCommitRollback
+		}
+		return err
+	})
+	if err == pgx.ErrTxCommitRollback {
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(b)
+}
+
+// BookingICS serves the booking as a calendar attachment. After a reschedule or cancellation the same event
+// is served with a higher sequence, so re-importing it updates or removes the client's calendar entry.
+func (h Handlers) BookingICS(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing token")
+		return
+	}
+
+	const qTok = `
+SELECT s.id, s.display_id, sh.shop_domain
+FROM portal_tokens t
+JOIN services s ON s.id = t.service_id
+JOIN shops sh ON sh.id = s.shop_id
+WHERE t.token = $1 AND t.revoked_at IS NULL AND t.expires_at > $2
+`
+	var serviceID, displayID, shopDomain string
+	if err := h.DB.QueryRow(r.Context(), qTok, token, time.Now()).Scan(&serviceID, &displayID, &shopDomain); err != nil {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "portal link not found")
+		return
+	}
+
+	b, err := h.Bookings.GetByService(r.Context(), serviceID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service has no booking")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	cal := booking.Calendar(b, "Appointment with "+shopDomain, "Service "+displayID)
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="booking-`+displayID+`.ics"`)
+	_, _ = w.Write(cal.Bytes())
+}
@@ -2,6 +2,7 @@ package portal
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -12,17 +13,25 @@ import (
 	"microservice/internal/api"
 	"microservice/internal/approval"
 	"microservice/internal/audit"
+	"microservice/internal/booking"
 	"microservice/internal/events"
 	"microservice/internal/milestone"
+	"microservice/internal/payment"
+	"microservice/internal/recurring"
 	"microservice/internal/service"
+	"microservice/internal/shop"
 	"microservice/pkg/db"
 	"microservice/pkg/config"
 )
 
 type Handlers struct {
-	DB         *pgxpool.Pool
-	Milestones *milestone.Repository
-	Cfg        config.Config
+	DB          *pgxpool.Pool
+	Milestones  *milestone.Repository
+	Cfg         config.Config
+	Bundles     payment.Bundles
+	Requests    payment.Requests
+	Recurrences *recurring.Repository
+	Bookings    *booking.Repository
 }
 
 func (h Handlers) View(w http.ResponseWriter, r *http.Request) {
@@ -38,7 +47,7 @@ func (h Handlers) View(w http.ResponseWriter, r *http.Request) {
 	const qSvc = `
 SELECT s.id, s.display_id, s.shop_id, sh.shop_domain, s.shopify_order_id, s.shopify_product_id,
        COALESCE(s.client_email,''), COALESCE(s.client_name,''),
-       s.total_amount::text, s.currency, s.status, s.service_config_snapshot, s.completed_via_override,
+       s.total_amount::text, s.currency, s.status, s.service_config_snapshot, s.completed_via_override, s.auto_charge,
        s.created_at, s.updated_at
 FROM portal_tokens t
 JOIN services s ON s.id = t.service_id
@@ -50,7 +59,7 @@ WHERE t.token = $1 AND t.revoked_at IS NULL AND t.expires_at > $2
 	if err := h.DB.QueryRow(r.Context(), qSvc, token, now).Scan(
 		&svc.ID, &svc.DisplayID, &svc.ShopID, &shopDomain, &svc.ShopifyOrderID, &svc.ShopifyProductID,
 		&svc.ClientEmail, &svc.ClientName,
-		&svc.TotalAmount, &svc.Currency, &svc.Status, &svc.ServiceConfigSnapshot, &svc.CompletedViaOverride,
+		&svc.TotalAmount, &svc.Currency, &svc.Status, &svc.ServiceConfigSnapshot, &svc.CompletedViaOverride, &svc.AutoCharge,
 		&svc.CreatedAt, &svc.UpdatedAt,
 	); err != nil {
 		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "portal link not found")
@@ -85,11 +94,34 @@ WHERE service_id = $1
 		}
 	}
 
+	// Recurring services also show the cycles still to come.
+	var recurrence any
+	var upcoming []recurring.Cycle
+	if rec, err := h.Recurrences.GetByService(r.Context(), svc.ShopID, svc.ID); err == nil {
+		recurrence = rec
+		upcoming = rec.Upcoming(recurring.UpcomingLimit)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	// The booked slot, or the last cancelled one so the client can book again.
+	var booked any
+	if b, err := h.Bookings.GetByService(r.Context(), svc.ID); err == nil {
+		booked = b
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]any{
-		"service":    svc,
-		"milestones": ms,
-		"approval":   appr,
+		"service":        svc,
+		"milestones":     ms,
+		"approval":       appr,
+		"recurrence":     recurrence,
+		"upcomingCycles": upcoming,
+		"booking":        booked,
 		"merchant": map[string]any{
 			"name":         shopDomain,
 			"supportEmail": h.Cfg.PortalSupportEmail,
@@ -178,13 +210,27 @@ func (h Handlers) clientAction(w http.ResponseWriter, r *http.Request, approve b
 			if err := approval.Approve(r.Context(), tx, svc.ID, req.Note); err != nil {
 				return err
 			}
-			// Unlock final milestone (locked -> unpaid).
-			if err := milestone.UnlockFinal(r.Context(), tx, svc.ID); err != nil {
+			// Unlock final milestone (locked -> unpaid, or further along when credit already reached it).
+			finalStatus, err := milestone.UnlockFinal(r.Context(), tx, svc.ID)
+			if err != nil {
 				return err
 			}
 
-			_ = audit.Insert(r.Context(), tx, svc.ShopID, &svcID, "APPROVED", actor, map[string]any{"note": req.Note})
-			_ = events.Insert(r.Context(), tx, svc.ID, "APPROVED", "Client approved", actor, now, map[string]any{})
+			if err := audit.Insert(r.Context(), tx, svc.ShopID, &svcID, "APPROVED", actor, map[string]any{"note": req.Note}); err != nil {
+				return err
+			}
+			if err := events.Insert(r.Context(), tx, svc.ID, "APPROVED", "Client approved", actor, now, map[string]any{}); err != nil {
+				return err
+			}
+			// Credit carried forward may already cover the final milestone; approval then completes the service.
+			if milestone.IsSettled(finalStatus) {
+				if err := events.Insert(r.Context(), tx, svc.ID, "MILESTONE_PAID", "Final milestone paid from credit", actor, now, map[string]any{"status": finalStatus}); err != nil {
+					return err
+				}
+				if err := service.CompleteIfApproved(r.Context(), tx, svc.ShopID, svc.ID, actor); err != nil {
+					return err
+				}
+			}
 		} else {
 			if err := approval.RequestRevision(r.Context(), tx, svc.ID, req.Note); err != nil {
 				return err
@@ -194,8 +240,14 @@ func (h Handlers) clientAction(w http.ResponseWriter, r *http.Request, approve b
 				return err
 			}
 
-			_ = audit.Insert(r.Context(), tx, svc.ShopID, &svcID, "REVISION_REQUESTED", actor, map[string]any{"note": req.Note})
-			_ = events.Insert(r.Context(), tx, svc.ID, "REVISION_REQUESTED", "Client requested revision", actor, now, map[string]any{})
+			meta := audit.Diff(map[string]any{"status": svc.Status}, map[string]any{"status": service.StatusInProgress})
+			meta["note"] = req.Note
+			if err := audit.Insert(r.Context(), tx, svc.ShopID, &svcID, "REVISION_REQUESTED", actor, meta); err != nil {
+				return err
+			}
+			if err := events.Insert(r.Context(), tx, svc.ID, "REVISION_REQUESTED", "Client requested revision", actor, now, map[string]any{}); err != nil {
+				return err
+			}
 		}
 
 		_ = tr // kept locked to prevent double-action races
@@ -213,3 +265,103 @@ func (h Handlers) clientAction(w http.ResponseWriter, r *http.Request, approve b
 }
 
 
+
+// PayRemainingBalance lets the client pay every milestone that can be paid now through one checkout.
+func (h Handlers) PayRemainingBalance(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing token")
+		return
+	}
+
+	var bundle *payment.Bundle
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		tr, err := GetActiveByTokenForUpdate(r.Context(), tx, token, time.Now())
+		if err != nil {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "portal link not found")
+			return pgx.ErrTxCommitRollback
+		}
+		svc, err := service.GetForUpdateAny(r.Context(), tx, tr.ServiceID)
+		if err != nil {
+			return err
+		}
+		shopRec, err := shop.GetByID(r.Context(), tx, svc.ShopID)
+		if err != nil {
+			return err
+		}
+
+		bundle, err = h.Bundles.Issue(r.Context(), tx, shopRec, svc.ID, "client")
+		if payment.WriteBundleError(w, err) {
+			return pgx.ErrTxCommitRollback
+		}
+		return err
+	})
+	if err == pgx.ErrTxCommitRollback {
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(bundle)
+}
+
+// PayMilestone lets the client start paying a milestone without waiting for the merchant to request it.
+// It follows the same rules as the admin request-payment endpoint and returns the same payment request.
+func (h Handlers) PayMilestone(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	milestoneID := chi.URLParam(r, "id")
+	if token == "" || milestoneID == "" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing token or milestone id")
+		return
+	}
+
+	var resp map[string]any
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		tr, err := GetActiveByTokenForUpdate(r.Context(), tx, token, time.Now())
+		if err != nil {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "portal link not found")
+			return pgx.ErrTxCommitRollback
+		}
+		svc, err := service.GetForUpdateAny(r.Context(), tx, tr.ServiceID)
+		if err != nil {
+			return err
+		}
+		// Shop-scoped lookup, then make sure the milestone belongs to this portal's service.
+		m, err := milestone.GetForUpdateScoped(r.Context(), tx, svc.ShopID, milestoneID)
+		if err != nil || m.ServiceID != svc.ID {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "milestone not found")
+			return pgx.ErrTxCommitRollback
+		}
+		shopRec, err := shop.GetByID(r.Context(), tx, svc.ShopID)
+		if err != nil {
+			return err
+		}
+
+		actor := "client"
+		resp, err = h.Requests.Issue(r.Context(), tx, shopRec, m, actor)
+		if payment.WriteRequestError(w, err) {
+			return pgx.ErrTxCommitRollback
+		}
+		if err != nil {
+			return err
+		}
+
+		svcID := svc.ID
+		return audit.Insert(r.Context(), tx, svc.ShopID, &svcID, "CLIENT_PAYMENT_STARTED", actor, map[string]any{
+			"milestoneId": m.ID, "paymentRail": resp["paymentRail"], "reference": resp["reference"],
+		})
+	})
+	if err == pgx.ErrTxCommitRollback {
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,108 @@
+package recurring
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/pkg/db"
+)
+
+// Generator adds the milestone of every cycle that has started. It is run by the scheduler; several
+// instances may run it at once. A recurrence that missed runs (downtime) catches up on all started cycles.
+type Generator struct {
+	DB    *pgxpool.Pool
+	Batch int // recurrences per run; 0 means 100
+}
+
+// Run generates the cycles that are due now.
+func (g Generator) Run(ctx context.Context) error {
+	batch := g.Batch
+	if batch <= 0 {
+		batch = 100
+	}
+	now := time.Now()
+
+	return db.WithTx(ctx, g.DB, func(tx pgx.Tx) error {
+		const q = selectRecurrence + `
+JOIN services s ON s.id = r.service_id
+WHERE r.status = 'active' AND r.next_cycle_at <= $1 AND s.status <> 'Completed'
+ORDER BY r.next_cycle_at ASC
+LIMIT $2
+FOR UPDATE OF r SKIP LOCKED
+`
+		rows, err := tx.Query(ctx, q, now, batch)
+		if err != nil {
+			return err
+		}
+		var due []*Recurrence
+		for rows.Next() {
+			r, err := scanRecurrence(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			due = append(due, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, r := range due {
+			if err := generate(ctx, tx, r, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func generate(ctx context.Context, tx pgx.Tx, r *Recurrence, now time.Time) error {
+	t := r.Template()
+	n := r.CyclesGenerated
+	for ; !t.CycleStart(r.AnchorAt, n).After(now); n++ {
+		if t.Finite() && n >= t.Cycles {
+			break
+		}
+		c := r.CycleAt(n)
+		const qInsert = `
+INSERT INTO milestones (service_id, sequence, amount, status, due_at, period_start, period_end)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (service_id, sequence) DO NOTHING
+`
+		if _, err := tx.Exec(ctx, qInsert, r.ServiceID, c.Cycle, c.Amount, milestone.StatusUnpaid, c.DueAt, c.Start, c.End); err != nil {
+			return err
+		}
+		data := map[string]any{"cycle": c.Cycle, "periodStart": c.Start, "periodEnd": c.End, "amount": c.Amount}
+		if err := events.Insert(ctx, tx, r.ServiceID, "RECURRING_CYCLE_STARTED", "Billing cycle started", "system", now, data); err != nil {
+			return err
+		}
+	}
+
+	status := StatusActive
+	var next *time.Time
+	if t.Finite() && n >= t.Cycles {
+		status = StatusEnded
+	} else {
+		at := t.CycleStart(r.AnchorAt, n)
+		next = &at
+	}
+
+	const qUpdate = `
+UPDATE service_recurrences
+SET cycles_generated = $2, next_cycle_at = $3, status = $4, updated_at = NOW()
+WHERE service_id = $1
+`
+	if _, err := tx.Exec(ctx, qUpdate, r.ServiceID, n, next, status); err != nil {
+		return err
+	}
+	if status == StatusEnded {
+		return events.Insert(ctx, tx, r.ServiceID, "RECURRING_ENDED", "Last billing cycle started", "system", now, map[string]any{"cycles": n})
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package recurring
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"microservice/internal/api"
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/internal/payment"
+	"microservice/internal/shop"
+	"microservice/pkg/db"
+)
+
+// UpcomingLimit is how many not-yet-started cycles views list.
+const UpcomingLimit = 3
+
+var ErrNotActive = errors.New("recurrence is not active")
+
+type Handlers struct {
+	DB    *pgxpool.Pool
+	Repo  *Repository
+	Rails payment.Rails
+}
+
+// Get returns the service's recurrence and its upcoming cycles.
+func (h Handlers) Get(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	rec, err := h.Repo.GetByService(r.Context(), shopCtx.ID, chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service has no recurrence")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"recurrence": rec, "upcoming": rec.Upcoming(UpcomingLimit)})
+}
+
+// Cancellation is the outcome of cancelling a recurrence mid-cycle.
+type Cancellation struct {
+	Cycle          int    `json:"cycle"`
+	CycleAmount    string `json:"cycleAmount"`
+	ProratedAmount string `json:"proratedAmount"` // what the current cycle now costs
+	RefundDue      string `json:"refundDue"`      // paid beyond the prorated amount; refund it in Shopify
+}
+
+// Cancel stops a recurrence. No further cycles are generated, and the current cycle is prorated to the time
+// elapsed: an unpaid cycle is repriced (withdrawing its payment request), an overpaid one reports the refund due.
+func (h Handlers) Cancel(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var out *Cancellation
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var err error
+		out, err = Cancel(r.Context(), tx, shopCtx, chi.URLParam(r, "id"), h.Rails, api.Actor(r.Context()), time.Now())
+		if errors.Is(err, ErrNotActive) {
+			api.WriteError(w, http.StatusConflict, "RECURRENCE_NOT_ACTIVE", err.Error())
+			return pgx.ErrTxCommitRollback
+		}
+		return err
+	})
+
+	if err != nil {
+		if err == pgx.ErrTxCommitRollback {
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service has no recurrence")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// Cancel stops the service's recurrence at now and prorates the cycle in progress.
+func Cancel(ctx context.Context, tx pgx.Tx, s *shop.Shop, serviceID string, rails payment.Rails, actor string, now time.Time) (*Cancellation, error) {
+	rec, err := GetForUpdate(ctx, tx, s.ID, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Status != StatusActive {
+		return nil, ErrNotActive
+	}
+
+	current := rec.CyclesGenerated - 1
+	const qCurrent = `SELECT id FROM milestones WHERE service_id = $1 AND sequence = $2`
+	var milestoneID string
+	if err := tx.QueryRow(ctx, qCurrent, serviceID, current).Scan(&milestoneID); err != nil {
+		return nil, err
+	}
+	m, err := milestone.GetForUpdateScoped(ctx, tx, s.ID, milestoneID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := rec.CycleAt(current)
+	if m.PeriodStart != nil && m.PeriodEnd != nil {
+		c.Start, c.End = *m.PeriodStart, *m.PeriodEnd
+	}
+	amount, err := decimal.NewFromString(m.Amount)
+	if err != nil {
+		return nil, err
+	}
+	paid, err := decimal.NewFromString(m.AmountPaid)
+	if err != nil {
+		return nil, err
+	}
+	scale, err := milestone.ScaleFor(m.Currency)
+	if err != nil {
+		return nil, err
+	}
+	earned := milestone.Prorate(amount, c.Start, c.End, now, scale)
+
+	// The outstanding request asks for the full cycle; withdraw it before repricing.
+	if !milestone.IsSettled(m.Status) && m.PaymentReference != "" {
+		if rail, ok := rails[m.PaymentRail]; ok {
+			if err := rail.CancelPaymentRequest(ctx, s, m.PaymentReference); err != nil {
+				return nil, err
+			}
+		}
+		if err := milestone.ClearPaymentRequest(ctx, tx, m.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Money already received is never un-booked: the cycle costs at least what was paid, and anything paid
+	// beyond the prorated amount is reported as a refund for the merchant to issue.
+	newAmount := decimal.Max(earned, paid)
+	if !newAmount.Equal(amount) {
+		if _, err := milestone.Reprice(ctx, tx, m.ID, newAmount, now); err != nil {
+			return nil, err
+		}
+	}
+	refund := decimal.Zero
+	if paid.GreaterThan(earned) {
+		refund = paid.Sub(earned)
+	}
+
+	const qCancel = `
+UPDATE service_recurrences
+SET status = 'cancelled', cancelled_at = $2, next_cycle_at = NULL, updated_at = NOW()
+WHERE service_id = $1
+`
+	if _, err := tx.Exec(ctx, qCancel, serviceID, now); err != nil {
+		return nil, err
+	}
+
+	out := &Cancellation{
+		Cycle:          current,
+		CycleAmount:    scale.Format(amount),
+		ProratedAmount: scale.Format(earned),
+		RefundDue:      scale.Format(refund),
+	}
+	data := map[string]any{
+		"cycle":          out.Cycle,
+		"milestoneId":    m.ID,
+		"periodStart":    c.Start,
+		"periodEnd":      c.End,
+		"cycleAmount":    out.CycleAmount,
+		"proratedAmount": out.ProratedAmount,
+		"refundDue":      out.RefundDue,
+	}
+	if err := audit.Insert(ctx, tx, s.ID, &serviceID, "RECURRING_CANCELLED", actor, data); err != nil {
+		return nil, err
+	}
+	if err := events.Insert(ctx, tx, serviceID, "RECURRING_CANCELLED", "Recurring billing cancelled", actor, now, data); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
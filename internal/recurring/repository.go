@@ -0,0 +1,189 @@
+// Package recurring runs retainer services: one milestone per billing cycle, generated as each cycle starts,
+// until the recurrence is cancelled or has run its cycles.
+package recurring
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"microservice/internal/milestone"
+)
+
+const (
+	StatusActive    = "active"
+	StatusCancelled = "cancelled"
+	StatusEnded     = "ended"
+)
+
+type Recurrence struct {
+	ServiceID       string     `json:"serviceId"`
+	Interval        string     `json:"interval"`
+	IntervalCount   int        `json:"intervalCount"`
+	CycleAmount     string     `json:"cycleAmount"`
+	MaxCycles       *int       `json:"maxCycles,omitempty"`
+	DueInDays       int        `json:"dueInDays"`
+	AnchorAt        time.Time  `json:"anchorAt"`
+	CyclesGenerated int        `json:"cyclesGenerated"`
+	NextCycleAt     *time.Time `json:"nextCycleAt,omitempty"`
+	Status          string     `json:"status"`
+	CancelledAt     *time.Time `json:"cancelledAt,omitempty"`
+}
+
+// Template rebuilds the template the recurrence was created from.
+func (r Recurrence) Template() milestone.RecurringTemplate {
+	t := milestone.RecurringTemplate{
+		Interval:      milestone.Interval(r.Interval),
+		IntervalCount: r.IntervalCount,
+		DueInDays:     r.DueInDays,
+	}
+	if r.MaxCycles != nil {
+		t.Cycles = *r.MaxCycles
+	}
+	return t
+}
+
+// Cycle is one billing period of a recurrence.
+type Cycle struct {
+	Cycle  int        `json:"cycle"`
+	Start  time.Time  `json:"start"`
+	End    time.Time  `json:"end"`
+	Amount string     `json:"amount"`
+	DueAt  *time.Time `json:"dueAt,omitempty"`
+}
+
+// CycleAt describes cycle n.
+func (r Recurrence) CycleAt(n int) Cycle {
+	t := r.Template()
+	c := Cycle{Cycle: n, Start: t.CycleStart(r.AnchorAt, n), End: t.CycleStart(r.AnchorAt, n+1), Amount: r.CycleAmount}
+	if t.DueInDays > 0 {
+		due := c.Start.AddDate(0, 0, t.DueInDays)
+		c.DueAt = &due
+	}
+	return c
+}
+
+// Upcoming lists up to limit cycles that have not started yet. It is empty once the recurrence is no longer
+// active.
+func (r Recurrence) Upcoming(limit int) []Cycle {
+	if r.Status != StatusActive {
+		return nil
+	}
+	var out []Cycle
+	for n := r.CyclesGenerated; len(out) < limit; n++ {
+		if r.MaxCycles != nil && n >= *r.MaxCycles {
+			break
+		}
+		out = append(out, r.CycleAt(n))
+	}
+	return out
+}
+
+// Insert starts a recurrence for a newly created service. Cycle 0, anchored at anchor, is the one the order paid.
+func Insert(ctx context.Context, tx pgx.Tx, serviceID string, t milestone.RecurringTemplate, cycleAmount decimal.Decimal, scale milestone.CurrencyScale, anchor time.Time) (*Recurrence, error) {
+	rec := Recurrence{
+		ServiceID:       serviceID,
+		Interval:        string(t.Interval),
+		IntervalCount:   t.IntervalCount,
+		CycleAmount:     scale.Format(cycleAmount),
+		DueInDays:       t.DueInDays,
+		AnchorAt:        anchor,
+		CyclesGenerated: 1,
+		Status:          StatusActive,
+	}
+	if rec.IntervalCount <= 0 {
+		rec.IntervalCount = 1
+	}
+	if t.Finite() {
+		rec.MaxCycles = &t.Cycles
+	}
+	if t.Finite() && t.Cycles == 1 {
+		rec.Status = StatusEnded
+	} else {
+		next := t.CycleStart(anchor, 1)
+		rec.NextCycleAt = &next
+	}
+
+	const q = `
+INSERT INTO service_recurrences (service_id, interval, interval_count, cycle_amount, max_cycles, due_in_days,
+                                 anchor_at, cycles_generated, next_cycle_at, status)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+	if _, err := tx.Exec(ctx, q, rec.ServiceID, rec.Interval, rec.IntervalCount, rec.CycleAmount, rec.MaxCycles, rec.DueInDays,
+		rec.AnchorAt, rec.CyclesGenerated, rec.NextCycleAt, rec.Status); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+const selectRecurrence = `
+SELECT r.service_id, r.interval, r.interval_count, r.cycle_amount::text, r.max_cycles, r.due_in_days,
+       r.anchor_at, r.cycles_generated, r.next_cycle_at, r.status, r.cancelled_at
+FROM service_recurrences r
+`
+
+func scanRecurrence(row pgx.Row) (*Recurrence, error) {
+	var r Recurrence
+	if err := row.Scan(&r.ServiceID, &r.Interval, &r.IntervalCount, &r.CycleAmount, &r.MaxCycles, &r.DueInDays,
+		&r.AnchorAt, &r.CyclesGenerated, &r.NextCycleAt, &r.Status, &r.CancelledAt); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetForUpdate locks the recurrence of a service of the shop.
+func GetForUpdate(ctx context.Context, tx pgx.Tx, shopID, serviceID string) (*Recurrence, error) {
+	const q = selectRecurrence + `
+JOIN services s ON s.id = r.service_id
+WHERE s.shop_id = $1 AND r.service_id = $2
+FOR UPDATE OF r
+`
+	return scanRecurrence(tx.QueryRow(ctx, q, shopID, serviceID))
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// GetByService returns the recurrence of a service of the shop, or pgx.ErrNoRows for fixed-split services.
+func (r *Repository) GetByService(ctx context.Context, shopID, serviceID string) (*Recurrence, error) {
+	const q = selectRecurrence + `
+JOIN services s ON s.id = r.service_id
+WHERE s.shop_id = $1 AND r.service_id = $2
+`
+	return scanRecurrence(r.db.QueryRow(ctx, q, shopID, serviceID))
+}
+
+// ListByShop lists the shop's recurrences that still generate cycles, plus those cancelled since
+// cancelledSince.
+func (r *Repository) ListByShop(ctx context.Context, shopID string, cancelledSince time.Time) ([]Recurrence, error) {
+	const q = selectRecurrence + `
+JOIN services s ON s.id = r.service_id
+WHERE s.shop_id = $1
+  AND ((r.status = 'active' AND r.next_cycle_at IS NOT NULL AND s.status <> 'Completed')
+       OR (r.status = 'cancelled' AND r.cancelled_at >= $2))
+ORDER BY r.anchor_at ASC
+`
+	rows, err := r.db.Query(ctx, q, shopID, cancelledSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Recurrence
+	for rows.Next() {
+		rec, err := scanRecurrence(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *rec)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,72 @@
+// Package scheduler runs periodic background jobs inside the API process.
+//
+// Jobs must be safe to run on several instances at once: they claim their work with row locks
+// (FOR UPDATE SKIP LOCKED) rather than relying on being the only runner.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is one kind of periodic work. Run processes whatever is due and returns; an error is logged and the
+// job runs again at the next tick.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+type Scheduler struct {
+	jobs []Job
+	wg   sync.WaitGroup
+}
+
+func New(jobs ...Job) *Scheduler {
+	return &Scheduler{jobs: jobs}
+}
+
+// Start runs every job once right away and then at its interval until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, j := range s.jobs {
+		if j.Interval <= 0 || j.Run == nil {
+			log.Printf("scheduler: job %q disabled", j.Name)
+			continue
+		}
+		s.wg.Add(1)
+		go func(j Job) {
+			defer s.wg.Done()
+			t := time.NewTicker(j.Interval)
+			defer t.Stop()
+			for {
+				s.run(ctx, j)
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+				}
+			}
+		}(j)
+	}
+}
+
+// Wait blocks until every job has stopped after ctx was cancelled.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(ctx context.Context, j Job) {
+	if ctx.Err() != nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("scheduler: job %q panicked: %v", j.Name, r)
+		}
+	}()
+	if err := j.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("scheduler: job %q failed: %v", j.Name, err)
+	}
+}
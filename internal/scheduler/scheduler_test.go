@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler_RunsUntilCancelled(t *testing.T) {
+	var runs, failing atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := New(
+		Job{Name: "count", Interval: 5 * time.Millisecond, Run: func(context.Context) error {
+			runs.Add(1)
+			return nil
+		}},
+		Job{Name: "fail", Interval: 5 * time.Millisecond, Run: func(context.Context) error {
+			failing.Add(1)
+			return errors.New("boom")
+		}},
+		Job{Name: "disabled", Interval: 0, Run: func(context.Context) error {
+			t.Error("disabled job must not run")
+			return nil
+		}},
+	)
+	s.Start(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runs.Load() < 3 || failing.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs did not keep running: runs=%d failing=%d", runs.Load(), failing.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	s.Wait()
+	after := runs.Load()
+	time.Sleep(20 * time.Millisecond)
+	if runs.Load() != after {
+		t.Fatalf("job kept running after cancel")
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"microservice/internal/api"
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/pkg/db"
+)
+
+type SetDeliverableRequest struct {
+	Done *bool `json:"done"`
+}
+
+// SetDeliverable ticks a milestone's deliverable checklist item on or off.
+func (h Handlers) SetDeliverable(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var req SetDeliverableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Done == nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "done is required")
+		return
+	}
+
+	milestoneID := chi.URLParam(r, "id")
+	actor := api.Actor(r.Context())
+	now := time.Now()
+
+	var out *milestone.Deliverable
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		m, err := milestone.GetForUpdateScoped(r.Context(), tx, s.ID, milestoneID)
+		if err != nil {
+			return err
+		}
+		before, after, err := milestone.SetDeliverableDone(r.Context(), tx, s.ID, m.ID, chi.URLParam(r, "deliverableId"), *req.Done, actor, now)
+		if err != nil {
+			return err
+		}
+		out = after
+		if before.Done == after.Done {
+			return nil
+		}
+
+		action, summary := "MILESTONE_DELIVERABLE_DONE", "Deliverable completed"
+		if !after.Done {
+			action, summary = "MILESTONE_DELIVERABLE_REOPENED", "Deliverable reopened"
+		}
+		data := map[string]any{"milestoneId": m.ID, "deliverableId": after.ID, "label": after.Label}
+		if err := audit.Insert(r.Context(), tx, s.ID, &m.ServiceID, action, actor, data); err != nil {
+			return err
+		}
+		return events.Insert(r.Context(), tx, m.ServiceID, action, summary, actor, now, data)
+	})
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "deliverable not found")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -16,14 +17,17 @@ import (
 	"microservice/internal/audit"
 	"microservice/internal/events"
 	"microservice/internal/milestone"
+	"microservice/pkg/config"
 	"microservice/pkg/db"
 )
 
 type Handlers struct {
-	DB        *pgxpool.Pool
-	Services  *Repository
-	Milestones *milestone.Repository
-	Approvals *approval.Repository
+	Cfg          config.Config
+	DB           *pgxpool.Pool
+	Services     *Repository
+	Milestones   *milestone.Repository
+	Approvals    *approval.Repository
+	AdminActions *adminaction.Repository
 }
 
 func (h Handlers) List(w http.ResponseWriter, r *http.Request) {
@@ -93,12 +97,22 @@ LIMIT 1
 		}
 	}
 
+	pending, err := h.AdminActions.ListByService(r.Context(), svc.ID, true)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if pending == nil {
+		pending = []adminaction.Action{}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]any{
-		"service":    svc,
-		"milestones": ms,
-		"approval":   appr,
-		"portal":     portalToken,
+		"service":          svc,
+		"milestones":       ms,
+		"approval":         appr,
+		"portal":           portalToken,
+		"pendingOverrides": pending,
 	})
 }
 
@@ -152,7 +166,7 @@ LIMIT 1
 			if err := tx.QueryRow(r.Context(), qFinal, svc.ID).Scan(&st); err != nil {
 				return err
 			}
-			if st != "paid" {
+			if !milestone.IsSettled(st) {
 				api.WriteError(w, http.StatusConflict, "FINAL_MILESTONE_LOCKED", "final milestone is not paid")
 				return pgx.ErrTxCommitRollback
 			}
@@ -179,116 +193,30 @@ LIMIT 1
 			return err
 		}
 
-		actor := "merchant"
+		actor := api.Actor(r.Context())
 		svcID := svc.ID
-		_ = audit.Insert(r.Context(), tx, shopCtx.ID, &svcID, "STATUS_CHANGED", actor, map[string]any{"from": svc.Status, "to": next})
-		_ = events.Insert(r.Context(), tx, svc.ID, "STATUS_CHANGED", "Status changed", actor, time.Now(), map[string]any{"from": svc.Status, "to": next})
-
-		return nil
-	})
-
-	if err != nil {
-		// If we used pgx.ErrTxCommitRollback to early-return after writing response, ignore.
-		if err == pgx.ErrTxCommitRollback {
-			return
-		}
-		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
-		return
-	}
-
-	w.WriteHeader(http.StatusNoContent)
-}
-
-type AdminOverrideRequest struct {
-	ActionType  string `json:"actionType"`
-	Reason      string `json:"reason"`
-	MilestoneID string `json:"milestoneId,omitempty"`
-}
-
-func (h Handlers) AdminOverride(w http.ResponseWriter, r *http.Request) {
-	shopCtx := api.ShopFromContext(r.Context())
-	if shopCtx == nil {
-		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
-		return
-	}
-
-	id := chi.URLParam(r, "id")
-	if id == "" {
-		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing id")
-		return
-	}
-
-	var req AdminOverrideRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
-		return
-	}
-	if req.Reason == "" {
-		api.WriteError(w, http.StatusBadRequest, "OVERRIDE_REASON_REQUIRED", "reason is required")
-		return
-	}
-
-	action := adminaction.ActionType(req.ActionType)
-	switch action {
-	case adminaction.ActionMarkMilestonePaid, adminaction.ActionCompleteServiceWithoutFinalPay, adminaction.ActionReopenService:
-	default:
-		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid actionType")
-		return
-	}
-
-	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
-		svc, err := GetForUpdate(r.Context(), tx, shopCtx.ID, id)
-		if err != nil {
+		meta := audit.Diff(map[string]any{"status": svc.Status}, map[string]any{"status": next})
+		meta["from"], meta["to"] = svc.Status, next
+		if err := audit.Insert(r.Context(), tx, shopCtx.ID, &svcID, "STATUS_CHANGED", actor, meta); err != nil {
 			return err
 		}
-
-		now := time.Now()
-		actor := "merchant"
-		svcID := svc.ID
-
-		switch action {
-		case adminaction.ActionMarkMilestonePaid:
-			if req.MilestoneID == "" {
-				api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "milestoneId is required for MARK_MILESTONE_PAID")
-				return pgx.ErrTxCommitRollback
-			}
-			m, err := milestone.GetForUpdate(r.Context(), tx, req.MilestoneID)
-			if err != nil || m.ServiceID != svc.ID {
-				api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "milestone not found")
-				return pgx.ErrTxCommitRollback
-			}
-			if m.Status == "paid" {
-				api.WriteError(w, http.StatusConflict, "MILESTONE_ALREADY_PAID", "milestone already paid")
-				return pgx.ErrTxCommitRollback
-			}
-			if err := milestone.MarkPaid(r.Context(), tx, m.ID, now); err != nil {
-				return err
-			}
-
-		case adminaction.ActionCompleteServiceWithoutFinalPay:
-			if err := UpdateStatus(r.Context(), tx, shopCtx.ID, svc.ID, StatusCompleted, true); err != nil {
-				return err
-			}
-
-		case adminaction.ActionReopenService:
-			// Reopen means go back to InProgress and clear override flag.
-			if err := UpdateStatus(r.Context(), tx, shopCtx.ID, svc.ID, StatusInProgress, false); err != nil {
-				return err
-			}
+		if err := events.Insert(r.Context(), tx, svc.ID, "STATUS_CHANGED", "Status changed", actor, time.Now(), map[string]any{"from": svc.Status, "to": next}); err != nil {
+			return err
 		}
 
-		_ = adminaction.Insert(r.Context(), tx, svc.ID, action, req.Reason, actor, map[string]any{"milestoneId": req.MilestoneID})
-		_ = audit.Insert(r.Context(), tx, shopCtx.ID, &svcID, "ADMIN_OVERRIDE", actor, map[string]any{"actionType": action, "reason": req.Reason, "milestoneId": req.MilestoneID})
-		_ = events.Insert(r.Context(), tx, svc.ID, "ADMIN_OVERRIDE", "Admin override applied", actor, now, map[string]any{"actionType": action, "reason": req.Reason, "milestoneId": req.MilestoneID})
-
 		return nil
 	})
 
 	if err != nil {
+		// If we used pgx.ErrTxCommitRollback to early-return after writing response, ignore.
 		if err == pgx.ErrTxCommitRollback {
 			return
 		}
-		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
 		return
 	}
 
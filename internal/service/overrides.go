@@ -0,0 +1,403 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+
+	"microservice/internal/adminaction"
+	"microservice/internal/api"
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/internal/staff"
+	"microservice/pkg/db"
+)
+
+// Admin overrides are two-person operations: one staff member proposes, a different one confirms or rejects
+// before the proposal expires. Only confirmation applies the effect.
+
+type AdminOverrideRequest struct {
+	ActionType  string `json:"actionType"`
+	Reason      string `json:"reason"`
+	MilestoneID string `json:"milestoneId,omitempty"`
+}
+
+type overrideDecisionRequest struct {
+	Note string `json:"note,omitempty"`
+}
+
+// overrideMetadata is what a proposal carries in admin_actions.metadata.
+type overrideMetadata struct {
+	MilestoneID string `json:"milestoneId,omitempty"`
+}
+
+// AdminOverride records a pending override proposal. Nothing about the service changes until it is confirmed.
+func (h Handlers) AdminOverride(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing id")
+		return
+	}
+
+	var req AdminOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		api.WriteError(w, http.StatusBadRequest, "OVERRIDE_REASON_REQUIRED", "reason is required")
+		return
+	}
+
+	action := adminaction.ActionType(req.ActionType)
+	switch action {
+	case adminaction.ActionMarkMilestonePaid:
+		if req.MilestoneID == "" {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "milestoneId is required for MARK_MILESTONE_PAID")
+			return
+		}
+	case adminaction.ActionCompleteServiceWithoutFinalPay, adminaction.ActionReopenService:
+		req.MilestoneID = ""
+	default:
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid actionType")
+		return
+	}
+
+	var proposal *adminaction.Action
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		svc, err := GetForUpdate(r.Context(), tx, shopCtx.ID, id)
+		if err != nil {
+			return err
+		}
+
+		if action == adminaction.ActionMarkMilestonePaid {
+			m, err := milestone.GetForUpdate(r.Context(), tx, req.MilestoneID)
+			if err != nil || m.ServiceID != svc.ID {
+				api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "milestone not found")
+				return pgx.ErrTxCommitRollback
+			}
+			if milestone.IsSettled(m.Status) {
+				api.WriteError(w, http.StatusConflict, "MILESTONE_ALREADY_PAID", "milestone already paid")
+				return pgx.ErrTxCommitRollback
+			}
+		}
+
+		now := time.Now()
+		actor := api.Actor(r.Context())
+		svcID := svc.ID
+		proposal, err = adminaction.Propose(r.Context(), tx, svc.ID, action, req.Reason, actor, now.Add(h.Cfg.OverrideProposalTTL), overrideMetadata{MilestoneID: req.MilestoneID})
+		if err != nil {
+			return err
+		}
+
+		data := map[string]any{"actionId": proposal.ID, "actionType": action, "reason": req.Reason, "milestoneId": req.MilestoneID, "expiresAt": proposal.ExpiresAt}
+		if err := audit.Insert(r.Context(), tx, shopCtx.ID, &svcID, "ADMIN_OVERRIDE_PROPOSED", actor, data); err != nil {
+			return err
+		}
+		return events.Insert(r.Context(), tx, svc.ID, "ADMIN_OVERRIDE_PROPOSED", "Admin override proposed, awaiting confirmation", actor, now, data)
+	})
+
+	if err != nil {
+		if err == pgx.ErrTxCommitRollback {
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(proposal)
+}
+
+// ListOverrides returns every override proposal of a service, newest first.
+func (h Handlers) ListOverrides(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	svc, err := h.Services.GetByID(r.Context(), shopCtx.ID, chi.URLParam(r, "id"))
+	if err != nil {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+		return
+	}
+
+	list, err := h.AdminActions.ListByService(r.Context(), svc.ID, r.URL.Query().Get("status") == string(adminaction.StatusPending))
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if list == nil {
+		list = []adminaction.Action{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"overrides": list})
+}
+
+// ConfirmOverride applies a pending proposal. The confirmer must be a different staff member than the proposer,
+// and proposals above OverrideSecondApproverAbove (or that complete/reopen a service) need a finance_admin.
+func (h Handlers) ConfirmOverride(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var req overrideDecisionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+			return
+		}
+	}
+
+	approver := api.Actor(r.Context())
+	if api.StaffFromContext(r.Context()) == nil {
+		api.WriteError(w, http.StatusForbidden, "STAFF_IDENTITY_REQUIRED", "confirming an override requires a verified staff member")
+		return
+	}
+
+	expired := false
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		svc, err := GetForUpdate(r.Context(), tx, shopCtx.ID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		a, err := adminaction.GetForUpdate(r.Context(), tx, svc.ID, chi.URLParam(r, "actionId"))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "override not found")
+				return pgx.ErrTxCommitRollback
+			}
+			return err
+		}
+
+		now := time.Now()
+		svcID := svc.ID
+		if a.Expired(now) {
+			// Record the expiry so the proposal stops showing as pending, then report it.
+			expired = true
+			return h.expireOverride(r, tx, shopCtx.ID, svc.ID, a, now)
+		}
+		if a.Status != adminaction.StatusPending {
+			api.WriteError(w, http.StatusConflict, "OVERRIDE_NOT_PENDING", "override is already "+string(a.Status))
+			return pgx.ErrTxCommitRollback
+		}
+		if a.ProposedBy == approver {
+			api.WriteError(w, http.StatusForbidden, "SAME_APPROVER", "an override must be confirmed by someone other than its proposer")
+			return pgx.ErrTxCommitRollback
+		}
+
+		var meta overrideMetadata
+		if len(a.Metadata) > 0 {
+			_ = json.Unmarshal(a.Metadata, &meta)
+		}
+
+		var before, after map[string]any
+		switch a.ActionType {
+		case adminaction.ActionMarkMilestonePaid:
+			m, err := milestone.GetForUpdate(r.Context(), tx, meta.MilestoneID)
+			if err != nil || m.ServiceID != svc.ID {
+				api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "milestone not found")
+				return pgx.ErrTxCommitRollback
+			}
+			if milestone.IsSettled(m.Status) {
+				api.WriteError(w, http.StatusConflict, "MILESTONE_ALREADY_PAID", "milestone already paid")
+				return pgx.ErrTxCommitRollback
+			}
+			if h.requiresFinanceAdmin(m.Amount) && staff.RoleFromContext(r.Context()) != staff.RoleFinanceAdmin {
+				api.WriteError(w, http.StatusForbidden, "FORBIDDEN", "override amount requires a finance_admin to confirm")
+				return pgx.ErrTxCommitRollback
+			}
+			// The override books whatever is still owed, so the ledger keeps adding up.
+			changes, err := RecordMilestonePayment(r.Context(), tx, shopCtx.ID, m, milestone.Payment{
+				Amount:     m.Outstanding(),
+				Source:     "override",
+				Reference:  a.ID,
+				ReceivedAt: now,
+			}, approver, map[string]any{"actionId": a.ID})
+			if err != nil {
+				return err
+			}
+			to := milestone.StatusPaid
+			for _, ch := range changes {
+				if ch.MilestoneID == m.ID {
+					to = ch.To
+				}
+			}
+			before = map[string]any{"milestoneStatus": m.Status, "amountPaid": m.AmountPaid}
+			after = map[string]any{"milestoneStatus": to, "amountPaid": m.Amount}
+
+		case adminaction.ActionCompleteServiceWithoutFinalPay, adminaction.ActionReopenService:
+			if staff.RoleFromContext(r.Context()) != staff.RoleFinanceAdmin {
+				api.WriteError(w, http.StatusForbidden, "FORBIDDEN", "requires role finance_admin")
+				return pgx.ErrTxCommitRollback
+			}
+			status, viaOverride := StatusInProgress, false
+			if a.ActionType == adminaction.ActionCompleteServiceWithoutFinalPay {
+				status, viaOverride = StatusCompleted, true
+			}
+			if err := UpdateStatus(r.Context(), tx, shopCtx.ID, svc.ID, status, viaOverride); err != nil {
+				return err
+			}
+			before = map[string]any{"status": svc.Status, "completedViaOverride": svc.CompletedViaOverride}
+			after = map[string]any{"status": status, "completedViaOverride": viaOverride}
+
+		default:
+			api.WriteError(w, http.StatusConflict, "VALIDATION_FAILED", "unsupported actionType")
+			return pgx.ErrTxCommitRollback
+		}
+
+		if err := adminaction.Decide(r.Context(), tx, a.ID, adminaction.StatusApplied, approver, req.Note, now); err != nil {
+			return err
+		}
+		auditMeta := audit.Diff(before, after)
+		auditMeta["actionId"], auditMeta["actionType"], auditMeta["reason"], auditMeta["milestoneId"] = a.ID, a.ActionType, a.Reason, meta.MilestoneID
+		auditMeta["proposedBy"], auditMeta["approvedBy"] = a.ProposedBy, approver
+		if err := audit.Insert(r.Context(), tx, shopCtx.ID, &svcID, "ADMIN_OVERRIDE", approver, auditMeta); err != nil {
+			return err
+		}
+		return events.Insert(r.Context(), tx, svc.ID, "ADMIN_OVERRIDE", "Admin override applied", approver, now, map[string]any{
+			"actionId": a.ID, "actionType": a.ActionType, "reason": a.Reason, "milestoneId": meta.MilestoneID,
+			"proposedBy": a.ProposedBy, "approvedBy": approver,
+		})
+	})
+
+	if err != nil {
+		if err == pgx.ErrTxCommitRollback {
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if expired {
+		api.WriteError(w, http.StatusConflict, "OVERRIDE_EXPIRED", "override proposal has expired")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RejectOverride closes a pending proposal without applying it. The proposer may use it to withdraw.
+func (h Handlers) RejectOverride(w http.ResponseWriter, r *http.Request) {
+	shopCtx := api.ShopFromContext(r.Context())
+	if shopCtx == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var req overrideDecisionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+			return
+		}
+	}
+
+	actor := api.Actor(r.Context())
+	expired := false
+	err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		svc, err := GetForUpdate(r.Context(), tx, shopCtx.ID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		a, err := adminaction.GetForUpdate(r.Context(), tx, svc.ID, chi.URLParam(r, "actionId"))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "override not found")
+				return pgx.ErrTxCommitRollback
+			}
+			return err
+		}
+
+		now := time.Now()
+		svcID := svc.ID
+		if a.Expired(now) {
+			expired = true
+			return h.expireOverride(r, tx, shopCtx.ID, svc.ID, a, now)
+		}
+		if a.Status != adminaction.StatusPending {
+			api.WriteError(w, http.StatusConflict, "OVERRIDE_NOT_PENDING", "override is already "+string(a.Status))
+			return pgx.ErrTxCommitRollback
+		}
+
+		if err := adminaction.Decide(r.Context(), tx, a.ID, adminaction.StatusRejected, actor, req.Note, now); err != nil {
+			return err
+		}
+		data := map[string]any{"actionId": a.ID, "actionType": a.ActionType, "proposedBy": a.ProposedBy, "rejectedBy": actor, "note": req.Note}
+		if err := audit.Insert(r.Context(), tx, shopCtx.ID, &svcID, "ADMIN_OVERRIDE_REJECTED", actor, data); err != nil {
+			return err
+		}
+		return events.Insert(r.Context(), tx, svc.ID, "ADMIN_OVERRIDE_REJECTED", "Admin override rejected", actor, now, data)
+	})
+
+	if err != nil {
+		if err == pgx.ErrTxCommitRollback {
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service not found")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if expired {
+		api.WriteError(w, http.StatusConflict, "OVERRIDE_EXPIRED", "override proposal has expired")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Handlers) expireOverride(r *http.Request, tx pgx.Tx, shopID, serviceID string, a *adminaction.Action, now time.Time) error {
+	if err := adminaction.Decide(r.Context(), tx, a.ID, adminaction.StatusExpired, "", "", now); err != nil {
+		return err
+	}
+	data := map[string]any{"actionId": a.ID, "actionType": a.ActionType, "proposedBy": a.ProposedBy, "expiresAt": a.ExpiresAt}
+	if err := audit.Insert(r.Context(), tx, shopID, &serviceID, "ADMIN_OVERRIDE_EXPIRED", "system", data); err != nil {
+		return err
+	}
+	return events.Insert(r.Context(), tx, serviceID, "ADMIN_OVERRIDE_EXPIRED", "Admin override expired unconfirmed", "system", now, data)
+}
+
+// requiresFinanceAdmin reports whether confirming an override touching amount needs a finance_admin.
+// Without a configured threshold every amount does.
+func (h Handlers) requiresFinanceAdmin(amount string) bool {
+	limit, err := decimal.NewFromString(strings.TrimSpace(h.Cfg.OverrideSecondApproverAbove))
+	if err != nil {
+		// No (or an unreadable) threshold: fail closed.
+		return true
+	}
+	amt, err := decimal.NewFromString(amount)
+	if err != nil {
+		// Unknown amount: fail closed.
+		return true
+	}
+	return amt.GreaterThan(limit)
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/pkg/currency"
+)
+
+// RecordMilestonePayment books a payment against a milestone through the ledger and records what changed:
+// milestones that became paid, partially paid or overpaid, credit carried onto later milestones, and service
+// completion when the final milestone is settled after approval.
+//
+// A milestone that still owes money after the payment has its payment request dropped, since that request
+// asked for more than arrived; the next request covers the remainder.
+func RecordMilestonePayment(ctx context.Context, tx pgx.Tx, shopID string, m *milestone.Record, p milestone.Payment, actor string, data map[string]any) ([]milestone.StatusChange, error) {
+	changes, err := milestone.RecordPayment(ctx, tx, m.ServiceID, m.ID, p)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceID := m.ServiceID
+	for _, ch := range changes {
+		meta := map[string]any{"milestoneId": ch.MilestoneID, "sequence": ch.Sequence, "amountPaid": currency.Format(ch.AmountPaid, m.Currency)}
+		if ch.MilestoneID == m.ID {
+			for k, v := range data {
+				meta[k] = v
+			}
+			meta["amount"] = currency.Format(p.Amount, m.Currency)
+		}
+
+		if ch.CreditIn.Sign() > 0 {
+			credit := map[string]any{"milestoneId": ch.MilestoneID, "sequence": ch.Sequence, "credit": currency.Format(ch.CreditIn, m.Currency), "fromMilestoneId": m.ID}
+			if err := events.Insert(ctx, tx, serviceID, "MILESTONE_CREDIT_APPLIED", "Overpayment carried forward as credit", actor, p.ReceivedAt, credit); err != nil {
+				return nil, err
+			}
+		}
+
+		if ch.From != ch.To || ch.MilestoneID == m.ID {
+			action, summary := "", ""
+			switch {
+			case ch.To == milestone.StatusOverpaid:
+				action, summary = "MILESTONE_OVERPAID", "Milestone overpaid"
+			case milestone.IsSettled(ch.To) && !milestone.IsSettled(ch.From):
+				action, summary = "MILESTONE_PAID", "Milestone paid"
+			case ch.To == milestone.StatusPartiallyPaid:
+				action, summary = "MILESTONE_PARTIALLY_PAID", "Milestone partially paid"
+			}
+			if action != "" {
+				meta["from"], meta["to"] = ch.From, ch.To
+				if err := audit.Insert(ctx, tx, shopID, &serviceID, action, actor, meta); err != nil {
+					return nil, err
+				}
+				if err := events.Insert(ctx, tx, serviceID, action, summary, actor, p.ReceivedAt, map[string]any{"milestoneId": ch.MilestoneID, "amountPaid": meta["amountPaid"]}); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if ch.MilestoneID == m.ID && !milestone.IsSettled(ch.To) && (m.PaymentReference != "" || m.DraftOrderID != "") {
+			if err := milestone.ClearPaymentRequest(ctx, tx, m.ID); err != nil {
+				return nil, err
+			}
+		}
+
+		if ch.Final && milestone.IsSettled(ch.To) && !milestone.IsSettled(ch.From) {
+			if err := CompleteIfApproved(ctx, tx, shopID, serviceID, actor); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// CompleteIfApproved completes a service whose final milestone is settled, once the client has approved.
+// A recurring service that is still billing has no final milestone yet and stays open.
+func CompleteIfApproved(ctx context.Context, tx pgx.Tx, shopID, serviceID, actor string) error {
+	const qAppr = `
+SELECT a.approved AND NOT EXISTS (
+  SELECT 1 FROM service_recurrences r WHERE r.service_id = a.service_id AND r.status = 'active'
+)
+FROM approvals a
+WHERE a.service_id = $1
+`
+	var approved bool
+	err := tx.QueryRow(ctx, qAppr, serviceID).Scan(&approved)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil || !approved {
+		return err
+	}
+	if err := UpdateStatus(ctx, tx, shopID, serviceID, StatusCompleted, false); err != nil {
+		return err
+	}
+	return events.Insert(ctx, tx, serviceID, "STATUS_CHANGED", "Service completed", actor, time.Now(), map[string]any{"to": StatusCompleted})
+}
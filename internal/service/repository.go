@@ -15,6 +15,7 @@ type Service struct {
 	ShopID                string          `json:"shopId"`
 	ShopifyOrderID        string          `json:"shopifyOrderId"`
 	ShopifyProductID      string          `json:"shopifyProductId,omitempty"`
+	ShopifyVariantID      string          `json:"shopifyVariantId,omitempty"` // ordered variant, when known
 	ClientEmail           string          `json:"clientEmail,omitempty"`
 	ClientName            string          `json:"clientName,omitempty"`
 	TotalAmount           string          `json:"totalAmount"`
@@ -22,6 +23,8 @@ type Service struct {
 	Status                Status          `json:"status"`
 	ServiceConfigSnapshot json.RawMessage `json:"serviceConfigSnapshot"`
 	CompletedViaOverride  bool            `json:"completedViaOverride"`
+	AutoCharge            bool            `json:"autoCharge"`              // client opted into charging milestones on their due date
+	ConfigVersion         *int            `json:"configVersion,omitempty"` // service product config version it was created from
 	CreatedAt             time.Time       `json:"createdAt"`
 	UpdatedAt             time.Time       `json:"updatedAt"`
 }
@@ -55,7 +58,7 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 func (r *Repository) ListByShop(ctx context.Context, shopID string) ([]ListItem, error) {
 	const q = `
 SELECT s.id, s.display_id, s.shop_id, s.shopify_order_id, s.shopify_product_id, s.client_email, s.client_name,
-       COALESCE(SUM(CASE WHEN m.status = 'paid' THEN m.amount ELSE 0 END), 0)::text AS paid_amount,
+       COALESCE(SUM(m.amount_paid), 0)::text AS paid_amount,
        s.total_amount::text, s.currency, s.status, s.created_at, s.updated_at, s.service_config_snapshot
 FROM services s
 LEFT JOIN milestones m ON m.service_id = s.id
@@ -87,7 +90,7 @@ ORDER BY s.created_at DESC
 func (r *Repository) GetByID(ctx context.Context, shopID, serviceID string) (*Service, error) {
 	const q = `
 SELECT id, display_id, shop_id, shopify_order_id, shopify_product_id, client_email, client_name,
-       total_amount::text, currency, status, service_config_snapshot, completed_via_override,
+       total_amount::text, currency, status, service_config_snapshot, completed_via_override, auto_charge, config_version, COALESCE(shopify_variant_id,''),
        created_at, updated_at
 FROM services
 WHERE shop_id = $1 AND id = $2
@@ -95,7 +98,7 @@ WHERE shop_id = $1 AND id = $2
 	var s Service
 	if err := r.db.QueryRow(ctx, q, shopID, serviceID).Scan(
 		&s.ID, &s.DisplayID, &s.ShopID, &s.ShopifyOrderID, &s.ShopifyProductID, &s.ClientEmail, &s.ClientName,
-		&s.TotalAmount, &s.Currency, &s.Status, &s.ServiceConfigSnapshot, &s.CompletedViaOverride,
+		&s.TotalAmount, &s.Currency, &s.Status, &s.ServiceConfigSnapshot, &s.CompletedViaOverride, &s.AutoCharge, &s.ConfigVersion, &s.ShopifyVariantID,
 		&s.CreatedAt, &s.UpdatedAt,
 	); err != nil {
 		return nil, err
@@ -106,7 +109,7 @@ WHERE shop_id = $1 AND id = $2
 func GetForUpdate(ctx context.Context, tx pgx.Tx, shopID, serviceID string) (*Service, error) {
 	const q = `
 SELECT id, display_id, shop_id, shopify_order_id, shopify_product_id, client_email, client_name,
-       total_amount::text, currency, status, service_config_snapshot, completed_via_override,
+       total_amount::text, currency, status, service_config_snapshot, completed_via_override, auto_charge, config_version, COALESCE(shopify_variant_id,''),
        created_at, updated_at
 FROM services
 WHERE shop_id = $1 AND id = $2
@@ -115,7 +118,7 @@ FOR UPDATE
 	var s Service
 	if err := tx.QueryRow(ctx, q, shopID, serviceID).Scan(
 		&s.ID, &s.DisplayID, &s.ShopID, &s.ShopifyOrderID, &s.ShopifyProductID, &s.ClientEmail, &s.ClientName,
-		&s.TotalAmount, &s.Currency, &s.Status, &s.ServiceConfigSnapshot, &s.CompletedViaOverride,
+		&s.TotalAmount, &s.Currency, &s.Status, &s.ServiceConfigSnapshot, &s.CompletedViaOverride, &s.AutoCharge, &s.ConfigVersion, &s.ShopifyVariantID,
 		&s.CreatedAt, &s.UpdatedAt,
 	); err != nil {
 		return nil, err
@@ -126,7 +129,7 @@ FOR UPDATE
 func GetForUpdateAny(ctx context.Context, tx pgx.Tx, serviceID string) (*Service, error) {
 	const q = `
 SELECT id, display_id, shop_id, shopify_order_id, shopify_product_id, client_email, client_name,
-       total_amount::text, currency, status, service_config_snapshot, completed_via_override,
+       total_amount::text, currency, status, service_config_snapshot, completed_via_override, auto_charge, config_version, COALESCE(shopify_variant_id,''),
        created_at, updated_at
 FROM services
 WHERE id = $1
@@ -135,7 +138,7 @@ FOR UPDATE
 	var s Service
 	if err := tx.QueryRow(ctx, q, serviceID).Scan(
 		&s.ID, &s.DisplayID, &s.ShopID, &s.ShopifyOrderID, &s.ShopifyProductID, &s.ClientEmail, &s.ClientName,
-		&s.TotalAmount, &s.Currency, &s.Status, &s.ServiceConfigSnapshot, &s.CompletedViaOverride,
+		&s.TotalAmount, &s.Currency, &s.Status, &s.ServiceConfigSnapshot, &s.CompletedViaOverride, &s.AutoCharge, &s.ConfigVersion, &s.ShopifyVariantID,
 		&s.CreatedAt, &s.UpdatedAt,
 	); err != nil {
 		return nil, err
@@ -154,3 +157,47 @@ WHERE shop_id = $3 AND id = $4
 }
 
 
+
+// ListPageByShop returns services in creation order using keyset pagination on (created_at, id).
+// Pass a zero afterCreatedAt to start from the beginning.
+func (r *Repository) ListPageByShop(ctx context.Context, shopID string, afterCreatedAt time.Time, afterID string, limit int) ([]Service, error) {
+	const q = `
+SELECT id, display_id, shop_id, shopify_order_id, COALESCE(shopify_product_id,''), COALESCE(client_email,''), COALESCE(client_name,''),
+       total_amount::text, currency, status, service_config_snapshot, completed_via_override, auto_charge, config_version, COALESCE(shopify_variant_id,''),
+       created_at, updated_at
+FROM services
+WHERE shop_id = $1
+  AND (created_at, id) > ($2, $3::uuid)
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+	if afterID == "" {
+		afterID = "00000000-0000-0000-0000-000000000000"
+	}
+	rows, err := r.db.Query(ctx, q, shopID, afterCreatedAt, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Service
+	for rows.Next() {
+		var s Service
+		if err := rows.Scan(
+			&s.ID, &s.DisplayID, &s.ShopID, &s.ShopifyOrderID, &s.ShopifyProductID, &s.ClientEmail, &s.ClientName,
+			&s.TotalAmount, &s.Currency, &s.Status, &s.ServiceConfigSnapshot, &s.CompletedViaOverride, &s.AutoCharge, &s.ConfigVersion, &s.ShopifyVariantID,
+			&s.CreatedAt, &s.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// SetAutoCharge switches scheduled charging of the service's milestones on or off.
+func SetAutoCharge(ctx context.Context, tx pgx.Tx, serviceID string, enabled bool) error {
+	const q = `UPDATE services SET auto_charge = $2, updated_at = NOW() WHERE id = $1`
+	_, err := tx.Exec(ctx, q, serviceID, enabled)
+	return err
+}
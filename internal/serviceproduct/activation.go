@@ -0,0 +1,83 @@
+package serviceproduct
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/audit"
+	"microservice/pkg/db"
+)
+
+// Activator promotes scheduled config versions once their activeFrom has passed. It is run by the scheduler;
+// several instances may run it at once. Orders paid in between already use the due version (see ActiveConfig),
+// so a late run only delays what the admin lists show.
+type Activator struct {
+	DB    *pgxpool.Pool
+	Batch int // versions per run; 0 means 100
+}
+
+// Run activates the versions that are due now. Only versions newer than the active one are activated, so a
+// scheduled version is superseded by any version saved after it, and of several due versions of one product
+// the newest ends up active.
+func (a Activator) Run(ctx context.Context) error {
+	batch := a.Batch
+	if batch <= 0 {
+		batch = 100
+	}
+	now := time.Now()
+
+	return db.WithTx(ctx, a.DB, func(tx pgx.Tx) error {
+		const q = `
+SELECT v.config_id, c.shop_id, c.shopify_product_id, c.shopify_variant_id, v.version, v.config
+FROM service_product_config_versions v
+JOIN service_product_configs c ON c.id = v.config_id
+WHERE v.activated_at IS NULL AND v.active_from <= $1 AND v.version > c.version AND c.deleted_at IS NULL
+ORDER BY v.active_from ASC, v.version ASC
+LIMIT $2
+FOR UPDATE OF c SKIP LOCKED
+`
+		rows, err := tx.Query(ctx, q, now, batch)
+		if err != nil {
+			return err
+		}
+		type due struct {
+			configID, shopID, productID, variantID string
+			version                                *Version
+		}
+		var list []due
+		for rows.Next() {
+			var d due
+			d.version = &Version{}
+			if err := rows.Scan(&d.configID, &d.shopID, &d.productID, &d.variantID, &d.version.Version, &d.version.Config); err != nil {
+				rows.Close()
+				return err
+			}
+			list = append(list, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		activated := map[string]int{}
+		for _, d := range list {
+			if d.version.Version <= activated[d.configID] {
+				// Older than a version activated above: superseded, like any pending version older than the active one.
+				continue
+			}
+			if _, err := activate(ctx, tx, d.configID, d.version); err != nil {
+				return err
+			}
+			activated[d.configID] = d.version.Version
+			data := auditKey(d.productID, d.variantID)
+			data["version"] = d.version.Version
+			if err := audit.Insert(ctx, tx, d.shopID, nil, "SERVICE_PRODUCT_CONFIG_ACTIVATED", "system", data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
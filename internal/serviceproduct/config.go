@@ -8,17 +8,50 @@ import (
 	"microservice/internal/milestone"
 )
 
+// ConfigVersion is the version new configs are written with. Version 1 configs (milestone splits only, no
+// kind) still parse unchanged.
+const ConfigVersion = 2
+
+// Config kinds. A milestone config splits the order total across Templates; a recurring config bills the
+// Recurring template every cycle.
+const (
+	KindMilestones = "milestones"
+	KindRecurring  = "recurring"
+)
+
 // Config is stored as JSONB in `service_product_configs.config`.
 // Keep this versioned so we can evolve without breaking existing records.
 type Config struct {
 	Version   int                      `json:"version"`
+	Kind      string                   `json:"kind,omitempty"` // empty means milestones
 	Currency  string                   `json:"currency,omitempty"`
 	Templates []milestone.MilestoneTemplate `json:"templates"`
+	Recurring *milestone.RecurringTemplate  `json:"recurring,omitempty"`
+	// Rounding picks the milestone(s) that absorb rounding when the total is split. Empty: the final milestone.
+	Rounding milestone.Rounding `json:"rounding,omitempty"`
+
+	// PaymentRail overrides the shop's payment rail for services of this product. Empty: use the shop's.
+	PaymentRail string `json:"paymentRail,omitempty"`
 
 	// Optional: if you want to lock percent-only templates early, this can be used later.
 	// For now we validate structural rules and (if all percent) require sum==100.
 }
 
+// Payment rails a config or shop may select; the payment package implements them.
+const (
+	PaymentRailDraftOrder    = "draft_order"
+	PaymentRailManualInvoice = "manual_invoice"
+	PaymentRailFake          = "fake" // local development and tests only
+)
+
+func ValidPaymentRail(name string) bool {
+	switch name {
+	case PaymentRailDraftOrder, PaymentRailManualInvoice, PaymentRailFake:
+		return true
+	}
+	return false
+}
+
 func ParseAndValidate(raw json.RawMessage) (Config, error) {
 	var cfg Config
 	if err := json.Unmarshal(raw, &cfg); err != nil {
@@ -27,26 +60,73 @@ func ParseAndValidate(raw json.RawMessage) (Config, error) {
 	if cfg.Version == 0 {
 		cfg.Version = 1
 	}
+	if cfg.Version > ConfigVersion {
+		return Config{}, milestone.ValidationError{Code: "VALIDATION_FAILED", Message: "unsupported config version"}
+	}
+	if cfg.Kind == "" {
+		cfg.Kind = KindMilestones
+	}
+
+	if cfg.PaymentRail != "" && !ValidPaymentRail(cfg.PaymentRail) {
+		return Config{}, milestone.ValidationError{Code: "VALIDATION_FAILED", Message: "unknown paymentRail"}
+	}
+
+	switch cfg.Kind {
+	case KindMilestones:
+		if cfg.Recurring != nil {
+			return Config{}, milestone.ValidationError{Code: "VALIDATION_FAILED", Message: "recurring is only allowed for kind recurring"}
+		}
+	case KindRecurring:
+		if cfg.Version < 2 {
+			return Config{}, milestone.ValidationError{Code: "VALIDATION_FAILED", Message: "recurring configs require version 2"}
+		}
+		if cfg.Recurring == nil {
+			return Config{}, milestone.ValidationError{Code: "VALIDATION_FAILED", Message: "recurring template is required"}
+		}
+		if len(cfg.Templates) > 0 {
+			return Config{}, milestone.ValidationError{Code: "VALIDATION_FAILED", Message: "recurring configs cannot have milestone templates"}
+		}
+		if err := milestone.ValidateRecurring(*cfg.Recurring); err != nil {
+			return Config{}, err
+		}
+		return cfg, nil
+	default:
+		return Config{}, milestone.ValidationError{Code: "VALIDATION_FAILED", Message: "kind must be milestones or recurring"}
+	}
 
 	if err := milestone.ValidateTemplate(cfg.Templates); err != nil {
 		return Config{}, err
 	}
+	if err := milestone.ValidateRounding(cfg.Rounding); err != nil {
+		return Config{}, err
+	}
 
-	// If all milestones are percentage-based, enforce sum == 100 exactly.
-	allPercent := true
+	// If all milestones are percentage-based, enforce sum == 100 exactly. With a remainder milestone the
+	// percentages must leave something for it.
+	allPercent, hasRemainder := true, false
 	sum := decimal.Zero
 	for _, t := range cfg.Templates {
-		if t.Type != milestone.TemplateTypePercentage {
+		switch t.Type {
+		case milestone.TemplateTypePercentage:
+			sum = sum.Add(t.Value)
+		case milestone.TemplateTypeRemainder:
+			hasRemainder = true
+		default:
 			allPercent = false
-			break
 		}
-		sum = sum.Add(t.Value)
 	}
-	if allPercent && !sum.Equal(decimal.NewFromInt(100)) {
+	hundred := decimal.NewFromInt(100)
+	if hasRemainder && !sum.LessThan(hundred) {
+		return Config{}, milestone.ValidationError{Code: "MILESTONE_SUM_INVALID", Message: "percentage milestones must leave a share for the remainder"}
+	}
+	if allPercent && !hasRemainder && !sum.Equal(hundred) {
 		return Config{}, milestone.ValidationError{Code: "MILESTONE_SUM_INVALID", Message: "percentage milestones must sum to 100"}
 	}
 
 	return cfg, nil
 }
 
-
+// IsRecurring reports whether services of this config bill per cycle instead of splitting a total.
+func (c Config) IsRecurring() bool {
+	return c.Kind == KindRecurring
+}
@@ -0,0 +1,67 @@
+package serviceproduct
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAndValidate_VersionOneStillParses(t *testing.T) {
+	raw := json.RawMessage(`{"version":1,"templates":[{"type":"percentage","value":"30","isFinal":false},{"type":"percentage","value":"70","isFinal":true}]}`)
+	cfg, err := ParseAndValidate(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Kind != KindMilestones || cfg.IsRecurring() {
+		t.Fatalf("expected a milestone config, got kind %q", cfg.Kind)
+	}
+
+	// Unversioned configs predate versioning and are version 1.
+	cfg, err = ParseAndValidate(json.RawMessage(`{"templates":[{"type":"fixed","value":"10","isFinal":true}]}`))
+	if err != nil || cfg.Version != 1 {
+		t.Fatalf("expected version 1, got %d (%v)", cfg.Version, err)
+	}
+}
+
+func TestParseAndValidate_Recurring(t *testing.T) {
+	cfg, err := ParseAndValidate(json.RawMessage(`{"version":2,"kind":"recurring","recurring":{"interval":"month","cycles":6}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.IsRecurring() || cfg.Recurring.Cycles != 6 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	bad := []string{
+		`{"version":1,"kind":"recurring","recurring":{"interval":"month"}}`,
+		`{"version":2,"kind":"recurring"}`,
+		`{"version":2,"kind":"recurring","recurring":{"interval":"month"},"templates":[{"type":"fixed","value":"10","isFinal":true}]}`,
+		`{"version":3,"templates":[{"type":"fixed","value":"10","isFinal":true}]}`,
+		`{"version":2,"kind":"weekly"}`,
+	}
+	for _, raw := range bad {
+		if _, err := ParseAndValidate(json.RawMessage(raw)); err == nil {
+			t.Fatalf("expected %s to be rejected", raw)
+		}
+	}
+}
+
+func TestParseAndValidate_RoundingAndRemainder(t *testing.T) {
+	cfg, err := ParseAndValidate(json.RawMessage(`{"version":2,"rounding":"largest_remainder","templates":[{"type":"percentage","value":"30"},{"type":"remainder","isFinal":true}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Rounding != "largest_remainder" {
+		t.Fatalf("unexpected rounding %q", cfg.Rounding)
+	}
+
+	bad := []string{
+		`{"version":2,"rounding":"up","templates":[{"type":"fixed","value":"10","isFinal":true}]}`,
+		`{"version":2,"templates":[{"type":"percentage","value":"100"},{"type":"remainder","isFinal":true}]}`,
+		`{"version":2,"templates":[{"type":"remainder"},{"type":"remainder","isFinal":true}]}`,
+	}
+	for _, raw := range bad {
+		if _, err := ParseAndValidate(json.RawMessage(raw)); err == nil {
+			t.Fatalf("expected %s to be rejected", raw)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package serviceproduct
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Change is one difference between two configs. Path points into the config JSON ("templates[1].value");
+// From is absent for added fields and To for removed ones.
+type Change struct {
+	Path string `json:"path"`
+	From any    `json:"from,omitempty"`
+	To   any    `json:"to,omitempty"`
+}
+
+// Diff lists the differences between two config documents, in path order. Numbers compare by their JSON text,
+// so "30" and 30 differ but 30 and 30 do not.
+func Diff(from, to json.RawMessage) ([]Change, error) {
+	a, err := decodeJSON(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decodeJSON(to)
+	if err != nil {
+		return nil, err
+	}
+	var out []Change
+	diffValue("", a, b, &out)
+	return out, nil
+}
+
+func decodeJSON(raw json.RawMessage) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func diffValue(path string, a, b any, out *[]Change) {
+	switch av := a.(type) {
+	case map[string]any:
+		if bv, ok := b.(map[string]any); ok {
+			keys := make([]string, 0, len(av)+len(bv))
+			for k := range av {
+				keys = append(keys, k)
+			}
+			for k := range bv {
+				if _, ok := av[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				child := k
+				if path != "" {
+					child = path + "." + k
+				}
+				x, inA := av[k]
+				y, inB := bv[k]
+				switch {
+				case !inA:
+					*out = append(*out, Change{Path: child, To: y})
+				case !inB:
+					*out = append(*out, Change{Path: child, From: x})
+				default:
+					diffValue(child, x, y, out)
+				}
+			}
+			return
+		}
+	case []any:
+		if bv, ok := b.([]any); ok {
+			for i := 0; i < len(av) || i < len(bv); i++ {
+				child := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(av):
+					*out = append(*out, Change{Path: child, To: bv[i]})
+				case i >= len(bv):
+					*out = append(*out, Change{Path: child, From: av[i]})
+				default:
+					diffValue(child, av[i], bv[i], out)
+				}
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, Change{Path: path, From: a, To: b})
+	}
+}
@@ -0,0 +1,41 @@
+package serviceproduct
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	from := json.RawMessage(`{"version":2,"templates":[{"type":"percentage","value":30},{"type":"percentage","value":70,"isFinal":true}],"paymentRail":"draft_order"}`)
+	to := json.RawMessage(`{"version":2,"templates":[{"type":"percentage","value":40},{"type":"percentage","value":60,"isFinal":true},{"type":"fixed","value":5}],"rounding":"deposit"}`)
+
+	changes, err := Diff(from, to)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := make([]string, len(changes))
+	for i, c := range changes {
+		got[i] = fmt.Sprintf("%s: %v -> %v", c.Path, c.From, c.To)
+	}
+	want := []string{
+		"paymentRail: draft_order -> <nil>",
+		"rounding: <nil> -> deposit",
+		"templates[0].value: 30 -> 40",
+		"templates[1].value: 70 -> 60",
+		"templates[2]: <nil> -> map[type:fixed value:5]",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d changes %v, want %v", len(got), got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("change %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	same, err := Diff(from, from)
+	if err != nil || len(same) != 0 {
+		t.Fatalf("expected no changes, got %v (%v)", same, err)
+	}
+}
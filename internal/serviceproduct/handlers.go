@@ -1,18 +1,37 @@
 package serviceproduct
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
 
 	"microservice/internal/api"
+	"microservice/internal/milestone"
+	"microservice/internal/shop"
+	"microservice/pkg/config"
+	"microservice/pkg/shopify"
 )
 
 type Handlers struct {
+	Cfg  config.Config
 	Repo *Repository
 }
 
+const (
+	// listRefreshLimit and listRefreshTimeout bound how much of a list request is spent refreshing stale
+	// cached products from Shopify; the rest are refreshed by later requests.
+	listRefreshLimit   = 10
+	listRefreshTimeout = 5 * time.Second
+)
+
 func (h Handlers) List(w http.ResponseWriter, r *http.Request) {
 	s := api.ShopFromContext(r.Context())
 	if s == nil {
@@ -25,6 +44,9 @@ func (h Handlers) List(w http.ResponseWriter, r *http.Request) {
 		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
 		return
 	}
+	ctx, cancel := context.WithTimeout(r.Context(), listRefreshTimeout)
+	h.Repo.refreshProducts(ctx, shop.AdminClient(h.Cfg, s), s.ID, recs, listRefreshLimit)
+	cancel()
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]any{"items": recs})
@@ -32,6 +54,8 @@ func (h Handlers) List(w http.ResponseWriter, r *http.Request) {
 
 type PutRequest struct {
 	Config json.RawMessage `json:"config"`
+	// ActiveFrom schedules the new version instead of activating it now ("new pricing from the 1st").
+	ActiveFrom *time.Time `json:"activeFrom,omitempty"`
 }
 
 func (h Handlers) Put(w http.ResponseWriter, r *http.Request) {
@@ -46,6 +70,8 @@ func (h Handlers) Put(w http.ResponseWriter, r *http.Request) {
 		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing shopify_product_id")
 		return
 	}
+	// Set on the variant routes: the config overrides the product's for orders of that variant.
+	variantID := chi.URLParam(r, "shopify_variant_id")
 
 	var req PutRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -59,7 +85,45 @@ func (h Handlers) Put(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rec, err := h.Repo.Upsert(r.Context(), s.ID, productID, req.Config)
+	// The product must exist in the shop; its title, image and variants are cached for the list.
+	product, err := shop.AdminClient(h.Cfg, s).Product(r.Context(), productID)
+	if errors.Is(err, shopify.ErrProductNotFound) {
+		api.WriteError(w, http.StatusBadRequest, "PRODUCT_NOT_FOUND", "shopify product "+productID+" not found")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusBadGateway, "SHOPIFY_UNAVAILABLE", "could not look up the shopify product")
+		return
+	}
+	if err := h.Repo.SaveProduct(r.Context(), s.ID, product); err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if variantID != "" && !hasVariant(product, variantID) {
+		api.WriteError(w, http.StatusBadRequest, "VARIANT_NOT_FOUND", "shopify variant "+variantID+" is not a variant of product "+productID)
+		return
+	}
+
+	if req.ActiveFrom != nil {
+		if !req.ActiveFrom.After(time.Now()) {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "activeFrom must be in the future")
+			return
+		}
+		v, err := h.Repo.Schedule(r.Context(), s.ID, productID, variantID, req.Config, *req.ActiveFrom, api.Actor(r.Context()))
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "no config to schedule a change of")
+			return
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	rec, err := h.Repo.Upsert(r.Context(), s.ID, productID, variantID, req.Config, api.Actor(r.Context()))
 	if err != nil {
 		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
 		return
@@ -69,4 +133,459 @@ func (h Handlers) Put(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(rec)
 }
 
+type PatchRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// Patch enables or disables the product's (or variant's) config without saving a new version.
+func (h Handlers) Patch(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var req PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+		return
+	}
+	if req.Enabled == nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "enabled is required")
+		return
+	}
+
+	rec, err := h.Repo.SetEnabled(r.Context(), s.ID, chi.URLParam(r, "shopify_product_id"), chi.URLParam(r, "shopify_variant_id"), *req.Enabled, api.Actor(r.Context()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service product config not found")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rec)
+}
+
+// Delete removes the product's (or variant's) config; its version history is kept. Services already created
+// from it are unaffected: they run on their snapshot.
+func (h Handlers) Delete(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	err := h.Repo.Delete(r.Context(), s.ID, chi.URLParam(r, "shopify_product_id"), chi.URLParam(r, "shopify_variant_id"), api.Actor(r.Context()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service product config not found")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Versions lists every saved version of the product's (or variant's) config, newest first.
+func (h Handlers) Versions(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	items, err := h.Repo.Versions(r.Context(), s.ID, chi.URLParam(r, "shopify_product_id"), chi.URLParam(r, "shopify_variant_id"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service product config not found")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if items == nil {
+		items = []Version{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+// DiffVersions compares two versions of the product's config: ?from=1&to=3.
+func (h Handlers) DiffVersions(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	productID, variantID := chi.URLParam(r, "shopify_product_id"), chi.URLParam(r, "shopify_variant_id")
+	var versions [2]*Version
+	for i, name := range []string{"from", "to"} {
+		n, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get(name)))
+		if err != nil || n <= 0 {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", name+" must be a version number")
+			return
+		}
+		versions[i], err = h.Repo.GetVersion(r.Context(), s.ID, productID, variantID, n)
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "config version not found")
+			return
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+			return
+		}
+	}
+
+	changes, err := Diff(versions[0].Config, versions[1].Config)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if changes == nil {
+		changes = []Change{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"from": versions[0].Version, "to": versions[1].Version, "changes": changes})
+}
+
+type RestoreRequest struct {
+	ActiveFrom *time.Time `json:"activeFrom,omitempty"`
+}
+
+// RestoreVersion saves an earlier version again as the newest one, active now or from activeFrom. The
+// restored config must still pass today's validation.
+func (h Handlers) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
 
+	productID, variantID := chi.URLParam(r, "shopify_product_id"), chi.URLParam(r, "shopify_variant_id")
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || version <= 0 {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid version")
+		return
+	}
+	var req RestoreRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+			return
+		}
+	}
+	if req.ActiveFrom != nil && !req.ActiveFrom.After(time.Now()) {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "activeFrom must be in the future")
+		return
+	}
+
+	old, err := h.Repo.GetVersion(r.Context(), s.ID, productID, variantID, version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "config version not found")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if _, err := ParseAndValidate(old.Config); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", err.Error())
+		return
+	}
+
+	v, err := h.Repo.Restore(r.Context(), s.ID, productID, variantID, version, req.ActiveFrom, api.Actor(r.Context()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "config version not found")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type SimulateRequest struct {
+	Total    string `json:"total"`
+	Currency string `json:"currency"`
+	// Config is simulated instead of the product's saved config, e.g. before saving it.
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+type SimulatedMilestone struct {
+	Sequence    int               `json:"sequence"`
+	Title       string            `json:"title,omitempty"`
+	Amount      string            `json:"amount"`
+	Status      string            `json:"status"` // once the order is booked: the deposit is paid
+	IsFinal     bool              `json:"isFinal"`
+	DueAt       *time.Time        `json:"dueAt,omitempty"`
+	PeriodStart *time.Time        `json:"periodStart,omitempty"`
+	PeriodEnd   *time.Time        `json:"periodEnd,omitempty"`
+	Details     milestone.Details `json:"details"`
+}
+
+// Simulate shows the milestones an order of the given total would create, without creating anything. It uses
+// the same config resolution and plan as the orders/paid webhook: on a variant route without a variant config the
+// product's config is simulated, and without either the matching shop rule's (against the cached product).
+// Config and amount problems are reported with their validation code.
+func (h Handlers) Simulate(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	productID, variantID := chi.URLParam(r, "shopify_product_id"), chi.URLParam(r, "shopify_variant_id")
+	var req SimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+		return
+	}
+	total, err := decimal.NewFromString(strings.TrimSpace(req.Total))
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "total must be a decimal amount")
+		return
+	}
+
+	raw := req.Config
+	var resolution *Resolution
+	if len(raw) == 0 {
+		res, err := h.Repo.Resolve(r.Context(), s.ID, productID, variantID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "service product config not found")
+			return
+		}
+		if errors.Is(err, ErrDisabled) {
+			api.WriteError(w, http.StatusConflict, "SERVICE_PRODUCT_DISABLED", "the config is disabled; orders create no services")
+			return
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+			return
+		}
+		raw, resolution = res.Config, &res.Resolution
+	}
+
+	cfg, err := ParseAndValidate(raw)
+	if err == nil {
+		var plan *ServicePlan
+		plan, err = Plan(cfg, total, req.Currency, time.Now())
+		if err == nil {
+			writeSimulation(w, plan, resolution)
+			return
+		}
+	}
+	var ve milestone.ValidationError
+	if errors.As(err, &ve) {
+		api.WriteError(w, http.StatusBadRequest, ve.Code, ve.Message)
+		return
+	}
+	api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", err.Error())
+}
+
+func writeSimulation(w http.ResponseWriter, plan *ServicePlan, resolution *Resolution) {
+	items := make([]SimulatedMilestone, 0, len(plan.Milestones))
+	for _, m := range plan.Milestones {
+		rec := milestone.Record{Sequence: m.Sequence, Title: m.Title, PeriodStart: m.PeriodStart}
+		items = append(items, SimulatedMilestone{
+			Sequence:    m.Sequence,
+			Title:       rec.DisplayTitle(),
+			Amount:      plan.Scale.Format(m.Amount),
+			Status:      m.StatusAfterOrder(),
+			IsFinal:     m.IsFinal,
+			DueAt:       m.DueAt,
+			PeriodStart: m.PeriodStart,
+			PeriodEnd:   m.PeriodEnd,
+			Details:     m.Details,
+		})
+	}
+	out := map[string]any{
+		"currency":   plan.Currency,
+		"total":      plan.Scale.Format(plan.Total),
+		"milestones": items,
+	}
+	if resolution != nil {
+		out["resolution"] = resolution
+		if resolution.ConfigVersion > 0 {
+			out["configVersion"] = resolution.ConfigVersion
+		}
+	}
+	if plan.CycleAmount.Sign() > 0 {
+		out["cycleAmount"] = plan.Scale.Format(plan.CycleAmount)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// GetDefault returns the shop default template used by rules without a config of their own.
+func (h Handlers) GetDefault(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	d, err := h.Repo.Default(r.Context(), s.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "no default template")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(d)
+}
+
+type DefaultRequest struct {
+	Config json.RawMessage `json:"config"`
+}
+
+// PutDefault saves the shop default template. It applies to orders created from then on.
+func (h Handlers) PutDefault(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var req DefaultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+		return
+	}
+	if _, err := ParseAndValidate(req.Config); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", err.Error())
+		return
+	}
+
+	d, err := h.Repo.PutDefault(r.Context(), s.ID, req.Config, api.Actor(r.Context()))
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(d)
+}
+
+// ListRules lists the shop's rules in the order orders evaluate them.
+func (h Handlers) ListRules(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	rules, err := h.Repo.Rules(r.Context(), s.ID)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if rules == nil {
+		rules = []Rule{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": rules})
+}
+
+type RuleRequest struct {
+	Kind     string `json:"kind"`
+	Match    string `json:"match"`
+	Priority *int   `json:"priority,omitempty"` // default 100
+	// Config is the rule's own template; omit it to use the shop default template.
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// defaultRulePriority leaves room for rules that should be evaluated before or after the usual ones.
+const defaultRulePriority = 100
+
+// PutRule creates the rule for kind and match, or updates the existing one.
+func (h Handlers) PutRule(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	var req RuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+		return
+	}
+	kind := strings.ToLower(strings.TrimSpace(req.Kind))
+	match, err := NormalizeRule(kind, req.Match)
+	if err != nil {
+		var ve milestone.ValidationError
+		errors.As(err, &ve)
+		api.WriteError(w, http.StatusBadRequest, ve.Code, ve.Message)
+		return
+	}
+	priority := defaultRulePriority
+	if req.Priority != nil {
+		priority = *req.Priority
+	}
+	cfg := req.Config
+	if string(cfg) == "null" {
+		cfg = nil
+	}
+	if len(cfg) > 0 {
+		if _, err := ParseAndValidate(cfg); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", err.Error())
+			return
+		}
+	}
+
+	rule, err := h.Repo.PutRule(r.Context(), s.ID, kind, match, priority, cfg, api.Actor(r.Context()))
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteRule removes a rule. Services already created by it keep their snapshot.
+func (h Handlers) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	err := h.Repo.DeleteRule(r.Context(), s.ID, chi.URLParam(r, "id"), api.Actor(r.Context()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "NOT_FOUND", "rule not found")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func hasVariant(p *shopify.Product, variantID string) bool {
+	for _, v := range p.Variants {
+		if v.ID == variantID {
+			return true
+		}
+	}
+	return false
+}
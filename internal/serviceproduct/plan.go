@@ -0,0 +1,96 @@
+package serviceproduct
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"microservice/internal/milestone"
+	"microservice/pkg/currency"
+)
+
+// PlannedMilestone is a milestone a paid order creates.
+type PlannedMilestone struct {
+	Sequence int
+	Amount   decimal.Decimal
+	IsFinal  bool
+	// Status is what the milestone is created with. The deposit is created unpaid and then paid by the order
+	// itself (PaidByOrder); the final milestone of a split is locked until approval.
+	Status      string
+	PaidByOrder bool
+	DueAt       *time.Time
+	PeriodStart *time.Time // billing period of a recurring cycle
+	PeriodEnd   *time.Time
+	milestone.Details
+}
+
+// StatusAfterOrder is the status the milestone has once the order that created it has been booked.
+func (m PlannedMilestone) StatusAfterOrder() string {
+	if m.PaidByOrder {
+		return milestone.StatusPaid
+	}
+	return m.Status
+}
+
+// ServicePlan is what a paid order of a configured product creates.
+type ServicePlan struct {
+	Currency   string
+	Scale      milestone.CurrencyScale
+	Total      decimal.Decimal
+	Milestones []PlannedMilestone
+	// CycleAmount is what each cycle of a recurring service costs; zero for milestone splits.
+	CycleAmount decimal.Decimal
+}
+
+// Plan computes what a paid order of total in currencyCode creates under cfg at now. The orders/paid webhook
+// creates services from it and the simulate endpoint shows it, so a simulation is exactly what an order does.
+// Errors are milestone.ValidationErrors.
+func Plan(cfg Config, total decimal.Decimal, currencyCode string, now time.Time) (*ServicePlan, error) {
+	scale, err := milestone.ScaleFor(currencyCode)
+	if err != nil {
+		return nil, err
+	}
+	total = total.Round(int32(scale))
+	if total.Sign() <= 0 {
+		return nil, milestone.ValidationError{Code: "SERVICE_TOTAL_INVALID", Message: "service total must be > 0"}
+	}
+	p := &ServicePlan{Currency: currency.Normalize(currencyCode), Scale: scale, Total: total}
+
+	if cfg.IsRecurring() {
+		// The order pays the first cycle; the generator job adds each later cycle as it starts.
+		start, end := cfg.Recurring.CycleStart(now, 0), cfg.Recurring.CycleStart(now, 1)
+		p.CycleAmount = cfg.Recurring.CycleAmount(total, scale)
+		p.Milestones = []PlannedMilestone{{
+			Amount:      total,
+			Status:      milestone.StatusUnpaid,
+			PaidByOrder: true,
+			PeriodStart: &start,
+			PeriodEnd:   &end,
+		}}
+		return p, nil
+	}
+
+	amounts, err := milestone.CalculateAmounts(total, cfg.Templates, scale, cfg.Rounding)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range amounts {
+		pm := PlannedMilestone{
+			Sequence:    i,
+			Amount:      m.Amount,
+			IsFinal:     m.IsFinal,
+			Status:      milestone.StatusUnpaid,
+			PaidByOrder: i == 0,
+			Details:     m.Details,
+		}
+		if i > 0 && m.IsFinal {
+			pm.Status = milestone.StatusLocked
+		}
+		if m.DueInDays > 0 {
+			due := now.AddDate(0, 0, m.DueInDays)
+			pm.DueAt = &due
+		}
+		p.Milestones = append(p.Milestones, pm)
+	}
+	return p, nil
+}
@@ -0,0 +1,86 @@
+package serviceproduct
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"microservice/internal/milestone"
+)
+
+func TestPlan_Milestones(t *testing.T) {
+	cfg, err := ParseAndValidate(json.RawMessage(`{"version":2,"templates":[{"type":"percentage","value":"30"},{"type":"percentage","value":"40","dueInDays":14},{"type":"remainder","isFinal":true}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	plan, err := Plan(cfg, decimal.RequireFromString("1000"), "jpy", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.Currency != "JPY" || plan.Scale != 0 {
+		t.Fatalf("unexpected currency %s scale %d", plan.Currency, plan.Scale)
+	}
+
+	want := []struct {
+		amount, after string
+		paid          bool
+	}{
+		{"300", milestone.StatusPaid, true},
+		{"400", milestone.StatusUnpaid, false},
+		{"300", milestone.StatusLocked, false},
+	}
+	if len(plan.Milestones) != len(want) {
+		t.Fatalf("expected %d milestones, got %d", len(want), len(plan.Milestones))
+	}
+	for i, w := range want {
+		m := plan.Milestones[i]
+		if plan.Scale.Format(m.Amount) != w.amount || m.StatusAfterOrder() != w.after || m.PaidByOrder != w.paid {
+			t.Fatalf("milestone %d = %s %s paid=%v, want %s %s paid=%v", i, m.Amount, m.StatusAfterOrder(), m.PaidByOrder, w.amount, w.after, w.paid)
+		}
+	}
+	if due := plan.Milestones[1].DueAt; due == nil || !due.Equal(now.AddDate(0, 0, 14)) {
+		t.Fatalf("unexpected due date %v", due)
+	}
+}
+
+func TestPlan_RecurringPaysFirstCycle(t *testing.T) {
+	cfg, err := ParseAndValidate(json.RawMessage(`{"version":2,"kind":"recurring","recurring":{"interval":"month","amount":"80"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+	plan, err := Plan(cfg, decimal.RequireFromString("100"), "EUR", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := plan.Milestones[0]
+	if len(plan.Milestones) != 1 || !m.PaidByOrder || plan.CycleAmount.StringFixed(2) != "80.00" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if !m.PeriodStart.Equal(now) || !m.PeriodEnd.Equal(time.Date(2026, 2, 28, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected period %v - %v", m.PeriodStart, m.PeriodEnd)
+	}
+}
+
+func TestPlan_ValidationCodes(t *testing.T) {
+	cfg, err := ParseAndValidate(json.RawMessage(`{"templates":[{"type":"fixed","value":"500"},{"type":"remainder","isFinal":true}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cases := map[string]struct{ total, currency string }{
+		"CURRENCY_INVALID":            {"1000", "ZZZ"},
+		"SERVICE_TOTAL_INVALID":       {"0", "USD"},
+		"MILESTONE_REMAINDER_INVALID": {"400", "USD"},
+	}
+	for code, c := range cases {
+		_, err := Plan(cfg, decimal.RequireFromString(c.total), c.currency, time.Now())
+		var ve milestone.ValidationError
+		if !errors.As(err, &ve) || ve.Code != code {
+			t.Fatalf("%s %s: expected %s, got %v", c.total, c.currency, code, err)
+		}
+	}
+}
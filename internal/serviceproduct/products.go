@@ -0,0 +1,216 @@
+package serviceproduct
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"microservice/internal/audit"
+	"microservice/pkg/db"
+	"microservice/pkg/shopify"
+)
+
+// productTTL is how long a cached product is shown before the list refreshes it from Shopify.
+const productTTL = 24 * time.Hour
+
+// Product is the cached Shopify product shown next to a config. DeletedAt is set once Shopify reported the
+// product deleted; the config is kept so its history stays readable, but no new orders can reach it.
+type Product struct {
+	Title    string                   `json:"title"`
+	Status   string                   `json:"status"`
+	ImageURL string                   `json:"imageUrl,omitempty"`
+	Variants []shopify.ProductVariant `json:"variants"`
+	// Tags and CollectionIDs are what service config rules match on.
+	Tags          []string   `json:"tags"`
+	CollectionIDs []string   `json:"collectionIds"`
+	SyncedAt      *time.Time `json:"syncedAt,omitempty"`
+	DeletedAt     *time.Time `json:"deletedAt,omitempty"`
+}
+
+// stale reports whether the cached copy should be refreshed. Deleted products are never refreshed.
+func (p *Product) stale(now time.Time) bool {
+	if p == nil {
+		return true
+	}
+	return p.DeletedAt == nil && (p.SyncedAt == nil || now.Sub(*p.SyncedAt) > productTTL)
+}
+
+// SaveProduct caches p as the shop's current copy of the product.
+func (r *Repository) SaveProduct(ctx context.Context, shopID string, p *shopify.Product) error {
+	return saveProduct(ctx, r.db, shopID, p)
+}
+
+func saveProduct(ctx context.Context, q Querier, shopID string, p *shopify.Product) error {
+	variants, err := json.Marshal(p.Variants)
+	if err != nil {
+		return err
+	}
+	tags, collections := p.Tags, p.CollectionIDs
+	if tags == nil {
+		tags = []string{}
+	}
+	if collections == nil {
+		collections = []string{}
+	}
+	const sql = `
+INSERT INTO shopify_products (shop_id, shopify_product_id, title, status, image_url, variants, tags, collection_ids, synced_at, deleted_at)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, NOW(), NULL)
+ON CONFLICT (shop_id, shopify_product_id) DO UPDATE SET
+  title = EXCLUDED.title,
+  status = EXCLUDED.status,
+  image_url = EXCLUDED.image_url,
+  variants = EXCLUDED.variants,
+  tags = EXCLUDED.tags,
+  collection_ids = EXCLUDED.collection_ids,
+  synced_at = EXCLUDED.synced_at,
+  deleted_at = NULL
+`
+	_, err = q.Exec(ctx, sql, shopID, p.ID, p.Title, p.Status, p.ImageURL, variants, tags, collections)
+	return err
+}
+
+// ProductFacts are the product attributes service config rules match on.
+type ProductFacts struct {
+	Tags          []string
+	CollectionIDs []string
+}
+
+// FactsFunc loads the facts of the ordered product. Resolve only calls it when a rule needs them; nil facts
+// mean they are unavailable.
+type FactsFunc func(ctx context.Context) (*ProductFacts, error)
+
+// CachedProductFacts reads the facts from the product cache only.
+func CachedProductFacts(q Querier, shopID, productID string) FactsFunc {
+	return func(ctx context.Context) (*ProductFacts, error) {
+		return cachedFacts(ctx, q, shopID, productID)
+	}
+}
+
+// RefreshOrdered brings the cached copies of an order's products up to date from Shopify, so that tag and
+// collection rules resolving the order see them as they are now. It is called before the order's transaction,
+// never inside it, and is best effort: ctx bounds it, and a product that cannot be fetched keeps its cached copy.
+// Shopify is only called when the shop has a rule that needs product data, and only for products without a
+// product-level config of their own, which takes precedence over the rules anyway.
+func (r *Repository) RefreshOrdered(ctx context.Context, client shopify.Client, shopID string, productIDs []string) {
+	rules, err := loadRules(ctx, r.db, shopID)
+	if err != nil || !needsFacts(rules) {
+		return
+	}
+	const qConfigured = `
+SELECT EXISTS (
+  SELECT 1 FROM service_product_configs
+  WHERE shop_id = $1 AND shopify_product_id = $2 AND shopify_variant_id = '' AND deleted_at IS NULL
+)
+`
+	for _, id := range productIDs {
+		var configured bool
+		if err := r.db.QueryRow(ctx, qConfigured, shopID, id).Scan(&configured); err != nil || configured {
+			continue
+		}
+		p, err := client.Product(ctx, id)
+		if err != nil {
+			continue
+		}
+		_ = r.SaveProduct(ctx, shopID, p)
+	}
+}
+
+func cachedFacts(ctx context.Context, q Querier, shopID, productID string) (*ProductFacts, error) {
+	const sql = `
+SELECT tags, collection_ids FROM shopify_products
+WHERE shop_id = $1 AND shopify_product_id = $2 AND synced_at IS NOT NULL
+`
+	var f ProductFacts
+	err := q.QueryRow(ctx, sql, shopID, productID).Scan(&f.Tags, &f.CollectionIDs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// MarkProductDeleted records that Shopify deleted the product. When the product or any of its variants has a
+// config the deletion is audited; configured reports whether it had one. Marking an already deleted product
+// again is a no-op.
+func MarkProductDeleted(ctx context.Context, tx pgx.Tx, shopID, shopifyProductID, actor string) (configured bool, err error) {
+	const q = `
+INSERT INTO shopify_products (shop_id, shopify_product_id, deleted_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (shop_id, shopify_product_id) DO UPDATE SET deleted_at = NOW()
+WHERE shopify_products.deleted_at IS NULL
+`
+	tag, err := tx.Exec(ctx, q, shopID, shopifyProductID)
+	if err != nil || tag.RowsAffected() == 0 {
+		return false, err
+	}
+
+	variants := []string{}
+	const qConfigs = `
+SELECT shopify_variant_id FROM service_product_configs
+WHERE shop_id = $1 AND shopify_product_id = $2 AND deleted_at IS NULL
+ORDER BY shopify_variant_id
+`
+	rows, err := tx.Query(ctx, qConfigs, shopID, shopifyProductID)
+	if err != nil {
+		return false, err
+	}
+	configs := 0
+	for rows.Next() {
+		var variantID string
+		if err := rows.Scan(&variantID); err != nil {
+			rows.Close()
+			return false, err
+		}
+		configs++
+		if variantID != "" {
+			variants = append(variants, variantID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || configs == 0 {
+		return false, err
+	}
+	return true, audit.Insert(ctx, tx, shopID, nil, "SERVICE_PRODUCT_DELETED", actor, map[string]any{"shopifyProductId": shopifyProductID, "variantConfigs": variants})
+}
+
+// refreshProducts re-fetches up to limit stale products of recs from Shopify and updates both the cache and
+// recs. It is best effort: a failed fetch keeps the cached copy. A product Shopify no longer has is marked
+// deleted, in case the products/delete webhook was missed.
+func (r *Repository) refreshProducts(ctx context.Context, client shopify.Client, shopID string, recs []Record, limit int) {
+	now := time.Now()
+	for i := range recs {
+		if limit <= 0 {
+			return
+		}
+		if !recs[i].Product.stale(now) {
+			continue
+		}
+		limit--
+
+		p, err := client.Product(ctx, recs[i].ShopifyProductID)
+		if errors.Is(err, shopify.ErrProductNotFound) {
+			_ = db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+				_, err := MarkProductDeleted(ctx, tx, shopID, recs[i].ShopifyProductID, "system")
+				return err
+			})
+			if recs[i].Product == nil {
+				recs[i].Product = &Product{Variants: []shopify.ProductVariant{}, Tags: []string{}, CollectionIDs: []string{}}
+			}
+			recs[i].Product.DeletedAt = &now
+			recs[i].ProductDeleted = true
+			continue
+		}
+		if err != nil || r.SaveProduct(ctx, shopID, p) != nil {
+			continue
+		}
+		recs[i].Product = &Product{
+			Title: p.Title, Status: p.Status, ImageURL: p.ImageURL, Variants: p.Variants,
+			Tags: p.Tags, CollectionIDs: p.CollectionIDs, SyncedAt: &now,
+		}
+	}
+}
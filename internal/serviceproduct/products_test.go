@@ -0,0 +1,28 @@
+package serviceproduct
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProductStale(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	fresh, old := now.Add(-time.Hour), now.Add(-productTTL-time.Minute)
+
+	cases := []struct {
+		name string
+		p    *Product
+		want bool
+	}{
+		{"never fetched", nil, true},
+		{"only deletion known", &Product{DeletedAt: &fresh}, false},
+		{"fresh", &Product{SyncedAt: &fresh}, false},
+		{"expired", &Product{SyncedAt: &old}, true},
+		{"expired but deleted", &Product{SyncedAt: &old, DeletedAt: &fresh}, false},
+	}
+	for _, c := range cases {
+		if got := c.p.stale(now); got != c.want {
+			t.Errorf("%s: stale = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
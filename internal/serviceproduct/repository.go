@@ -3,8 +3,15 @@ package serviceproduct
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/audit"
+	"microservice/pkg/db"
 )
 
 type Repository struct {
@@ -16,38 +23,272 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 }
 
 type Record struct {
-	ID              string          `json:"id"`
+	ID               string          `json:"id"`
 	ShopID           string          `json:"shopId"`
 	ShopifyProductID string          `json:"shopifyProductId"`
+	ShopifyVariantID string          `json:"shopifyVariantId,omitempty"` // empty for the product-level config
 	Config           json.RawMessage `json:"config"`
+	ConfigVersion    int             `json:"configVersion"` // the active version
+	Enabled          bool            `json:"enabled"`       // disabled configs create no services
 	CreatedAt        string          `json:"createdAt"`
 	UpdatedAt        string          `json:"updatedAt"`
+	// Product is the cached Shopify product; nil until it was first fetched. Only List fills it.
+	Product        *Product `json:"product,omitempty"`
+	ProductDeleted bool     `json:"productDeleted"`
 }
 
-func (r *Repository) Upsert(ctx context.Context, shopID, shopifyProductID string, cfg json.RawMessage) (*Record, error) {
-	const q = `
-INSERT INTO service_product_configs (shop_id, shopify_product_id, config)
-VALUES ($1, $2, $3)
-ON CONFLICT (shop_id, shopify_product_id) DO UPDATE SET
-  config = EXCLUDED.config,
-  updated_at = NOW()
-RETURNING id, shop_id, shopify_product_id, config, created_at::text, updated_at::text
+// Version is one saved revision of a product's config. Versions are numbered per product from 1 and never
+// change once saved; restoring an old version saves it again as a new one.
+type Version struct {
+	Version      int             `json:"version"`
+	Config       json.RawMessage `json:"config"`
+	Active       bool            `json:"active"`                 // the version new services are created from
+	ActiveFrom   *time.Time      `json:"activeFrom,omitempty"`   // set for scheduled versions
+	ActivatedAt  *time.Time      `json:"activatedAt,omitempty"`  // nil while scheduled
+	RestoredFrom *int            `json:"restoredFrom,omitempty"` // the version this one copies
+	Superseded   bool            `json:"superseded,omitempty"`   // scheduled, but a newer version is active: it never activates
+	CreatedBy    string          `json:"createdBy"`
+	CreatedAt    time.Time       `json:"createdAt"`
+}
+
+// ErrDisabled is returned for a config that exists but is disabled.
+var ErrDisabled = errors.New("service product config is disabled")
+
+// Querier is satisfied by both a pool and a transaction.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// ActiveConfig returns the config new services of the product (or, with a non-empty variantID, of that variant)
+// are created from, and its version. A scheduled version whose activeFrom has passed is used even before the
+// activation job has promoted it, unless a newer version was activated since. It does not fall back from a
+// variant to its product; see Resolve. A disabled config is ErrDisabled.
+func ActiveConfig(ctx context.Context, q Querier, shopID, productID, variantID string) (json.RawMessage, int, error) {
+	const sql = `
+SELECT COALESCE(v.config, c.config), COALESCE(v.version, c.version), c.enabled
+FROM service_product_configs c
+LEFT JOIN LATERAL (
+  SELECT config, version
+  FROM service_product_config_versions
+  WHERE config_id = c.id AND activated_at IS NULL AND active_from <= NOW() AND version > c.version
+  ORDER BY version DESC
+  LIMIT 1
+) v ON TRUE
+WHERE c.shop_id = $1 AND c.shopify_product_id = $2 AND c.shopify_variant_id = $3 AND c.deleted_at IS NULL
 `
-	rec := &Record{}
-	if err := r.db.QueryRow(ctx, q, shopID, shopifyProductID, cfg).Scan(
-		&rec.ID, &rec.ShopID, &rec.ShopifyProductID, &rec.Config, &rec.CreatedAt, &rec.UpdatedAt,
-	); err != nil {
+	var cfg json.RawMessage
+	var version int
+	var enabled bool
+	if err := q.QueryRow(ctx, sql, shopID, productID, variantID).Scan(&cfg, &version, &enabled); err != nil {
+		return nil, 0, err
+	}
+	if !enabled {
+		return nil, 0, ErrDisabled
+	}
+	return cfg, version, nil
+}
+
+// Resolve returns the config an order of the variant creates services from; see the package-level Resolve.
+// Rules are matched against the cached product, without calling Shopify.
+func (r *Repository) Resolve(ctx context.Context, shopID, shopifyProductID, shopifyVariantID string) (*Resolved, error) {
+	return Resolve(ctx, r.db, shopID, shopifyProductID, shopifyVariantID, CachedProductFacts(r.db, shopID, shopifyProductID))
+}
+
+// Upsert saves cfg as the next version of the product's (or variant's) config and makes it active now. A
+// disabled config stays disabled; a deleted one comes back enabled, numbering on from its last version.
+func (r *Repository) Upsert(ctx context.Context, shopID, shopifyProductID, shopifyVariantID string, cfg json.RawMessage, actor string) (*Record, error) {
+	var rec *Record
+	err := db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		const qEnsure = `
+INSERT INTO service_product_configs (shop_id, shopify_product_id, shopify_variant_id, config)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (shop_id, shopify_product_id, shopify_variant_id) DO UPDATE SET enabled = TRUE, deleted_at = NULL
+WHERE service_product_configs.deleted_at IS NOT NULL
+`
+		if _, err := tx.Exec(ctx, qEnsure, shopID, shopifyProductID, shopifyVariantID, cfg); err != nil {
+			return err
+		}
+		configID, err := lockConfig(ctx, tx, shopID, shopifyProductID, shopifyVariantID)
+		if err != nil {
+			return err
+		}
+		v, err := insertVersion(ctx, tx, configID, cfg, nil, nil, actor)
+		if err != nil {
+			return err
+		}
+		rec, err = activate(ctx, tx, configID, v)
+		if err != nil {
+			return err
+		}
+		data := auditKey(shopifyProductID, shopifyVariantID)
+		data["version"] = v.Version
+		return audit.Insert(ctx, tx, shopID, nil, "SERVICE_PRODUCT_CONFIG_SAVED", actor, data)
+	})
+	return rec, err
+}
+
+// Schedule saves cfg as the next version of the product's (or variant's) config, to become active at
+// activeFrom. The config must already exist; pgx.ErrNoRows otherwise.
+func (r *Repository) Schedule(ctx context.Context, shopID, shopifyProductID, shopifyVariantID string, cfg json.RawMessage, activeFrom time.Time, actor string) (*Version, error) {
+	var v *Version
+	err := db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		configID, err := lockConfig(ctx, tx, shopID, shopifyProductID, shopifyVariantID)
+		if err != nil {
+			return err
+		}
+		v, err = insertVersion(ctx, tx, configID, cfg, &activeFrom, nil, actor)
+		if err != nil {
+			return err
+		}
+		data := auditKey(shopifyProductID, shopifyVariantID)
+		data["version"], data["activeFrom"] = v.Version, activeFrom
+		return audit.Insert(ctx, tx, shopID, nil, "SERVICE_PRODUCT_CONFIG_SCHEDULED", actor, data)
+	})
+	return v, err
+}
+
+// Restore saves the config of an earlier version as the next version, active now or, with a non-nil
+// activeFrom, from then on. It returns pgx.ErrNoRows when the config or version does not exist.
+func (r *Repository) Restore(ctx context.Context, shopID, shopifyProductID, shopifyVariantID string, version int, activeFrom *time.Time, actor string) (*Version, error) {
+	var v *Version
+	err := db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		configID, err := lockConfig(ctx, tx, shopID, shopifyProductID, shopifyVariantID)
+		if err != nil {
+			return err
+		}
+		old, err := getVersion(ctx, tx, configID, version)
+		if err != nil {
+			return err
+		}
+		v, err = insertVersion(ctx, tx, configID, old.Config, activeFrom, &old.Version, actor)
+		if err != nil {
+			return err
+		}
+		if activeFrom == nil {
+			if _, err := activate(ctx, tx, configID, v); err != nil {
+				return err
+			}
+		}
+		data := auditKey(shopifyProductID, shopifyVariantID)
+		data["version"], data["restoredFrom"], data["activeFrom"] = v.Version, old.Version, activeFrom
+		return audit.Insert(ctx, tx, shopID, nil, "SERVICE_PRODUCT_CONFIG_RESTORED", actor, data)
+	})
+	return v, err
+}
+
+// Versions lists the config's versions, newest first. It returns pgx.ErrNoRows when there is no config.
+func (r *Repository) Versions(ctx context.Context, shopID, shopifyProductID, shopifyVariantID string) ([]Version, error) {
+	var configID string
+	var active int
+	const qConfig = `
+SELECT id, version FROM service_product_configs
+WHERE shop_id = $1 AND shopify_product_id = $2 AND shopify_variant_id = $3 AND deleted_at IS NULL
+`
+	if err := r.db.QueryRow(ctx, qConfig, shopID, shopifyProductID, shopifyVariantID).Scan(&configID, &active); err != nil {
 		return nil, err
 	}
-	return rec, nil
+
+	const q = selectVersion + `
+WHERE config_id = $1
+ORDER BY version DESC
+`
+	rows, err := r.db.Query(ctx, q, configID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Version
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		v.Active = v.Version == active
+		v.Superseded = v.ActivatedAt == nil && v.Version < active
+		out = append(out, *v)
+	}
+	return out, rows.Err()
+}
+
+// GetVersion returns one version of the product's (or variant's) config.
+func (r *Repository) GetVersion(ctx context.Context, shopID, shopifyProductID, shopifyVariantID string, version int) (*Version, error) {
+	const q = selectVersion + `
+WHERE config_id = (
+  SELECT id FROM service_product_configs
+  WHERE shop_id = $1 AND shopify_product_id = $2 AND shopify_variant_id = $3 AND deleted_at IS NULL
+)
+  AND version = $4
+`
+	return scanVersion(r.db.QueryRow(ctx, q, shopID, shopifyProductID, shopifyVariantID, version))
+}
+
+// SetEnabled enables or disables the product's (or variant's) config. Disabling pauses the product: its orders
+// create no services until it is enabled again. It returns pgx.ErrNoRows when there is no config.
+func (r *Repository) SetEnabled(ctx context.Context, shopID, shopifyProductID, shopifyVariantID string, enabled bool, actor string) (*Record, error) {
+	var rec *Record
+	err := db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		configID, err := lockConfig(ctx, tx, shopID, shopifyProductID, shopifyVariantID)
+		if err != nil {
+			return err
+		}
+		const qGet = `SELECT ` + recordColumns + ` FROM service_product_configs WHERE id = $1`
+		if rec, err = scanRecord(tx.QueryRow(ctx, qGet, configID)); err != nil || rec.Enabled == enabled {
+			return err
+		}
+		const q = `
+UPDATE service_product_configs SET enabled = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING ` + recordColumns + `
+`
+		if rec, err = scanRecord(tx.QueryRow(ctx, q, configID, enabled)); err != nil {
+			return err
+		}
+		action := "SERVICE_PRODUCT_CONFIG_DISABLED"
+		if enabled {
+			action = "SERVICE_PRODUCT_CONFIG_ENABLED"
+		}
+		data := auditKey(shopifyProductID, shopifyVariantID)
+		data["version"] = rec.ConfigVersion
+		return audit.Insert(ctx, tx, shopID, nil, action, actor, data)
+	})
+	return rec, err
+}
+
+// Delete marks the product's (or variant's) config deleted: orders of it no longer create services, and its
+// scheduled versions never activate. The versions are kept, so services created from it still name a version
+// that exists, and saving the config again numbers on from there. It returns pgx.ErrNoRows when there is no
+// config.
+func (r *Repository) Delete(ctx context.Context, shopID, shopifyProductID, shopifyVariantID, actor string) error {
+	return db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		const q = `
+UPDATE service_product_configs SET deleted_at = NOW(), updated_at = NOW()
+WHERE shop_id = $1 AND shopify_product_id = $2 AND shopify_variant_id = $3 AND deleted_at IS NULL
+RETURNING version, enabled
+`
+		var version int
+		var enabled bool
+		if err := tx.QueryRow(ctx, q, shopID, shopifyProductID, shopifyVariantID).Scan(&version, &enabled); err != nil {
+			return err
+		}
+		data := auditKey(shopifyProductID, shopifyVariantID)
+		data["version"], data["enabled"] = version, enabled
+		return audit.Insert(ctx, tx, shopID, nil, "SERVICE_PRODUCT_CONFIG_DELETED", actor, data)
+	})
 }
 
+// List returns the shop's product and variant configs with their cached products, most recently updated first.
 func (r *Repository) List(ctx context.Context, shopID string) ([]Record, error) {
 	const q = `
-SELECT id, shop_id, shopify_product_id, config, created_at::text, updated_at::text
-FROM service_product_configs
-WHERE shop_id = $1
-ORDER BY updated_at DESC
+SELECT c.id, c.shop_id, c.shopify_product_id, c.shopify_variant_id, c.config, c.version, c.enabled, c.created_at::text, c.updated_at::text,
+  p.shopify_product_id IS NOT NULL, COALESCE(p.title, ''), COALESCE(p.status, ''), COALESCE(p.image_url, ''),
+  COALESCE(p.variants, '[]'::jsonb), COALESCE(p.tags, '{}'), COALESCE(p.collection_ids, '{}'), p.synced_at, p.deleted_at
+FROM service_product_configs c
+LEFT JOIN shopify_products p ON p.shop_id = c.shop_id AND p.shopify_product_id = c.shopify_product_id
+WHERE c.shop_id = $1 AND c.deleted_at IS NULL
+ORDER BY c.updated_at DESC
 `
 	rows, err := r.db.Query(ctx, q, shopID)
 	if err != nil {
@@ -58,12 +299,105 @@ ORDER BY updated_at DESC
 	var out []Record
 	for rows.Next() {
 		var rec Record
-		if err := rows.Scan(&rec.ID, &rec.ShopID, &rec.ShopifyProductID, &rec.Config, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+		var cached bool
+		var p Product
+		var variants []byte
+		if err := rows.Scan(
+			&rec.ID, &rec.ShopID, &rec.ShopifyProductID, &rec.ShopifyVariantID, &rec.Config, &rec.ConfigVersion, &rec.Enabled, &rec.CreatedAt, &rec.UpdatedAt,
+			&cached, &p.Title, &p.Status, &p.ImageURL, &variants, &p.Tags, &p.CollectionIDs, &p.SyncedAt, &p.DeletedAt,
+		); err != nil {
 			return nil, err
 		}
+		if cached {
+			if err := json.Unmarshal(variants, &p.Variants); err != nil {
+				return nil, err
+			}
+			rec.Product = &p
+			rec.ProductDeleted = p.DeletedAt != nil
+		}
 		out = append(out, rec)
 	}
 	return out, rows.Err()
 }
 
+const recordColumns = `id, shop_id, shopify_product_id, shopify_variant_id, config, version, enabled, created_at::text, updated_at::text`
+
+func scanRecord(row pgx.Row) (*Record, error) {
+	rec := &Record{}
+	if err := row.Scan(
+		&rec.ID, &rec.ShopID, &rec.ShopifyProductID, &rec.ShopifyVariantID, &rec.Config, &rec.ConfigVersion, &rec.Enabled, &rec.CreatedAt, &rec.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+const selectVersion = `
+SELECT version, config, active_from, activated_at, restored_from, created_by, created_at
+FROM service_product_config_versions
+`
 
+func scanVersion(row pgx.Row) (*Version, error) {
+	var v Version
+	if err := row.Scan(&v.Version, &v.Config, &v.ActiveFrom, &v.ActivatedAt, &v.RestoredFrom, &v.CreatedBy, &v.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// lockConfig locks the config row, which serializes version numbering. A deleted config is pgx.ErrNoRows.
+func lockConfig(ctx context.Context, tx pgx.Tx, shopID, shopifyProductID, shopifyVariantID string) (string, error) {
+	const q = `
+SELECT id FROM service_product_configs
+WHERE shop_id = $1 AND shopify_product_id = $2 AND shopify_variant_id = $3 AND deleted_at IS NULL
+FOR UPDATE
+`
+	var id string
+	err := tx.QueryRow(ctx, q, shopID, shopifyProductID, shopifyVariantID).Scan(&id)
+	return id, err
+}
+
+// auditKey is the audit data identifying a config; the variant is only named for variant configs.
+func auditKey(shopifyProductID, shopifyVariantID string) map[string]any {
+	data := map[string]any{"shopifyProductId": shopifyProductID}
+	if shopifyVariantID != "" {
+		data["shopifyVariantId"] = shopifyVariantID
+	}
+	return data
+}
+
+func getVersion(ctx context.Context, tx pgx.Tx, configID string, version int) (*Version, error) {
+	const q = selectVersion + `WHERE config_id = $1 AND version = $2`
+	return scanVersion(tx.QueryRow(ctx, q, configID, version))
+}
+
+func insertVersion(ctx context.Context, tx pgx.Tx, configID string, cfg json.RawMessage, activeFrom *time.Time, restoredFrom *int, actor string) (*Version, error) {
+	const q = `
+INSERT INTO service_product_config_versions (config_id, version, config, active_from, restored_from, created_by)
+SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5
+FROM service_product_config_versions
+WHERE config_id = $1
+RETURNING version, config, active_from, activated_at, restored_from, created_by, created_at
+`
+	return scanVersion(tx.QueryRow(ctx, q, configID, cfg, activeFrom, restoredFrom, actor))
+}
+
+// activate makes v the product's active config.
+func activate(ctx context.Context, tx pgx.Tx, configID string, v *Version) (*Record, error) {
+	const qVersion = `
+UPDATE service_product_config_versions SET activated_at = NOW()
+WHERE config_id = $1 AND version = $2
+RETURNING activated_at
+`
+	if err := tx.QueryRow(ctx, qVersion, configID, v.Version).Scan(&v.ActivatedAt); err != nil {
+		return nil, err
+	}
+	v.Active = true
+
+	const qConfig = `
+UPDATE service_product_configs SET config = $2, version = $3, updated_at = NOW()
+WHERE id = $1
+RETURNING ` + recordColumns + `
+`
+	return scanRecord(tx.QueryRow(ctx, qConfig, configID, v.Config, v.Version))
+}
@@ -0,0 +1,139 @@
+package serviceproduct
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Where a resolved config came from.
+const (
+	SourceVariant = "variant" // the ordered variant's own config
+	SourceProduct = "product" // the product's config
+	SourceRule    = "rule"    // a shop rule, with its own config or the shop default template
+)
+
+// Resolution records how an order line was resolved to a config. It is stored in the service snapshot so the
+// choice can be explained later, whatever the configs and rules look like by then.
+type Resolution struct {
+	Source          string   `json:"source"`
+	ProductID       string   `json:"productId"`
+	VariantID       string   `json:"variantId,omitempty"`     // the ordered variant
+	ConfigVersion   int      `json:"configVersion,omitempty"` // product and variant configs only; rules are not versioned
+	Rule            *RuleRef `json:"rule,omitempty"`
+	DefaultTemplate bool     `json:"defaultTemplate,omitempty"` // the rule used the shop default template
+	// ProductDataUnavailable is set when tag and collection rules could not be evaluated because neither
+	// Shopify nor the product cache had the product's tags and collections.
+	ProductDataUnavailable bool `json:"productDataUnavailable,omitempty"`
+}
+
+// RuleRef identifies the rule a config was resolved by, as it was at the time.
+type RuleRef struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`
+	Match    string `json:"match,omitempty"`
+	Priority int    `json:"priority"`
+}
+
+// Resolved is the config an ordered product variant creates its service from.
+type Resolved struct {
+	Config json.RawMessage
+	Resolution
+}
+
+// Snapshot is the config with the resolution added under "resolution", as stored on the service.
+func (r *Resolved) Snapshot() (json.RawMessage, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(r.Config, &doc); err != nil {
+		return nil, err
+	}
+	res, err := json.Marshal(r.Resolution)
+	if err != nil {
+		return nil, err
+	}
+	doc["resolution"] = res
+	return json.Marshal(doc)
+}
+
+// Resolve finds the config for an order of the variant (variantID may be empty). The first of these applies:
+//
+//  1. the variant's own config;
+//  2. the product's config;
+//  3. the first matching shop rule in evaluation order (see Rule), with its own config or the shop default
+//     template. A rule relying on the default is skipped while the shop has none.
+//
+// A disabled variant or product config stops the resolution with ErrDisabled: the merchant paused it, so
+// neither the product config nor a rule stands in. facts is only called when a tag or collection rule has to be
+// evaluated. Resolve returns pgx.ErrNoRows when nothing applies, i.e. the product is not a service product.
+func Resolve(ctx context.Context, q Querier, shopID, productID, variantID string, facts FactsFunc) (*Resolved, error) {
+	res := &Resolved{Resolution: Resolution{ProductID: productID, VariantID: variantID}}
+	if variantID != "" {
+		cfg, version, err := ActiveConfig(ctx, q, shopID, productID, variantID)
+		if err == nil {
+			res.Config, res.Source, res.ConfigVersion = cfg, SourceVariant, version
+			return res, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+	}
+	cfg, version, err := ActiveConfig(ctx, q, shopID, productID, "")
+	if err == nil {
+		res.Config, res.Source, res.ConfigVersion = cfg, SourceProduct, version
+		return res, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	rules, err := loadRules(ctx, q, shopID)
+	if err != nil {
+		return nil, err
+	}
+	var pf *ProductFacts
+	if needsFacts(rules) && facts != nil {
+		if pf, err = facts(ctx); err != nil {
+			return nil, err
+		}
+	}
+	res.ProductDataUnavailable = needsFacts(rules) && pf == nil
+
+	var def *DefaultTemplate
+	if usesDefault(rules) {
+		def, err = getDefault(ctx, q, shopID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+	}
+	rule := firstRule(rules, pf, def != nil)
+	if rule == nil {
+		return nil, pgx.ErrNoRows
+	}
+	res.Config, res.Source = rule.Config, SourceRule
+	if len(rule.Config) == 0 {
+		res.Config, res.DefaultTemplate = def.Config, true
+	}
+	res.Rule = &RuleRef{ID: rule.ID, Kind: rule.Kind, Match: rule.Match, Priority: rule.Priority}
+	return res, nil
+}
+
+// firstRule is the first rule, in evaluation order, that matches the product and has a config to apply.
+func firstRule(rules []Rule, facts *ProductFacts, hasDefault bool) *Rule {
+	for i := range rules {
+		if rules[i].matches(facts) && (len(rules[i].Config) > 0 || hasDefault) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func usesDefault(rules []Rule) bool {
+	for _, r := range rules {
+		if len(r.Config) == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package serviceproduct
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"microservice/internal/audit"
+	"microservice/internal/milestone"
+	"microservice/pkg/db"
+)
+
+// Rule kinds, in the order rules of equal priority are evaluated.
+const (
+	RuleTag        = "tag"        // every product with the tag
+	RuleCollection = "collection" // every product in the collection
+	RuleAll        = "all"        // every product of the shop
+)
+
+var ruleKindOrder = map[string]int{RuleTag: 0, RuleCollection: 1, RuleAll: 2}
+
+// Rule makes products without their own config service products. Rules are evaluated by ascending priority,
+// then kind (tag, collection, all), then match, so exactly one rule wins however they were created.
+type Rule struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`
+	Match    string `json:"match,omitempty"` // lower-cased tag or numeric collection id; empty for all
+	Priority int    `json:"priority"`        // lower first
+	// Config is the rule's own template; nil means the shop default template.
+	Config    json.RawMessage `json:"config,omitempty"`
+	CreatedBy string          `json:"createdBy"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// NormalizeRule validates a rule's kind and match and returns the match as it is stored.
+func NormalizeRule(kind, match string) (string, error) {
+	match = strings.TrimSpace(match)
+	switch kind {
+	case RuleTag:
+		match = strings.ToLower(match)
+	case RuleCollection:
+		match = strings.TrimPrefix(match, "gid://shopify/Collection/")
+		if n, err := strconv.ParseInt(match, 10, 64); err != nil || n <= 0 || strconv.FormatInt(n, 10) != match {
+			return "", milestone.ValidationError{Code: "RULE_INVALID", Message: "collection rules match a numeric collection id"}
+		}
+	case RuleAll:
+		if match != "" {
+			return "", milestone.ValidationError{Code: "RULE_INVALID", Message: "all rules take no match"}
+		}
+		return "", nil
+	default:
+		return "", milestone.ValidationError{Code: "RULE_INVALID", Message: "kind must be tag, collection or all"}
+	}
+	if match == "" {
+		return "", milestone.ValidationError{Code: "RULE_INVALID", Message: kind + " rules need a match"}
+	}
+	return match, nil
+}
+
+// sortRules puts rules in evaluation order.
+func sortRules(rules []Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		if ruleKindOrder[a.Kind] != ruleKindOrder[b.Kind] {
+			return ruleKindOrder[a.Kind] < ruleKindOrder[b.Kind]
+		}
+		return a.Match < b.Match
+	})
+}
+
+// needsFacts reports whether any rule matches on product tags or collections.
+func needsFacts(rules []Rule) bool {
+	for _, r := range rules {
+		if r.Kind != RuleAll {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule applies to a product with the given facts; nil facts match only all rules.
+func (r Rule) matches(f *ProductFacts) bool {
+	if r.Kind == RuleAll {
+		return true
+	}
+	if f == nil {
+		return false
+	}
+	switch r.Kind {
+	case RuleTag:
+		for _, t := range f.Tags {
+			if strings.ToLower(strings.TrimSpace(t)) == r.Match {
+				return true
+			}
+		}
+	case RuleCollection:
+		for _, id := range f.CollectionIDs {
+			if id == r.Match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DefaultTemplate is the shop's default service template, used by rules without a config of their own.
+type DefaultTemplate struct {
+	Config    json.RawMessage `json:"config"`
+	UpdatedBy string          `json:"updatedBy"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// Default returns the shop default template; pgx.ErrNoRows when the shop has none.
+func (r *Repository) Default(ctx context.Context, shopID string) (*DefaultTemplate, error) {
+	return getDefault(ctx, r.db, shopID)
+}
+
+func getDefault(ctx context.Context, q Querier, shopID string) (*DefaultTemplate, error) {
+	const sql = `SELECT config, updated_by, updated_at FROM service_config_defaults WHERE shop_id = $1`
+	var d DefaultTemplate
+	if err := q.QueryRow(ctx, sql, shopID).Scan(&d.Config, &d.UpdatedBy, &d.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// PutDefault saves the shop default template.
+func (r *Repository) PutDefault(ctx context.Context, shopID string, cfg json.RawMessage, actor string) (*DefaultTemplate, error) {
+	var d DefaultTemplate
+	err := db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		const q = `
+INSERT INTO service_config_defaults (shop_id, config, updated_by, updated_at)
+VALUES ($1, $2, $3, NOW())
+ON CONFLICT (shop_id) DO UPDATE SET config = EXCLUDED.config, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+RETURNING config, updated_by, updated_at
+`
+		if err := tx.QueryRow(ctx, q, shopID, cfg, actor).Scan(&d.Config, &d.UpdatedBy, &d.UpdatedAt); err != nil {
+			return err
+		}
+		return audit.Insert(ctx, tx, shopID, nil, "SERVICE_DEFAULT_TEMPLATE_SAVED", actor, map[string]any{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Rules lists the shop's rules in evaluation order.
+func (r *Repository) Rules(ctx context.Context, shopID string) ([]Rule, error) {
+	return loadRules(ctx, r.db, shopID)
+}
+
+func loadRules(ctx context.Context, q Querier, shopID string) ([]Rule, error) {
+	const sql = selectRule + `WHERE shop_id = $1`
+	rows, err := q.Query(ctx, sql, shopID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Rule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortRules(out)
+	return out, nil
+}
+
+// PutRule creates the rule for kind and match, or updates its priority and config. match must be normalized
+// (see NormalizeRule); a nil cfg makes the rule use the shop default template.
+func (r *Repository) PutRule(ctx context.Context, shopID, kind, match string, priority int, cfg json.RawMessage, actor string) (*Rule, error) {
+	var rule *Rule
+	err := db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		const q = `
+INSERT INTO service_config_rules (shop_id, kind, match, priority, config, created_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (shop_id, kind, match) DO UPDATE SET
+  priority = EXCLUDED.priority,
+  config = EXCLUDED.config,
+  updated_at = NOW()
+RETURNING id, kind, match, priority, config, created_by, created_at, updated_at
+`
+		var err error
+		rule, err = scanRule(tx.QueryRow(ctx, q, shopID, kind, match, priority, cfg, actor))
+		if err != nil {
+			return err
+		}
+		return audit.Insert(ctx, tx, shopID, nil, "SERVICE_CONFIG_RULE_SAVED", actor, map[string]any{
+			"ruleId": rule.ID, "kind": kind, "match": match, "priority": priority, "defaultTemplate": len(cfg) == 0,
+		})
+	})
+	return rule, err
+}
+
+// DeleteRule removes a rule; pgx.ErrNoRows when the shop has no such rule.
+func (r *Repository) DeleteRule(ctx context.Context, shopID, ruleID, actor string) error {
+	return db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		const q = `
+DELETE FROM service_config_rules WHERE shop_id = $1 AND id = $2
+RETURNING id, kind, match, priority, config, created_by, created_at, updated_at
+`
+		rule, err := scanRule(tx.QueryRow(ctx, q, shopID, ruleID))
+		if err != nil {
+			return err
+		}
+		return audit.Insert(ctx, tx, shopID, nil, "SERVICE_CONFIG_RULE_DELETED", actor, map[string]any{
+			"ruleId": rule.ID, "kind": rule.Kind, "match": rule.Match, "priority": rule.Priority,
+		})
+	})
+}
+
+const selectRule = `
+SELECT id, kind, match, priority, config, created_by, created_at, updated_at
+FROM service_config_rules
+`
+
+func scanRule(row pgx.Row) (*Rule, error) {
+	var r Rule
+	if err := row.Scan(&r.ID, &r.Kind, &r.Match, &r.Priority, &r.Config, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
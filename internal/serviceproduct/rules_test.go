@@ -0,0 +1,111 @@
+package serviceproduct
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"microservice/internal/milestone"
+)
+
+func TestNormalizeRule(t *testing.T) {
+	cases := []struct {
+		kind, match, want, code string
+	}{
+		{RuleTag, "  Service ", "service", ""},
+		{RuleCollection, "gid://shopify/Collection/77", "77", ""},
+		{RuleCollection, "77", "77", ""},
+		{RuleAll, "", "", ""},
+		{RuleTag, " ", "", "RULE_INVALID"},
+		{RuleCollection, "summer", "", "RULE_INVALID"},
+		{RuleCollection, "007", "", "RULE_INVALID"},
+		{RuleAll, "x", "", "RULE_INVALID"},
+		{"vendor", "acme", "", "RULE_INVALID"},
+	}
+	for _, c := range cases {
+		got, err := NormalizeRule(c.kind, c.match)
+		if c.code != "" {
+			var ve milestone.ValidationError
+			if !errors.As(err, &ve) || ve.Code != c.code {
+				t.Errorf("NormalizeRule(%q, %q): expected %s, got %v", c.kind, c.match, c.code, err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("NormalizeRule(%q, %q) = %q, %v; want %q", c.kind, c.match, got, err, c.want)
+		}
+	}
+}
+
+func TestSortRules_Deterministic(t *testing.T) {
+	want := []Rule{
+		{ID: "a", Kind: RuleAll, Priority: 10},
+		{ID: "b", Kind: RuleTag, Match: "pro", Priority: 50},
+		{ID: "c", Kind: RuleTag, Match: "service", Priority: 50},
+		{ID: "d", Kind: RuleCollection, Match: "12", Priority: 50},
+		{ID: "e", Kind: RuleAll, Priority: 50},
+		{ID: "f", Kind: RuleTag, Match: "basic", Priority: 100},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		rules := append([]Rule(nil), want...)
+		rng.Shuffle(len(rules), func(i, j int) { rules[i], rules[j] = rules[j], rules[i] })
+		sortRules(rules)
+		for j := range want {
+			if rules[j].ID != want[j].ID {
+				t.Fatalf("unexpected order %v", rules)
+			}
+		}
+	}
+}
+
+func TestFirstRule(t *testing.T) {
+	cfg := json.RawMessage(`{"templates":[]}`)
+	rules := []Rule{
+		{ID: "tag-default", Kind: RuleTag, Match: "service", Priority: 10},
+		{ID: "collection", Kind: RuleCollection, Match: "77", Priority: 20, Config: cfg},
+		{ID: "all", Kind: RuleAll, Priority: 30, Config: cfg},
+	}
+
+	facts := &ProductFacts{Tags: []string{"Service"}, CollectionIDs: []string{"77"}}
+	if got := firstRule(rules, facts, true); got == nil || got.ID != "tag-default" {
+		t.Fatalf("expected the tag rule, got %+v", got)
+	}
+	// Without a default template the tag rule has nothing to apply.
+	if got := firstRule(rules, facts, false); got == nil || got.ID != "collection" {
+		t.Fatalf("expected the collection rule, got %+v", got)
+	}
+	// Unknown product data only matches rules for every product.
+	if got := firstRule(rules, nil, true); got == nil || got.ID != "all" {
+		t.Fatalf("expected the all rule, got %+v", got)
+	}
+	if got := firstRule(rules[:2], &ProductFacts{Tags: []string{"merch"}}, true); got != nil {
+		t.Fatalf("expected no rule, got %+v", got)
+	}
+}
+
+func TestResolvedSnapshot(t *testing.T) {
+	res := &Resolved{
+		Config: json.RawMessage(`{"version":1,"templates":[{"type":"percentage","value":100,"isFinal":true}]}`),
+		Resolution: Resolution{
+			Source:          SourceRule,
+			ProductID:       "42",
+			VariantID:       "4201",
+			Rule:            &RuleRef{ID: "r1", Kind: RuleTag, Match: "service", Priority: 100},
+			DefaultTemplate: true,
+		},
+	}
+	snap, err := res.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"resolution":{"source":"rule","productId":"42","variantId":"4201","rule":{"id":"r1","kind":"tag","match":"service","priority":100},"defaultTemplate":true},"templates":[{"type":"percentage","value":100,"isFinal":true}],"version":1}`
+	if string(snap) != want {
+		t.Fatalf("unexpected snapshot\n got %s\nwant %s", snap, want)
+	}
+	// The snapshot still parses as the config it was resolved to.
+	if _, err := ParseAndValidate(snap); err != nil {
+		t.Fatalf("snapshot no longer parses: %v", err)
+	}
+}
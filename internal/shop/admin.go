@@ -0,0 +1,26 @@
+package shop
+
+import (
+	"strings"
+
+	"microservice/pkg/config"
+	"microservice/pkg/shopify"
+)
+
+// AdminClient is the Admin API client for a shop.
+func AdminClient(cfg config.Config, s *Shop) shopify.Client {
+	client := shopify.Client{
+		ShopDomain:  s.Domain,
+		AccessToken: s.AccessToken,
+		APIVersion:  cfg.Shopify.APIVersion,
+	}
+	if cfg.AppEnv != "prod" {
+		// Dev convenience: allow using a Shopify "Develop app" Admin API token (shpat_...) to bypass
+		// Protected Customer Data restrictions that apply to public apps.
+		if strings.TrimSpace(cfg.Shopify.DevAdminAccessToken) != "" {
+			client.AccessToken = strings.TrimSpace(cfg.Shopify.DevAdminAccessToken)
+		}
+		client.BaseURL = cfg.Shopify.AdminBaseURL
+	}
+	return client
+}
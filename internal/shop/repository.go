@@ -3,6 +3,7 @@ package shop
 import (
 	"context"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -21,11 +22,11 @@ VALUES ($1, $2, 'active')
 ON CONFLICT (shop_domain) DO UPDATE SET
   access_token = EXCLUDED.access_token,
   status = 'active'
-RETURNING id, shop_domain, access_token, COALESCE(plan,''), COALESCE(status,'active'), installed_at
+RETURNING id, shop_domain, access_token, COALESCE(plan,''), COALESCE(status,'active'), COALESCE(payment_rail,''), installed_at
 `
 	s := &Shop{}
 	if err := r.db.QueryRow(ctx, q, domain, accessToken).Scan(
-		&s.ID, &s.Domain, &s.AccessToken, &s.Plan, &s.Status, &s.InstalledAt,
+		&s.ID, &s.Domain, &s.AccessToken, &s.Plan, &s.Status, &s.PaymentRail, &s.InstalledAt,
 	); err != nil {
 		return nil, err
 	}
@@ -34,19 +35,42 @@ RETURNING id, shop_domain, access_token, COALESCE(plan,''), COALESCE(status,'act
 
 func (r *Repository) FindByDomain(ctx context.Context, domain string) (*Shop, error) {
 	const q = `
-SELECT id, shop_domain, access_token, COALESCE(plan,''), COALESCE(status,'active'), installed_at
+SELECT id, shop_domain, access_token, COALESCE(plan,''), COALESCE(status,'active'), COALESCE(payment_rail,''), installed_at
 FROM shops
 WHERE shop_domain = $1
 `
 	s := &Shop{}
 	if err := r.db.QueryRow(ctx, q, domain).Scan(
-		&s.ID, &s.Domain, &s.AccessToken, &s.Plan, &s.Status, &s.InstalledAt,
+		&s.ID, &s.Domain, &s.AccessToken, &s.Plan, &s.Status, &s.PaymentRail, &s.InstalledAt,
 	); err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
+// GetByID loads a shop inside a transaction, for callers that only know it through a service (the portal).
+func GetByID(ctx context.Context, tx pgx.Tx, id string) (*Shop, error) {
+	const q = `
+SELECT id, shop_domain, access_token, COALESCE(plan,''), COALESCE(status,'active'), COALESCE(payment_rail,''), installed_at
+FROM shops
+WHERE id = $1
+`
+	s := &Shop{}
+	if err := tx.QueryRow(ctx, q, id).Scan(
+		&s.ID, &s.Domain, &s.AccessToken, &s.Plan, &s.Status, &s.PaymentRail, &s.InstalledAt,
+	); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetPaymentRail changes the shop's default payment rail; "" restores the built-in default.
+func SetPaymentRail(ctx context.Context, tx pgx.Tx, id, rail string) error {
+	const q = `UPDATE shops SET payment_rail = NULLIF($2,'') WHERE id = $1`
+	_, err := tx.Exec(ctx, q, id, rail)
+	return err
+}
+
 func (r *Repository) DeleteByID(ctx context.Context, id string) error {
 	const q = `DELETE FROM shops WHERE id = $1`
 	_, err := r.db.Exec(ctx, q, id)
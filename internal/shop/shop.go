@@ -8,6 +8,7 @@ type Shop struct {
 	AccessToken string
 	Plan        string
 	Status      string
+	PaymentRail string // empty: default rail
 	InstalledAt time.Time
 }
 
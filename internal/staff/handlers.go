@@ -0,0 +1,118 @@
+package staff
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"microservice/internal/api"
+	"microservice/internal/audit"
+	"microservice/pkg/db"
+)
+
+type Handlers struct {
+	DB   *pgxpool.Pool
+	Repo *Repository
+}
+
+func (h Handlers) List(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	items, err := h.Repo.ListRoles(r.Context(), s.ID)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+	if items == nil {
+		items = []RoleAssignment{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+type PutRoleRequest struct {
+	Role string `json:"role"`
+}
+
+var errLastFinanceAdmin = errors.New("last finance admin")
+
+func (h Handlers) PutRole(w http.ResponseWriter, r *http.Request) {
+	s := api.ShopFromContext(r.Context())
+	if s == nil {
+		api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+		return
+	}
+
+	userID := strings.TrimSpace(chi.URLParam(r, "userId"))
+	if userID == "" {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "missing userId")
+		return
+	}
+
+	var req PutRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "invalid json")
+		return
+	}
+	role, err := ParseRole(req.Role)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, "VALIDATION_FAILED", "role must be viewer, operator or finance_admin")
+		return
+	}
+
+	err = db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
+		if err := LockRoles(r.Context(), tx, s.ID); err != nil {
+			return err
+		}
+		// First assignment in a shop: make the caller finance_admin so configuring roles can't lock them out.
+		if caller := api.StaffFromContext(r.Context()); caller != nil && caller.UserID != "" && caller.UserID != userID {
+			n, err := CountRole(r.Context(), tx, s.ID, RoleFinanceAdmin)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				if _, err := SetRole(r.Context(), tx, s.ID, caller.UserID, RoleFinanceAdmin); err != nil {
+					return err
+				}
+			}
+		}
+
+		prev, err := SetRole(r.Context(), tx, s.ID, userID, role)
+		if err != nil {
+			return err
+		}
+		// Never leave a shop that uses roles without someone able to manage them. That covers demoting the
+		// last finance_admin as well as a first assignment the bootstrap above could not cover (the caller
+		// assigning themselves, or no staff identity).
+		n, err := CountRole(r.Context(), tx, s.ID, RoleFinanceAdmin)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return errLastFinanceAdmin
+		}
+		meta := audit.Diff(map[string]any{"role": prev}, map[string]any{"role": role})
+		meta["userId"] = userID
+		return audit.Insert(r.Context(), tx, s.ID, nil, "STAFF_ROLE_CHANGED", api.Actor(r.Context()), meta)
+	})
+	if err != nil {
+		if errors.Is(err, errLastFinanceAdmin) {
+			api.WriteError(w, http.StatusConflict, "LAST_FINANCE_ADMIN", "the shop must keep at least one finance_admin")
+			return
+		}
+		api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
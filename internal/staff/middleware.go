@@ -0,0 +1,68 @@
+package staff
+
+import (
+	"context"
+	"net/http"
+
+	"microservice/internal/api"
+)
+
+type ctxKey string
+
+const ctxKeyRole ctxKey = "staff_role"
+
+// Authorizer resolves the caller's role and enforces minimum roles on routes.
+//
+// Resolution:
+//   - a staff user with an assigned role gets that role;
+//   - while a shop has not assigned any roles yet, every verified staff user is finance_admin so existing
+//     installs keep working until someone sets roles up;
+//   - otherwise unassigned staff are viewers;
+//   - requests without a staff identity (dev X-Shop-Domain fallback) are finance_admin outside prod, viewer in prod.
+type Authorizer struct {
+	Repo   *Repository
+	AppEnv string
+}
+
+func (a Authorizer) Resolve(ctx context.Context, shopID string) (Role, error) {
+	st := api.StaffFromContext(ctx)
+	if st == nil || st.UserID == "" {
+		if a.AppEnv != "prod" {
+			return RoleFinanceAdmin, nil
+		}
+		return RoleViewer, nil
+	}
+
+	return a.Repo.EffectiveRole(ctx, shopID, st.UserID)
+}
+
+// Require rejects callers whose role is below min. It must run after ShopifySessionAuth.
+func (a Authorizer) Require(min Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := api.ShopFromContext(r.Context())
+			if s == nil {
+				api.WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing shop identity")
+				return
+			}
+
+			role, err := a.Resolve(r.Context(), s.ID)
+			if err != nil {
+				api.WriteError(w, http.StatusInternalServerError, "INTERNAL", "internal error")
+				return
+			}
+			if !role.Allows(min) {
+				api.WriteError(w, http.StatusForbidden, "FORBIDDEN", "requires role "+string(min))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyRole, role)))
+		})
+	}
+}
+
+// RoleFromContext returns the role resolved by Require, or "" outside a protected route.
+func RoleFromContext(ctx context.Context) Role {
+	r, _ := ctx.Value(ctxKeyRole).(Role)
+	return r
+}
@@ -0,0 +1,187 @@
+package staff
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Member is a Shopify staff user seen through a verified session token.
+type Member struct {
+	ShopID     string    `json:"shopId"`
+	UserID     string    `json:"userId"`
+	Name       string    `json:"name,omitempty"`
+	Email      string    `json:"email,omitempty"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// Touch records that a staff member made a request. last_seen_at is refreshed at most every few minutes so hot
+// paths don't write on every call. Name and email are not taken from requests, where they are unverified;
+// needsIdentity reports that they are due to be fetched from Shopify and stored with SetIdentity.
+func (r *Repository) Touch(ctx context.Context, shopID, userID string) (needsIdentity bool, err error) {
+	const q = `
+INSERT INTO staff_members (shop_id, user_id, last_seen_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (shop_id, user_id) DO UPDATE SET last_seen_at = NOW()
+WHERE staff_members.last_seen_at < NOW() - INTERVAL '5 minutes'
+RETURNING identity_checked_at IS NULL OR identity_checked_at < NOW() - INTERVAL '1 day'
+`
+	err = r.db.QueryRow(ctx, q, shopID, userID).Scan(&needsIdentity)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return needsIdentity, err
+}
+
+// SetIdentity stores the staff member's name and email as Shopify reported them for their own session.
+func (r *Repository) SetIdentity(ctx context.Context, shopID, userID, name, email string) error {
+	const q = `
+UPDATE staff_members
+SET name = NULLIF($3,''), email = NULLIF($4,''), identity_checked_at = NOW()
+WHERE shop_id = $1 AND user_id = $2
+`
+	_, err := r.db.Exec(ctx, q, shopID, userID, name, email)
+	return err
+}
+
+func (r *Repository) List(ctx context.Context, shopID string) ([]Member, error) {
+	const q = `
+SELECT shop_id, user_id, COALESCE(name,''), COALESCE(email,''), last_seen_at
+FROM staff_members
+WHERE shop_id = $1
+ORDER BY last_seen_at DESC
+`
+	rows, err := r.db.Query(ctx, q, shopID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Member
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.ShopID, &m.UserID, &m.Name, &m.Email, &m.LastSeenAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// RoleAssignment is a staff member with an explicitly assigned role.
+type RoleAssignment struct {
+	Member
+	Role Role `json:"role"`
+}
+
+// GetRole returns the assigned role for a user, or "" when none is assigned.
+func (r *Repository) GetRole(ctx context.Context, shopID, userID string) (Role, error) {
+	const q = `SELECT COALESCE(role,'') FROM staff_members WHERE shop_id = $1 AND user_id = $2`
+	var role string
+	if err := r.db.QueryRow(ctx, q, shopID, userID).Scan(&role); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return Role(role), nil
+}
+
+// EffectiveRole is the role a staff member acts with: the assigned one, finance_admin while the shop has
+// assigned no roles at all, viewer otherwise.
+func (r *Repository) EffectiveRole(ctx context.Context, shopID, userID string) (Role, error) {
+	role, err := r.GetRole(ctx, shopID, userID)
+	if err != nil {
+		return "", err
+	}
+	if role != "" {
+		return role, nil
+	}
+
+	assigned, err := r.HasAssignedRoles(ctx, shopID)
+	if err != nil {
+		return "", err
+	}
+	if !assigned {
+		return RoleFinanceAdmin, nil
+	}
+	return RoleViewer, nil
+}
+
+// HasAssignedRoles reports whether the shop has configured any roles yet.
+func (r *Repository) HasAssignedRoles(ctx context.Context, shopID string) (bool, error) {
+	const q = `SELECT EXISTS (SELECT 1 FROM staff_members WHERE shop_id = $1 AND role IS NOT NULL)`
+	var ok bool
+	err := r.db.QueryRow(ctx, q, shopID).Scan(&ok)
+	return ok, err
+}
+
+// LockRoles serializes role changes within a shop, so a check such as "at least one finance_admin remains"
+// cannot be raced by a concurrent change. It locks the shop row without blocking rows that reference it.
+func LockRoles(ctx context.Context, tx pgx.Tx, shopID string) error {
+	const q = `SELECT id FROM shops WHERE id = $1 FOR NO KEY UPDATE`
+	var id string
+	return tx.QueryRow(ctx, q, shopID).Scan(&id)
+}
+
+// CountRole counts staff members holding exactly role.
+func CountRole(ctx context.Context, tx pgx.Tx, shopID string, role Role) (int, error) {
+	const q = `SELECT COUNT(*) FROM staff_members WHERE shop_id = $1 AND role = $2`
+	var n int
+	err := tx.QueryRow(ctx, q, shopID, string(role)).Scan(&n)
+	return n, err
+}
+
+// SetRole assigns a role (creating the member row if the user has not signed in yet) and returns the previous role.
+func SetRole(ctx context.Context, tx pgx.Tx, shopID, userID string, role Role) (Role, error) {
+	const qPrev = `SELECT COALESCE(role,'') FROM staff_members WHERE shop_id = $1 AND user_id = $2 FOR UPDATE`
+	var prev string
+	if err := tx.QueryRow(ctx, qPrev, shopID, userID).Scan(&prev); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+
+	const q = `
+INSERT INTO staff_members (shop_id, user_id, role)
+VALUES ($1, $2, $3)
+ON CONFLICT (shop_id, user_id) DO UPDATE SET role = EXCLUDED.role
+`
+	_, err := tx.Exec(ctx, q, shopID, userID, string(role))
+	return Role(prev), err
+}
+
+func (r *Repository) ListRoles(ctx context.Context, shopID string) ([]RoleAssignment, error) {
+	const q = `
+SELECT shop_id, user_id, COALESCE(name,''), COALESCE(email,''), last_seen_at, COALESCE(role,'')
+FROM staff_members
+WHERE shop_id = $1
+ORDER BY user_id ASC
+`
+	rows, err := r.db.Query(ctx, q, shopID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RoleAssignment
+	for rows.Next() {
+		var a RoleAssignment
+		var role string
+		if err := rows.Scan(&a.ShopID, &a.UserID, &a.Name, &a.Email, &a.LastSeenAt, &role); err != nil {
+			return nil, err
+		}
+		a.Role = Role(role)
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
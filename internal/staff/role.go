@@ -0,0 +1,34 @@
+package staff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role controls which merchant endpoints a staff member may call. Roles are ordered: each includes the ones below it.
+type Role string
+
+const (
+	RoleViewer       Role = "viewer"
+	RoleOperator     Role = "operator"
+	RoleFinanceAdmin Role = "finance_admin"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:       1,
+	RoleOperator:     2,
+	RoleFinanceAdmin: 3,
+}
+
+func ParseRole(s string) (Role, error) {
+	r := Role(strings.ReplaceAll(strings.ToLower(strings.TrimSpace(s)), "-", "_"))
+	if _, ok := roleRank[r]; !ok {
+		return "", fmt.Errorf("unknown role: %s", s)
+	}
+	return r, nil
+}
+
+// Allows reports whether r grants at least min.
+func (r Role) Allows(min Role) bool {
+	return roleRank[r] >= roleRank[min] && roleRank[r] > 0
+}
@@ -0,0 +1,25 @@
+package staff
+
+import "testing"
+
+func TestParseRole_AcceptsDashedForm(t *testing.T) {
+	got, err := ParseRole("Finance-Admin")
+	if err != nil || got != RoleFinanceAdmin {
+		t.Fatalf("expected finance_admin, got %q err=%v", got, err)
+	}
+	if _, err := ParseRole("owner"); err == nil {
+		t.Fatalf("expected error for unknown role")
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	if !RoleFinanceAdmin.Allows(RoleOperator) || !RoleOperator.Allows(RoleOperator) {
+		t.Fatalf("expected higher or equal roles to be allowed")
+	}
+	if RoleViewer.Allows(RoleOperator) {
+		t.Fatalf("viewer must not be allowed operator actions")
+	}
+	if Role("").Allows(RoleViewer) {
+		t.Fatalf("empty role must not be allowed anything")
+	}
+}
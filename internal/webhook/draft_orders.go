@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"microservice/internal/audit"
+	"microservice/internal/events"
+	"microservice/internal/milestone"
+	"microservice/internal/payment"
+	"microservice/internal/shop"
+	"microservice/pkg/currency"
+)
+
+type draftOrderPayload struct {
+	ID            int64  `json:"id"`
+	Status        string `json:"status"` // open | invoice_sent | completed
+	TotalPrice    string `json:"total_price"`
+	SubtotalPrice string `json:"subtotal_price"`
+	Currency      string `json:"currency"`
+	OrderID       int64  `json:"order_id"`
+}
+
+// Amount is what the draft asks for before tax and shipping, the figure compared with the milestone.
+func (p draftOrderPayload) Amount() string {
+	if p.SubtotalPrice != "" {
+		return p.SubtotalPrice
+	}
+	return p.TotalPrice
+}
+
+// handleDraftOrderUpdate keeps a milestone's draft order in sync: a completed draft with a different subtotal
+// flags the milestone, and an open draft whose subtotal was edited no longer matches what the milestone still owes, so its link
+// is dropped and the next payment request issues a fresh one.
+func (h Handler) handleDraftOrderUpdate(ctx context.Context, tx pgx.Tx, shopRec *shop.Shop, body []byte) error {
+	var payload draftOrderPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.ID == 0 {
+		return nil
+	}
+
+	draftOrderID := int64ToString(payload.ID)
+	m, err := milestone.FindByDraftOrderForUpdate(ctx, tx, shopRec.ID, draftOrderID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	status := strings.ToLower(strings.TrimSpace(payload.Status))
+	if err := milestone.SetDraftOrderStatus(ctx, tx, m.ID, status); err != nil {
+		return err
+	}
+	amount := payload.Amount()
+	if amount == "" || payment.SameAmount(amount, currency.Format(m.Outstanding(), m.Currency)) {
+		return nil
+	}
+
+	data := map[string]any{"draftOrderId": draftOrderID, "draftOrderStatus": status}
+	if status == "completed" {
+		if payload.OrderID != 0 {
+			data["orderId"] = int64ToString(payload.OrderID)
+		}
+		return payment.FlagAmountMismatch(ctx, tx, shopRec.ID, m, amount, "webhook", data)
+	}
+	if milestone.IsSettled(m.Status) {
+		return nil
+	}
+	data["draftOrderAmount"] = amount
+	return dropStalePaymentLink(ctx, tx, shopRec.ID, m, "amount_changed", data)
+}
+
+// handleDraftOrderDelete drops the payment link of an unpaid milestone whose draft order was deleted in Shopify,
+// or cancels the payment bundle the draft order was issued for.
+func (h Handler) handleDraftOrderDelete(ctx context.Context, tx pgx.Tx, shopRec *shop.Shop, body []byte) error {
+	var payload draftOrderPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.ID == 0 {
+		return nil
+	}
+
+	draftOrderID := int64ToString(payload.ID)
+	m, err := milestone.FindByDraftOrderForUpdate(ctx, tx, shopRec.ID, draftOrderID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Not a milestone draft; it may be a "pay remaining balance" bundle.
+			_, err := payment.CancelBundleByDraftOrder(ctx, tx, shopRec.ID, draftOrderID, "webhook")
+			return err
+		}
+		return err
+	}
+	if milestone.IsSettled(m.Status) {
+		return milestone.SetDraftOrderStatus(ctx, tx, m.ID, "deleted")
+	}
+	return dropStalePaymentLink(ctx, tx, shopRec.ID, m, "deleted", map[string]any{"draftOrderId": draftOrderID})
+}
+
+func dropStalePaymentLink(ctx context.Context, tx pgx.Tx, shopID string, m *milestone.Record, reason string, data map[string]any) error {
+	if err := milestone.ClearPaymentRequest(ctx, tx, m.ID); err != nil {
+		return err
+	}
+
+	meta := map[string]any{"milestoneId": m.ID, "reason": reason}
+	for k, v := range data {
+		meta[k] = v
+	}
+	actor := "webhook"
+	serviceID := m.ServiceID
+	if err := audit.Insert(ctx, tx, shopID, &serviceID, "MILESTONE_PAYMENT_LINK_STALE", actor, meta); err != nil {
+		return err
+	}
+	return events.Insert(ctx, tx, serviceID, "MILESTONE_PAYMENT_LINK_STALE", "Payment link no longer valid", actor, time.Now(), meta)
+}
@@ -24,11 +24,14 @@ import (
 	"microservice/internal/audit"
 	"microservice/internal/events"
 	"microservice/internal/milestone"
+	"microservice/internal/payment"
 	"microservice/internal/portal"
+	"microservice/internal/recurring"
 	"microservice/internal/service"
 	"microservice/internal/serviceproduct"
 	"microservice/internal/shop"
 	"microservice/pkg/config"
+	"microservice/pkg/currency"
 	"microservice/pkg/db"
 )
 
@@ -79,6 +82,10 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		eventID = payloadHash
 	}
 
+	if topic == "orders_paid" {
+		h.refreshOrderedProducts(r.Context(), shopRec, body)
+	}
+
 	// Idempotency gate + handler execution in one tx.
 	if err := db.WithTx(r.Context(), h.DB, func(tx pgx.Tx) error {
 		if err := insertWebhookEvent(r.Context(), tx, shopRec.ID, topic, eventID, payloadHash); err != nil {
@@ -97,6 +104,12 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return h.handleOrdersPaid(r.Context(), tx, shopRec, body)
 		case "milestone_paid":
 			return h.handleMilestonePaid(r.Context(), tx, shopRec, body)
+		case "draft_orders_update":
+			return h.handleDraftOrderUpdate(r.Context(), tx, shopRec, body)
+		case "draft_orders_delete":
+			return h.handleDraftOrderDelete(r.Context(), tx, shopRec, body)
+		case "products_delete":
+			return h.handleProductDelete(r.Context(), tx, shopRec, body)
 		case "app_uninstalled":
 			// Delete shop row; FK cascades remove related data.
 			return h.Shops.DeleteByID(r.Context(), shopRec.ID)
@@ -112,6 +125,35 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// orderRefreshTimeout bounds the product refresh ahead of an order's transaction, well inside the time Shopify
+// allows a webhook to answer.
+const orderRefreshTimeout = 2 * time.Second
+
+// refreshOrderedProducts updates the cached copies of the order's products before the order is handled, so
+// the Shopify calls happen outside its transaction.
+func (h Handler) refreshOrderedProducts(ctx context.Context, shopRec *shop.Shop, body []byte) {
+	var payload orderPaidPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return
+	}
+	seen := map[int64]bool{}
+	var productIDs []string
+	for _, li := range payload.LineItems {
+		if li.ProductID == 0 || seen[li.ProductID] {
+			continue
+		}
+		seen[li.ProductID] = true
+		productIDs = append(productIDs, int64ToString(li.ProductID))
+	}
+	if len(productIDs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, orderRefreshTimeout)
+	defer cancel()
+	h.ServiceProducts.RefreshOrdered(ctx, shop.AdminClient(h.Cfg, shopRec), shopRec.ID, productIDs)
+}
+
 func (h Handler) handleOrdersPaid(ctx context.Context, tx pgx.Tx, shopRec *shop.Shop, body []byte) error {
 	var payload orderPaidPayload
 	if err := json.Unmarshal(body, &payload); err != nil {
@@ -121,56 +163,122 @@ func (h Handler) handleOrdersPaid(ctx context.Context, tx pgx.Tx, shopRec *shop.
 		return nil
 	}
 
-	// Milestone payment orders: if the order note contains a milestone_id, mark that milestone paid.
-	if milestoneID := ParseKeyFromNote(payload.Note, "milestone_id"); milestoneID != "" {
-		return h.applyMilestonePaymentFromOrder(ctx, tx, shopRec, milestoneID, payload.ID)
+	// "Pay remaining balance" orders carry a signed bundle id and settle several milestones at once.
+	bundleID, bundleServiceID, isBundle, err := payment.VerifyBundleAttributes(h.Cfg.ReconciliationSecret, shopRec.ID, payload.Attributes())
+	if errors.Is(err, payment.ErrBadSignature) {
+		log.Printf("orders_paid: bundle reconciliation signature invalid shop=%s order_id=%d", shopRec.Domain, payload.ID)
+		return audit.Insert(ctx, tx, shopRec.ID, nil, "MILESTONE_PAYMENT_SIGNATURE_INVALID", "webhook", map[string]any{"orderId": int64ToString(payload.ID), "bundleId": bundleID})
+	}
+	if isBundle {
+		return payment.ApplyBundlePayment(ctx, tx, shopRec.ID, bundleID, bundleServiceID, int64ToString(payload.ID), payload.PaidAmount(), "webhook")
+	}
+
+	// Milestone payment orders: the draft order rail resolves the order back to the milestone it was issued for.
+	// Signed custom attributes are preferred; the note is only a fallback for drafts issued before signing.
+	rail := payment.DraftOrderRail{Cfg: h.Cfg}
+	res, err := rail.ResolveConfirmation(ctx, shopRec, payment.Confirmation{
+		Note:       payload.Note,
+		ExternalID: int64ToString(payload.ID),
+		Amount:     payload.PaidAmount(),
+		Attributes: payload.Attributes(),
+	})
+	if errors.Is(err, payment.ErrBadSignature) {
+		log.Printf("orders_paid: reconciliation signature invalid shop=%s order_id=%d", shopRec.Domain, payload.ID)
+		return audit.Insert(ctx, tx, shopRec.ID, nil, "MILESTONE_PAYMENT_SIGNATURE_INVALID", "webhook", map[string]any{"orderId": int64ToString(payload.ID)})
+	}
+	if err == nil && res.Source == "note" {
+		// A note is only trusted on the order of a draft issued for that milestone before drafts were signed;
+		// storefront orders carry whatever cart note the customer typed.
+		legacy, err := milestone.IssuedUnsignedDraft(ctx, tx, res.MilestoneID)
+		if err != nil {
+			return err
+		}
+		if !legacy || payload.SourceName != draftOrderSourceName {
+			log.Printf("orders_paid: unsigned note match rejected shop=%s order_id=%d source_name=%s", shopRec.Domain, payload.ID, payload.SourceName)
+			return audit.Insert(ctx, tx, shopRec.ID, nil, "MILESTONE_PAYMENT_SIGNATURE_INVALID", "webhook", map[string]any{"orderId": int64ToString(payload.ID), "milestoneId": res.MilestoneID, "matchedBy": res.Source})
+		}
+	}
+	if err == nil && res.MilestoneID != "" {
+		return h.applyMilestonePaymentFromOrder(ctx, tx, shopRec, res, payload.ID, payload.PaidAmount(), payload.PresentmentPaid())
 	}
 
-	// Find first line item that has a configured service product.
-	var chosenProductID string
-	var cfgRaw json.RawMessage
+	// Find the first line item that resolves to a config: the ordered variant's own, its product's, or a shop
+	// rule's (see serviceproduct.Resolve). Rules see the cached products, refreshed before this transaction.
+	var resolved *serviceproduct.Resolved
 	for _, li := range payload.LineItems {
 		if li.ProductID == 0 {
 			continue
 		}
+		var variantID string
+		if li.VariantID != 0 {
+			variantID = int64ToString(li.VariantID)
+		}
 		productID := int64ToString(li.ProductID)
-		rec, err := getServiceProductConfig(ctx, tx, shopRec.ID, productID)
-		if err == nil && len(rec) > 0 {
-			chosenProductID = productID
-			cfgRaw = rec
+		facts := serviceproduct.CachedProductFacts(tx, shopRec.ID, productID)
+		res, err := serviceproduct.Resolve(ctx, tx, shopRec.ID, productID, variantID, facts)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if errors.Is(err, serviceproduct.ErrDisabled) {
+			if h.Cfg.AppEnv != "prod" {
+				log.Printf("orders_paid: service_product_config disabled shop=%s order_id=%d product_id=%s variant_id=%s", shopRec.Domain, payload.ID, productID, variantID)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(res.Config) > 0 {
+			resolved = res
 			break
 		}
 	}
-	if chosenProductID == "" {
+	if resolved == nil {
 		if h.Cfg.AppEnv != "prod" {
 			log.Printf("orders_paid: no service_product_config found shop=%s order_id=%d", shopRec.Domain, payload.ID)
 		}
 		return nil
 	}
 
+	chosenProductID, cfgRaw, cfgVersion := resolved.ProductID, resolved.Config, resolved.ConfigVersion
 	cfg, err := serviceproduct.ParseAndValidate(cfgRaw)
 	if err != nil {
 		if h.Cfg.AppEnv != "prod" {
-			log.Printf("orders_paid: invalid service_product_config shop=%s product_id=%s err=%v", shopRec.Domain, chosenProductID, err)
+			log.Printf("orders_paid: invalid service_product_config shop=%s product_id=%s variant_id=%s err=%v", shopRec.Domain, chosenProductID, resolved.VariantID, err)
 		}
 		return nil
 	}
-
-	total, err := decimal.NewFromString(payload.TotalPrice)
+	// The snapshot records how the config was resolved next to the config itself.
+	snapshot, err := resolved.Snapshot()
 	if err != nil {
 		return nil
 	}
 
-	amounts, err := milestone.CalculateAmounts(total, cfg.Templates, milestone.DefaultCurrencyScale)
+	// Services are billed in the shop currency, which Shopify settles in; amounts are never guessed into USD.
+	shopMoney := payload.ShopTotal()
+	total, err := decimal.NewFromString(shopMoney.Amount)
 	if err != nil {
-		if h.Cfg.AppEnv != "prod" {
-			log.Printf("orders_paid: milestone calc failed shop=%s order_id=%d err=%v", shopRec.Domain, payload.ID, err)
-		}
 		return nil
 	}
+	now := time.Now()
+	plan, err := serviceproduct.Plan(cfg, total, shopMoney.CurrencyCode, now)
+	if err != nil {
+		log.Printf("orders_paid: cannot plan milestones shop=%s order_id=%d currency=%q err=%v", shopRec.Domain, payload.ID, shopMoney.CurrencyCode, err)
+		return nil
+	}
+	scale := plan.Scale
+
+	// A client paying in another currency also sees each milestone in that currency.
+	presentment, presentmentAmounts := payload.PresentmentTotal(), []string(nil)
+	if presentment.CurrencyCode != "" {
+		presentmentAmounts = splitPresentment(plan.Milestones, plan.Total, presentment)
+		if presentmentAmounts == nil {
+			presentment = money{}
+		}
+	}
 
 	// Create service (idempotent by UNIQUE(shop_id, shopify_order_id)).
-	serviceID, created, err := insertService(ctx, tx, shopRec.ID, payload.ID, chosenProductID, payload.Email, payload.CustomerName(), total, payload.Currency, cfgRaw)
+	serviceID, created, err := insertService(ctx, tx, shopRec.ID, payload.ID, chosenProductID, resolved.VariantID, payload.Email, payload.CustomerName(), scale.Format(plan.Total), plan.Currency, presentment, snapshot, cfgVersion)
 	if err != nil {
 		if isUniqueViolation(err) {
 			return nil
@@ -178,11 +286,10 @@ func (h Handler) handleOrdersPaid(ctx context.Context, tx pgx.Tx, shopRec *shop.
 		return err
 	}
 
-	now := time.Now()
 	actor := "webhook"
 
 	if created {
-		if err := audit.Insert(ctx, tx, shopRec.ID, &serviceID, "SERVICE_CREATED", actor, map[string]any{"shopifyOrderId": payload.ID, "productId": chosenProductID}); err != nil {
+		if err := audit.Insert(ctx, tx, shopRec.ID, &serviceID, "SERVICE_CREATED", actor, map[string]any{"shopifyOrderId": payload.ID, "productId": chosenProductID, "variantId": resolved.VariantID, "configSource": resolved.Source, "configVersion": cfgVersion}); err != nil {
 			return err
 		}
 		if err := events.Insert(ctx, tx, serviceID, "SERVICE_CREATED", "Service created", actor, now, map[string]any{"shopifyOrderId": payload.ID}); err != nil {
@@ -196,28 +303,63 @@ func (h Handler) handleOrdersPaid(ctx context.Context, tx pgx.Tx, shopRec *shop.
 		if err := events.Insert(ctx, tx, serviceID, "PORTAL_TOKEN_CREATED", "Client portal link created", actor, now, map[string]any{}); err != nil {
 			return err
 		}
+
+		// The client may opt into having later milestones charged on their due date at checkout.
+		if payment.AutoChargeOptedIn(payload.Attributes()) {
+			if err := service.SetAutoCharge(ctx, tx, serviceID, true); err != nil {
+				return err
+			}
+			if err := audit.Insert(ctx, tx, shopRec.ID, &serviceID, "AUTO_CHARGE_OPTED_IN", actor, map[string]any{"shopifyOrderId": payload.ID}); err != nil {
+				return err
+			}
+			if err := events.Insert(ctx, tx, serviceID, "AUTO_CHARGE_OPTED_IN", "Client opted into automatic charges", actor, now, map[string]any{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if created && cfg.IsRecurring() {
+		rec, err := recurring.Insert(ctx, tx, serviceID, *cfg.Recurring, plan.CycleAmount, scale, now)
+		if err != nil {
+			return err
+		}
+		if err := events.Insert(ctx, tx, serviceID, "RECURRING_STARTED", "Recurring billing started", actor, now, map[string]any{"interval": rec.Interval, "intervalCount": rec.IntervalCount, "cycleAmount": rec.CycleAmount, "maxCycles": rec.MaxCycles}); err != nil {
+			return err
+		}
 	}
 
 	// Create milestones if none exist yet (idempotent by UNIQUE(service_id, sequence)).
-	for i, m := range amounts {
-		status := "unpaid"
-		var paidAt *time.Time
-		if i == 0 {
-			status = "paid"
-			paidAt = &now
-		} else if m.IsFinal {
-			status = "locked"
+	for i, m := range plan.Milestones {
+		var presentmentAmount string
+		if presentmentAmounts != nil {
+			presentmentAmount = presentmentAmounts[i]
 		}
 
-		if err := insertMilestone(ctx, tx, serviceID, i, m.Amount, status, paidAt); err != nil {
+		milestoneID, err := insertMilestone(ctx, tx, serviceID, m.Sequence, scale.Format(m.Amount), presentmentAmount, m.Status, m.DueAt, m.PeriodStart, m.PeriodEnd)
+		if err != nil {
 			if isUniqueViolation(err) {
 				continue
 			}
 			return err
 		}
+		if err := milestone.InsertDetails(ctx, tx, milestoneID, m.Details); err != nil {
+			return err
+		}
 
-		if i == 0 {
-			if err := audit.Insert(ctx, tx, shopRec.ID, &serviceID, "DEPOSIT_PAID", actor, map[string]any{"sequence": 0, "amount": m.Amount.StringFixed(2)}); err != nil {
+		if m.PaidByOrder {
+			// The order itself paid the deposit; book it like any other payment.
+			if _, err := milestone.RecordPayment(ctx, tx, serviceID, milestoneID, milestone.Payment{
+				Amount:     m.Amount,
+				Source:     "deposit",
+				Reference:  int64ToString(payload.ID),
+				ReceivedAt: now,
+
+				PresentmentAmount:   presentmentAmount,
+				PresentmentCurrency: presentment.CurrencyCode,
+			}); err != nil {
+				return err
+			}
+			if err := audit.Insert(ctx, tx, shopRec.ID, &serviceID, "DEPOSIT_PAID", actor, map[string]any{"sequence": 0, "amount": scale.Format(m.Amount)}); err != nil {
 				return err
 			}
 			if err := events.Insert(ctx, tx, serviceID, "MILESTONE_PAID", "Deposit paid", actor, now, map[string]any{"sequence": 0}); err != nil {
@@ -236,50 +378,51 @@ func (h Handler) handleOrdersPaid(ctx context.Context, tx pgx.Tx, shopRec *shop.
 	return nil
 }
 
-func (h Handler) applyMilestonePaymentFromOrder(ctx context.Context, tx pgx.Tx, shopRec *shop.Shop, milestoneID string, orderID int64) error {
+func (h Handler) applyMilestonePaymentFromOrder(ctx context.Context, tx pgx.Tx, shopRec *shop.Shop, res *payment.Resolution, orderID int64, paidAmount string, presentment money) error {
 	// Shop-scope + row-lock the milestone.
-	m, err := milestone.GetForUpdateScoped(ctx, tx, shopRec.ID, milestoneID)
+	m, err := milestone.GetForUpdateScoped(ctx, tx, shopRec.ID, res.MilestoneID)
 	if err != nil {
 		return nil
 	}
-	if m.Status == "paid" {
+	if res.ServiceID != "" && !strings.EqualFold(res.ServiceID, m.ServiceID) {
+		// The ids were signed (or noted) together; a mismatch means they were not issued for this milestone.
+		log.Printf("orders_paid: milestone/service mismatch shop=%s order_id=%d source=%s", shopRec.Domain, orderID, res.Source)
 		return nil
 	}
-	if m.Status == "locked" {
+	if m.Status == milestone.StatusLocked {
 		// Final milestone cannot be paid before approval; ignore.
 		return nil
 	}
 
-	now := time.Now()
-	if err := milestone.MarkPaid(ctx, tx, m.ID, now); err != nil {
-		return err
+	// A draft edited after it was issued can be paid with a different subtotal. The ledger books what was
+	// actually paid for the line items (partial, or carried forward when over); the mismatch is flagged for
+	// review. Tax, shipping and tips Shopify added on top are not the milestone's and are left out.
+	// An order that paid nothing (fully discounted) or whose subtotal cannot be read books nothing: the
+	// milestone is only flagged, never settled on the strength of the order alone.
+	outstanding := m.Outstanding()
+	amount, err := decimal.NewFromString(strings.TrimSpace(paidAmount))
+	if err != nil || amount.Sign() <= 0 {
+		return payment.FlagAmountMismatch(ctx, tx, shopRec.ID, m, paidAmount, "webhook", map[string]any{"orderId": int64ToString(orderID), "matchedBy": res.Source})
 	}
 
-	actor := "webhook"
-	serviceID := m.ServiceID
-	if err := audit.Insert(ctx, tx, shopRec.ID, &serviceID, "MILESTONE_PAID", actor, map[string]any{"milestoneId": m.ID, "orderId": int64ToString(orderID)}); err != nil {
-		return err
+	_, err = service.RecordMilestonePayment(ctx, tx, shopRec.ID, m, milestone.Payment{
+		Amount:     amount,
+		Source:     "order",
+		Reference:  int64ToString(orderID),
+		ReceivedAt: time.Now(),
+
+		PresentmentAmount:   presentment.Amount,
+		PresentmentCurrency: presentment.CurrencyCode,
+	}, "webhook", map[string]any{"orderId": int64ToString(orderID), "matchedBy": res.Source})
+	if errors.Is(err, milestone.ErrDuplicatePayment) {
+		return nil
 	}
-	if err := events.Insert(ctx, tx, serviceID, "MILESTONE_PAID", "Milestone paid", actor, now, map[string]any{"milestoneId": m.ID}); err != nil {
+	if err != nil {
 		return err
 	}
-
-	// If this is the final milestone, complete only if approved.
-	const qFinalSeq = `SELECT sequence FROM milestones WHERE service_id = $1 ORDER BY sequence DESC LIMIT 1`
-	var finalSeq int
-	if err := tx.QueryRow(ctx, qFinalSeq, serviceID).Scan(&finalSeq); err == nil && m.Sequence == finalSeq {
-		const qAppr = `SELECT approved FROM approvals WHERE service_id = $1`
-		var approved bool
-		if err := tx.QueryRow(ctx, qAppr, serviceID).Scan(&approved); err == nil && approved {
-			if err := service.UpdateStatus(ctx, tx, shopRec.ID, serviceID, service.StatusCompleted, false); err != nil {
-				return err
-			}
-			if err := events.Insert(ctx, tx, serviceID, "STATUS_CHANGED", "Service completed", actor, now, map[string]any{"to": service.StatusCompleted}); err != nil {
-				return err
-			}
-		}
+	if !payment.SameAmount(paidAmount, currency.Format(outstanding, m.Currency)) {
+		return payment.FlagAmountMismatch(ctx, tx, shopRec.ID, m, paidAmount, "webhook", map[string]any{"orderId": int64ToString(orderID)})
 	}
-
 	return nil
 }
 
@@ -305,45 +448,25 @@ LIMIT 1
 		return nil
 	}
 
-	m, err := milestone.GetForUpdate(ctx, tx, milestoneID)
+	m, err := milestone.GetForUpdateScoped(ctx, tx, shopRec.ID, milestoneID)
 	if err != nil {
 		return nil
 	}
-	if m.Status == "paid" {
+	if milestone.IsSettled(m.Status) || m.Status == milestone.StatusLocked {
 		return nil
 	}
 
-	now := time.Now()
-	if err := milestone.MarkPaid(ctx, tx, m.ID, now); err != nil {
-		return err
-	}
-
-	actor := "webhook"
-	if err := audit.Insert(ctx, tx, shopRec.ID, &serviceID, "MILESTONE_PAID", actor, map[string]any{"sequence": m.Sequence, "draftOrderId": payload.DraftOrderID}); err != nil {
-		return err
-	}
-	if err := events.Insert(ctx, tx, serviceID, "MILESTONE_PAID", "Milestone paid", actor, now, map[string]any{"sequence": m.Sequence}); err != nil {
-		return err
-	}
-
-	// If this is the final milestone, attempt completion if approved; otherwise just record paid.
-	const qFinalSeq = `SELECT sequence FROM milestones WHERE service_id = $1 ORDER BY sequence DESC LIMIT 1`
-	var finalSeq int
-	if err := tx.QueryRow(ctx, qFinalSeq, serviceID).Scan(&finalSeq); err == nil && m.Sequence == finalSeq {
-		// Check approval
-		const qAppr = `SELECT approved FROM approvals WHERE service_id = $1`
-		var approved bool
-		if err := tx.QueryRow(ctx, qAppr, serviceID).Scan(&approved); err == nil && approved {
-			if err := service.UpdateStatus(ctx, tx, shopRec.ID, serviceID, service.StatusCompleted, false); err != nil {
-				return err
-			}
-			if err := events.Insert(ctx, tx, serviceID, "STATUS_CHANGED", "Service completed", actor, now, map[string]any{"to": service.StatusCompleted}); err != nil {
-				return err
-			}
-		}
+	// This notification carries no amount; it settles whatever is still owed.
+	_, err = service.RecordMilestonePayment(ctx, tx, shopRec.ID, m, milestone.Payment{
+		Amount:     m.Outstanding(),
+		Source:     "order",
+		Reference:  payload.DraftOrderID,
+		ReceivedAt: time.Now(),
+	}, "webhook", map[string]any{"draftOrderId": payload.DraftOrderID})
+	if errors.Is(err, milestone.ErrDuplicatePayment) {
+		return nil
 	}
-
-	return nil
+	return err
 }
 
 func insertWebhookEvent(ctx context.Context, tx pgx.Tx, shopID, topic, eventID, payloadHash string) error {
@@ -355,38 +478,60 @@ VALUES ($1, $2, $3, $4, NOW())
 	return err
 }
 
-func getServiceProductConfig(ctx context.Context, tx pgx.Tx, shopID, productID string) (json.RawMessage, error) {
+// insertService creates the service of a paid order. A configVersion of 0 (a rule's config) is stored as NULL.
+func insertService(ctx context.Context, tx pgx.Tx, shopID string, shopifyOrderID int64, shopifyProductID, shopifyVariantID string, email string, name string, total string, currencyCode string, presentment money, snapshot json.RawMessage, configVersion int) (string, bool, error) {
 	const q = `
-SELECT config
-FROM service_product_configs
-WHERE shop_id = $1 AND shopify_product_id = $2
-`
-	var cfg json.RawMessage
-	err := tx.QueryRow(ctx, q, shopID, productID).Scan(&cfg)
-	return cfg, err
-}
-
-func insertService(ctx context.Context, tx pgx.Tx, shopID string, shopifyOrderID int64, shopifyProductID string, email string, name string, total decimal.Decimal, currency string, snapshot json.RawMessage) (string, bool, error) {
-	const q = `
-INSERT INTO services (shop_id, shopify_order_id, shopify_product_id, client_email, client_name, total_amount, currency, status, service_config_snapshot)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+INSERT INTO services (shop_id, shopify_order_id, shopify_product_id, client_email, client_name, total_amount, currency, status, service_config_snapshot,
+                      presentment_total_amount, presentment_currency, config_version, shopify_variant_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10,'')::numeric, NULLIF($11,''), NULLIF($12::int, 0), NULLIF($13,''))
 RETURNING id
 `
 	var id string
-	err := tx.QueryRow(ctx, q, shopID, int64ToString(shopifyOrderID), shopifyProductID, email, name, total.StringFixed(2), currencyOrDefault(currency), string(service.StatusBooked), snapshot).Scan(&id)
+	err := tx.QueryRow(ctx, q, shopID, int64ToString(shopifyOrderID), shopifyProductID, email, name, total, currencyCode, string(service.StatusBooked), snapshot,
+		presentment.Amount, presentment.CurrencyCode, configVersion, shopifyVariantID).Scan(&id)
 	if err != nil {
 		return "", false, err
 	}
 	return id, true, nil
 }
 
-func insertMilestone(ctx context.Context, tx pgx.Tx, serviceID string, seq int, amount decimal.Decimal, status string, paidAt *time.Time) error {
+func insertMilestone(ctx context.Context, tx pgx.Tx, serviceID string, seq int, amount, presentmentAmount string, status string, dueAt, periodStart, periodEnd *time.Time) (string, error) {
 	const q = `
-INSERT INTO milestones (service_id, sequence, amount, status, paid_at)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO milestones (service_id, sequence, amount, presentment_amount, status, due_at, period_start, period_end)
+VALUES ($1, $2, $3, NULLIF($4,'')::numeric, $5, $6, $7, $8)
+RETURNING id
 `
-	_, err := tx.Exec(ctx, q, serviceID, seq, amount.StringFixed(2), status, paidAt)
-	return err
+	var id string
+	err := tx.QueryRow(ctx, q, serviceID, seq, amount, presentmentAmount, status, dueAt, periodStart, periodEnd).Scan(&id)
+	return id, err
+}
+
+// splitPresentment converts each milestone amount into the presentment currency at the rate the order was paid
+// at, so the amounts the client sees add up to what they paid. It returns nil when the presentment total is not
+// usable.
+func splitPresentment(amounts []serviceproduct.PlannedMilestone, total decimal.Decimal, presentment money) []string {
+	scale, err := milestone.ScaleFor(presentment.CurrencyCode)
+	if err != nil || total.Sign() <= 0 {
+		return nil
+	}
+	ptotal, err := decimal.NewFromString(presentment.Amount)
+	if err != nil || ptotal.Sign() <= 0 {
+		return nil
+	}
+	ptotal = ptotal.Round(int32(scale))
+
+	out := make([]string, len(amounts))
+	allocated := decimal.Zero
+	for i, m := range amounts {
+		p := m.Amount.Mul(ptotal).Div(total).Round(int32(scale))
+		if i == len(amounts)-1 {
+			// The last milestone takes the rounding remainder so the amounts add up to what was paid.
+			p = ptotal.Sub(allocated)
+		}
+		allocated = allocated.Add(p)
+		out[i] = scale.Format(p)
+	}
+	return out
 }
 
 func sha256Hex(b []byte) string {
@@ -394,14 +539,6 @@ func sha256Hex(b []byte) string {
 	return hex.EncodeToString(h[:])
 }
 
-func currencyOrDefault(c string) string {
-	c = strings.TrimSpace(c)
-	if c == "" {
-		return "USD"
-	}
-	return c
-}
-
 func isUniqueViolation(err error) bool {
 	var pgErr *pgconn.PgError
 	if ok := errors.As(err, &pgErr); ok {
@@ -410,26 +547,103 @@ func isUniqueViolation(err error) bool {
 	return false
 }
 
+// draftOrderSourceName is the source_name Shopify gives orders completed from a draft order.
+const draftOrderSourceName = "shopify_draft_order"
+
 type orderPaidPayload struct {
 	ID         int64  `json:"id"`
 	Email      string `json:"email"`
 	TotalPrice string `json:"total_price"`
 	Currency   string `json:"currency"`
 	Note       string `json:"note"`
-	Customer   struct {
+	// SourceName is where the order was placed; orders completed from a draft order carry draftOrderSourceName.
+	SourceName string `json:"source_name"`
+	// SubtotalPrice is the line items' total after discounts, without tax, shipping and tips.
+	SubtotalPrice string `json:"subtotal_price"`
+	// PresentmentCurrency is the currency the client checked out in; the price sets carry amounts in both.
+	PresentmentCurrency string   `json:"presentment_currency"`
+	TotalPriceSet       moneySet `json:"total_price_set"`
+	SubtotalPriceSet    moneySet `json:"subtotal_price_set"`
+	// NoteAttributes carries the draft order's custom attributes into the paid order.
+	NoteAttributes []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"note_attributes"`
+	Customer struct {
 		FirstName string `json:"first_name"`
 		LastName  string `json:"last_name"`
 	} `json:"customer"`
 	LineItems []struct {
 		ProductID int64 `json:"product_id"`
+		VariantID int64 `json:"variant_id"`
 	} `json:"line_items"`
 }
 
+func (o orderPaidPayload) Attributes() map[string]string {
+	out := make(map[string]string, len(o.NoteAttributes))
+	for _, a := range o.NoteAttributes {
+		out[a.Name] = a.Value
+	}
+	return out
+}
+
+// ShopTotal is what the order totalled in the shop currency.
+func (o orderPaidPayload) ShopTotal() money {
+	if m := o.TotalPriceSet.ShopMoney; m.Amount != "" && m.CurrencyCode != "" {
+		return m
+	}
+	return money{Amount: o.TotalPrice, CurrencyCode: o.Currency}
+}
+
+// PresentmentTotal is what the client paid in the currency they checked out in. It is empty when that is the
+// shop currency.
+func (o orderPaidPayload) PresentmentTotal() money {
+	return o.presentment(o.TotalPriceSet.PresentmentMoney)
+}
+
+// PaidAmount is what the order paid for its line items in the shop currency: the subtotal, without the tax,
+// shipping and tips Shopify may have added. Milestone and bundle payments are booked at this figure.
+func (o orderPaidPayload) PaidAmount() string {
+	if m := o.SubtotalPriceSet.ShopMoney; m.Amount != "" {
+		return m.Amount
+	}
+	if o.SubtotalPrice != "" {
+		return o.SubtotalPrice
+	}
+	return o.TotalPrice
+}
+
+// PresentmentPaid is PaidAmount in the currency the client checked out in; empty as for PresentmentTotal.
+func (o orderPaidPayload) PresentmentPaid() money {
+	return o.presentment(o.SubtotalPriceSet.PresentmentMoney)
+}
+
+func (o orderPaidPayload) presentment(m money) money {
+	if m.CurrencyCode == "" {
+		m.CurrencyCode = o.PresentmentCurrency
+	}
+	m.CurrencyCode = currency.Normalize(m.CurrencyCode)
+	if m.Amount == "" || m.CurrencyCode == "" || m.CurrencyCode == currency.Normalize(o.ShopTotal().CurrencyCode) {
+		return money{}
+	}
+	return m
+}
+
 func (o orderPaidPayload) CustomerName() string {
 	name := strings.TrimSpace(o.Customer.FirstName + " " + o.Customer.LastName)
 	return strings.TrimSpace(name)
 }
 
+type money struct {
+	Amount       string `json:"amount"`
+	CurrencyCode string `json:"currency_code"`
+}
+
+type moneySet struct {
+	ShopMoney        money `json:"shop_money"`
+	PresentmentMoney money `json:"presentment_money"`
+}
+
 type milestonePaidPayload struct {
 	DraftOrderID string `json:"draft_order_id"`
 }
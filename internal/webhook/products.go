@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+
+	"microservice/internal/serviceproduct"
+	"microservice/internal/shop"
+)
+
+type productDeletePayload struct {
+	ID int64 `json:"id"`
+}
+
+// handleProductDelete flags the service product config of a product deleted in Shopify. The config and the
+// services created from it are kept; the admin list shows the product as deleted.
+func (h Handler) handleProductDelete(ctx context.Context, tx pgx.Tx, shopRec *shop.Shop, body []byte) error {
+	var payload productDeletePayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.ID == 0 {
+		return nil
+	}
+	_, err := serviceproduct.MarkProductDeleted(ctx, tx, shopRec.ID, int64ToString(payload.ID), "webhook")
+	return err
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS audit_logs_shop_created_idx;
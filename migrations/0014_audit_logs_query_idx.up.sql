@@ -0,0 +1,2 @@
+-- Supports the merchant audit query API (newest-first, keyset pagination per shop).
+CREATE INDEX IF NOT EXISTS audit_logs_shop_created_idx ON audit_logs(shop_id, created_at DESC, id DESC);
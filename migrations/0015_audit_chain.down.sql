@@ -0,0 +1,11 @@
+ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS audit_logs_service_id_fkey;
+ALTER TABLE audit_logs
+  ADD CONSTRAINT audit_logs_service_id_fkey FOREIGN KEY (service_id) REFERENCES services(id) ON DELETE SET NULL;
+
+DROP TABLE IF EXISTS audit_chain_heads;
+DROP INDEX IF EXISTS audit_logs_shop_chain_seq_uidx;
+
+ALTER TABLE audit_logs
+  DROP COLUMN IF EXISTS hash,
+  DROP COLUMN IF EXISTS prev_hash,
+  DROP COLUMN IF EXISTS chain_seq;
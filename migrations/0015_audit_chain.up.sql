@@ -0,0 +1,26 @@
+-- Tamper-evident, per-shop hash chain over audit_logs.
+-- Each chained row stores the previous row's hash and the hash of its own canonical content;
+-- audit_chain_heads holds the tip so truncating the newest rows is detectable too.
+-- Rows written before this migration stay unchained (chain_seq IS NULL).
+
+ALTER TABLE audit_logs
+  ADD COLUMN IF NOT EXISTS chain_seq BIGINT,
+  ADD COLUMN IF NOT EXISTS prev_hash TEXT,
+  ADD COLUMN IF NOT EXISTS hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS audit_logs_shop_chain_seq_uidx
+  ON audit_logs(shop_id, chain_seq)
+  WHERE chain_seq IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS audit_chain_heads (
+  shop_id UUID PRIMARY KEY REFERENCES shops(id) ON DELETE CASCADE,
+  last_seq BIGINT NOT NULL DEFAULT 0,
+  last_hash TEXT NOT NULL DEFAULT '',
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- service_id is part of the hashed content, so it must never be rewritten by ON DELETE SET NULL.
+-- Services are only removed together with their shop, which cascades to audit_logs as well.
+ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS audit_logs_service_id_fkey;
+ALTER TABLE audit_logs
+  ADD CONSTRAINT audit_logs_service_id_fkey FOREIGN KEY (service_id) REFERENCES services(id);
@@ -0,0 +1,11 @@
+-- Staff users seen via verified Shopify session tokens (sub claim).
+-- Lets audit views show a name/email next to "staff:<userId>" actors.
+CREATE TABLE IF NOT EXISTS staff_members (
+  shop_id UUID NOT NULL REFERENCES shops(id) ON DELETE CASCADE,
+  user_id TEXT NOT NULL,
+  name TEXT,
+  email TEXT,
+  last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (shop_id, user_id)
+);
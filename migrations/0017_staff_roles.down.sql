@@ -0,0 +1 @@
+ALTER TABLE staff_members DROP COLUMN IF EXISTS role;
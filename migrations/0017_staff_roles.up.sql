@@ -0,0 +1,4 @@
+-- Per-shop role assignments for staff users (NULL = not assigned).
+ALTER TABLE staff_members
+  ADD COLUMN IF NOT EXISTS role TEXT
+  CHECK (role IN ('viewer', 'operator', 'finance_admin'));
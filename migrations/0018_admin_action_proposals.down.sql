@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS admin_actions_service_status_idx;
+
+ALTER TABLE admin_actions
+  DROP COLUMN IF EXISTS expires_at,
+  DROP COLUMN IF EXISTS decision_note,
+  DROP COLUMN IF EXISTS decided_at,
+  DROP COLUMN IF EXISTS decided_by,
+  DROP COLUMN IF EXISTS status;
@@ -0,0 +1,12 @@
+-- Admin overrides become two-person proposals: a pending row that a second staff member confirms or rejects.
+-- Rows written before this migration were applied immediately.
+ALTER TABLE admin_actions
+  ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'applied'
+    CHECK (status IN ('pending', 'applied', 'rejected', 'expired')),
+  ADD COLUMN IF NOT EXISTS decided_by TEXT,
+  ADD COLUMN IF NOT EXISTS decided_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS decision_note TEXT,
+  ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+-- actor remains the proposer.
+CREATE INDEX IF NOT EXISTS admin_actions_service_status_idx ON admin_actions(service_id, status);
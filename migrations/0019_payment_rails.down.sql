@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS milestones_payment_reference_idx;
+
+ALTER TABLE milestones
+  DROP COLUMN IF EXISTS payment_instructions,
+  DROP COLUMN IF EXISTS payment_reference,
+  DROP COLUMN IF EXISTS payment_rail;
+
+ALTER TABLE shops
+  DROP COLUMN IF EXISTS payment_rail;
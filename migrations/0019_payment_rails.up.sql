@@ -0,0 +1,19 @@
+-- Payment rails: how a milestone is collected (Shopify draft order, manual invoice, ...).
+-- NULL on shops means the default rail (draft_order).
+ALTER TABLE shops
+  ADD COLUMN IF NOT EXISTS payment_rail TEXT
+    CHECK (payment_rail IN ('draft_order', 'manual_invoice', 'fake'));
+
+-- The rail a milestone's payment request went through and the rail's own reference for it
+-- (draft order id, invoice number, ...). draft_order_id/checkout_url stay populated for the draft order rail.
+ALTER TABLE milestones
+  ADD COLUMN IF NOT EXISTS payment_rail TEXT,
+  ADD COLUMN IF NOT EXISTS payment_reference TEXT,
+  ADD COLUMN IF NOT EXISTS payment_instructions TEXT;
+
+UPDATE milestones
+SET payment_rail = 'draft_order', payment_reference = draft_order_id
+WHERE draft_order_id IS NOT NULL AND payment_rail IS NULL;
+
+CREATE INDEX IF NOT EXISTS milestones_payment_reference_idx ON milestones(payment_rail, payment_reference)
+  WHERE payment_reference IS NOT NULL;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS milestones_draft_order_id_idx;
+
+ALTER TABLE milestones
+  DROP COLUMN IF EXISTS observed_amount,
+  DROP COLUMN IF EXISTS amount_mismatch,
+  DROP COLUMN IF EXISTS draft_order_status;
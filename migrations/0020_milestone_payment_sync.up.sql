@@ -0,0 +1,8 @@
+-- Draft order lifecycle sync. The last known draft status lets us tell stale payment links apart,
+-- and amount_mismatch flags milestones whose payment did not match milestones.amount.
+ALTER TABLE milestones
+  ADD COLUMN IF NOT EXISTS draft_order_status TEXT,
+  ADD COLUMN IF NOT EXISTS amount_mismatch BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN IF NOT EXISTS observed_amount NUMERIC(12,2);
+
+CREATE INDEX IF NOT EXISTS milestones_draft_order_id_idx ON milestones(draft_order_id) WHERE draft_order_id IS NOT NULL;
@@ -0,0 +1,7 @@
+-- Collapse derived statuses back to the binary model.
+UPDATE milestones SET status = 'paid' WHERE status = 'overpaid';
+UPDATE milestones SET status = 'unpaid' WHERE status = 'partially_paid';
+
+ALTER TABLE milestones DROP COLUMN IF EXISTS amount_paid;
+
+DROP TABLE IF EXISTS milestone_payments;
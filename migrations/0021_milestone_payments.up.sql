@@ -0,0 +1,39 @@
+-- One row per payment received against a milestone, plus credit moved between milestones.
+-- milestones.amount_paid is SUM(amount) of a milestone's rows and milestones.status is derived from it.
+CREATE TABLE IF NOT EXISTS milestone_payments (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  milestone_id UUID NOT NULL REFERENCES milestones(id) ON DELETE CASCADE,
+  service_id UUID NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+
+  kind TEXT NOT NULL CHECK (kind IN ('payment', 'credit_in', 'credit_out')),
+  amount NUMERIC(12,2) NOT NULL CHECK (amount <> 0), -- credit_out rows are negative
+
+  -- Where the money came from: order, manual, override, deposit, import, backfill.
+  source TEXT NOT NULL,
+  reference TEXT,
+
+  -- For credit rows: the milestone the credit moved from/to.
+  related_milestone_id UUID REFERENCES milestones(id) ON DELETE SET NULL,
+
+  received_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS milestone_payments_milestone_idx ON milestone_payments(milestone_id);
+CREATE INDEX IF NOT EXISTS milestone_payments_service_idx ON milestone_payments(service_id);
+
+-- The same Shopify order is never booked twice.
+CREATE UNIQUE INDEX IF NOT EXISTS milestone_payments_order_uniq
+  ON milestone_payments(milestone_id, reference)
+  WHERE kind = 'payment' AND source = 'order' AND reference IS NOT NULL;
+
+ALTER TABLE milestones
+  ADD COLUMN IF NOT EXISTS amount_paid NUMERIC(12,2) NOT NULL DEFAULT 0;
+
+-- Milestones paid before the ledger existed get one backfilled payment row for their full amount.
+INSERT INTO milestone_payments (milestone_id, service_id, kind, amount, source, received_at)
+SELECT id, service_id, 'payment', amount, 'backfill', COALESCE(paid_at, created_at)
+FROM milestones
+WHERE status = 'paid' AND amount > 0;
+
+UPDATE milestones SET amount_paid = amount WHERE status = 'paid';
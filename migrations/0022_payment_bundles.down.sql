@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS payment_bundle_items;
+DROP TABLE IF EXISTS payment_bundles;
@@ -0,0 +1,31 @@
+-- A payment bundle is one draft order covering every milestone of a service that could be paid when it was
+-- issued ("pay remaining balance"). Items keep the amount each milestone was billed for in the bundle.
+CREATE TABLE IF NOT EXISTS payment_bundles (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  shop_id UUID NOT NULL REFERENCES shops(id) ON DELETE CASCADE,
+  service_id UUID NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+
+  status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'paid', 'cancelled')),
+  amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+  currency TEXT NOT NULL,
+
+  draft_order_id TEXT,
+  checkout_url TEXT,
+
+  requested_by TEXT NOT NULL,
+  paid_order_id TEXT,
+  paid_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- At most one open bundle per service; issuing a new one cancels the previous.
+CREATE UNIQUE INDEX IF NOT EXISTS payment_bundles_open_uniq ON payment_bundles(service_id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS payment_bundles_draft_order_idx ON payment_bundles(shop_id, draft_order_id)
+  WHERE draft_order_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS payment_bundle_items (
+  bundle_id UUID NOT NULL REFERENCES payment_bundles(id) ON DELETE CASCADE,
+  milestone_id UUID NOT NULL REFERENCES milestones(id) ON DELETE CASCADE,
+  amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+  PRIMARY KEY (bundle_id, milestone_id)
+);
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS milestone_charge_attempts;
+
+ALTER TABLE services
+  DROP COLUMN IF EXISTS payment_mandate_id,
+  DROP COLUMN IF EXISTS auto_charge;
+
+DROP INDEX IF EXISTS milestones_due_at_idx;
+
+ALTER TABLE milestones
+  DROP COLUMN IF EXISTS due_at;
@@ -0,0 +1,37 @@
+-- Due dates drive scheduled work such as auto-charging. NULL: no due date.
+ALTER TABLE milestones
+  ADD COLUMN IF NOT EXISTS due_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS milestones_due_at_idx ON milestones(due_at)
+  WHERE due_at IS NOT NULL AND status IN ('unpaid', 'partially_paid');
+
+-- Auto-charge is opted into by the client at checkout; milestones are then charged on their due date through
+-- the payment mandate the client granted on the original order.
+ALTER TABLE services
+  ADD COLUMN IF NOT EXISTS auto_charge BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN IF NOT EXISTS payment_mandate_id TEXT;
+
+-- One row per charge attempt. The idempotency key is sent to Shopify, so re-submitting a pending attempt after a
+-- crash can never charge twice.
+CREATE TABLE IF NOT EXISTS milestone_charge_attempts (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  milestone_id UUID NOT NULL REFERENCES milestones(id) ON DELETE CASCADE,
+  service_id UUID NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+
+  attempt INT NOT NULL CHECK (attempt > 0),
+  idempotency_key TEXT NOT NULL UNIQUE,
+  amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+
+  payment_reference TEXT,
+  error TEXT,
+  next_retry_at TIMESTAMPTZ, -- set on failed attempts that will be retried
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMPTZ,
+
+  UNIQUE (milestone_id, attempt)
+);
+
+CREATE INDEX IF NOT EXISTS milestone_charge_attempts_pending_idx ON milestone_charge_attempts(created_at)
+  WHERE status = 'pending';
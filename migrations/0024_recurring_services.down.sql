@@ -0,0 +1,5 @@
+ALTER TABLE milestones
+  DROP COLUMN IF EXISTS period_end,
+  DROP COLUMN IF EXISTS period_start;
+
+DROP TABLE IF EXISTS service_recurrences;
@@ -0,0 +1,29 @@
+-- Recurring (retainer) services bill one milestone per cycle. Cycle 0 is paid by the order; the generator job
+-- adds the milestone for each later cycle when it starts, until the recurrence is cancelled or runs its cycles.
+CREATE TABLE IF NOT EXISTS service_recurrences (
+  service_id UUID PRIMARY KEY REFERENCES services(id) ON DELETE CASCADE,
+
+  interval TEXT NOT NULL CHECK (interval IN ('week', 'month')),
+  interval_count INT NOT NULL DEFAULT 1 CHECK (interval_count > 0),
+  cycle_amount NUMERIC(12,2) NOT NULL CHECK (cycle_amount > 0),
+  max_cycles INT CHECK (max_cycles > 0), -- NULL: until cancelled
+  due_in_days INT NOT NULL DEFAULT 0,
+
+  anchor_at TIMESTAMPTZ NOT NULL,
+  cycles_generated INT NOT NULL DEFAULT 1,
+  next_cycle_at TIMESTAMPTZ, -- NULL once no further cycle will start
+
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'cancelled', 'ended')),
+  cancelled_at TIMESTAMPTZ,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS service_recurrences_next_cycle_idx ON service_recurrences(next_cycle_at)
+  WHERE status = 'active';
+
+-- The billing period a recurring milestone covers; NULL for milestones of fixed-split services.
+ALTER TABLE milestones
+  ADD COLUMN IF NOT EXISTS period_start TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS period_end TIMESTAMPTZ;
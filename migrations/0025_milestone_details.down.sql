@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS milestone_deliverables;
+
+ALTER TABLE milestones
+  DROP COLUMN IF EXISTS client_note,
+  DROP COLUMN IF EXISTS description,
+  DROP COLUMN IF EXISTS title;
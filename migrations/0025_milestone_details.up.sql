@@ -0,0 +1,22 @@
+-- Client-facing milestone text, copied from the service product template when the milestone is created so
+-- later template edits don't rewrite what the client already saw.
+ALTER TABLE milestones
+  ADD COLUMN IF NOT EXISTS title TEXT,
+  ADD COLUMN IF NOT EXISTS description TEXT,
+  ADD COLUMN IF NOT EXISTS client_note TEXT;
+
+-- Deliverables checklist of a milestone; the merchant ticks items off as they are delivered.
+CREATE TABLE IF NOT EXISTS milestone_deliverables (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  milestone_id UUID NOT NULL REFERENCES milestones(id) ON DELETE CASCADE,
+  position INT NOT NULL,
+  label TEXT NOT NULL,
+
+  done BOOLEAN NOT NULL DEFAULT FALSE,
+  done_at TIMESTAMPTZ,
+  done_by TEXT,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+  UNIQUE (milestone_id, position)
+);
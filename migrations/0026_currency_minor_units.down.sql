@@ -0,0 +1,42 @@
+ALTER TABLE milestone_payments
+  DROP COLUMN IF EXISTS presentment_amount,
+  DROP COLUMN IF EXISTS presentment_currency;
+
+ALTER TABLE milestones
+  DROP COLUMN IF EXISTS presentment_amount;
+
+ALTER TABLE services
+  DROP COLUMN IF EXISTS presentment_total_amount,
+  DROP COLUMN IF EXISTS presentment_currency;
+
+-- Going back to two decimals rounds three-decimal amounts.
+ALTER TABLE service_recurrences
+  DROP CONSTRAINT IF EXISTS service_recurrences_cycle_amount_scale,
+  ALTER COLUMN cycle_amount TYPE NUMERIC(12,2);
+
+ALTER TABLE milestone_charge_attempts
+  DROP CONSTRAINT IF EXISTS milestone_charge_attempts_amount_scale,
+  ALTER COLUMN amount TYPE NUMERIC(12,2);
+
+ALTER TABLE payment_bundle_items
+  DROP CONSTRAINT IF EXISTS payment_bundle_items_amount_scale,
+  ALTER COLUMN amount TYPE NUMERIC(12,2);
+
+ALTER TABLE payment_bundles
+  DROP CONSTRAINT IF EXISTS payment_bundles_amount_scale,
+  ALTER COLUMN amount TYPE NUMERIC(12,2);
+
+ALTER TABLE milestone_payments
+  DROP CONSTRAINT IF EXISTS milestone_payments_amount_scale,
+  ALTER COLUMN amount TYPE NUMERIC(12,2);
+
+ALTER TABLE milestones
+  DROP CONSTRAINT IF EXISTS milestones_amount_scale,
+  ALTER COLUMN observed_amount TYPE NUMERIC(12,2),
+  ALTER COLUMN amount_paid TYPE NUMERIC(12,2),
+  ALTER COLUMN amount TYPE NUMERIC(12,2);
+
+ALTER TABLE services
+  DROP CONSTRAINT IF EXISTS services_total_amount_scale,
+  ALTER COLUMN currency SET DEFAULT 'USD',
+  ALTER COLUMN total_amount TYPE NUMERIC(12,2);
@@ -0,0 +1,45 @@
+-- Amounts are stored with their currency's minor units (0 for JPY, 2 for EUR, 3 for KWD). Unconstrained NUMERIC
+-- keeps the scale each amount was written with, so amounts read back formatted for their currency.
+ALTER TABLE services
+  ALTER COLUMN total_amount TYPE NUMERIC,
+  ADD CONSTRAINT services_total_amount_scale CHECK (scale(total_amount) <= 3),
+  ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE milestones
+  ALTER COLUMN amount TYPE NUMERIC,
+  ALTER COLUMN amount_paid TYPE NUMERIC,
+  ALTER COLUMN observed_amount TYPE NUMERIC,
+  ADD CONSTRAINT milestones_amount_scale CHECK (scale(amount) <= 3 AND scale(amount_paid) <= 3);
+
+ALTER TABLE milestone_payments
+  ALTER COLUMN amount TYPE NUMERIC,
+  ADD CONSTRAINT milestone_payments_amount_scale CHECK (scale(amount) <= 3);
+
+ALTER TABLE payment_bundles
+  ALTER COLUMN amount TYPE NUMERIC,
+  ADD CONSTRAINT payment_bundles_amount_scale CHECK (scale(amount) <= 3);
+
+ALTER TABLE payment_bundle_items
+  ALTER COLUMN amount TYPE NUMERIC,
+  ADD CONSTRAINT payment_bundle_items_amount_scale CHECK (scale(amount) <= 3);
+
+ALTER TABLE milestone_charge_attempts
+  ALTER COLUMN amount TYPE NUMERIC,
+  ADD CONSTRAINT milestone_charge_attempts_amount_scale CHECK (scale(amount) <= 3);
+
+ALTER TABLE service_recurrences
+  ALTER COLUMN cycle_amount TYPE NUMERIC,
+  ADD CONSTRAINT service_recurrences_cycle_amount_scale CHECK (scale(cycle_amount) <= 3);
+
+-- Orders paid in a currency other than the shop's: services, milestones and payments are kept in the shop
+-- currency (what Shopify settles in); the amounts the client saw are kept alongside. NULL when both are the same.
+ALTER TABLE services
+  ADD COLUMN IF NOT EXISTS presentment_currency TEXT,
+  ADD COLUMN IF NOT EXISTS presentment_total_amount NUMERIC CHECK (scale(presentment_total_amount) <= 3);
+
+ALTER TABLE milestones
+  ADD COLUMN IF NOT EXISTS presentment_amount NUMERIC CHECK (scale(presentment_amount) <= 3);
+
+ALTER TABLE milestone_payments
+  ADD COLUMN IF NOT EXISTS presentment_currency TEXT,
+  ADD COLUMN IF NOT EXISTS presentment_amount NUMERIC CHECK (scale(presentment_amount) <= 3);
@@ -0,0 +1,7 @@
+ALTER TABLE services
+  DROP COLUMN IF EXISTS config_version;
+
+ALTER TABLE service_product_configs
+  DROP COLUMN IF EXISTS version;
+
+DROP TABLE IF EXISTS service_product_config_versions;
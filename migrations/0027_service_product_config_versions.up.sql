@@ -0,0 +1,34 @@
+-- Every saved service product config is kept as a numbered version. service_product_configs holds the active
+-- one; a version saved with active_from in the future waits until then (the activation job promotes it, and
+-- orders paid after active_from already use it).
+CREATE TABLE IF NOT EXISTS service_product_config_versions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  config_id UUID NOT NULL REFERENCES service_product_configs(id) ON DELETE CASCADE,
+  version INT NOT NULL CHECK (version > 0),
+  config JSONB NOT NULL,
+
+  active_from TIMESTAMPTZ,  -- NULL: active as soon as it was saved
+  activated_at TIMESTAMPTZ, -- NULL while scheduled
+  restored_from INT,        -- the version this one copies, when it was saved by a restore
+
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (config_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS service_product_config_versions_due_idx ON service_product_config_versions(active_from)
+  WHERE activated_at IS NULL;
+
+ALTER TABLE service_product_configs
+  ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+-- Existing configs become their product's version 1.
+INSERT INTO service_product_config_versions (config_id, version, config, activated_at, created_by, created_at)
+SELECT id, 1, config, updated_at, 'system', updated_at
+FROM service_product_configs
+ON CONFLICT (config_id, version) DO NOTHING;
+
+-- The config version a service was created from; NULL for services created before versioning or imported with
+-- an inline config.
+ALTER TABLE services
+  ADD COLUMN IF NOT EXISTS config_version INT;
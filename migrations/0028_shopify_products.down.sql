@@ -0,0 +1 @@
+DROP TABLE IF EXISTS shopify_products;
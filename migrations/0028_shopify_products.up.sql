@@ -0,0 +1,15 @@
+-- Cached Shopify product data shown next to service product configs. Rows are refreshed from the Admin API
+-- when a config is saved or the cached copy is stale; deleted_at is set by the products/delete webhook.
+CREATE TABLE IF NOT EXISTS shopify_products (
+  shop_id UUID NOT NULL REFERENCES shops(id) ON DELETE CASCADE,
+  shopify_product_id TEXT NOT NULL,
+
+  title TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT '',
+  image_url TEXT,
+  variants JSONB NOT NULL DEFAULT '[]'::jsonb,
+
+  synced_at TIMESTAMPTZ,  -- NULL when only the deletion is known
+  deleted_at TIMESTAMPTZ, -- set when Shopify reported the product deleted
+  PRIMARY KEY (shop_id, shopify_product_id)
+);
@@ -0,0 +1,13 @@
+ALTER TABLE services
+  DROP COLUMN IF EXISTS shopify_variant_id;
+
+DELETE FROM service_product_configs WHERE shopify_variant_id <> '';
+
+ALTER TABLE service_product_configs
+  DROP CONSTRAINT IF EXISTS service_product_configs_shop_product_variant_key;
+
+ALTER TABLE service_product_configs
+  ADD CONSTRAINT service_product_configs_shop_id_shopify_product_id_key UNIQUE (shop_id, shopify_product_id);
+
+ALTER TABLE service_product_configs
+  DROP COLUMN IF EXISTS shopify_variant_id;
@@ -0,0 +1,15 @@
+-- A config can apply to a single variant of a product ("Basic / Pro / Premium"), overriding the product-level
+-- config for orders of that variant. Product-level configs have an empty variant id so the unique key still
+-- holds for them.
+ALTER TABLE service_product_configs
+  ADD COLUMN IF NOT EXISTS shopify_variant_id TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE service_product_configs
+  DROP CONSTRAINT IF EXISTS service_product_configs_shop_id_shopify_product_id_key;
+
+ALTER TABLE service_product_configs
+  ADD CONSTRAINT service_product_configs_shop_product_variant_key UNIQUE (shop_id, shopify_product_id, shopify_variant_id);
+
+-- The ordered variant a service was created from; NULL for services created before variant configs.
+ALTER TABLE services
+  ADD COLUMN IF NOT EXISTS shopify_variant_id TEXT;
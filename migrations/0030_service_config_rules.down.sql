@@ -0,0 +1,6 @@
+ALTER TABLE shopify_products
+  DROP COLUMN IF EXISTS collection_ids,
+  DROP COLUMN IF EXISTS tags;
+
+DROP TABLE IF EXISTS service_config_rules;
+DROP TABLE IF EXISTS service_config_defaults;
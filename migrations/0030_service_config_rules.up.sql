@@ -0,0 +1,28 @@
+-- Shop-wide service templates. A product without its own config becomes a service product when a rule matches
+-- it: every product with a tag, every product in a collection, or every product of the shop. A rule carries its
+-- own config or, with config NULL, uses the shop default template.
+CREATE TABLE IF NOT EXISTS service_config_defaults (
+  shop_id UUID PRIMARY KEY REFERENCES shops(id) ON DELETE CASCADE,
+  config JSONB NOT NULL,
+  updated_by TEXT NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS service_config_rules (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  shop_id UUID NOT NULL REFERENCES shops(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL CHECK (kind IN ('tag', 'collection', 'all')),
+  match TEXT NOT NULL DEFAULT '', -- lower-cased tag or numeric collection id; empty for 'all'
+  priority INT NOT NULL DEFAULT 100, -- lower is evaluated first
+  config JSONB,                       -- NULL: the shop default template
+
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (shop_id, kind, match)
+);
+
+-- Rules match on product tags and collections, which the product cache now keeps.
+ALTER TABLE shopify_products
+  ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}',
+  ADD COLUMN IF NOT EXISTS collection_ids TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+ALTER TABLE service_product_configs
+  DROP COLUMN IF EXISTS enabled;
@@ -0,0 +1,4 @@
+-- A disabled config keeps its versions but creates no services: orders of the product (or variant) are
+-- ignored instead of falling back to the product config or shop rules.
+ALTER TABLE service_product_configs
+  ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT TRUE;
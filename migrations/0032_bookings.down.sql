@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bookings;
+DROP TABLE IF EXISTS booking_settings;
@@ -0,0 +1,42 @@
+-- Booking availability and rules per shop. windows holds the weekly availability: wall-clock times in
+-- timezone, split into slots of slot_minutes that each take up to capacity bookings. The row is also the lock
+-- that serializes bookings of the shop, so two clients cannot take the last place of a slot.
+CREATE TABLE IF NOT EXISTS booking_settings (
+  shop_id UUID PRIMARY KEY REFERENCES shops(id) ON DELETE CASCADE,
+  timezone TEXT NOT NULL DEFAULT 'UTC',
+  min_notice_hours INT NOT NULL DEFAULT 24 CHECK (min_notice_hours >= 0),
+  horizon_days INT NOT NULL DEFAULT 60 CHECK (horizon_days > 0),
+  change_cutoff_hours INT NOT NULL DEFAULT 24 CHECK (change_cutoff_hours >= 0),
+  max_reschedules INT NOT NULL DEFAULT 2 CHECK (max_reschedules >= 0),
+  windows JSONB NOT NULL DEFAULT '[]',
+  closed_dates TEXT[] NOT NULL DEFAULT '{}', -- YYYY-MM-DD in timezone
+
+  updated_by TEXT NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- The slot a client booked for a service. A reschedule moves the booking and bumps sequence, so calendar
+-- clients update the appointment they already have instead of adding a second one.
+CREATE TABLE IF NOT EXISTS bookings (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  shop_id UUID NOT NULL REFERENCES shops(id) ON DELETE CASCADE,
+  service_id UUID NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+
+  slot_start TIMESTAMPTZ NOT NULL,
+  slot_end TIMESTAMPTZ NOT NULL CHECK (slot_end > slot_start),
+  timezone TEXT NOT NULL, -- the shop's timezone when booked
+
+  status TEXT NOT NULL DEFAULT 'booked' CHECK (status IN ('booked', 'cancelled')),
+  sequence INT NOT NULL DEFAULT 0,
+  reschedule_count INT NOT NULL DEFAULT 0,
+  cancelled_at TIMESTAMPTZ,
+  cancelled_by TEXT,
+  cancel_reason TEXT,
+
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS bookings_one_active_per_service ON bookings(service_id) WHERE status = 'booked';
+CREATE INDEX IF NOT EXISTS bookings_shop_slot_idx ON bookings(shop_id, slot_start) WHERE status = 'booked';
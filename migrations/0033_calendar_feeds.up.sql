@@ -0,0 +1,14 @@
+-- Secret calendar feed URLs: one for the whole shop and one per staff member (staff_user_id set). Rotating a
+-- feed revokes its token and issues a new one, like portal tokens; feeds do not expire.
+CREATE TABLE IF NOT EXISTS calendar_feed_tokens (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  shop_id UUID NOT NULL REFERENCES shops(id) ON DELETE CASCADE,
+  staff_user_id TEXT, -- NULL: the shop feed
+  token TEXT NOT NULL UNIQUE,
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS calendar_feed_tokens_one_active
+  ON calendar_feed_tokens(shop_id, COALESCE(staff_user_id, '')) WHERE revoked_at IS NULL;
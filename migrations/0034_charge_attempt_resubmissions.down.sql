@@ -0,0 +1,11 @@
+UPDATE milestone_charge_attempts SET status = 'failed' WHERE status = 'abandoned';
+
+ALTER TABLE milestone_charge_attempts
+  DROP CONSTRAINT IF EXISTS milestone_charge_attempts_status_check;
+ALTER TABLE milestone_charge_attempts
+  ADD CONSTRAINT milestone_charge_attempts_status_check
+  CHECK (status IN ('pending', 'succeeded', 'failed'));
+
+ALTER TABLE milestone_charge_attempts
+  DROP COLUMN IF EXISTS last_sent_at,
+  DROP COLUMN IF EXISTS resubmissions;
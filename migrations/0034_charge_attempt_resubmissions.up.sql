@@ -0,0 +1,11 @@
+-- Pending attempts are resubmitted when Shopify never answered. Each resubmission counts against the
+-- milestone's attempt budget, and an attempt whose milestone no longer matches it is abandoned instead.
+ALTER TABLE milestone_charge_attempts
+  ADD COLUMN IF NOT EXISTS resubmissions INT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS last_sent_at TIMESTAMPTZ;
+
+ALTER TABLE milestone_charge_attempts
+  DROP CONSTRAINT IF EXISTS milestone_charge_attempts_status_check;
+ALTER TABLE milestone_charge_attempts
+  ADD CONSTRAINT milestone_charge_attempts_status_check
+  CHECK (status IN ('pending', 'succeeded', 'failed', 'abandoned'));
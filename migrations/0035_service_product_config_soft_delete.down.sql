@@ -0,0 +1,4 @@
+DELETE FROM service_product_configs WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE service_product_configs
+  DROP COLUMN IF EXISTS deleted_at;
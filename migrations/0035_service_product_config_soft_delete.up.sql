@@ -0,0 +1,4 @@
+-- Deleting a config only marks it deleted: its versions stay, and so do the config_version numbers services
+-- were created from. Saving a config for the product again brings it back with its next version number.
+ALTER TABLE service_product_configs
+  ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
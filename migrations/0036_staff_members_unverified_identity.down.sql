@@ -0,0 +1,2 @@
+-- The cleared names and emails were unverified and are not restored.
+SELECT 1;
@@ -0,0 +1,4 @@
+-- Staff names and emails were recorded from request headers any staff member could set, and audit views showed
+-- them as the actor's identity. They cannot be trusted, so they are cleared; audit entries fall back to the
+-- staff user id until a verified source fills them.
+UPDATE staff_members SET name = NULL, email = NULL;
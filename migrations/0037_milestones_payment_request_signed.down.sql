@@ -0,0 +1 @@
+ALTER TABLE milestones DROP COLUMN IF EXISTS payment_request_signed;
@@ -0,0 +1,3 @@
+-- Draft orders issued from now on carry signed reconciliation attributes. Requests issued before keep FALSE,
+-- which is what still lets their paid orders be matched by the order note.
+ALTER TABLE milestones ADD COLUMN IF NOT EXISTS payment_request_signed BOOLEAN NOT NULL DEFAULT FALSE;
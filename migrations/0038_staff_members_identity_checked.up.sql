@@ -0,0 +1,3 @@
+-- Staff names and emails are now filled from Shopify's own record of the user (the associated_user of an online
+-- access token). identity_checked_at is when that last happened, so it is refreshed about once a day.
+ALTER TABLE staff_members ADD COLUMN IF NOT EXISTS identity_checked_at TIMESTAMPTZ;
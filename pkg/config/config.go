@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -35,6 +37,32 @@ type Config struct {
 
 	// PortalLogoURL is shown in the client portal header (optional).
 	PortalLogoURL string
+
+	// OverrideSecondApproverAbove is a decimal amount; override proposals that mark paid or waive more than this
+	// can only be confirmed by a finance_admin. Below it an operator may confirm. Empty means only a
+	// finance_admin may confirm them.
+	OverrideSecondApproverAbove string
+
+	// ReconciliationSecret signs the milestone/service ids attached to payment draft orders so paid orders can
+	// be matched back without trusting the editable note. Defaults to the Shopify API secret.
+	ReconciliationSecret string
+
+	// OverrideProposalTTL is how long an admin override proposal waits for confirmation before it expires.
+	OverrideProposalTTL time.Duration
+
+	// SchedulerEnabled runs background jobs (auto-charge, ...) in this process.
+	SchedulerEnabled bool
+
+	// AutoChargeInterval is how often due milestones of opted-in services are charged. AutoChargeMaxAttempts
+	// bounds the charge attempts per milestone; a failed attempt is retried after AutoChargeRetryBackoff,
+	// doubling each time.
+	AutoChargeInterval     time.Duration
+	AutoChargeMaxAttempts  int
+	AutoChargeRetryBackoff time.Duration
+	// RecurringInterval is how often the milestones of started cycles of recurring services are generated.
+	RecurringInterval time.Duration
+	// ConfigActivationInterval is how often scheduled service product config versions are activated.
+	ConfigActivationInterval time.Duration
 }
 
 type DBConfig struct {
@@ -62,6 +90,9 @@ type ShopifyConfig struct {
 	//
 	// Never set this in production.
 	DevAdminAccessToken string
+
+	// AdminBaseURL points Admin API calls at a local fake instead of https://{shop} (ignored in prod).
+	AdminBaseURL string
 }
 
 func Load() Config {
@@ -102,11 +133,23 @@ func Load() Config {
 			WebhookSecret: os.Getenv("SHOPIFY_WEBHOOK_SECRET"),
 			APIVersion:    env("SHOPIFY_API_VERSION", "2025-10"),
 			DevAdminAccessToken: os.Getenv("SHOPIFY_DEV_ADMIN_ACCESS_TOKEN"),
+			AdminBaseURL:        os.Getenv("SHOPIFY_ADMIN_BASE_URL"),
 		},
 
 		PortalAllowedOrigins: envList("PORTAL_ALLOWED_ORIGINS", "http://localhost:5173,http://localhost:4173"),
 		PortalSupportEmail:   os.Getenv("PORTAL_SUPPORT_EMAIL"),
 		PortalLogoURL:        os.Getenv("PORTAL_LOGO_URL"),
+
+		OverrideSecondApproverAbove: os.Getenv("OVERRIDE_SECOND_APPROVER_ABOVE"),
+		OverrideProposalTTL:         envDuration("OVERRIDE_PROPOSAL_TTL", 24*time.Hour),
+		ReconciliationSecret:        env("RECONCILIATION_SECRET", os.Getenv("SHOPIFY_API_SECRET")),
+
+		SchedulerEnabled:         env("SCHEDULER_ENABLED", "true") == "true",
+		AutoChargeInterval:       envDuration("AUTO_CHARGE_INTERVAL", 15*time.Minute),
+		AutoChargeMaxAttempts:    envInt("AUTO_CHARGE_MAX_ATTEMPTS", 3),
+		AutoChargeRetryBackoff:   envDuration("AUTO_CHARGE_RETRY_BACKOFF", 24*time.Hour),
+		RecurringInterval:        envDuration("RECURRING_INTERVAL", time.Hour),
+		ConfigActivationInterval: envDuration("CONFIG_ACTIVATION_INTERVAL", 5*time.Minute),
 	}
 }
 
@@ -118,6 +161,22 @@ func env(key, fallback string) string {
 	return v
 }
 
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+func envInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
 func envList(key, fallbackCSV string) []string {
 	v := os.Getenv(key)
 	if v == "" {
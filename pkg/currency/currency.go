@@ -0,0 +1,91 @@
+// Package currency knows the minor units (decimal places) of ISO 4217 currencies, which decide how amounts
+// in that currency are rounded, stored and sent to Shopify.
+package currency
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// MaxMinorUnits is the most decimal places an amount column stores.
+const MaxMinorUnits = 3
+
+// zeroDecimal and threeDecimal list the active ISO 4217 currencies whose minor unit is not 2. Currencies with
+// four minor units (CLF, UYW) are accounting units no shop sells in and are not supported.
+var (
+	zeroDecimal = []string{
+		"BIF", "CLP", "DJF", "GNF", "ISK", "JPY", "KMF", "KRW", "PYG", "RWF", "UGX", "UYI", "VND", "VUV", "XAF",
+		"XOF", "XPF",
+	}
+	threeDecimal = []string{"BHD", "IQD", "JOD", "KWD", "LYD", "OMR", "TND"}
+	twoDecimal   = []string{
+		"AED", "AFN", "ALL", "AMD", "ANG", "AOA", "ARS", "AUD", "AWG", "AZN", "BAM", "BBD", "BDT", "BGN", "BMD",
+		"BND", "BOB", "BRL", "BSD", "BTN", "BWP", "BYN", "BZD", "CAD", "CDF", "CHF", "CNY", "COP", "CRC", "CUP",
+		"CVE", "CZK", "DKK", "DOP", "DZD", "EGP", "ERN", "ETB", "EUR", "FJD", "FKP", "GBP", "GEL", "GHS", "GIP",
+		"GMD", "GTQ", "GYD", "HKD", "HNL", "HTG", "HUF", "IDR", "ILS", "INR", "IRR", "JMD", "KES", "KGS", "KHR",
+		"KPW", "KYD", "KZT", "LAK", "LBP", "LKR", "LRD", "LSL", "MAD", "MDL", "MGA", "MKD", "MMK", "MNT", "MOP",
+		"MRU", "MUR", "MVR", "MWK", "MXN", "MYR", "MZN", "NAD", "NGN", "NIO", "NOK", "NPR", "NZD", "PAB", "PEN",
+		"PGK", "PHP", "PKR", "PLN", "QAR", "RON", "RSD", "RUB", "SAR", "SBD", "SCR", "SDG", "SEK", "SGD", "SHP",
+		"SLE", "SOS", "SRD", "SSP", "STN", "SVC", "SYP", "SZL", "THB", "TJS", "TMT", "TOP", "TRY", "TTD", "TWD",
+		"TZS", "UAH", "USD", "UZS", "VES", "WST", "XCD", "YER", "ZAR", "ZMW", "ZWL",
+	}
+)
+
+var minorUnits = func() map[string]int32 {
+	m := make(map[string]int32, len(zeroDecimal)+len(twoDecimal)+len(threeDecimal))
+	for _, c := range zeroDecimal {
+		m[c] = 0
+	}
+	for _, c := range twoDecimal {
+		m[c] = 2
+	}
+	for _, c := range threeDecimal {
+		m[c] = 3
+	}
+	return m
+}()
+
+// Normalize upper-cases and trims a currency code.
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// MinorUnits returns the number of decimal places of the currency.
+func MinorUnits(code string) (int32, error) {
+	code = Normalize(code)
+	if code == "" {
+		return 0, fmt.Errorf("currency is required")
+	}
+	n, ok := minorUnits[code]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %q", code)
+	}
+	return n, nil
+}
+
+// Valid reports whether the currency is known.
+func Valid(code string) bool {
+	_, err := MinorUnits(code)
+	return err == nil
+}
+
+// Round rounds amount to the currency's minor units.
+func Round(amount decimal.Decimal, code string) (decimal.Decimal, error) {
+	n, err := MinorUnits(code)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	return amount.Round(n), nil
+}
+
+// Format renders amount with exactly the currency's minor units ("1000" JPY, "12.50" EUR, "1.250" KWD).
+// Amounts in an unknown currency are rendered as they are.
+func Format(amount decimal.Decimal, code string) string {
+	n, err := MinorUnits(code)
+	if err != nil {
+		return amount.String()
+	}
+	return amount.StringFixed(n)
+}
@@ -0,0 +1,51 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMinorUnits(t *testing.T) {
+	cases := map[string]int32{"USD": 2, "eur": 2, " JPY ": 0, "KRW": 0, "KWD": 3, "BHD": 3}
+	for code, want := range cases {
+		got, err := MinorUnits(code)
+		if err != nil || got != want {
+			t.Fatalf("MinorUnits(%q) = %d, %v; want %d", code, got, err, want)
+		}
+	}
+	for _, code := range []string{"", "XXX", "CLF"} {
+		if _, err := MinorUnits(code); err == nil {
+			t.Fatalf("expected %q to be unsupported", code)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		amount, code, want string
+	}{
+		{"1000", "JPY", "1000"},
+		{"999.6", "JPY", "1000"},
+		{"12.5", "EUR", "12.50"},
+		{"1.25", "KWD", "1.250"},
+		{"1.2345", "KWD", "1.235"},
+	}
+	for _, c := range cases {
+		if got := Format(decimal.RequireFromString(c.amount), c.code); got != c.want {
+			t.Fatalf("Format(%s, %s) = %s, want %s", c.amount, c.code, got, c.want)
+		}
+	}
+}
+
+func TestTablesDoNotOverlap(t *testing.T) {
+	seen := map[string]bool{}
+	for _, list := range [][]string{zeroDecimal, twoDecimal, threeDecimal} {
+		for _, c := range list {
+			if seen[c] {
+				t.Fatalf("%s listed twice", c)
+			}
+			seen[c] = true
+		}
+	}
+}
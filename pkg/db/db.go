@@ -50,6 +50,20 @@ func WithTx(ctx context.Context, pool *pgxpool.Pool, fn func(tx pgx.Tx) error) e
 	return tx.Commit(ctx)
 }
 
+// WithSnapshot runs fn in a read-only REPEATABLE READ transaction, so every query in fn sees the same snapshot.
+func WithSnapshot(ctx context.Context, pool *pgxpool.Pool, fn func(tx pgx.Tx) error) error {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
 func runtimeConnString(cfg config.Config) string {
 	if strings.TrimSpace(cfg.DatabaseURL) != "" {
 		return cfg.DatabaseURL
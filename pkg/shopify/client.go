@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -15,6 +16,8 @@ type Client struct {
 	ShopDomain  string
 	AccessToken string
 	APIVersion  string
+	// BaseURL replaces https://{ShopDomain} when set, e.g. to point at a local fake Admin API in tests.
+	BaseURL string
 }
 
 func (c Client) doJSON(ctx context.Context, method, path string, reqBody any, respBody any) (int, error) {
@@ -35,7 +38,11 @@ func (c Client) doJSON(ctx context.Context, method, path string, reqBody any, re
 		}
 	}
 
-	u := fmt.Sprintf("https://%s/admin/api/%s%s", c.ShopDomain, c.APIVersion, path)
+	base := "https://" + c.ShopDomain
+	if c.BaseURL != "" {
+		base = strings.TrimRight(c.BaseURL, "/")
+	}
+	u := fmt.Sprintf("%s/admin/api/%s%s", base, c.APIVersion, path)
 	req, err := http.NewRequestWithContext(ctx, method, u, &buf)
 	if err != nil {
 		return 0, err
@@ -32,7 +32,28 @@ type DraftOrderResult struct {
 	InvoiceURL string `json:"invoice_url"`
 }
 
-func (c Client) CreateDraftOrder(ctx context.Context, title string, amount string, currency string, note string) (draftOrderID string, checkoutURL string, err error) {
+// Attribute is a draft order custom attribute. Shopify copies them to the resulting order's note_attributes;
+// keys starting with "_" are hidden from the customer at checkout.
+type Attribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// DraftOrderLine is one custom line item of a draft order, at quantity 1.
+type DraftOrderLine struct {
+	Title  string
+	Amount string
+}
+
+func (c Client) CreateDraftOrder(ctx context.Context, title string, amount string, currency string, note string, attributes []Attribute) (draftOrderID string, checkoutURL string, err error) {
+	return c.CreateDraftOrderLines(ctx, []DraftOrderLine{{Title: title, Amount: amount}}, currency, note, attributes)
+}
+
+// CreateDraftOrderLines creates a draft order with one custom line item per entry of lines.
+func (c Client) CreateDraftOrderLines(ctx context.Context, lines []DraftOrderLine, currency string, note string, attributes []Attribute) (draftOrderID string, checkoutURL string, err error) {
+	if len(lines) == 0 {
+		return "", "", fmt.Errorf("draftOrderCreate: no line items")
+	}
 	// Use GraphQL instead of REST /draft_orders.json.
 	// Some shops/apps are blocked from certain REST endpoints (protected customer data policy),
 	// while GraphQL draftOrderCreate remains available when properly scoped.
@@ -71,15 +92,21 @@ mutation DraftOrderCreate($input: DraftOrderInput!) {
 
 	// GraphQL input for a custom line item draft order.
 	// Note: Shopify accepts decimals as strings for originalUnitPrice.
+	// Custom line items are taxable by default; the amount is what the milestone owes, so tax must not be
+	// added on top of it.
+	lineItems := make([]map[string]any, 0, len(lines))
+	for _, l := range lines {
+		lineItems = append(lineItems, map[string]any{
+			"title":             l.Title,
+			"quantity":          1,
+			"originalUnitPrice": l.Amount,
+			"taxable":           false,
+		})
+	}
 	input := map[string]any{
-		"note": note,
-		"lineItems": []map[string]any{
-			{
-				"title":             title,
-				"quantity":          1,
-				"originalUnitPrice": amount,
-			},
-		},
+		"note":             note,
+		"customAttributes": attributes,
+		"lineItems":        lineItems,
 	}
 
 	var resp gqlResp
@@ -115,3 +142,161 @@ mutation DraftOrderCreate($input: DraftOrderInput!) {
 }
 
 
+
+// DeleteDraftOrder deletes an open draft order so its invoice link stops working.
+// draftOrderID is the numeric id returned by CreateDraftOrder.
+func (c Client) DeleteDraftOrder(ctx context.Context, draftOrderID string) error {
+	const mutation = `
+mutation DraftOrderDelete($input: DraftOrderDeleteInput!) {
+  draftOrderDelete(input: $input) {
+    deletedId
+    userErrors {
+      field
+      message
+    }
+  }
+}
+`
+
+	type gqlResp struct {
+		Data struct {
+			DraftOrderDelete struct {
+				DeletedID  string `json:"deletedId"`
+				UserErrors []struct {
+					Field   []string `json:"field"`
+					Message string   `json:"message"`
+				} `json:"userErrors"`
+			} `json:"draftOrderDelete"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	gid := draftOrderID
+	if !strings.HasPrefix(gid, "gid://") {
+		gid = "gid://shopify/DraftOrder/" + draftOrderID
+	}
+
+	var resp gqlResp
+	if _, err := c.doJSON(ctx, http.MethodPost, "/graphql.json", map[string]any{
+		"query":     mutation,
+		"variables": map[string]any{"input": map[string]any{"id": gid}},
+	}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("draftOrderDelete graphql error: %s", resp.Errors[0].Message)
+	}
+	if len(resp.Data.DraftOrderDelete.UserErrors) > 0 {
+		return fmt.Errorf("draftOrderDelete user error: %s", resp.Data.DraftOrderDelete.UserErrors[0].Message)
+	}
+	return nil
+}
+
+// DraftOrderState is the part of a draft order the app keeps in sync with its milestone.
+type DraftOrderState struct {
+	ID         string
+	Status     string // OPEN | INVOICE_SENT | COMPLETED
+	InvoiceURL string
+	TotalPrice string
+	// SubtotalPrice is the line items' total before tax and shipping: the amount the app asked for.
+	SubtotalPrice string
+	Currency      string
+	OrderID       string // set once completed
+}
+
+// GetDraftOrder fetches a draft order by numeric id. It returns nil, nil when the draft no longer exists.
+func (c Client) GetDraftOrder(ctx context.Context, draftOrderID string) (*DraftOrderState, error) {
+	const query = `
+query DraftOrder($id: ID!) {
+  draftOrder(id: $id) {
+    id
+    status
+    invoiceUrl
+    totalPriceSet {
+      shopMoney {
+        amount
+        currencyCode
+      }
+    }
+    subtotalPriceSet {
+      shopMoney {
+        amount
+      }
+    }
+    order {
+      id
+    }
+  }
+}
+`
+
+	type gqlResp struct {
+		Data struct {
+			DraftOrder *struct {
+				ID            string `json:"id"`
+				Status        string `json:"status"`
+				InvoiceURL    string `json:"invoiceUrl"`
+				TotalPriceSet struct {
+					ShopMoney struct {
+						Amount       string `json:"amount"`
+						CurrencyCode string `json:"currencyCode"`
+					} `json:"shopMoney"`
+				} `json:"totalPriceSet"`
+				SubtotalPriceSet struct {
+					ShopMoney struct {
+						Amount string `json:"amount"`
+					} `json:"shopMoney"`
+				} `json:"subtotalPriceSet"`
+				Order *struct {
+					ID string `json:"id"`
+				} `json:"order"`
+			} `json:"draftOrder"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	gid := draftOrderID
+	if !strings.HasPrefix(gid, "gid://") {
+		gid = "gid://shopify/DraftOrder/" + draftOrderID
+	}
+
+	var resp gqlResp
+	if _, err := c.doJSON(ctx, http.MethodPost, "/graphql.json", map[string]any{
+		"query":     query,
+		"variables": map[string]any{"id": gid},
+	}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("draftOrder graphql error: %s", resp.Errors[0].Message)
+	}
+	d := resp.Data.DraftOrder
+	if d == nil {
+		return nil, nil
+	}
+
+	st := &DraftOrderState{
+		ID:            gidTail(d.ID),
+		Status:        d.Status,
+		InvoiceURL:    d.InvoiceURL,
+		TotalPrice:    d.TotalPriceSet.ShopMoney.Amount,
+		SubtotalPrice: d.SubtotalPriceSet.ShopMoney.Amount,
+		Currency:      d.TotalPriceSet.ShopMoney.CurrencyCode,
+	}
+	if d.Order != nil {
+		st.OrderID = gidTail(d.Order.ID)
+	}
+	return st, nil
+}
+
+// gidTail converts a GID ("gid://shopify/DraftOrder/123") to the numeric id we store.
+func gidTail(gid string) string {
+	if i := strings.LastIndex(gid, "/"); i >= 0 && i < len(gid)-1 {
+		return strings.TrimSpace(gid[i+1:])
+	}
+	return gid
+}
@@ -0,0 +1,241 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// MandatePayment charges a customer's stored payment method (mandate) against an existing order,
+// e.g. the outstanding balance of an order with deferred payment terms.
+type MandatePayment struct {
+	OrderID        string // numeric order id
+	MandateID      string // payment mandate GID, as returned by OrderMandates
+	Amount         string
+	Currency       string
+	IdempotencyKey string // retrying with the same key never charges twice
+}
+
+type MandatePaymentResult struct {
+	PaymentReferenceID string
+	JobID              string
+}
+
+// OrderTransaction is a transaction Shopify recorded on an order.
+type OrderTransaction struct {
+	PaymentID string
+	Kind      string // SALE, CAPTURE, AUTHORIZATION, ...
+	Status    string // SUCCESS, FAILURE, ERROR, PENDING, AWAITING_RESPONSE or UNKNOWN
+	ErrorCode string
+	Amount    string
+}
+
+// MandatePaymentError is a userError returned by orderCreateMandatePayment, e.g. a declined card.
+type MandatePaymentError struct {
+	Code    string
+	Message string
+}
+
+func (e *MandatePaymentError) Error() string {
+	if e.Code == "" {
+		return "orderCreateMandatePayment user error: " + e.Message
+	}
+	return fmt.Sprintf("orderCreateMandatePayment user error: %s: %s", e.Code, e.Message)
+}
+
+// OrderMandates returns the ids of the payment mandates the customer granted when paying the order.
+func (c Client) OrderMandates(ctx context.Context, orderID string) ([]string, error) {
+	const query = `
+query OrderMandates($id: ID!) {
+  order(id: $id) {
+    paymentCollectionDetails {
+      vaultedPaymentMethods {
+        id
+      }
+    }
+  }
+}
+`
+
+	type gqlResp struct {
+		Data struct {
+			Order *struct {
+				PaymentCollectionDetails struct {
+					VaultedPaymentMethods []struct {
+						ID string `json:"id"`
+					} `json:"vaultedPaymentMethods"`
+				} `json:"paymentCollectionDetails"`
+			} `json:"order"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	var resp gqlResp
+	_, err := c.doJSON(ctx, http.MethodPost, "/graphql.json", map[string]any{
+		"query":     query,
+		"variables": map[string]any{"id": "gid://shopify/Order/" + orderID},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("order mandates graphql error: %s", resp.Errors[0].Message)
+	}
+	if resp.Data.Order == nil {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+
+	var out []string
+	for _, m := range resp.Data.Order.PaymentCollectionDetails.VaultedPaymentMethods {
+		if m.ID != "" {
+			out = append(out, m.ID)
+		}
+	}
+	return out, nil
+}
+
+// CreateMandatePayment charges p.Amount on the order through the mandate and captures it right away.
+// A charge Shopify rejects outright is returned as *MandatePaymentError. An accepted charge is only queued:
+// Shopify processes it in a background job, and PaymentTransactions tells whether it went through.
+func (c Client) CreateMandatePayment(ctx context.Context, p MandatePayment) (*MandatePaymentResult, error) {
+	const mutation = `
+mutation OrderCreateMandatePayment($id: ID!, $mandateId: ID!, $idempotencyKey: String!, $amount: MoneyInput, $autoCapture: Boolean) {
+  orderCreateMandatePayment(id: $id, mandateId: $mandateId, idempotencyKey: $idempotencyKey, amount: $amount, autoCapture: $autoCapture) {
+    job {
+      id
+      done
+    }
+    paymentReferenceId
+    userErrors {
+      field
+      message
+      code
+    }
+  }
+}
+`
+
+	type gqlResp struct {
+		Data struct {
+			OrderCreateMandatePayment struct {
+				Job *struct {
+					ID   string `json:"id"`
+					Done bool   `json:"done"`
+				} `json:"job"`
+				PaymentReferenceID string `json:"paymentReferenceId"`
+				UserErrors         []struct {
+					Field   []string `json:"field"`
+					Message string   `json:"message"`
+					Code    string   `json:"code"`
+				} `json:"userErrors"`
+			} `json:"orderCreateMandatePayment"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	var resp gqlResp
+	_, err := c.doJSON(ctx, http.MethodPost, "/graphql.json", map[string]any{
+		"query": mutation,
+		"variables": map[string]any{
+			"id":             "gid://shopify/Order/" + p.OrderID,
+			"mandateId":      p.MandateID,
+			"idempotencyKey": p.IdempotencyKey,
+			"amount":         map[string]any{"amount": p.Amount, "currencyCode": p.Currency},
+			"autoCapture":    true,
+		},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("orderCreateMandatePayment graphql error: %s", resp.Errors[0].Message)
+	}
+	out := resp.Data.OrderCreateMandatePayment
+	if len(out.UserErrors) > 0 {
+		return nil, &MandatePaymentError{Code: out.UserErrors[0].Code, Message: out.UserErrors[0].Message}
+	}
+	if out.PaymentReferenceID == "" {
+		return nil, fmt.Errorf("orderCreateMandatePayment returned no payment reference")
+	}
+
+	res := &MandatePaymentResult{PaymentReferenceID: out.PaymentReferenceID}
+	if out.Job != nil {
+		res.JobID = out.Job.ID
+	}
+	return res, nil
+}
+
+// PaymentTransactions returns the order's transactions for one payment, e.g. the payment reference
+// CreateMandatePayment returned. It is empty until Shopify's job has recorded the payment.
+func (c Client) PaymentTransactions(ctx context.Context, orderID, paymentID string) ([]OrderTransaction, error) {
+	const query = `
+query OrderTransactions($id: ID!) {
+  order(id: $id) {
+    transactions {
+      paymentId
+      kind
+      status
+      errorCode
+      amountSet {
+        shopMoney {
+          amount
+        }
+      }
+    }
+  }
+}
+`
+
+	type gqlResp struct {
+		Data struct {
+			Order *struct {
+				Transactions []struct {
+					PaymentID string  `json:"paymentId"`
+					Kind      string  `json:"kind"`
+					Status    string  `json:"status"`
+					ErrorCode *string `json:"errorCode"`
+					AmountSet struct {
+						ShopMoney struct {
+							Amount string `json:"amount"`
+						} `json:"shopMoney"`
+					} `json:"amountSet"`
+				} `json:"transactions"`
+			} `json:"order"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	var resp gqlResp
+	_, err := c.doJSON(ctx, http.MethodPost, "/graphql.json", map[string]any{
+		"query":     query,
+		"variables": map[string]any{"id": "gid://shopify/Order/" + orderID},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("order transactions graphql error: %s", resp.Errors[0].Message)
+	}
+	if resp.Data.Order == nil {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+
+	var out []OrderTransaction
+	for _, t := range resp.Data.Order.Transactions {
+		if t.PaymentID != paymentID {
+			continue
+		}
+		tx := OrderTransaction{PaymentID: t.PaymentID, Kind: t.Kind, Status: t.Status, Amount: t.AmountSet.ShopMoney.Amount}
+		if t.ErrorCode != nil {
+			tx.ErrorCode = *t.ErrorCode
+		}
+		out = append(out, tx)
+	}
+	return out, nil
+}
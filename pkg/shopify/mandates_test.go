@@ -0,0 +1,106 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"microservice/pkg/shopify/shopifytest"
+)
+
+func fakeClient(t *testing.T) (Client, *shopifytest.FakeAdmin) {
+	t.Helper()
+	fake := shopifytest.NewFakeAdmin()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return Client{ShopDomain: "example.myshopify.com", AccessToken: "shpat_test", BaseURL: srv.URL}, fake
+}
+
+func TestCreateMandatePayment_AgainstFakeAdmin(t *testing.T) {
+	c, fake := fakeClient(t)
+	fake.AddOrder("1001", "gid://shopify/PaymentMandate/7")
+
+	mandates, err := c.OrderMandates(context.Background(), "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mandates) != 1 || mandates[0] != "gid://shopify/PaymentMandate/7" {
+		t.Fatalf("unexpected mandates %v", mandates)
+	}
+
+	p := MandatePayment{OrderID: "1001", MandateID: mandates[0], Amount: "250.00", Currency: "EUR", IdempotencyKey: "m-1:1"}
+	res, err := c.CreateMandatePayment(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PaymentReferenceID == "" {
+		t.Fatalf("expected a payment reference")
+	}
+
+	// Same idempotency key: same charge, nothing new recorded.
+	again, err := c.CreateMandatePayment(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.PaymentReferenceID != res.PaymentReferenceID || len(fake.Payments()) != 1 {
+		t.Fatalf("expected idempotent retry, got %q and %d payments", again.PaymentReferenceID, len(fake.Payments()))
+	}
+	if got := fake.Payments()[0]; got.Amount != "250.00" || got.Currency != "EUR" {
+		t.Fatalf("unexpected recorded payment %+v", got)
+	}
+}
+
+func TestCreateMandatePayment_DeclineIsUserError(t *testing.T) {
+	c, fake := fakeClient(t)
+	fake.AddOrder("1001", "mandate-1")
+	fake.DeclineNext(1, "PAYMENT_DECLINED", "card declined")
+
+	_, err := c.CreateMandatePayment(context.Background(), MandatePayment{OrderID: "1001", MandateID: "mandate-1", Amount: "10.00", Currency: "USD", IdempotencyKey: "k1"})
+	var mpErr *MandatePaymentError
+	if !errors.As(err, &mpErr) || mpErr.Code != "PAYMENT_DECLINED" {
+		t.Fatalf("expected declined MandatePaymentError, got %v", err)
+	}
+
+	if _, err := c.CreateMandatePayment(context.Background(), MandatePayment{OrderID: "1001", MandateID: "mandate-1", Amount: "10.00", Currency: "USD", IdempotencyKey: "k2"}); err != nil {
+		t.Fatalf("expected retry with a new key to succeed, got %v", err)
+	}
+}
+
+func TestPaymentTransactions_FollowsTheQueuedPayment(t *testing.T) {
+	c, fake := fakeClient(t)
+	fake.AddOrder("1001", "mandate-1")
+
+	res, err := c.CreateMandatePayment(context.Background(), MandatePayment{OrderID: "1001", MandateID: "mandate-1", Amount: "10.00", Currency: "USD", IdempotencyKey: "k1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.SetPaymentStatus(res.PaymentReferenceID, "PENDING", "")
+	txs, err := c.PaymentTransactions(context.Background(), "1001", res.PaymentReferenceID)
+	if err != nil || len(txs) != 1 || txs[0].Status != "PENDING" {
+		t.Fatalf("expected one pending transaction, got %+v %v", txs, err)
+	}
+
+	fake.SetPaymentStatus(res.PaymentReferenceID, "FAILURE", "CARD_DECLINED")
+	txs, err = c.PaymentTransactions(context.Background(), "1001", res.PaymentReferenceID)
+	if err != nil || len(txs) != 1 || txs[0].Status != "FAILURE" || txs[0].ErrorCode != "CARD_DECLINED" || txs[0].Amount != "10.00" {
+		t.Fatalf("expected one failed transaction, got %+v %v", txs, err)
+	}
+
+	if txs, err := c.PaymentTransactions(context.Background(), "1001", "other-payment"); err != nil || len(txs) != 0 {
+		t.Fatalf("expected no transactions for another payment, got %+v %v", txs, err)
+	}
+}
+
+func TestCreateDraftOrderLines_AgainstFakeAdmin(t *testing.T) {
+	c, fake := fakeClient(t)
+
+	id, url, err := c.CreateDraftOrderLines(context.Background(), []DraftOrderLine{{Title: "A", Amount: "10.00"}, {Title: "B", Amount: "20.00"}}, "USD", "note", []Attribute{{Key: "_k", Value: "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drafts := fake.DraftOrders()
+	if id == "" || url == "" || len(drafts) != 1 || len(drafts[0].Lines) != 2 || drafts[0].Attributes["_k"] != "v" {
+		t.Fatalf("unexpected draft %q %q %+v", id, url, drafts)
+	}
+}
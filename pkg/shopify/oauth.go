@@ -18,6 +18,17 @@ type OAuthExchanger struct {
 type accessTokenResponse struct {
 	AccessToken string `json:"access_token"`
 	Scope       string `json:"scope"`
+	// AssociatedUser is only returned with online access tokens.
+	AssociatedUser *AssociatedUser `json:"associated_user"`
+}
+
+// AssociatedUser is the staff member an online access token acts for, as Shopify knows them.
+type AssociatedUser struct {
+	ID            int64  `json:"id"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 func (o OAuthExchanger) ExchangeCodeForToken(ctx context.Context, shopDomain, code string) (string, error) {
@@ -58,4 +69,45 @@ func (o OAuthExchanger) ExchangeCodeForToken(ctx context.Context, shopDomain, co
 	return r.AccessToken, nil
 }
 
+// ExchangeSessionTokenForUser trades a verified session token for an online access token and returns the
+// staff member it was issued for. Only the user is kept; the online token itself is not needed.
+func (o OAuthExchanger) ExchangeSessionTokenForUser(ctx context.Context, shopDomain, sessionToken string) (*AssociatedUser, error) {
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"client_id":            o.APIKey,
+		"client_secret":        o.APISecret,
+		"grant_type":           "urn:ietf:params:oauth:grant-type:token-exchange",
+		"subject_token":        sessionToken,
+		"subject_token_type":   "urn:ietf:params:oauth:token-type:id_token",
+		"requested_token_type": "urn:shopify:params:oauth:token-type:online-access-token",
+	})
+
+	u := fmt.Sprintf("https://%s/admin/oauth/access_token", shopDomain)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
 
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("shopify session token exchange failed: status=%d", resp.StatusCode)
+	}
+
+	var r accessTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	if r.AssociatedUser == nil || r.AssociatedUser.ID == 0 {
+		return nil, fmt.Errorf("shopify session token exchange returned no associated_user")
+	}
+	return r.AssociatedUser, nil
+}
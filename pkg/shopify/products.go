@@ -0,0 +1,136 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrProductNotFound is returned when the shop has no product with the requested id.
+var ErrProductNotFound = errors.New("shopify product not found")
+
+// Product is the part of a Shopify product the app shows next to a service product config.
+type Product struct {
+	ID       string           `json:"id"` // numeric id
+	Title    string           `json:"title"`
+	Status   string           `json:"status"` // ACTIVE, ARCHIVED or DRAFT
+	ImageURL string           `json:"imageUrl,omitempty"`
+	Variants []ProductVariant `json:"variants"`
+	Tags     []string         `json:"tags"`
+	// CollectionIDs are the numeric ids of the collections the product is in (at most maxProductCollections).
+	CollectionIDs []string `json:"collectionIds"`
+}
+
+type ProductVariant struct {
+	ID    string `json:"id"` // numeric id
+	Title string `json:"title"`
+	SKU   string `json:"sku,omitempty"`
+	Price string `json:"price"`
+}
+
+// maxProductVariants is the most variants a product can have in Shopify.
+const maxProductVariants = 250
+
+// maxProductCollections caps the collections fetched per product; the first page is enough for matching.
+const maxProductCollections = 250
+
+// Product fetches a product with its variants, tags and collections by numeric id. A product that does not exist (or was deleted) is
+// ErrProductNotFound.
+func (c Client) Product(ctx context.Context, productID string) (*Product, error) {
+	const query = `
+query Product($id: ID!, $variants: Int!, $collections: Int!) {
+  product(id: $id) {
+    id
+    title
+    status
+    tags
+    featuredImage {
+      url
+    }
+    collections(first: $collections) {
+      nodes {
+        id
+      }
+    }
+    variants(first: $variants) {
+      nodes {
+        id
+        title
+        sku
+        price
+      }
+    }
+  }
+}
+`
+
+	type gqlResp struct {
+		Data struct {
+			Product *struct {
+				ID            string   `json:"id"`
+				Title         string   `json:"title"`
+				Status        string   `json:"status"`
+				Tags          []string `json:"tags"`
+				FeaturedImage *struct {
+					URL string `json:"url"`
+				} `json:"featuredImage"`
+				Collections struct {
+					Nodes []struct {
+						ID string `json:"id"`
+					} `json:"nodes"`
+				} `json:"collections"`
+				Variants struct {
+					Nodes []struct {
+						ID    string `json:"id"`
+						Title string `json:"title"`
+						SKU   string `json:"sku"`
+						Price string `json:"price"`
+					} `json:"nodes"`
+				} `json:"variants"`
+			} `json:"product"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	productID = strings.TrimSpace(productID)
+	if productID == "" {
+		return nil, ErrProductNotFound
+	}
+
+	var resp gqlResp
+	_, err := c.doJSON(ctx, http.MethodPost, "/graphql.json", map[string]any{
+		"query": query,
+		"variables": map[string]any{
+			"id":          "gid://shopify/Product/" + productID,
+			"variants":    maxProductVariants,
+			"collections": maxProductCollections,
+		},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("product graphql error: %s", resp.Errors[0].Message)
+	}
+	if resp.Data.Product == nil {
+		return nil, ErrProductNotFound
+	}
+
+	gp := resp.Data.Product
+	p := &Product{ID: gidTail(gp.ID), Title: gp.Title, Status: gp.Status, Variants: []ProductVariant{}, Tags: []string{}, CollectionIDs: []string{}}
+	p.Tags = append(p.Tags, gp.Tags...)
+	for _, c := range gp.Collections.Nodes {
+		p.CollectionIDs = append(p.CollectionIDs, gidTail(c.ID))
+	}
+	if gp.FeaturedImage != nil {
+		p.ImageURL = gp.FeaturedImage.URL
+	}
+	for _, v := range gp.Variants.Nodes {
+		p.Variants = append(p.Variants, ProductVariant{ID: gidTail(v.ID), Title: v.Title, SKU: v.SKU, Price: v.Price})
+	}
+	return p, nil
+}
@@ -0,0 +1,50 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"microservice/pkg/shopify/shopifytest"
+)
+
+func TestProduct_AgainstFakeAdmin(t *testing.T) {
+	c, fake := fakeClient(t)
+	fake.AddProduct(shopifytest.Product{
+		ID:            "42",
+		Title:         "Kitchen remodel",
+		ImageURL:      "https://cdn.example.com/kitchen.png",
+		Tags:          []string{"service", "Remodel"},
+		CollectionIDs: []string{"77"},
+		Variants: []shopifytest.ProductVariant{
+			{ID: "4201", Title: "Small", SKU: "KR-S", Price: "5000.00"},
+			{ID: "4202", Title: "Large", Price: "9000.00"},
+		},
+	})
+
+	p, err := c.Product(context.Background(), "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != "42" || p.Title != "Kitchen remodel" || p.Status != "ACTIVE" || p.ImageURL != "https://cdn.example.com/kitchen.png" {
+		t.Fatalf("unexpected product %+v", p)
+	}
+	if len(p.Variants) != 2 || p.Variants[0] != (ProductVariant{ID: "4201", Title: "Small", SKU: "KR-S", Price: "5000.00"}) || p.Variants[1].ID != "4202" {
+		t.Fatalf("unexpected variants %+v", p.Variants)
+	}
+	if len(p.Tags) != 2 || p.Tags[1] != "Remodel" || len(p.CollectionIDs) != 1 || p.CollectionIDs[0] != "77" {
+		t.Fatalf("unexpected tags %v or collections %v", p.Tags, p.CollectionIDs)
+	}
+}
+
+func TestProduct_NotFound(t *testing.T) {
+	c, fake := fakeClient(t)
+	fake.AddProduct(shopifytest.Product{ID: "42", Title: "Kitchen remodel"})
+	fake.DeleteProduct("42")
+
+	for _, id := range []string{"42", "", "  "} {
+		if _, err := c.Product(context.Background(), id); !errors.Is(err, ErrProductNotFound) {
+			t.Fatalf("Product(%q): expected ErrProductNotFound, got %v", id, err)
+		}
+	}
+}
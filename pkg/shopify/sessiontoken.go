@@ -13,11 +13,16 @@ type SessionTokenClaims struct {
 
 	// Shopify uses custom claims; we only rely on a few.
 	Dest string `json:"dest,omitempty"` // e.g. https://{shop}
+	Sid  string `json:"sid,omitempty"`  // session id
 }
 
 type VerifiedSession struct {
 	ShopDomain string
 	ExpiresAt  time.Time
+
+	// UserID is the staff member's Shopify user ID (the "sub" claim). Empty for tokens without a user.
+	UserID    string
+	SessionID string
 }
 
 // VerifySessionToken verifies an embedded app session token (JWT, HS256) using the app API secret.
@@ -68,6 +73,8 @@ func VerifySessionToken(tokenString string, apiKey string, apiSecret string, now
 	return &VerifiedSession{
 		ShopDomain: shopDomain,
 		ExpiresAt:  claims.ExpiresAt.Time,
+		UserID:     strings.TrimSpace(claims.Subject),
+		SessionID:  claims.Sid,
 	}, nil
 }
 
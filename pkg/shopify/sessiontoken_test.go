@@ -18,6 +18,7 @@ func TestVerifySessionToken_AudienceAndDest(t *testing.T) {
 			Audience:  []string{apiKey},
 			ExpiresAt: jwt.NewNumericDate(now.Add(10 * time.Minute)),
 			IssuedAt:  jwt.NewNumericDate(now.Add(-1 * time.Minute)),
+			Subject:   "42",
 		},
 		Dest: "https://my-shop.myshopify.com",
 	}
@@ -35,6 +36,9 @@ func TestVerifySessionToken_AudienceAndDest(t *testing.T) {
 	if got.ShopDomain != "my-shop.myshopify.com" {
 		t.Fatalf("shop domain mismatch: %q", got.ShopDomain)
 	}
+	if got.UserID != "42" {
+		t.Fatalf("user id mismatch: %q", got.UserID)
+	}
 }
 
 
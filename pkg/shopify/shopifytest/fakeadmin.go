@@ -0,0 +1,350 @@
+// Package shopifytest provides an in-memory stand-in for the parts of the Shopify Admin GraphQL API this
+// service calls. Point shopify.Client.BaseURL at an httptest.Server running a FakeAdmin.
+package shopifytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MandatePayment is a charge the fake accepted.
+type MandatePayment struct {
+	OrderID        string
+	MandateID      string
+	Amount         string
+	Currency       string
+	IdempotencyKey string
+	Reference      string
+	// Status is the status of the payment's transaction: SUCCESS unless set with SetPaymentStatus.
+	Status    string
+	ErrorCode string
+}
+
+// DraftOrder is a draft order the fake created.
+type DraftOrder struct {
+	ID         string
+	Note       string
+	Attributes map[string]string
+	Lines      []DraftOrderLine
+}
+
+type DraftOrderLine struct {
+	Title string
+	Price string
+}
+
+// Product is a product the fake knows. Variants are numeric ids with their titles and prices.
+type Product struct {
+	ID       string
+	Title    string
+	Status   string
+	ImageURL string
+	Variants []ProductVariant
+	Tags     []string
+	// CollectionIDs are numeric collection ids.
+	CollectionIDs []string
+}
+
+type ProductVariant struct {
+	ID    string
+	Title string
+	SKU   string
+	Price string
+}
+
+type decline struct {
+	code, message string
+}
+
+// FakeAdmin answers OrderMandates, OrderCreateMandatePayment, OrderTransactions, DraftOrderCreate and Product
+// requests.
+// It is safe for concurrent use.
+type FakeAdmin struct {
+	mu       sync.Mutex
+	next     int
+	mandates map[string][]string // order id -> mandate ids
+	payments []MandatePayment
+	byKey    map[string]MandatePayment
+	declines []decline
+	drafts   []DraftOrder
+	products map[string]Product
+}
+
+func NewFakeAdmin() *FakeAdmin {
+	return &FakeAdmin{mandates: map[string][]string{}, byKey: map[string]MandatePayment{}, products: map[string]Product{}}
+}
+
+// AddOrder registers an order paid with the given payment mandates.
+func (f *FakeAdmin) AddOrder(orderID string, mandateIDs ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mandates[orderID] = append([]string(nil), mandateIDs...)
+}
+
+// AddProduct registers a product. A product with the same id is replaced.
+func (f *FakeAdmin) AddProduct(p Product) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.products[p.ID] = p
+}
+
+// DeleteProduct removes a product, as if the merchant deleted it.
+func (f *FakeAdmin) DeleteProduct(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.products, id)
+}
+
+// DeclineNext makes the next n mandate payments fail with a userError.
+func (f *FakeAdmin) DeclineNext(n int, code, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f.declines = append(f.declines, decline{code: code, message: message})
+	}
+}
+
+// SetPaymentStatus changes the transaction status of an accepted mandate payment, e.g. to PENDING while
+// Shopify's job is still processing it or FAILURE once the charge was declined.
+func (f *FakeAdmin) SetPaymentStatus(reference, status, errorCode string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, p := range f.payments {
+		if p.Reference == reference {
+			f.payments[i].Status, f.payments[i].ErrorCode = status, errorCode
+			f.byKey[p.IdempotencyKey] = f.payments[i]
+		}
+	}
+}
+
+// Payments returns the accepted mandate payments in order.
+func (f *FakeAdmin) Payments() []MandatePayment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]MandatePayment(nil), f.payments...)
+}
+
+// DraftOrders returns the created draft orders in order.
+func (f *FakeAdmin) DraftOrders() []DraftOrder {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]DraftOrder(nil), f.drafts...)
+}
+
+func (f *FakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/graphql.json") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("X-Shopify-Access-Token") == "" {
+		http.Error(w, `{"errors":"[API] Invalid API key or access token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	var data any
+	switch {
+	case strings.Contains(req.Query, "orderCreateMandatePayment"):
+		data = f.createMandatePayment(req.Variables)
+	case strings.Contains(req.Query, "transactions"):
+		data = f.orderTransactions(req.Variables)
+	case strings.Contains(req.Query, "vaultedPaymentMethods"):
+		data = f.orderMandates(req.Variables)
+	case strings.Contains(req.Query, "draftOrderCreate"):
+		data = f.draftOrderCreate(req.Variables)
+	case strings.Contains(req.Query, "product(id:"):
+		data = f.product(req.Variables)
+	default:
+		writeJSON(w, map[string]any{"errors": []map[string]any{{"message": "fake admin: unsupported operation"}}})
+		return
+	}
+	writeJSON(w, map[string]any{"data": data})
+}
+
+func (f *FakeAdmin) orderMandates(vars map[string]any) any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mandates, ok := f.mandates[gidTail(str(vars["id"]))]
+	if !ok {
+		return map[string]any{"order": nil}
+	}
+	methods := make([]map[string]any, 0, len(mandates))
+	for _, id := range mandates {
+		methods = append(methods, map[string]any{"id": id})
+	}
+	return map[string]any{"order": map[string]any{
+		"paymentCollectionDetails": map[string]any{"vaultedPaymentMethods": methods},
+	}}
+}
+
+func (f *FakeAdmin) createMandatePayment(vars map[string]any) any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := func(ref string, userErrors []map[string]any) any {
+		out := map[string]any{"paymentReferenceId": nil, "job": nil, "userErrors": userErrors}
+		if ref != "" {
+			out["paymentReferenceId"] = ref
+			out["job"] = map[string]any{"id": "gid://shopify/Job/" + ref, "done": true}
+		}
+		return map[string]any{"orderCreateMandatePayment": out}
+	}
+
+	key := str(vars["idempotencyKey"])
+	if p, ok := f.byKey[key]; ok {
+		return result(p.Reference, []map[string]any{})
+	}
+
+	orderID, mandateID := gidTail(str(vars["id"])), str(vars["mandateId"])
+	known := false
+	for _, m := range f.mandates[orderID] {
+		known = known || m == mandateID
+	}
+	if !known {
+		return result("", []map[string]any{{"field": []string{"mandateId"}, "message": "Mandate not found", "code": "NOT_FOUND"}})
+	}
+	if len(f.declines) > 0 {
+		d := f.declines[0]
+		f.declines = f.declines[1:]
+		return result("", []map[string]any{{"field": []string{"id"}, "message": d.message, "code": d.code}})
+	}
+
+	f.next++
+	amount, _ := vars["amount"].(map[string]any)
+	p := MandatePayment{
+		OrderID:        orderID,
+		MandateID:      mandateID,
+		Amount:         str(amount["amount"]),
+		Currency:       str(amount["currencyCode"]),
+		IdempotencyKey: key,
+		Reference:      fmt.Sprintf("fake-payment-%d", f.next),
+		Status:         "SUCCESS",
+	}
+	f.payments = append(f.payments, p)
+	f.byKey[key] = p
+	return result(p.Reference, []map[string]any{})
+}
+
+func (f *FakeAdmin) orderTransactions(vars map[string]any) any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	orderID := gidTail(str(vars["id"]))
+	if _, ok := f.mandates[orderID]; !ok {
+		return map[string]any{"order": nil}
+	}
+	txs := []map[string]any{}
+	for _, p := range f.payments {
+		if p.OrderID != orderID {
+			continue
+		}
+		var errorCode any
+		if p.ErrorCode != "" {
+			errorCode = p.ErrorCode
+		}
+		txs = append(txs, map[string]any{
+			"paymentId": p.Reference,
+			"kind":      "SALE",
+			"status":    p.Status,
+			"errorCode": errorCode,
+			"amountSet": map[string]any{"shopMoney": map[string]any{"amount": p.Amount}},
+		})
+	}
+	return map[string]any{"order": map[string]any{"transactions": txs}}
+}
+
+func (f *FakeAdmin) draftOrderCreate(vars map[string]any) any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	input, _ := vars["input"].(map[string]any)
+	f.next++
+	d := DraftOrder{ID: fmt.Sprintf("%d", 1000+f.next), Note: str(input["note"]), Attributes: map[string]string{}}
+	if attrs, ok := input["customAttributes"].([]any); ok {
+		for _, a := range attrs {
+			if kv, ok := a.(map[string]any); ok {
+				d.Attributes[str(kv["key"])] = str(kv["value"])
+			}
+		}
+	}
+	if lines, ok := input["lineItems"].([]any); ok {
+		for _, l := range lines {
+			if li, ok := l.(map[string]any); ok {
+				d.Lines = append(d.Lines, DraftOrderLine{Title: str(li["title"]), Price: str(li["originalUnitPrice"])})
+			}
+		}
+	}
+	f.drafts = append(f.drafts, d)
+
+	return map[string]any{"draftOrderCreate": map[string]any{
+		"draftOrder": map[string]any{
+			"id":         "gid://shopify/DraftOrder/" + d.ID,
+			"invoiceUrl": "http://fake-admin.local/invoices/" + d.ID,
+		},
+		"userErrors": []map[string]any{},
+	}}
+}
+
+func (f *FakeAdmin) product(vars map[string]any) any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.products[gidTail(str(vars["id"]))]
+	if !ok {
+		return map[string]any{"product": nil}
+	}
+	variants := make([]map[string]any, 0, len(p.Variants))
+	for _, v := range p.Variants {
+		variants = append(variants, map[string]any{
+			"id": "gid://shopify/ProductVariant/" + v.ID, "title": v.Title, "sku": v.SKU, "price": v.Price,
+		})
+	}
+	status := p.Status
+	if status == "" {
+		status = "ACTIVE"
+	}
+	collections := make([]map[string]any, 0, len(p.CollectionIDs))
+	for _, id := range p.CollectionIDs {
+		collections = append(collections, map[string]any{"id": "gid://shopify/Collection/" + id})
+	}
+	tags := append([]string{}, p.Tags...)
+	var image any
+	if p.ImageURL != "" {
+		image = map[string]any{"url": p.ImageURL}
+	}
+	return map[string]any{"product": map[string]any{
+		"id":            "gid://shopify/Product/" + p.ID,
+		"title":         p.Title,
+		"status":        status,
+		"tags":          tags,
+		"featuredImage": image,
+		"collections":   map[string]any{"nodes": collections},
+		"variants":      map[string]any{"nodes": variants},
+	}}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func str(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func gidTail(gid string) string {
+	if i := strings.LastIndex(gid, "/"); i >= 0 && i < len(gid)-1 {
+		return gid[i+1:]
+	}
+	return gid
+}